@@ -0,0 +1,217 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+// blockingTransport is a transmission interface that blocks reads until a response is supplied via the responses channel.
+type blockingTransport struct {
+	commands  chan []byte
+	responses chan []byte
+	rsp       *bytes.Reader
+}
+
+func newBlockingTransport() *blockingTransport {
+	return &blockingTransport{commands: make(chan []byte, 10), responses: make(chan []byte, 10)}
+}
+
+func (b *blockingTransport) Read(data []byte) (int, error) {
+	if b.rsp == nil || b.rsp.Len() == 0 {
+		b.rsp = bytes.NewReader(<-b.responses)
+	}
+	return b.rsp.Read(data)
+}
+
+func (b *blockingTransport) Write(data []byte) (int, error) {
+	b.commands <- append([]byte(nil), data...)
+	return len(data), nil
+}
+
+func (b *blockingTransport) Close() error {
+	return nil
+}
+
+func (b *blockingTransport) respond(t *testing.T, rc ResponseCode, params ...interface{}) {
+	p, err := mu.MarshalToBytes(params...)
+	if err != nil {
+		t.Fatalf("MarshalToBytes failed: %v", err)
+	}
+	hdr := make([]byte, 10)
+	binary.BigEndian.PutUint16(hdr, uint16(TagNoSessions))
+	binary.BigEndian.PutUint32(hdr[2:], uint32(len(hdr)+len(p)))
+	binary.BigEndian.PutUint32(hdr[6:], uint32(rc))
+	b.responses <- append(hdr, p...)
+}
+
+// cancellableBlockingTransport is a blockingTransport that implements CancellableTransport, and calls the supplied function when a
+// command is canceled.
+type cancellableBlockingTransport struct {
+	*blockingTransport
+	onCancel func()
+}
+
+func (c *cancellableBlockingTransport) Cancel() error {
+	c.onCancel()
+	return nil
+}
+
+func TestCommandContext(t *testing.T) {
+	t.Run("AlreadyCanceled", func(t *testing.T) {
+		transport := newBlockingTransport()
+		tpm, _ := NewTPMContext(transport)
+		defer closeTPM(t, tpm)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		tpm.SetCommandContext(ctx)
+
+		_, err := tpm.GetRandom(8)
+		var e *CommandCanceledError
+		if !xerrors.As(err, &e) || e.Command != CommandGetRandom {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !xerrors.Is(err, context.Canceled) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if len(transport.commands) != 0 {
+			t.Errorf("Command should not have been sent")
+		}
+	})
+
+	t.Run("Abandon", func(t *testing.T) {
+		transport := newBlockingTransport()
+		tpm, _ := NewTPMContext(transport)
+		defer closeTPM(t, tpm)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		tpm.SetCommandContext(ctx)
+
+		_, err := tpm.GetRandom(8)
+		var e *CommandCanceledError
+		if !xerrors.As(err, &e) || e.Command != CommandGetRandom {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !xerrors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		<-transport.commands
+
+		// The response to the abandoned command should be discarded.
+		tpm.SetCommandContext(nil)
+		transport.respond(t, Success, Digest("abandoned"))
+		transport.respond(t, Success, Digest("12345678"))
+
+		digest, err := tpm.GetRandom(8)
+		if err != nil {
+			t.Fatalf("GetRandom failed: %v", err)
+		}
+		if !bytes.Equal(digest, []byte("12345678")) {
+			t.Errorf("Unexpected response (%x)", digest)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		blocking := newBlockingTransport()
+		transport := &cancellableBlockingTransport{blockingTransport: blocking}
+		transport.onCancel = func() {
+			blocking.respond(t, 0x909) // TPM_RC_CANCELED
+		}
+		tpm, _ := NewTPMContext(transport)
+		defer closeTPM(t, tpm)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		tpm.SetCommandContext(ctx)
+
+		go func() {
+			<-blocking.commands
+			cancel()
+		}()
+
+		_, err := tpm.GetRandom(8)
+		var e *CommandCanceledError
+		if !xerrors.As(err, &e) || e.Command != CommandGetRandom {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !xerrors.Is(err, context.Canceled) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("CancelIgnored", func(t *testing.T) {
+		blocking := newBlockingTransport()
+		transport := &cancellableBlockingTransport{blockingTransport: blocking}
+		transport.onCancel = func() {
+			blocking.respond(t, Success, Digest("12345678"))
+		}
+		tpm, _ := NewTPMContext(transport)
+		defer closeTPM(t, tpm)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		tpm.SetCommandContext(ctx)
+
+		go func() {
+			<-blocking.commands
+			cancel()
+		}()
+
+		digest, err := tpm.GetRandom(8)
+		if err != nil {
+			t.Fatalf("GetRandom failed: %v", err)
+		}
+		if !bytes.Equal(digest, []byte("12345678")) {
+			t.Errorf("Unexpected response (%x)", digest)
+		}
+	})
+	t.Run("CancelNoResponse", func(t *testing.T) {
+		defer MockCancelGracePeriod(10 * time.Millisecond)()
+
+		blocking := newBlockingTransport()
+		transport := &cancellableBlockingTransport{blockingTransport: blocking, onCancel: func() {}}
+		tpm, _ := NewTPMContext(transport)
+		defer closeTPM(t, tpm)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		tpm.SetCommandContext(ctx)
+
+		go func() {
+			<-blocking.commands
+			cancel()
+		}()
+
+		_, err := tpm.GetRandom(8)
+		var e *CommandCanceledError
+		if !xerrors.As(err, &e) || e.Command != CommandGetRandom {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !xerrors.Is(err, context.Canceled) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+
+		// The late response to the canceled command should be discarded.
+		tpm.SetCommandContext(nil)
+		blocking.respond(t, Success, Digest("abandoned"))
+		blocking.respond(t, Success, Digest("12345678"))
+
+		digest, err := tpm.GetRandom(8)
+		if err != nil {
+			t.Fatalf("GetRandom failed: %v", err)
+		}
+		if !bytes.Equal(digest, []byte("12345678")) {
+			t.Errorf("Unexpected response (%x)", digest)
+		}
+	})
+}
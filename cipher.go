@@ -0,0 +1,253 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"crypto/cipher"
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
+
+// symmetricCipher contains the state shared by the cipher.Stream and cipher.BlockMode implementations that are backed by a
+// symmetric key loaded in to the TPM.
+type symmetricCipher struct {
+	tpm         *TPMContext
+	keyContext  ResourceContext
+	mode        SymModeId
+	blockSize   int
+	decrypt     bool
+	iv          IV
+	authSession SessionContext
+	sessions    []SessionContext
+}
+
+func (c *symmetricCipher) run(data []byte, iv IV) ([]byte, IV) {
+	out, ivOut, err := c.tpm.EncryptDecrypt2(c.keyContext, data, c.decrypt, c.mode, iv, c.authSession, c.sessions...)
+	if err != nil {
+		panic(xerrors.Errorf("cannot execute symmetric operation on TPM: %w", err))
+	}
+	if len(out) != len(data) {
+		panic(&InvalidResponseError{CommandEncryptDecrypt2, fmt.Sprintf("unexpected output size (got %d bytes, expected %d bytes)",
+			len(out), len(data))})
+	}
+	return out, ivOut
+}
+
+// symmetricBlockSize returns the block size in bytes of the specified symmetric algorithm, or false if the algorithm isn't supported.
+func symmetricBlockSize(alg SymObjectAlgorithmId) (int, bool) {
+	switch alg {
+	case SymObjectAlgorithmAES, SymObjectAlgorithmSM4, SymObjectAlgorithmCamellia:
+		return 16, true
+	default:
+		return 0, false
+	}
+}
+
+func (t *TPMContext) newSymmetricCipher(keyContext ResourceContext, mode SymModeId, decrypt bool, iv IV, authSession SessionContext,
+	sessions []SessionContext) (*symmetricCipher, error) {
+	object, isObject := keyContext.(*objectContext)
+	if !isObject {
+		return nil, makeInvalidArgError("keyContext", "resource context is not an object")
+	}
+	public := object.public()
+	if public == nil || public.Type != ObjectTypeSymCipher {
+		return nil, makeInvalidArgError("keyContext", "resource context does not correspond to a symmetric cipher object")
+	}
+	blockSize, ok := symmetricBlockSize(public.Params.SymDetail().Sym.Algorithm)
+	if !ok {
+		return nil, makeInvalidArgError("keyContext", fmt.Sprintf("unsupported symmetric algorithm %v",
+			public.Params.SymDetail().Sym.Algorithm))
+	}
+	if mode == SymModeNull {
+		mode = public.Params.SymDetail().Sym.Mode.Sym()
+	}
+
+	if err := t.initPropertiesIfNeeded(); err != nil {
+		return nil, err
+	}
+
+	c := &symmetricCipher{
+		tpm:         t,
+		keyContext:  keyContext,
+		mode:        mode,
+		blockSize:   blockSize,
+		decrypt:     decrypt,
+		authSession: authSession,
+		sessions:    sessions}
+	if len(iv) > 0 {
+		c.iv = make(IV, len(iv))
+		copy(c.iv, iv)
+	}
+	return c, nil
+}
+
+type symmetricStream struct {
+	*symmetricCipher
+	batchSize int
+	keystream []byte // Unused key stream bytes
+	feedback  []byte // Cipher text for the current partial block in CFB mode
+}
+
+// XORKeyStream implements cipher.Stream.XORKeyStream.
+func (s *symmetricStream) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("output smaller than input")
+	}
+
+	for len(src) > 0 {
+		switch {
+		case len(s.keystream) > 0:
+			// Consume key stream that was obtained previously.
+			n := len(src)
+			if n > len(s.keystream) {
+				n = len(s.keystream)
+			}
+			if s.mode == SymModeCFB && s.decrypt {
+				s.feedback = append(s.feedback, src[:n]...)
+			}
+			for i := 0; i < n; i++ {
+				dst[i] = src[i] ^ s.keystream[i]
+			}
+			if s.mode == SymModeCFB && !s.decrypt {
+				s.feedback = append(s.feedback, dst[:n]...)
+			}
+			s.keystream = s.keystream[n:]
+			if s.mode == SymModeCFB && len(s.keystream) == 0 {
+				s.iv = s.feedback
+				s.feedback = nil
+			}
+			dst = dst[n:]
+			src = src[n:]
+		case s.mode == SymModeCFB && len(src) >= s.blockSize:
+			// The key stream depends on the cipher text in CFB mode, so whole blocks are sent to the TPM directly.
+			n := len(src) - (len(src) % s.blockSize)
+			out, iv := s.run(src[:n], s.iv)
+			copy(dst, out)
+			s.iv = iv
+			dst = dst[n:]
+			src = src[n:]
+		default:
+			// Obtain more key stream by processing a run of zero bytes. In CFB mode, this is only done for a trailing partial block
+			// and doesn't advance the chaining value. In CTR and OFB modes, the key stream doesn't depend on the input and so this is
+			// done in batches in order to minimize the number of round trips to the TPM.
+			n := s.blockSize
+			if s.mode != SymModeCFB {
+				n = len(src) + s.blockSize - 1
+				n -= n % s.blockSize
+				if n < s.batchSize {
+					n = s.batchSize
+				}
+			}
+			keystream, iv := s.run(make([]byte, n), s.iv)
+			s.keystream = keystream
+			if s.mode != SymModeCFB {
+				s.iv = iv
+			}
+		}
+	}
+}
+
+// NewSymmetricStream returns a cipher.Stream that performs encryption or decryption using the symmetric key associated with
+// keyContext, which must correspond to an object of type ObjectTypeSymCipher that was loaded or created by this TPMContext. The
+// operations are performed on the TPM with TPMContext.EncryptDecrypt2, and the key never leaves the TPM. The key requires
+// authorization with the user auth role, with session based authorization provided via keyContextAuthSession. As the returned stream
+// executes many commands, any SessionContext instances provided should have the AttrContinueSession attribute defined.
+//
+// The key must use AES, SM4 or Camellia, which all have a block size of 16 bytes.
+//
+// The mode argument must be one of SymModeCFB, SymModeCTR or SymModeOFB. If it is SymModeNull, then the mode of the key will be used.
+// The iv argument specifies the initial chaining value and must be the same size as the block size of the key. If decrypt is true,
+// the stream performs decryption, else it performs encryption.
+//
+// In CTR and OFB modes, the key stream is obtained from the TPM in batches that are the size of the TPM's input buffer, so that a
+// series of small calls to XORKeyStream don't each require a round trip to the TPM. In CFB mode, the key stream depends on the
+// cipher text, so whole blocks are sent to the TPM as they are supplied and only a trailing partial block requires an additional
+// round trip.
+//
+// As cipher.Stream has no way of returning an error, the XORKeyStream method of the returned stream will panic with an error if the
+// TPM returns an error.
+func (t *TPMContext) NewSymmetricStream(keyContext ResourceContext, mode SymModeId, decrypt bool, iv IV, keyContextAuthSession SessionContext, sessions ...SessionContext) (cipher.Stream, error) {
+	c, err := t.newSymmetricCipher(keyContext, mode, decrypt, iv, keyContextAuthSession, sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.mode {
+	case SymModeCFB, SymModeCTR, SymModeOFB:
+	default:
+		return nil, makeInvalidArgError("mode", fmt.Sprintf("unsupported mode %v for a stream cipher", c.mode))
+	}
+	if len(c.iv) != c.blockSize {
+		return nil, makeInvalidArgError("iv", fmt.Sprintf("expected %d bytes", c.blockSize))
+	}
+
+	return &symmetricStream{symmetricCipher: c, batchSize: t.maxBufferSize - (t.maxBufferSize % c.blockSize)}, nil
+}
+
+type symmetricBlockMode struct {
+	*symmetricCipher
+}
+
+// BlockSize implements cipher.BlockMode.BlockSize.
+func (m *symmetricBlockMode) BlockSize() int {
+	return m.blockSize
+}
+
+// CryptBlocks implements cipher.BlockMode.CryptBlocks.
+func (m *symmetricBlockMode) CryptBlocks(dst, src []byte) {
+	if len(src)%m.blockSize != 0 {
+		panic("input not full blocks")
+	}
+	if len(dst) < len(src) {
+		panic("output smaller than input")
+	}
+	if len(src) == 0 {
+		return
+	}
+
+	out, iv := m.run(src, m.iv)
+	copy(dst, out)
+	if m.mode == SymModeCBC {
+		m.iv = iv
+	}
+}
+
+// NewSymmetricBlockMode returns a cipher.BlockMode that performs encryption or decryption using the symmetric key associated with
+// keyContext, which must correspond to an object of type ObjectTypeSymCipher that was loaded or created by this TPMContext. The
+// operations are performed on the TPM with TPMContext.EncryptDecrypt2, and the key never leaves the TPM. The key requires
+// authorization with the user auth role, with session based authorization provided via keyContextAuthSession. As the returned block
+// mode may execute many commands, any SessionContext instances provided should have the AttrContinueSession attribute defined.
+//
+// The key must use AES, SM4 or Camellia, which all have a block size of 16 bytes.
+//
+// The mode argument must be one of SymModeCBC or SymModeECB. If it is SymModeNull, then the mode of the key will be used. In CBC
+// mode, the iv argument specifies the initial chaining value and must be the same size as the block size of the key. In ECB mode,
+// iv must be empty. If decrypt is true, the block mode performs decryption, else it performs encryption.
+//
+// Each call to CryptBlocks on the returned block mode results in the supplied blocks being sent to the TPM with as few round trips
+// as the TPM's input buffer size permits. As cipher.BlockMode has no way of returning an error, CryptBlocks will panic with an error
+// if the TPM returns an error.
+func (t *TPMContext) NewSymmetricBlockMode(keyContext ResourceContext, mode SymModeId, decrypt bool, iv IV, keyContextAuthSession SessionContext, sessions ...SessionContext) (cipher.BlockMode, error) {
+	c, err := t.newSymmetricCipher(keyContext, mode, decrypt, iv, keyContextAuthSession, sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.mode {
+	case SymModeCBC:
+		if len(c.iv) != c.blockSize {
+			return nil, makeInvalidArgError("iv", fmt.Sprintf("expected %d bytes", c.blockSize))
+		}
+	case SymModeECB:
+		if len(c.iv) != 0 {
+			return nil, makeInvalidArgError("iv", "must be empty for ECB mode")
+		}
+	default:
+		return nil, makeInvalidArgError("mode", fmt.Sprintf("unsupported mode %v for a block mode", c.mode))
+	}
+
+	return &symmetricBlockMode{symmetricCipher: c}, nil
+}
@@ -0,0 +1,214 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+)
+
+func TestSymmetricStream(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	key := make([]byte, 16)
+	rand.Read(key)
+
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("NewCipher failed: %v", err)
+	}
+
+	// Mix of sizes that exercise partial blocks, whole blocks and inputs that span a partially consumed block.
+	sizes := []int{1, 15, 16, 3, 40, 200, 7, 2048, 9}
+
+	for _, data := range []struct {
+		desc    string
+		keyMode SymModeId
+		mode    SymModeId
+		newRef  func(iv []byte, decrypt bool) cipher.Stream
+	}{
+		{
+			desc:    "CFB",
+			keyMode: SymModeCFB,
+			mode:    SymModeNull,
+			newRef: func(iv []byte, decrypt bool) cipher.Stream {
+				if decrypt {
+					return cipher.NewCFBDecrypter(c, iv)
+				}
+				return cipher.NewCFBEncrypter(c, iv)
+			},
+		},
+		{
+			desc:    "CTR",
+			keyMode: SymModeNull,
+			mode:    SymModeCTR,
+			newRef:  func(iv []byte, _ bool) cipher.Stream { return cipher.NewCTR(c, iv) },
+		},
+		{
+			desc:    "OFB",
+			keyMode: SymModeOFB,
+			mode:    SymModeOFB,
+			newRef:  func(iv []byte, _ bool) cipher.Stream { return cipher.NewOFB(c, iv) },
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			keyContext := loadSymmetricKeyForTesting(t, tpm, key, data.keyMode, nil)
+			defer flushContext(t, tpm, keyContext)
+
+			iv := make(IV, aes.BlockSize)
+			rand.Read(iv)
+
+			var plaintext [][]byte
+			for _, n := range sizes {
+				b := make([]byte, n)
+				rand.Read(b)
+				plaintext = append(plaintext, b)
+			}
+
+			enc, err := tpm.NewSymmetricStream(keyContext, data.mode, false, iv, nil)
+			if err != nil {
+				t.Fatalf("NewSymmetricStream failed: %v", err)
+			}
+			ref := data.newRef(iv, false)
+
+			var ciphertext [][]byte
+			for _, p := range plaintext {
+				out := make([]byte, len(p))
+				enc.XORKeyStream(out, p)
+
+				expected := make([]byte, len(p))
+				ref.XORKeyStream(expected, p)
+				if !bytes.Equal(out, expected) {
+					t.Fatalf("Unexpected ciphertext")
+				}
+				ciphertext = append(ciphertext, out)
+			}
+
+			dec, err := tpm.NewSymmetricStream(keyContext, data.mode, true, iv, nil)
+			if err != nil {
+				t.Fatalf("NewSymmetricStream failed: %v", err)
+			}
+			for i, c := range ciphertext {
+				// Decrypt in place to check that aliased buffers are handled correctly.
+				dec.XORKeyStream(c, c)
+				if !bytes.Equal(c, plaintext[i]) {
+					t.Fatalf("Unexpected plaintext")
+				}
+			}
+		})
+	}
+
+	t.Run("InvalidMode", func(t *testing.T) {
+		keyContext := loadSymmetricKeyForTesting(t, tpm, key, SymModeCBC, nil)
+		defer flushContext(t, tpm, keyContext)
+
+		if _, err := tpm.NewSymmetricStream(keyContext, SymModeNull, false, make(IV, aes.BlockSize), nil); err == nil {
+			t.Errorf("NewSymmetricStream should fail for a CBC key")
+		}
+	})
+
+	t.Run("UnsupportedAlgorithm", func(t *testing.T) {
+		keyContext := loadSymmetricKeyForTesting(t, tpm, key, SymModeCFB, nil)
+		defer flushContext(t, tpm, keyContext)
+
+		// TPM_ALG_TDES has a block size of 8 bytes, which isn't supported.
+		keyContext.(TestObjectResourceContext).GetPublic().Params.SymDetail().Sym.Algorithm = SymObjectAlgorithmId(0x0003)
+		_, err := tpm.NewSymmetricStream(keyContext, SymModeNull, false, make(IV, 8), nil)
+		if err == nil || err.Error() != "invalid keyContext argument: unsupported symmetric algorithm 0x0003" {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
+
+func TestSymmetricBlockMode(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	key := make([]byte, 16)
+	rand.Read(key)
+
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("NewCipher failed: %v", err)
+	}
+
+	sizes := []int{16, 64, 0, 4096, 32}
+
+	for _, data := range []struct {
+		desc    string
+		keyMode SymModeId
+		mode    SymModeId
+	}{
+		{
+			desc:    "CBC",
+			keyMode: SymModeCBC,
+			mode:    SymModeNull,
+		},
+		{
+			desc:    "ECB",
+			keyMode: SymModeNull,
+			mode:    SymModeECB,
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			keyContext := loadSymmetricKeyForTesting(t, tpm, key, data.keyMode, nil)
+			defer flushContext(t, tpm, keyContext)
+
+			var iv IV
+			if data.desc == "CBC" {
+				iv = make(IV, aes.BlockSize)
+				rand.Read(iv)
+			}
+
+			enc, err := tpm.NewSymmetricBlockMode(keyContext, data.mode, false, iv, nil)
+			if err != nil {
+				t.Fatalf("NewSymmetricBlockMode failed: %v", err)
+			}
+			if enc.BlockSize() != aes.BlockSize {
+				t.Errorf("Unexpected block size")
+			}
+			dec, err := tpm.NewSymmetricBlockMode(keyContext, data.mode, true, iv, nil)
+			if err != nil {
+				t.Fatalf("NewSymmetricBlockMode failed: %v", err)
+			}
+
+			var ref cipher.BlockMode
+			if iv != nil {
+				ref = cipher.NewCBCEncrypter(c, iv)
+			}
+
+			for _, n := range sizes {
+				plaintext := make([]byte, n)
+				rand.Read(plaintext)
+
+				ciphertext := make([]byte, n)
+				enc.CryptBlocks(ciphertext, plaintext)
+
+				expected := make([]byte, n)
+				if ref != nil {
+					ref.CryptBlocks(expected, plaintext)
+				} else {
+					for i := 0; i < n; i += aes.BlockSize {
+						c.Encrypt(expected[i:], plaintext[i:])
+					}
+				}
+				if !bytes.Equal(ciphertext, expected) {
+					t.Fatalf("Unexpected ciphertext")
+				}
+
+				dec.CryptBlocks(ciphertext, ciphertext)
+				if !bytes.Equal(ciphertext, plaintext) {
+					t.Fatalf("Unexpected plaintext")
+				}
+			}
+		})
+	}
+}
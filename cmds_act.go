@@ -0,0 +1,25 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+// Section 33 - Authenticated Countdown Timer
+
+// ACTSetTimeout executes the TPM2_ACT_SetTimeout command to set the timeout of the authenticated countdown timer (ACT) associated
+// with actContext, which must correspond to one of the HandleACT0 to HandleACTF handles. The ACT will count down from startTimeout
+// seconds, and the platform specific action associated with the ACT will be performed when it reaches zero, unless the timeout is
+// updated again before that happens. This makes it possible to use an ACT as a watchdog. Setting startTimeout to zero will clear the
+// AttrACTSignaled attribute of the ACT, without starting a new countdown. The current timeout and state of each ACT can be obtained
+// with TPMContext.GetCapabilityACT.
+//
+// The command requires authorization with the user auth role for actContext, with session based authorization provided via
+// actContextAuthSession. An authorization policy for an ACT can be set with TPMContext.SetPrimaryPolicy.
+//
+// If an update for the timeout of the ACT is still pending after the command has been resubmitted the maximum number of times (see
+// TPMContext.SetMaxSubmissions), a *TPMWarning error with a warning code of WarningRetry will be returned.
+func (t *TPMContext) ACTSetTimeout(actContext ResourceContext, startTimeout uint32, actContextAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandACTSetTimeout, sessions,
+		ResourceContextWithSession{Context: actContext, Session: actContextAuthSession}, Delimiter,
+		startTimeout)
+}
@@ -0,0 +1,46 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+)
+
+func TestACTSetTimeout(t *testing.T) {
+	tpm, _ := openTPMSimulatorForTesting(t)
+	defer closeTPM(t, tpm)
+
+	acts, err := tpm.GetCapabilityACT(HandleACT0, CapabilityMaxProperties)
+	if err != nil {
+		t.Fatalf("GetCapabilityACT failed: %v", err)
+	}
+	if len(acts) == 0 {
+		t.Skip("TPM does not implement any ACTs")
+	}
+
+	act := tpm.GetPermanentContext(acts[0].Handle)
+
+	if err := tpm.ACTSetTimeout(act, 3600, nil); err != nil {
+		t.Fatalf("ACTSetTimeout failed: %v", err)
+	}
+	defer func() {
+		if err := tpm.ACTSetTimeout(act, 0, nil); err != nil {
+			t.Errorf("ACTSetTimeout failed: %v", err)
+		}
+	}()
+
+	acts, err = tpm.GetCapabilityACT(act.Handle(), 1)
+	if err != nil {
+		t.Fatalf("GetCapabilityACT failed: %v", err)
+	}
+	if len(acts) != 1 || acts[0].Handle != act.Handle() {
+		t.Fatalf("GetCapabilityACT returned the wrong ACT")
+	}
+	if acts[0].Timeout == 0 || acts[0].Timeout > 3600 {
+		t.Errorf("Unexpected timeout (%d)", acts[0].Timeout)
+	}
+}
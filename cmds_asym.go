@@ -0,0 +1,203 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+// Section 14 - Asymmetric Primitives
+
+// RSAEncrypt executes the TPM2_RSA_Encrypt command to perform RSA encryption of the supplied message using the public part of the
+// RSA key associated with keyContext. The key must have the AttrDecrypt attribute, else a *TPMHandleError error with an error code of
+// ErrorAttributes will be returned for handle index 1. If keyContext does not correspond to a RSA key, a *TPMHandleError error with
+// an error code of ErrorKey will be returned for handle index 1.
+//
+// The padding scheme is determined by the scheme of the key associated with keyContext and the inScheme argument. If the key has a
+// scheme of RSASchemeNull, then inScheme must be provided to select either RSASchemeRSAES or RSASchemeOAEP. If the key has a scheme
+// other than RSASchemeNull, then inScheme may be nil or must specify the same scheme as the key. If the resulting scheme is not a
+// valid encryption scheme for the key, a *TPMParameterError error with an error code of ErrorScheme will be returned for parameter
+// index 2.
+//
+// If the selected scheme is RSASchemeOAEP, the optional label will be associated with the message. The TPM requires a non-empty
+// label to be zero terminated, so this function will append a zero byte to label if it isn't already terminated.
+//
+// If the message is too large for the key and selected scheme, a *TPMParameterError error with an error code of ErrorValue will be
+// returned for parameter index 1.
+//
+// The message argument can be encrypted using a session with the AttrCommandEncrypt attribute set.
+//
+// On success, the encrypted data is returned.
+func (t *TPMContext) RSAEncrypt(keyContext ResourceContext, message PublicKeyRSA, inScheme *RSAScheme, label Label, sessions ...SessionContext) (PublicKeyRSA, error) {
+	if inScheme == nil {
+		inScheme = &RSAScheme{Scheme: RSASchemeNull}
+	}
+	label = terminateRSALabel(label)
+
+	var outData PublicKeyRSA
+	if err := t.RunCommand(CommandRSAEncrypt, sessions,
+		keyContext, Delimiter,
+		message, inScheme, label, Delimiter,
+		Delimiter,
+		&outData); err != nil {
+		return nil, err
+	}
+
+	return outData, nil
+}
+
+// RSADecrypt executes the TPM2_RSA_Decrypt command to perform RSA decryption of the supplied cipher text using the private part of
+// the RSA key associated with keyContext. The command requires authorization with the user auth role for keyContext, with session
+// based authorization provided via keyContextAuthSession.
+//
+// If keyContext does not correspond to a RSA key, a *TPMHandleError error with an error code of ErrorKey will be returned for handle
+// index 1. If the key does not have the AttrDecrypt attribute or it has the AttrRestricted attribute, a *TPMHandleError error with an
+// error code of ErrorAttributes will be returned for handle index 1.
+//
+// The padding scheme is selected in the same way as for TPMContext.RSAEncrypt. If the resulting scheme is not a valid encryption
+// scheme for the key, a *TPMParameterError error with an error code of ErrorScheme will be returned for parameter index 2.
+//
+// If the selected scheme is RSASchemeOAEP, the label must match the one used when the message was encrypted. As with
+// TPMContext.RSAEncrypt, a zero byte will be appended to a non-empty label if it isn't already terminated. If the cipher text
+// cannot be decrypted with the selected scheme and label, a *TPMParameterError error with an error code of ErrorValue will be
+// returned for parameter index 1. If the cipher text is larger than the modulus of the key, a *TPMParameterError error with an error
+// code of ErrorSize will be returned for parameter index 1.
+//
+// The cipherText argument can be encrypted using a session with the AttrCommandEncrypt attribute set, and the returned message can be
+// encrypted using a session with the AttrResponseEncrypt attribute set.
+//
+// On success, the decrypted message is returned.
+func (t *TPMContext) RSADecrypt(keyContext ResourceContext, cipherText PublicKeyRSA, inScheme *RSAScheme, label Label, keyContextAuthSession SessionContext, sessions ...SessionContext) (PublicKeyRSA, error) {
+	if inScheme == nil {
+		inScheme = &RSAScheme{Scheme: RSASchemeNull}
+	}
+	label = terminateRSALabel(label)
+
+	var message PublicKeyRSA
+	if err := t.RunCommand(CommandRSADecrypt, sessions,
+		ResourceContextWithSession{Context: keyContext, Session: keyContextAuthSession}, Delimiter,
+		cipherText, inScheme, label, Delimiter,
+		Delimiter,
+		&message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// ECDHKeyGen executes the TPM2_ECDH_KeyGen command to generate an ephemeral key pair on the curve of the ECC key associated with
+// keyContext, and then perform a point multiplication of the public point of the key associated with keyContext with the ephemeral
+// private key. This can be used by a sender to create a shared secret for a recipient in possession of the private part of the key
+// associated with keyContext, without needing to perform any point multiplication on the host.
+//
+// If keyContext does not correspond to an ECC key, a *TPMHandleError error with an error code of ErrorKey will be returned for
+// handle index 1. If the key does not have the AttrDecrypt attribute, a *TPMHandleError error with an error code of ErrorAttributes
+// will be returned for handle index 1.
+//
+// On success, the result of the point multiplication (zPoint) and the public point of the ephemeral key (pubPoint) are returned. The
+// recipient can compute the same value of zPoint with TPMContext.ECDHZGen by supplying pubPoint.
+func (t *TPMContext) ECDHKeyGen(keyContext ResourceContext, sessions ...SessionContext) (zPoint, pubPoint *ECCPoint, err error) {
+	var zPointSized eccPointSized
+	var pubPointSized eccPointSized
+	if err := t.RunCommand(CommandECDHKeyGen, sessions,
+		keyContext, Delimiter,
+		Delimiter,
+		Delimiter,
+		&zPointSized, &pubPointSized); err != nil {
+		return nil, nil, err
+	}
+
+	return zPointSized.Ptr, pubPointSized.Ptr, nil
+}
+
+// ECDHZGen executes the TPM2_ECDH_ZGen command to perform a point multiplication of the supplied point with the private part of the
+// ECC key associated with keyContext. This is used by a recipient to recover the shared secret computed by a sender with
+// TPMContext.ECDHKeyGen or by an equivalent computation on the host. The command requires authorization with the user auth role for
+// keyContext, with session based authorization provided via keyContextAuthSession.
+//
+// If keyContext does not correspond to an ECC key, a *TPMHandleError error with an error code of ErrorKey will be returned for
+// handle index 1. If the key does not have the AttrDecrypt attribute or it has the AttrRestricted attribute, a *TPMHandleError error
+// with an error code of ErrorAttributes will be returned for handle index 1. If the key has a scheme other than ECCSchemeNull or
+// ECCSchemeECDH, a *TPMHandleError error with an error code of ErrorScheme will be returned for handle index 1.
+//
+// If inPoint is not on the curve of the key associated with keyContext, a *TPMParameterError error with an error code of
+// ErrorECCPoint will be returned for parameter index 1.
+//
+// The inPoint argument can be encrypted using a session with the AttrCommandEncrypt attribute set, and the returned point can be
+// encrypted using a session with the AttrResponseEncrypt attribute set.
+//
+// On success, the result of the point multiplication is returned.
+func (t *TPMContext) ECDHZGen(keyContext ResourceContext, inPoint *ECCPoint, keyContextAuthSession SessionContext, sessions ...SessionContext) (*ECCPoint, error) {
+	var outPoint eccPointSized
+	if err := t.RunCommand(CommandECDHZGen, sessions,
+		ResourceContextWithSession{Context: keyContext, Session: keyContextAuthSession}, Delimiter,
+		eccPointSized{inPoint}, Delimiter,
+		Delimiter,
+		&outPoint); err != nil {
+		return nil, err
+	}
+
+	return outPoint.Ptr, nil
+}
+
+// ECCParameters executes the TPM2_ECC_Parameters command to obtain the parameters of the ECC curve identified by curveID. If the
+// curve is not supported by the TPM, a *TPMParameterError error with an error code of ErrorValue will be returned for parameter
+// index 1.
+//
+// On success, the parameters of the curve are returned. These can be converted to an elliptic.Curve with
+// AlgorithmDetailECC.GoCurve.
+func (t *TPMContext) ECCParameters(curveID ECCCurve, sessions ...SessionContext) (*AlgorithmDetailECC, error) {
+	var parameters AlgorithmDetailECC
+	if err := t.RunCommand(CommandECCParameters, sessions,
+		Delimiter,
+		curveID, Delimiter,
+		Delimiter,
+		&parameters); err != nil {
+		return nil, err
+	}
+
+	return &parameters, nil
+}
+
+// ZGen2Phase executes the TPM2_ZGen_2Phase command to perform the second phase of a two-phase key exchange protocol, using the
+// static key associated with keyAContext and the ephemeral key associated with counter, which must have been created by a previous
+// call to TPMContext.ECEphemeral. The inQsB and inQeB arguments are the static and ephemeral public points of the other party. The
+// inScheme argument selects the key exchange scheme, which must be one of ECCSchemeECDH, ECCSchemeECMQV or ECCSchemeSM2. The command
+// requires authorization with the user auth role for keyAContext, with session based authorization provided via
+// keyAContextAuthSession.
+//
+// If keyAContext does not correspond to an ECC key, a *TPMHandleError error with an error code of ErrorKey will be returned for
+// handle index 1. If the key does not have the AttrDecrypt attribute or it has the AttrRestricted attribute, a *TPMHandleError error
+// with an error code of ErrorAttributes will be returned for handle index 1. If the key has a scheme other than ECCSchemeNull that
+// does not match inScheme, a *TPMParameterError error with an error code of ErrorScheme will be returned for parameter index 3.
+//
+// If either inQsB or inQeB are not on the curve of the key, a *TPMParameterError error with an error code of ErrorECCPoint will be
+// returned for parameter index 1 or 2.
+//
+// If counter does not correspond to an active ephemeral key, a *TPMParameterError error with an error code of ErrorValue will be
+// returned for parameter index 4.
+//
+// The inQsB argument can be encrypted using a session with the AttrCommandEncrypt attribute set, and the returned outZ1 point can be
+// encrypted using a session with the AttrResponseEncrypt attribute set.
+//
+// On success, the computed points outZ1 and outZ2 are returned. The ephemeral key associated with counter can no longer be used.
+func (t *TPMContext) ZGen2Phase(keyAContext ResourceContext, inQsB, inQeB *ECCPoint, inScheme ECCSchemeId, counter uint16, keyAContextAuthSession SessionContext, sessions ...SessionContext) (outZ1, outZ2 *ECCPoint, err error) {
+	var outZ1Sized eccPointSized
+	var outZ2Sized eccPointSized
+	if err := t.RunCommand(CommandZGen2Phase, sessions,
+		ResourceContextWithSession{Context: keyAContext, Session: keyAContextAuthSession}, Delimiter,
+		eccPointSized{inQsB}, eccPointSized{inQeB}, inScheme, counter, Delimiter,
+		Delimiter,
+		&outZ1Sized, &outZ2Sized); err != nil {
+		return nil, nil, err
+	}
+
+	return outZ1Sized.Ptr, outZ2Sized.Ptr, nil
+}
+
+func terminateRSALabel(label Label) Label {
+	if len(label) == 0 || label[len(label)-1] == 0 {
+		return label
+	}
+	out := make(Label, len(label)+1)
+	copy(out, label)
+	return out
+}
@@ -0,0 +1,323 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+)
+
+func TestRSAEncryptDecrypt(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	primary := createRSASrkForTesting(t, tpm, nil)
+	defer flushContext(t, tpm, primary)
+
+	create := func(t *testing.T, scheme *RSAScheme, authValue Auth) (ResourceContext, *Public) {
+		template := Public{
+			Type:    ObjectTypeRSA,
+			NameAlg: HashAlgorithmSHA256,
+			Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrDecrypt,
+			Params: PublicParamsU{
+				Data: &RSAParams{
+					Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+					Scheme:    *scheme,
+					KeyBits:   2048,
+					Exponent:  0}}}
+		sensitive := SensitiveCreate{UserAuth: authValue}
+		priv, pub, _, _, _, err := tpm.Create(primary, &sensitive, &template, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		context, err := tpm.Load(primary, priv, pub, nil)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		context.SetAuthValue(authValue)
+
+		return context, pub
+	}
+
+	msg := []byte("super secret message")
+
+	run := func(t *testing.T, keyScheme, inScheme *RSAScheme, label Label, authValue Auth, session SessionContext) {
+		key, _ := create(t, keyScheme, authValue)
+		defer flushContext(t, tpm, key)
+
+		var sessions []SessionContext
+		if session != nil {
+			sessions = append(sessions, session)
+		}
+
+		cipherText, err := tpm.RSAEncrypt(key, msg, inScheme, label, sessions...)
+		if err != nil {
+			t.Fatalf("RSAEncrypt failed: %v", err)
+		}
+		if len(cipherText) != 256 {
+			t.Errorf("Unexpected cipher text length (got %d bytes)", len(cipherText))
+		}
+
+		plainText, err := tpm.RSADecrypt(key, cipherText, inScheme, label, session)
+		if err != nil {
+			t.Fatalf("RSADecrypt failed: %v", err)
+		}
+		if !bytes.Equal(plainText, msg) {
+			t.Errorf("RSADecrypt returned the wrong data")
+		}
+	}
+
+	t.Run("OAEPKeyScheme", func(t *testing.T) {
+		run(t, &RSAScheme{Scheme: RSASchemeOAEP, Details: AsymSchemeU{Data: &EncSchemeOAEP{HashAlg: HashAlgorithmSHA256}}}, nil, nil, nil, nil)
+	})
+	t.Run("RSAESKeyScheme", func(t *testing.T) {
+		run(t, &RSAScheme{Scheme: RSASchemeRSAES, Details: AsymSchemeU{Data: &EncSchemeRSAES{}}}, nil, nil, nil, nil)
+	})
+	t.Run("OAEPInScheme", func(t *testing.T) {
+		run(t, &RSAScheme{Scheme: RSASchemeNull},
+			&RSAScheme{Scheme: RSASchemeOAEP, Details: AsymSchemeU{Data: &EncSchemeOAEP{HashAlg: HashAlgorithmSHA1}}}, nil, nil, nil)
+	})
+	t.Run("RSAESInScheme", func(t *testing.T) {
+		run(t, &RSAScheme{Scheme: RSASchemeNull}, &RSAScheme{Scheme: RSASchemeRSAES, Details: AsymSchemeU{Data: &EncSchemeRSAES{}}}, nil, nil, nil)
+	})
+	t.Run("WithLabel", func(t *testing.T) {
+		run(t, &RSAScheme{Scheme: RSASchemeOAEP, Details: AsymSchemeU{Data: &EncSchemeOAEP{HashAlg: HashAlgorithmSHA256}}}, nil, Label("foo"), nil, nil)
+	})
+	t.Run("UsePasswordAuth", func(t *testing.T) {
+		run(t, &RSAScheme{Scheme: RSASchemeOAEP, Details: AsymSchemeU{Data: &EncSchemeOAEP{HashAlg: HashAlgorithmSHA256}}}, nil, nil, testAuth, nil)
+	})
+	t.Run("UseSessionAuthAndParameterEncryption", func(t *testing.T) {
+		sessionContext, err := tpm.StartAuthSession(nil, primary, SessionTypeHMAC, &SymDef{
+			Algorithm: SymAlgorithmAES,
+			KeyBits:   SymKeyBitsU{Data: uint16(128)},
+			Mode:      SymModeU{Data: SymModeCFB}}, HashAlgorithmSHA256)
+		if err != nil {
+			t.Fatalf("StartAuthSession failed: %v", err)
+		}
+		defer flushContext(t, tpm, sessionContext)
+
+		run(t, &RSAScheme{Scheme: RSASchemeOAEP, Details: AsymSchemeU{Data: &EncSchemeOAEP{HashAlg: HashAlgorithmSHA256}}}, nil, nil, testAuth,
+			sessionContext.WithAttrs(AttrContinueSession|AttrCommandEncrypt|AttrResponseEncrypt))
+	})
+
+	t.Run("DecryptExternal", func(t *testing.T) {
+		key, pub := create(t, &RSAScheme{Scheme: RSASchemeOAEP, Details: AsymSchemeU{Data: &EncSchemeOAEP{HashAlg: HashAlgorithmSHA256}}}, nil)
+		defer flushContext(t, tpm, key)
+
+		pubKey := rsa.PublicKey{N: new(big.Int).SetBytes(pub.Unique.RSA()), E: DefaultRSAExponent}
+		cipherText, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &pubKey, msg, []byte("foo\x00"))
+		if err != nil {
+			t.Fatalf("EncryptOAEP failed: %v", err)
+		}
+
+		plainText, err := tpm.RSADecrypt(key, cipherText, nil, Label("foo"), nil)
+		if err != nil {
+			t.Fatalf("RSADecrypt failed: %v", err)
+		}
+		if !bytes.Equal(plainText, msg) {
+			t.Errorf("RSADecrypt returned the wrong data")
+		}
+	})
+}
+
+func TestECDH(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	primary := createRSASrkForTesting(t, tpm, nil)
+	defer flushContext(t, tpm, primary)
+
+	template := Public{
+		Type:    ObjectTypeECC,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrDecrypt,
+		Params: PublicParamsU{
+			Data: &ECCParams{
+				Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+				Scheme:    ECCScheme{Scheme: ECCSchemeNull},
+				CurveID:   ECCCurveNIST_P256,
+				KDF:       KDFScheme{Scheme: KDFAlgorithmNull}}}}
+	sensitive := SensitiveCreate{UserAuth: testAuth}
+	priv, pub, _, _, _, err := tpm.Create(primary, &sensitive, &template, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	key, err := tpm.Load(primary, priv, pub, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer flushContext(t, tpm, key)
+	key.SetAuthValue(testAuth)
+
+	t.Run("KeyGenAndZGen", func(t *testing.T) {
+		zPoint, pubPoint, err := tpm.ECDHKeyGen(key)
+		if err != nil {
+			t.Fatalf("ECDHKeyGen failed: %v", err)
+		}
+
+		outPoint, err := tpm.ECDHZGen(key, pubPoint, nil)
+		if err != nil {
+			t.Fatalf("ECDHZGen failed: %v", err)
+		}
+		if !bytes.Equal(outPoint.X, zPoint.X) || !bytes.Equal(outPoint.Y, zPoint.Y) {
+			t.Errorf("ECDHZGen returned an unexpected point")
+		}
+	})
+
+	t.Run("ZGenWithExternalKey", func(t *testing.T) {
+		curve := elliptic.P256()
+		ephPriv, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey failed: %v", err)
+		}
+
+		sessionContext, err := tpm.StartAuthSession(nil, key, SessionTypeHMAC, &SymDef{
+			Algorithm: SymAlgorithmAES,
+			KeyBits:   SymKeyBitsU{Data: uint16(128)},
+			Mode:      SymModeU{Data: SymModeCFB}}, HashAlgorithmSHA256)
+		if err != nil {
+			t.Fatalf("StartAuthSession failed: %v", err)
+		}
+		defer flushContext(t, tpm, sessionContext)
+
+		outPoint, err := tpm.ECDHZGen(key, &ECCPoint{X: x.Bytes(), Y: y.Bytes()},
+			sessionContext.WithAttrs(AttrContinueSession|AttrCommandEncrypt|AttrResponseEncrypt))
+		if err != nil {
+			t.Fatalf("ECDHZGen failed: %v", err)
+		}
+
+		zX, zY := curve.ScalarMult(new(big.Int).SetBytes(pub.Unique.ECC().X), new(big.Int).SetBytes(pub.Unique.ECC().Y), ephPriv)
+		if new(big.Int).SetBytes(outPoint.X).Cmp(zX) != 0 || new(big.Int).SetBytes(outPoint.Y).Cmp(zY) != 0 {
+			t.Errorf("ECDHZGen returned an unexpected point")
+		}
+	})
+}
+
+func TestECCParameters(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandECCParameters)
+
+	parameters, err := tpm.ECCParameters(ECCCurveNIST_P256)
+	if err != nil {
+		t.Fatalf("ECCParameters failed: %v", err)
+	}
+	if parameters.CurveID != ECCCurveNIST_P256 {
+		t.Errorf("Unexpected curve ID (got %v)", parameters.CurveID)
+	}
+	if parameters.KeySize != 256 {
+		t.Errorf("Unexpected key size (got %d)", parameters.KeySize)
+	}
+
+	expected := elliptic.P256().Params()
+	for _, p := range []struct {
+		name     string
+		actual   ECCParameter
+		expected *big.Int
+	}{
+		{"p", parameters.P, expected.P},
+		{"b", parameters.B, expected.B},
+		{"gX", parameters.GX, expected.Gx},
+		{"gY", parameters.GY, expected.Gy},
+		{"n", parameters.N, expected.N},
+	} {
+		if new(big.Int).SetBytes(p.actual).Cmp(p.expected) != 0 {
+			t.Errorf("Unexpected value for %s", p.name)
+		}
+	}
+
+	if parameters.GoCurve() != elliptic.P256() {
+		t.Errorf("Unexpected curve")
+	}
+}
+
+func TestZGen2Phase(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandZGen2Phase, CommandECEphemeral)
+
+	primary := createRSASrkForTesting(t, tpm, nil)
+	defer flushContext(t, tpm, primary)
+
+	curve := elliptic.P256()
+
+	run := func(t *testing.T, keyScheme ECCSchemeId) {
+		var details AsymSchemeU
+		if keyScheme != ECCSchemeNull {
+			details = AsymSchemeU{Data: &KeySchemeECDH{HashAlg: HashAlgorithmSHA256}}
+		}
+		template := Public{
+			Type:    ObjectTypeECC,
+			NameAlg: HashAlgorithmSHA256,
+			Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrDecrypt,
+			Params: PublicParamsU{
+				Data: &ECCParams{
+					Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+					Scheme:    ECCScheme{Scheme: keyScheme, Details: details},
+					CurveID:   ECCCurveNIST_P256,
+					KDF:       KDFScheme{Scheme: KDFAlgorithmNull}}}}
+		priv, pub, _, _, _, err := tpm.Create(primary, nil, &template, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		keyA, err := tpm.Load(primary, priv, pub, nil)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		defer flushContext(t, tpm, keyA)
+
+		qeA, counter, err := tpm.ECEphemeral(ECCCurveNIST_P256)
+		if err != nil {
+			t.Fatalf("ECEphemeral failed: %v", err)
+		}
+
+		dsB, qsBX, qsBY, err := elliptic.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey failed: %v", err)
+		}
+		deB, qeBX, qeBY, err := elliptic.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey failed: %v", err)
+		}
+
+		outZ1, outZ2, err := tpm.ZGen2Phase(keyA, &ECCPoint{X: qsBX.Bytes(), Y: qsBY.Bytes()}, &ECCPoint{X: qeBX.Bytes(), Y: qeBY.Bytes()},
+			ECCSchemeECDH, counter, nil)
+		if err != nil {
+			t.Fatalf("ZGen2Phase failed: %v", err)
+		}
+
+		z1X, _ := curve.ScalarMult(new(big.Int).SetBytes(pub.Unique.ECC().X), new(big.Int).SetBytes(pub.Unique.ECC().Y), dsB)
+		if new(big.Int).SetBytes(outZ1.X).Cmp(z1X) != 0 {
+			t.Errorf("ZGen2Phase returned an unexpected value for outZ1")
+		}
+		z2X, _ := curve.ScalarMult(new(big.Int).SetBytes(qeA.X), new(big.Int).SetBytes(qeA.Y), deB)
+		if new(big.Int).SetBytes(outZ2.X).Cmp(z2X) != 0 {
+			t.Errorf("ZGen2Phase returned an unexpected value for outZ2")
+		}
+
+		_, _, err = tpm.ZGen2Phase(keyA, &ECCPoint{X: qsBX.Bytes(), Y: qsBY.Bytes()}, &ECCPoint{X: qeBX.Bytes(), Y: qeBY.Bytes()},
+			ECCSchemeECDH, counter, nil)
+		if !IsTPMParameterError(err, ErrorValue, CommandZGen2Phase, 4) {
+			t.Errorf("Unexpected error re-using ephemeral key: %v", err)
+		}
+	}
+
+	t.Run("NullKeyScheme", func(t *testing.T) {
+		run(t, ECCSchemeNull)
+	})
+	t.Run("ECDHKeyScheme", func(t *testing.T) {
+		run(t, ECCSchemeECDH)
+	})
+}
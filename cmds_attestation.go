@@ -6,6 +6,10 @@ package tpm2
 
 // Section 18 - Attestation Commands
 
+import (
+	"fmt"
+)
+
 // Certify executes the TPM2_Certify command, which is used to prove that an object with a specific name is loaded in to the TPM.
 // By producing an attestation, the TPM certifies that the object with a given name is loaded in to the TPM and consistent with a
 // valid sensitive area.
@@ -255,3 +259,58 @@ func (t *TPMContext) GetTime(privacyAdminContext, signContext ResourceContext, q
 
 	return timeInfo, &signature, nil
 }
+
+// CertifyX509 executes the TPM2_CertifyX509 command, which is used to produce a X.509 certificate for the object associated with
+// objectContext, signed by the key associated with signContext. The caller supplies the issuer, validity, subject and extensions
+// fields of the certificate via partialCertificate, and the TPM adds the version, serial number, signature algorithm and the
+// subject public key info for the object. AssembleCertifyX509Certificate can be used to reassemble the complete certificate from the
+// values returned by this function.
+//
+// The objectContext parameter corresponds to the object for which to produce a certificate. The command requires authorization with
+// the admin role for objectContext, with session based authorization provided via objectContextAuthSession.
+//
+// The signContext parameter corresponds to the key used to sign the certificate. The command requires authorization with the user
+// auth role for signContext, with session based authorization provided via signContextAuthSession.
+//
+// If the object associated with signContext is not a signing key, a *TPMHandleError error with an error code of ErrorKey will be
+// returned for handle index 2.
+//
+// If the scheme of the key associated with signContext is AsymSchemeNull, then inScheme must be provided to specify a valid signing
+// scheme for the key. If it isn't, a *TPMParameterError error with an error code of ErrorScheme will be returned for parameter index
+// 2.
+//
+// If the scheme of the key associated with signContext is not AsymSchemeNull, then inScheme may be nil. If it is provided, then the
+// specified scheme must match that of the signing key, else a *TPMParameterError error with an error code of ErrorScheme will be
+// returned for parameter index 2.
+//
+// If the encoded partialCertificate is not valid, or the key usage extension is not consistent with the attributes of the object
+// associated with objectContext, a *TPMParameterError error with an error code of ErrorValue or ErrorAttributes will be returned for
+// parameter index 3.
+//
+// On success, it returns the DER encoded SEQUENCE containing the fields added to the certificate by the TPM, the digest of the
+// TBSCertificate that was signed, and the signature.
+func (t *TPMContext) CertifyX509(objectContext, signContext ResourceContext, partialCertificate *PartialCertificate, inScheme *SigScheme, objectContextAuthSession, signContextAuthSession SessionContext, sessions ...SessionContext) (addedToCertificate MaxBuffer, tbsDigest Digest, signature *Signature, err error) {
+	if partialCertificate == nil {
+		return nil, nil, nil, makeInvalidArgError("partialCertificate", "nil value")
+	}
+	partial, err := partialCertificate.Marshal()
+	if err != nil {
+		return nil, nil, nil, makeInvalidArgError("partialCertificate", fmt.Sprintf("cannot marshal: %v", err))
+	}
+
+	if inScheme == nil {
+		inScheme = &SigScheme{Scheme: SigSchemeAlgNull}
+	}
+
+	var sig Signature
+
+	if err := t.RunCommand(CommandCertifyX509, sessions,
+		ResourceContextWithSession{Context: objectContext, Session: objectContextAuthSession}, ResourceContextWithSession{Context: signContext, Session: signContextAuthSession}, Delimiter,
+		Data(nil), inScheme, MaxBuffer(partial), Delimiter,
+		Delimiter,
+		&addedToCertificate, &tbsDigest, &sig); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return addedToCertificate, tbsDigest, &sig, nil
+}
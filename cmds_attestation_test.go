@@ -6,9 +6,13 @@ package tpm2_test
 
 import (
 	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	. "github.com/canonical/go-tpm2"
 )
@@ -650,3 +654,76 @@ func TestGetTime(t *testing.T) {
 		run(t, ak, HandleEndorsement, nil, nil, sessionContext, nil)
 	})
 }
+
+func TestCertifyX509(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandCertifyX509)
+
+	primary := createRSASrkForTesting(t, tpm, nil)
+	defer flushContext(t, tpm, primary)
+
+	template := Public{
+		Type:    ObjectTypeRSA,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrSign,
+		Params: PublicParamsU{
+			Data: &RSAParams{
+				Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+				Scheme: RSAScheme{
+					Scheme:  RSASchemeRSASSA,
+					Details: AsymSchemeU{Data: &SigSchemeRSASSA{HashAlg: HashAlgorithmSHA256}}},
+				KeyBits:  2048,
+				Exponent: 0}}}
+	priv, pub, _, _, _, err := tpm.Create(primary, nil, &template, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	key, err := tpm.Load(primary, priv, pub, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer flushContext(t, tpm, key)
+
+	notBefore := time.Now().Truncate(time.Second)
+	partial := PartialCertificate{
+		Issuer:    pkix.Name{CommonName: "go-tpm2 test"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.Add(24 * time.Hour),
+		Subject:   pkix.Name{CommonName: "go-tpm2 test"},
+		KeyUsage:  x509.KeyUsageDigitalSignature}
+
+	addedToCertificate, tbsDigest, signature, err := tpm.CertifyX509(key, key, &partial, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("CertifyX509 failed: %v", err)
+	}
+	if signature.SigAlg != SigSchemeAlgRSASSA {
+		t.Errorf("Unexpected signature algorithm (%v)", signature.SigAlg)
+	}
+
+	cert, err := AssembleCertifyX509Certificate(&partial, addedToCertificate, tbsDigest, signature)
+	if err != nil {
+		t.Fatalf("AssembleCertifyX509Certificate failed: %v", err)
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		t.Errorf("Invalid certificate signature: %v", err)
+	}
+
+	pubKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		t.Fatalf("Unexpected public key type")
+	}
+	if !bytes.Equal(pubKey.N.Bytes(), pub.Unique.RSA()) {
+		t.Errorf("Certificate has the wrong public key")
+	}
+	if cert.Subject.CommonName != "go-tpm2 test" {
+		t.Errorf("Certificate has the wrong subject")
+	}
+	if cert.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Errorf("Certificate has the wrong key usage")
+	}
+	if !cert.NotBefore.Equal(partial.NotBefore) || !cert.NotAfter.Equal(partial.NotAfter) {
+		t.Errorf("Certificate has the wrong validity")
+	}
+}
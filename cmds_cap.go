@@ -81,6 +81,9 @@ func (t *TPMContext) GetCapability(capability Capability, property, propertyCoun
 			case CapabilityAuthPolicies:
 				capabilityData.Data.Data = append(capabilityData.Data.AuthPolicies(), data.Data.AuthPolicies()...)
 				s = len(data.Data.AuthPolicies())
+			case CapabilityACT:
+				capabilityData.Data.Data = append(capabilityData.Data.ACTData(), data.Data.ACTData()...)
+				s = len(data.Data.ACTData())
 			}
 			nextProperty += uint32(s)
 			remaining -= uint32(s)
@@ -207,6 +210,18 @@ func (t *TPMContext) GetCapabilityAuthPolicies(first Handle, propertyCount uint3
 	return data.Data.AuthPolicies(), nil
 }
 
+// GetCapabilityACT is a helper function that wraps around TPMContext.GetCapability, and returns the timeout and state of the
+// authenticated countdown timers (ACTs) implemented by the TPM. The first parameter indicates the handle of the first ACT for which
+// to return data. If this ACT isn't implemented, then data for the next implemented ACT is returned instead. The propertyCount
+// parameter indicates the number of ACTs for which to return data.
+func (t *TPMContext) GetCapabilityACT(first Handle, propertyCount uint32, sessions ...SessionContext) (ACTDataList, error) {
+	data, err := t.GetCapability(CapabilityACT, uint32(first), propertyCount, sessions...)
+	if err != nil {
+		return nil, err
+	}
+	return data.Data.ACTData(), nil
+}
+
 // TPMManufacturer corresponds to the TPM manufacturer and is returned when querying the value PropertyManufacturer with
 // TPMContext.GetCapabilityTPMProperties
 type TPMManufacturer uint32
@@ -234,6 +249,8 @@ func (t *TPMContext) IsTPM2() (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	// The response isn't processed, so complete the trace here.
+	t.completeCommandTrace(ctx.trace, nil)
 	if ctx.responseTag == TagNoSessions {
 		return true, nil
 	}
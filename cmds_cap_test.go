@@ -5,6 +5,7 @@
 package tpm2_test
 
 import (
+	"encoding/binary"
 	"fmt"
 	"testing"
 
@@ -26,6 +27,10 @@ func TestGetCapabilityAlgs(t *testing.T) {
 
 	count := 0
 	expected := 16
+	if useSimulator {
+		// The in-process simulator doesn't implement ECDAA.
+		expected--
+	}
 
 	for _, prop := range data {
 		var a AlgorithmAttributes
@@ -258,6 +263,14 @@ func TestGetManufacturer(t *testing.T) {
 		t.Fatalf("GetManufacturer failed: %v", err)
 	}
 
+	if useSimulator {
+		// The in-process simulator reports a manufacturer ID of "SIM".
+		if id != TPMManufacturer(binary.BigEndian.Uint32([]byte("SIM\x00"))) {
+			t.Errorf("Unexpected manufacturer: %v", id)
+		}
+		return
+	}
+
 	m := fmt.Sprintf("%s", id)
 	switch m {
 	case "IBM", "Microsoft":
@@ -363,3 +376,79 @@ func TestIsTPM2(t *testing.T) {
 		t.Errorf("IsTPM2 returned the wrong result")
 	}
 }
+
+func TestGetCapabilityACT(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	acts, err := tpm.GetCapabilityACT(HandleACT0, CapabilityMaxProperties)
+	if err != nil {
+		t.Fatalf("GetCapabilityACT failed: %v", err)
+	}
+
+	for _, act := range acts {
+		if act.Handle < HandleACT0 || act.Handle > HandleACTF {
+			t.Errorf("Unexpected handle %v", act.Handle)
+		}
+	}
+}
+
+type maxCommandSizeScriptedTransport struct {
+	*scriptedTransport
+	maxCommandSize int
+}
+
+func (t *maxCommandSizeScriptedTransport) MaxCommandSize() int {
+	return t.maxCommandSize
+}
+
+func TestMaxCommandSize(t *testing.T) {
+	for _, data := range []struct {
+		desc           string
+		transportLimit int
+		expected       int
+	}{
+		{
+			desc:     "TPMLimit",
+			expected: 8192,
+		},
+		{
+			desc:           "TransportLimit",
+			transportLimit: 4096,
+			expected:       4096,
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			s := &scriptedTransport{}
+			s.addResponse(t, Success, false, CapabilityData{
+				Capability: CapabilityTPMProperties,
+				Data: CapabilitiesU{Data: TaggedTPMPropertyList{
+					{Property: PropertyInputBuffer, Value: 1024},
+					{Property: PropertyNVBufferMax, Value: 1024},
+					{Property: PropertyMaxCommandSize, Value: 8192}}}})
+
+			var transport Transport = s
+			if data.transportLimit > 0 {
+				transport = &maxCommandSizeScriptedTransport{scriptedTransport: s, maxCommandSize: data.transportLimit}
+			}
+			tpm, _ := NewTPMContext(transport)
+			defer closeTPM(t, tpm)
+
+			size, err := tpm.MaxCommandSize()
+			if err != nil {
+				t.Fatalf("MaxCommandSize failed: %v", err)
+			}
+			if size != data.expected {
+				t.Errorf("Unexpected size %d", size)
+			}
+
+			// The properties should only be obtained from the TPM once.
+			if _, err := tpm.MaxCommandSize(); err != nil {
+				t.Errorf("MaxCommandSize failed: %v", err)
+			}
+			if len(s.commands) != 1 {
+				t.Errorf("Unexpected number of commands (%d)", len(s.commands))
+			}
+		})
+	}
+}
@@ -20,8 +20,31 @@ func (t *TPMContext) ReadClock(sessions ...SessionContext) (*TimeInfo, error) {
 	return &currentTime, nil
 }
 
-// func (t *TPMContext) ClockSet(auth Handle, newTime uint64, authAuth interface{}) error {
-// }
+// ClockSet executes the TPM2_ClockSet command to advance the value of the TPM's Clock to the value of newTime. The TPM's Clock can
+// only be moved forward with this command. The authContext parameter must correspond to HandleOwner or HandlePlatform. The command
+// requires authorization with the user auth role for authContext, with session based authorization provided via
+// authContextAuthSession.
+//
+// If newTime is earlier than the current value of the TPM's Clock, or it is larger than the maximum value that the TPM's Clock can
+// hold, a *TPMParameterError error with an error code of ErrorValue will be returned for parameter index 1.
+//
+// On success, the TPM's Clock will be set to newTime, and subsequent commands that read the clock (eg, TPMContext.ReadClock) will
+// observe the updated value. The new value is written to NV memory immediately.
+func (t *TPMContext) ClockSet(authContext ResourceContext, newTime uint64, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandClockSet, sessions,
+		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, Delimiter,
+		newTime)
+}
 
-// func (t *TPMContext) ClockRateAdjust(auth Handle, rateAdjust ClockAdjust, authAuth interface{}) error {
-// }
+// ClockRateAdjust executes the TPM2_ClockRateAdjust command to adjust the rate at which the TPM's Clock and Time are updated, in
+// order to compensate for any inaccuracy in the TPM's oscillator. The rateAdjust argument specifies the size and direction of the
+// adjustment. The authContext parameter must correspond to HandleOwner or HandlePlatform. The command requires authorization with
+// the user auth role for authContext, with session based authorization provided via authContextAuthSession.
+//
+// The size of each adjustment step is TPM specific, and the TPM will not adjust the rate beyond the limits defined by the TPM
+// library specification.
+func (t *TPMContext) ClockRateAdjust(authContext ResourceContext, rateAdjust ClockAdjust, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandClockRateAdjust, sessions,
+		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, Delimiter,
+		rateAdjust)
+}
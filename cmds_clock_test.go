@@ -0,0 +1,88 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+)
+
+func TestClockSet(t *testing.T) {
+	tpm, _ := openTPMSimulatorForTesting(t)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandClockSet)
+
+	run := func(t *testing.T, auth ResourceContext) {
+		time, err := tpm.ReadClock()
+		if err != nil {
+			t.Fatalf("ReadClock failed: %v", err)
+		}
+
+		newTime := time.ClockInfo.Clock + 3600000
+		if err := tpm.ClockSet(auth, newTime, nil); err != nil {
+			t.Fatalf("ClockSet failed: %v", err)
+		}
+
+		time, err = tpm.ReadClock()
+		if err != nil {
+			t.Fatalf("ReadClock failed: %v", err)
+		}
+		if time.ClockInfo.Clock < newTime {
+			t.Errorf("Unexpected clock value (got %d, expected >= %d)", time.ClockInfo.Clock, newTime)
+		}
+
+		err = tpm.ClockSet(auth, newTime-1000, nil)
+		if !IsTPMParameterError(err, ErrorValue, CommandClockSet, 1) {
+			t.Errorf("Unexpected error when setting the clock backwards: %v", err)
+		}
+	}
+
+	t.Run("Owner", func(t *testing.T) {
+		run(t, tpm.OwnerHandleContext())
+	})
+	t.Run("Platform", func(t *testing.T) {
+		run(t, tpm.PlatformHandleContext())
+	})
+}
+
+func TestClockRateAdjust(t *testing.T) {
+	tpm, _ := openTPMSimulatorForTesting(t)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandClockRateAdjust)
+
+	for _, data := range []struct {
+		desc    string
+		adjust  ClockAdjust
+		restore ClockAdjust
+	}{
+		{
+			desc:    "CoarseFaster",
+			adjust:  ClockAdjustCoarseFaster,
+			restore: ClockAdjustCoarseSlower,
+		},
+		{
+			desc:    "FineSlower",
+			adjust:  ClockAdjustFineSlower,
+			restore: ClockAdjustFineFaster,
+		},
+		{
+			desc:    "NoChange",
+			adjust:  ClockAdjustNoChange,
+			restore: ClockAdjustNoChange,
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			if err := tpm.ClockRateAdjust(tpm.OwnerHandleContext(), data.adjust, nil); err != nil {
+				t.Fatalf("ClockRateAdjust failed: %v", err)
+			}
+			if err := tpm.ClockRateAdjust(tpm.OwnerHandleContext(), data.restore, nil); err != nil {
+				t.Errorf("ClockRateAdjust failed: %v", err)
+			}
+		})
+	}
+}
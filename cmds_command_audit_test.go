@@ -14,6 +14,8 @@ func TestSetCommandCodeAuditStatus(t *testing.T) {
 	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy|testCapabilityEndorsementHierarchy|testCapabilitySetCommandCodeAuditStatus)
 	defer closeTPM(t, tpm)
 
+	skipIfCommandNotSupported(t, tpm, CommandSetCommandCodeAuditStatus, CommandGetCommandAuditDigest)
+
 	var allCommands CommandCodeList
 	if commands, err := tpm.GetCapabilityCommands(CommandFirst, CapabilityMaxProperties); err != nil {
 		t.Fatalf("GetCapability failed: %v", err)
@@ -66,8 +66,53 @@ func (t *TPMContext) Duplicate(objectContext, newParentContext ResourceContext,
 	return encryptionKeyOut, duplicate, outSymSeed, nil
 }
 
-// func (t *TPMContext) Rewrap(oldParent, newParent HandleContext, inDuplicate Private, name Name, inSymSeed EncryptedSecret, oldParentAuth interface{}, sessions ...SessionContext) (Private, EncryptedSecret, error) {
-// }
+// Rewrap executes the TPM2_Rewrap command in order to remove the outer duplication wrapper from the duplicated object specified by
+// the inDuplicate and name arguments, which was created for the parent associated with oldParent, and then apply a new outer
+// duplication wrapper for the parent associated with newParent. This allows an object to be moved between parents via a rewrapping
+// authority without exposing its sensitive area. The name argument must be the name of the duplicated object. The seed used to
+// generate the symmetric key and HMAC key for the existing outer duplication wrapper, encrypted using the methods defined by
+// oldParent, must be provided via the inSymSeed argument.
+//
+// If oldParent is nil, then inDuplicate is assumed to not have an outer duplication wrapper. If newParent is nil, then no outer
+// duplication wrapper is applied to the returned duplicate.
+//
+// This command requires authorization with the user auth role for oldParent, with session based authorization provided via
+// oldParentAuthSession.
+//
+// If oldParent or newParent are not associated with a storage parent, a *TPMHandleError error with an error code of ErrorType will
+// be returned for handle index 1 or 2 respectively.
+//
+// If oldParent is associated with a RSA key and the size of inSymSeed does not match the size of the key's public modulus, a
+// *TPMParameterError error with an error code of ErrorSize will be returned for parameter index 3. If oldParent is associated with
+// an ECC key and inSymSeed does not contain enough data to unmarshal a ECC point or the ECC point cannot be unmarshalled, a
+// *TPMParameterError error with an error code of ErrorInsufficient or ErrorECCPoint will be returned for parameter index 3. If the
+// seed cannot be recovered from inSymSeed, a *TPMParameterError error with an error code of ErrorValue will be returned for
+// parameter index 3.
+//
+// If the integrity value or IV for the outer duplication wrapper of inDuplicate cannot be unmarshalled correctly, a
+// *TPMParameterError error with an error code of either ErrorInsufficient or ErrorSize will be returned for parameter index 1. If
+// the integrity check fails, a *TPMParameterError error with an error code of ErrorIntegrity will be returned for parameter index 1.
+//
+// If newParent corresponds to an ECC key and the public point of the key is not on the curve specified by the key, a *TPMError
+// error with an error code of ErrorKey will be returned.
+//
+// On success, the object protected with an outer duplication wrapper for newParent (if it was provided) is returned. If newParent
+// was provided, the seed used to generate the symmetric key and the HMAC key for the new outer duplication wrapper is encrypted using
+// the methods defined by newParent and returned as an EncryptedSecret.
+func (t *TPMContext) Rewrap(oldParent, newParent ResourceContext, inDuplicate Private, name Name, inSymSeed EncryptedSecret, oldParentAuthSession SessionContext, sessions ...SessionContext) (Private, EncryptedSecret, error) {
+	var outDuplicate Private
+	var outSymSeed EncryptedSecret
+
+	if err := t.RunCommand(CommandRewrap, sessions,
+		ResourceContextWithSession{Context: oldParent, Session: oldParentAuthSession}, newParent, Delimiter,
+		inDuplicate, name, inSymSeed, Delimiter,
+		Delimiter,
+		&outDuplicate, &outSymSeed); err != nil {
+		return nil, nil, err
+	}
+
+	return outDuplicate, outSymSeed, nil
+}
 
 // Import executes the TPM2_Import command in order to encrypt the sensitive area of the object associated with the objectPublic and
 // duplicate arguments with the symmetric algorithm of the storage parent associated with parentContext, so that it can be loaded and
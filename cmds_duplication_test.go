@@ -281,6 +281,110 @@ type sensitiveSized struct {
 	Ptr *Sensitive `tpm2:"sized"`
 }
 
+func TestRewrap(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	oldParent := createRSASrkForTesting(t, tpm, nil)
+	defer flushContext(t, tpm, oldParent)
+	newParent := createECCSrkForTesting(t, tpm, nil)
+	defer flushContext(t, tpm, newParent)
+
+	trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+	trial.PolicyCommandCode(CommandDuplicate)
+
+	template := Public{
+		Type:       ObjectTypeRSA,
+		NameAlg:    HashAlgorithmSHA256,
+		Attrs:      AttrSensitiveDataOrigin | AttrUserWithAuth | AttrNoDA | AttrSign,
+		AuthPolicy: trial.GetDigest(),
+		Params: PublicParamsU{
+			Data: &RSAParams{
+				Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+				Scheme:    RSAScheme{Scheme: RSASchemeNull},
+				KeyBits:   2048,
+				Exponent:  0}}}
+	priv, pub, _, _, _, err := tpm.Create(oldParent, nil, &template, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	object, err := tpm.Load(oldParent, priv, pub, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer verifyContextFlushed(t, tpm, object)
+
+	sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	defer verifyContextFlushed(t, tpm, sessionContext)
+
+	if err := tpm.PolicyCommandCode(sessionContext, CommandDuplicate); err != nil {
+		t.Fatalf("PolicyCommandCode failed: %v", err)
+	}
+
+	_, duplicate, symSeed, err := tpm.Duplicate(object, oldParent, nil, nil, sessionContext)
+	if err != nil {
+		t.Fatalf("Duplicate failed: %v", err)
+	}
+
+	// Flush the original object so that there is a free transient object slot for the imported object.
+	name := object.Name()
+	flushContext(t, tpm, object)
+
+	run := func(t *testing.T, newParentContext ResourceContext) (Private, EncryptedSecret) {
+		outDuplicate, outSymSeed, err := tpm.Rewrap(oldParent, newParentContext, duplicate, name, symSeed, nil)
+		if err != nil {
+			t.Fatalf("Rewrap failed: %v", err)
+		}
+
+		if newParentContext == nil && len(outSymSeed) > 0 {
+			t.Errorf("Rewrap returned an unexpected seed")
+		}
+
+		priv, err := tpm.Import(newParent, nil, pub, outDuplicate, outSymSeed, nil, nil)
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		imported, err := tpm.Load(newParent, priv, pub, nil)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		defer flushContext(t, tpm, imported)
+
+		if !bytes.Equal(imported.Name(), name) {
+			t.Errorf("Imported object has the wrong name")
+		}
+
+		return outDuplicate, outSymSeed
+	}
+
+	t.Run("NewParent", func(t *testing.T) {
+		run(t, newParent)
+	})
+
+	t.Run("NoNewParent", func(t *testing.T) {
+		outDuplicate, _ := run(t, nil)
+
+		var sensitive sensitiveSized
+		if _, err := mu.UnmarshalFromBytes(outDuplicate, &sensitive); err != nil {
+			t.Fatalf("UnmarshalFromBytes failed: %v", err)
+		}
+		if sensitive.Ptr.Type != template.Type {
+			t.Errorf("Unexpected duplicate type")
+		}
+	})
+
+	t.Run("WrongName", func(t *testing.T) {
+		_, _, err := tpm.Rewrap(oldParent, newParent, duplicate, oldParent.Name(), symSeed, nil)
+		if !IsTPMParameterError(err, ErrorIntegrity, CommandRewrap, 1) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
+
 func TestImport(t *testing.T) {
 	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
 	defer closeTPM(t, tpm)
@@ -175,8 +175,23 @@ func (t *TPMContext) PolicyPCR(policySession SessionContext, pcrDigest Digest, p
 		pcrDigest, pcrs)
 }
 
-// func (t *TPMContext) PolicyLocality(policySession HandleContext, locality Locality, sessions ...SessionContext) error {
-// }
+// PolicyLocality executes the TPM2_PolicyLocality command to gate a policy based on the locality at which the command being
+// authorized is executed, and is a deferred assertion. The locality argument is a bitmask of the permitted localities, or a single
+// extended locality.
+//
+// If locality is zero, a *TPMParameterError error with an error code of ErrorRange will be returned for parameter index 1. If this
+// command has been executed previously in this session and the value of locality doesn't intersect with the permitted localities
+// recorded on the session context, a *TPMParameterError error with an error code of ErrorRange will be returned for parameter index
+// 1.
+//
+// On successful completion, the policy digest of the session context associated with policySession will be extended to include the
+// value of locality, and the permitted localities will be recorded on the session context. When the session is used, a *TPMError
+// error with an error code of WarningLocality will be returned if the command is not executed at one of the permitted localities.
+func (t *TPMContext) PolicyLocality(policySession SessionContext, locality Locality, sessions ...SessionContext) error {
+	return t.RunCommand(CommandPolicyLocality, sessions,
+		policySession, Delimiter,
+		locality)
+}
 
 // PolicyNV executes the TPM2_PolicyNV command to gate a policy based on the contents of the NV index associated with nvIndex, and is
 // an immediate assertion. The caller specifies a value to be used for the comparison via the operandB argument, an offset from the
@@ -253,8 +268,16 @@ func (t *TPMContext) PolicyCommandCode(policySession SessionContext, code Comman
 		code)
 }
 
-// func (t *TPMContext) PolicyPhysicalPresence(policySession HandleContext, sessions ...SessionContext) error {
-// }
+// PolicyPhysicalPresence executes the TPM2_PolicyPhysicalPresence command to indicate that physical presence will need to be
+// asserted at the time that the session is used for authorization, and is a deferred assertion.
+//
+// On successful completion, the policy digest of the session context associated with policySession will be extended to record that
+// this assertion has been executed, and a flag will be set on the session context to indicate that physical presence must be
+// asserted when the session is used. If physical presence is not asserted when the session is used, a *TPMError error with an error
+// code of ErrorPP will be returned.
+func (t *TPMContext) PolicyPhysicalPresence(policySession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandPolicyPhysicalPresence, sessions, policySession)
+}
 
 // PolicyCpHash executes the TPM2_PolicyCpHash command to bind a policy to a specific command and set of command parameters. This is
 // a deferred assertion.
@@ -413,8 +436,55 @@ func (t *TPMContext) PolicyNvWritten(policySession SessionContext, writtenSet bo
 	return t.RunCommand(CommandPolicyNvWritten, sessions, policySession, Delimiter, writtenSet)
 }
 
-// func (t *TPMContext) PolicyTemplate(policySession HandleContext, templateHash Digest, sessions ...SessionContext) error {
-// }
+// PolicyTemplate executes the TPM2_PolicyTemplate command to bind a policy to a specific object creation template, and is a deferred
+// assertion. The templateHash argument is the digest of the marshalled public area template that must be supplied to
+// TPMContext.Create, TPMContext.CreatePrimary or TPMContext.CreateLoaded when the session is used, computed with the digest algorithm
+// of the session. This allows a policy to control the type of objects that can be created under a parent object or hierarchy.
+//
+// If this command has been executed previously in this session with a different value of templateHash, or the session has already
+// been bound to a command parameter digest with TPMContext.PolicyCpHash or a set of names with TPMContext.PolicyNameHash, a
+// *TPMParameterError error with an error code of ErrorCpHash will be returned for parameter index 1. If the length of templateHash
+// does not match the digest algorithm of the session, a *TPMParameterError error with an error code of ErrorSize will be returned
+// for parameter index 1.
+//
+// On successful completion, the policy digest of the session context associated with policySession will be extended to include the
+// value of templateHash, and the value of templateHash will be recorded on the session context. When the session is used, the TPM
+// will return a *TPMError error with an error code of ErrorPolicyFail if the template supplied to the command being authorized
+// doesn't match templateHash.
+func (t *TPMContext) PolicyTemplate(policySession SessionContext, templateHash Digest, sessions ...SessionContext) error {
+	return t.RunCommand(CommandPolicyTemplate, sessions,
+		policySession, Delimiter,
+		templateHash)
+}
 
-// func (t *TPMContext) PolicyAuthorizeNV(authContext, nvIndex, policySession HandleContext, authContextAuth interface{}, sessions ...SessionContext) error {
-// }
+// PolicyAuthorizeNV executes the TPM2_PolicyAuthorizeNV command, which allows policies to change by storing the approved policy
+// digest in the NV index associated with nvIndex. This is an immediate assertion. Unlike TPMContext.PolicyAuthorize, revoking an
+// approved policy doesn't require a signing key, as the approved policy can be changed by writing a new digest to the NV index.
+// The NV index must contain a TPMT_HA structure, consisting of a digest algorithm followed by a digest.
+//
+// The command requires authorization to read the NV index, defined by the state of the AttrNVPPRead, AttrNVOwnerRead,
+// AttrNVAuthRead and AttrNVPolicyRead attributes. The handle used for authorization is specified via authContext. If the NV index
+// has the AttrNVPPRead attribute, authorization can be satisfied with HandlePlatform. If the NV index has the AttrNVOwnerRead
+// attribute, authorization can be satisfied with HandleOwner. If the NV index has the AttrNVAuthRead or AttrNVPolicyRead attribute,
+// authorization can be satisfied with nvIndex. The command requires authorization with the user auth role for authContext, with
+// session based authorization provided via authContextAuthSession. If the resource associated with authContext is not permitted to
+// authorize this access and policySession does not correspond to a trial session, a *TPMError error with an error code of
+// ErrorNVAuthorization will be returned.
+//
+// If the index associated with nvIndex has the AttrNVReadLocked attribute set, a *TPMError error with an error code of
+// ErrorNVLocked will be returned. If the index associated with nvIndex has not been initialized (ie, the AttrNVWritten attribute is
+// not set), a *TPMError with an error code of ErrorNVUninitialized will be returned. If the index associated with nvIndex has the
+// type NVTypeCounter, NVTypeBits or NVTypeExtend, a *TPMHandleError error with an error code of ErrorAttributes will be returned
+// for handle index 2.
+//
+// If policySession does not correspond to a trial session and the contents of the NV index do not match the current policy digest
+// of the session context associated with policySession, a *TPMError error with an error code of ErrorValue will be returned. If
+// the contents of the NV index are not a valid TPMT_HA structure, or the digest algorithm is not the same as the algorithm of the
+// session, a *TPMError error with an error code of ErrorHash will be returned.
+//
+// On successful completion, the policy digest of the session context associated with policySession is cleared, and then extended
+// to include the name of nvIndex.
+func (t *TPMContext) PolicyAuthorizeNV(authContext, nvIndex ResourceContext, policySession SessionContext, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandPolicyAuthorizeNV, sessions,
+		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, nvIndex, policySession)
+}
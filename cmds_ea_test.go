@@ -649,6 +649,35 @@ func TestPolicyCommandCode(t *testing.T) {
 	}
 }
 
+func TestPolicyPhysicalPresence(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandPolicyPhysicalPresence)
+
+	trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+	trial.PolicyPhysicalPresence()
+
+	sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	defer flushContext(t, tpm, sessionContext)
+
+	if err := tpm.PolicyPhysicalPresence(sessionContext); err != nil {
+		t.Fatalf("PolicyPhysicalPresence failed: %v", err)
+	}
+
+	digest, err := tpm.PolicyGetDigest(sessionContext)
+	if err != nil {
+		t.Fatalf("PolicyGetDigest failed: %v", err)
+	}
+
+	if !bytes.Equal(digest, trial.GetDigest()) {
+		t.Errorf("Unexpected session digest")
+	}
+}
+
 func TestPolicyCpHash(t *testing.T) {
 	tpm := openTPMForTesting(t, 0)
 	defer closeTPM(t, tpm)
@@ -1052,6 +1081,66 @@ func TestPolicyPassword(t *testing.T) {
 	}
 }
 
+func TestPolicyLocality(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	for _, data := range []struct {
+		desc     string
+		locality Locality
+	}{
+		{
+			desc:     "Zero",
+			locality: LocalityZero,
+		},
+		{
+			desc:     "ZeroAndThree",
+			locality: LocalityZero | LocalityThree,
+		},
+		{
+			desc:     "Extended",
+			locality: 40,
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+			trial.PolicyLocality(data.locality)
+
+			sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+			if err != nil {
+				t.Fatalf("StartAuthSession failed: %v", err)
+			}
+			defer flushContext(t, tpm, sessionContext)
+
+			if err := tpm.PolicyLocality(sessionContext, data.locality); err != nil {
+				t.Fatalf("PolicyLocality failed: %v", err)
+			}
+
+			digest, err := tpm.PolicyGetDigest(sessionContext)
+			if err != nil {
+				t.Fatalf("PolicyGetDigest failed: %v", err)
+			}
+
+			if !bytes.Equal(digest, trial.GetDigest()) {
+				t.Errorf("Unexpected session digest")
+			}
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+		if err != nil {
+			t.Fatalf("StartAuthSession failed: %v", err)
+		}
+		defer flushContext(t, tpm, sessionContext)
+
+		err = tpm.PolicyLocality(sessionContext, 0)
+		if !IsTPMParameterError(err, ErrorRange, CommandPolicyLocality, 1) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
+
 func TestPolicyNV(t *testing.T) {
 	tpm := openTPMForTesting(t, testCapabilityOwnerPersist)
 	defer closeTPM(t, tpm)
@@ -1300,3 +1389,145 @@ func TestPolicyNvWritten(t *testing.T) {
 		})
 	}
 }
+
+func TestPolicyTemplate(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandPolicyTemplate)
+
+	for _, data := range []struct {
+		desc     string
+		template *Public
+	}{
+		{
+			desc: "RSA",
+			template: &Public{
+				Type:    ObjectTypeRSA,
+				NameAlg: HashAlgorithmSHA256,
+				Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrSign,
+				Params: PublicParamsU{
+					Data: &RSAParams{
+						Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+						Scheme:    RSAScheme{Scheme: RSASchemeNull},
+						KeyBits:   2048,
+						Exponent:  0}}},
+		},
+		{
+			desc: "ECC",
+			template: &Public{
+				Type:    ObjectTypeECC,
+				NameAlg: HashAlgorithmSHA256,
+				Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrSign,
+				Params: PublicParamsU{
+					Data: &ECCParams{
+						Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+						Scheme:    ECCScheme{Scheme: ECCSchemeNull},
+						CurveID:   ECCCurveNIST_P256,
+						KDF:       KDFScheme{Scheme: KDFAlgorithmNull}}}},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			b, err := mu.MarshalToBytes(data.template)
+			if err != nil {
+				t.Fatalf("MarshalToBytes failed: %v", err)
+			}
+			h := crypto.SHA256.New()
+			h.Write(b)
+			templateHash := h.Sum(nil)
+
+			trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+			trial.PolicyTemplate(templateHash)
+
+			sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+			if err != nil {
+				t.Fatalf("StartAuthSession failed: %v", err)
+			}
+			defer flushContext(t, tpm, sessionContext)
+
+			if err := tpm.PolicyTemplate(sessionContext, templateHash); err != nil {
+				t.Fatalf("PolicyTemplate failed: %v", err)
+			}
+
+			digest, err := tpm.PolicyGetDigest(sessionContext)
+			if err != nil {
+				t.Fatalf("PolicyGetDigest failed: %v", err)
+			}
+
+			if !bytes.Equal(digest, trial.GetDigest()) {
+				t.Errorf("Unexpected session digest")
+			}
+		})
+	}
+}
+
+func TestPolicyAuthorizeNV(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerPersist)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandPolicyAuthorizeNV)
+
+	owner := tpm.OwnerHandleContext()
+
+	nvPub := NVPublic{
+		Index:   0x0181ffff,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   NVTypeOrdinary.WithAttrs(AttrNVAuthRead | AttrNVAuthWrite),
+		Size:    34}
+	index, err := tpm.NVDefineSpace(owner, nil, &nvPub, nil)
+	if err != nil {
+		t.Fatalf("NVDefineSpace failed: %v", err)
+	}
+	defer undefineNVSpace(t, tpm, index, owner)
+
+	approved, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+	approved.PolicyAuthValue()
+
+	b, _ := mu.MarshalToBytes(HashAlgorithmSHA256, mu.RawBytes(approved.GetDigest()))
+	if err := tpm.NVWrite(index, index, b, 0, nil); err != nil {
+		t.Fatalf("NVWrite failed: %v", err)
+	}
+
+	trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+	trial.PolicyAuthorizeNV(index.Name())
+
+	t.Run("Approved", func(t *testing.T) {
+		sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+		if err != nil {
+			t.Fatalf("StartAuthSession failed: %v", err)
+		}
+		defer flushContext(t, tpm, sessionContext)
+
+		if err := tpm.PolicyAuthValue(sessionContext); err != nil {
+			t.Fatalf("PolicyAuthValue failed: %v", err)
+		}
+		if err := tpm.PolicyAuthorizeNV(index, index, sessionContext, nil); err != nil {
+			t.Fatalf("PolicyAuthorizeNV failed: %v", err)
+		}
+
+		digest, err := tpm.PolicyGetDigest(sessionContext)
+		if err != nil {
+			t.Fatalf("PolicyGetDigest failed: %v", err)
+		}
+
+		if !bytes.Equal(digest, trial.GetDigest()) {
+			t.Errorf("Unexpected session digest")
+		}
+	})
+
+	t.Run("NotApproved", func(t *testing.T) {
+		sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+		if err != nil {
+			t.Fatalf("StartAuthSession failed: %v", err)
+		}
+		defer flushContext(t, tpm, sessionContext)
+
+		if err := tpm.PolicyCommandCode(sessionContext, CommandNVRead); err != nil {
+			t.Fatalf("PolicyCommandCode failed: %v", err)
+		}
+		err = tpm.PolicyAuthorizeNV(index, index, sessionContext, nil)
+		if !IsTPMError(err, ErrorValue, CommandPolicyAuthorizeNV) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+// Section 19 - Ephemeral EC Keys
+
+// Commit executes the TPM2_Commit command, which performs the first part of an anonymous signing operation (such as ECDAA) with the
+// key associated with signContext. The TPM generates an ephemeral value and stores it internally, associating it with the returned
+// counter value. The ephemeral value can then be used by a subsequent call to TPMContext.Sign with a scheme that has a count
+// parameter set to the returned counter. The command requires authorization with the user auth role for signContext, with session
+// based authorization provided via signContextAuthSession.
+//
+// If signContext does not correspond to an ECC key, a *TPMHandleError error with an error code of ErrorKey will be returned for
+// handle index 1. If the key does not have the AttrSign attribute, a *TPMHandleError error with an error code of ErrorAttributes will
+// be returned for handle index 1. If the key has a scheme that isn't an anonymous scheme, a *TPMHandleError error with an error code
+// of ErrorScheme will be returned for handle index 1.
+//
+// The optional p1, s2 and y2 arguments are used to compute the points returned from this function. If p1 is provided and is not on
+// the curve of the key, a *TPMParameterError error with an error code of ErrorECCPoint will be returned for parameter index 1. If
+// only one of s2 and y2 are provided, a *TPMParameterError error with an error code of ErrorSize will be returned for parameter index
+// 2 or 3. If s2 and y2 are provided and the resulting point is not on the curve of the key, a *TPMParameterError error with an error
+// code of ErrorNoResult will be returned for parameter index 2.
+//
+// The p1 argument can be encrypted using a session with the AttrCommandEncrypt attribute set, and the returned K point can be
+// encrypted using a session with the AttrResponseEncrypt attribute set.
+//
+// On success, the points K, L and E are returned, along with the counter value associated with the ephemeral value. K and L are only
+// returned if s2 and y2 are provided.
+func (t *TPMContext) Commit(signContext ResourceContext, p1 *ECCPoint, s2 SensitiveData, y2 ECCParameter, signContextAuthSession SessionContext, sessions ...SessionContext) (k, l, e *ECCPoint, counter uint16, err error) {
+	var kSized eccPointSized
+	var lSized eccPointSized
+	var eSized eccPointSized
+	if err := t.RunCommand(CommandCommit, sessions,
+		ResourceContextWithSession{Context: signContext, Session: signContextAuthSession}, Delimiter,
+		eccPointSized{p1}, s2, y2, Delimiter,
+		Delimiter,
+		&kSized, &lSized, &eSized, &counter); err != nil {
+		return nil, nil, nil, 0, err
+	}
+
+	return kSized.Ptr, lSized.Ptr, eSized.Ptr, counter, nil
+}
+
+// ECEphemeral executes the TPM2_EC_Ephemeral command to create an ephemeral key on the curve identified by curveID, for use in a
+// two-phase key exchange protocol. The private part of the ephemeral key is stored inside the TPM and associated with the returned
+// counter value. It can then be used by a subsequent call to TPMContext.ZGen2Phase.
+//
+// If curveID does not correspond to a curve supported by the TPM, a *TPMParameterError error with an error code of ErrorCurve will
+// be returned for parameter index 1.
+//
+// On success, the public point of the ephemeral key and the associated counter value are returned.
+func (t *TPMContext) ECEphemeral(curveID ECCCurve, sessions ...SessionContext) (q *ECCPoint, counter uint16, err error) {
+	var qSized eccPointSized
+	if err := t.RunCommand(CommandECEphemeral, sessions,
+		Delimiter,
+		curveID, Delimiter,
+		Delimiter,
+		&qSized, &counter); err != nil {
+		return nil, 0, err
+	}
+
+	return qSized.Ptr, counter, nil
+}
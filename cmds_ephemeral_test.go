@@ -0,0 +1,100 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"crypto"
+	"math/big"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+)
+
+func TestECEphemeral(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandECEphemeral)
+
+	q1, counter1, err := tpm.ECEphemeral(ECCCurveNIST_P256)
+	if err != nil {
+		t.Fatalf("ECEphemeral failed: %v", err)
+	}
+	if !ECCCurveNIST_P256.GoCurve().IsOnCurve(new(big.Int).SetBytes(q1.X), new(big.Int).SetBytes(q1.Y)) {
+		t.Errorf("ECEphemeral returned a point that isn't on the curve")
+	}
+
+	q2, counter2, err := tpm.ECEphemeral(ECCCurveNIST_P256)
+	if err != nil {
+		t.Fatalf("ECEphemeral failed: %v", err)
+	}
+	if counter1 == counter2 {
+		t.Errorf("ECEphemeral returned the same counter twice")
+	}
+	if new(big.Int).SetBytes(q1.X).Cmp(new(big.Int).SetBytes(q2.X)) == 0 {
+		t.Errorf("ECEphemeral returned the same point twice")
+	}
+}
+
+func TestCommit(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandCommit)
+
+	primary := createRSASrkForTesting(t, tpm, nil)
+	defer flushContext(t, tpm, primary)
+
+	template := Public{
+		Type:    ObjectTypeECC,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrSign,
+		Params: PublicParamsU{
+			Data: &ECCParams{
+				Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+				Scheme: ECCScheme{
+					Scheme:  ECCSchemeECDAA,
+					Details: AsymSchemeU{Data: &SigSchemeECDAA{HashAlg: HashAlgorithmSHA256}}},
+				CurveID: ECCCurveBN_P256,
+				KDF:     KDFScheme{Scheme: KDFAlgorithmNull}}}}
+	sensitive := SensitiveCreate{UserAuth: testAuth}
+	priv, pub, _, _, _, err := tpm.Create(primary, &sensitive, &template, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	key, err := tpm.Load(primary, priv, pub, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer flushContext(t, tpm, key)
+	key.SetAuthValue(testAuth)
+
+	k, l, _, counter, err := tpm.Commit(key, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if k != nil || l != nil {
+		t.Errorf("Commit returned unexpected points")
+	}
+
+	h := crypto.SHA256.New()
+	h.Write([]byte("message"))
+
+	signature, err := tpm.Sign(key, h.Sum(nil), &SigScheme{
+		Scheme:  SigSchemeAlgECDAA,
+		Details: SigSchemeU{Data: &SigSchemeECDAA{HashAlg: HashAlgorithmSHA256, Count: counter}}}, nil, nil)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if signature.SigAlg != SigSchemeAlgECDAA {
+		t.Errorf("Unexpected signature algorithm")
+	}
+
+	if _, err := tpm.Sign(key, h.Sum(nil), &SigScheme{
+		Scheme:  SigSchemeAlgECDAA,
+		Details: SigSchemeU{Data: &SigSchemeECDAA{HashAlg: HashAlgorithmSHA256, Count: counter}}}, nil, nil); err == nil {
+		t.Errorf("Sign should fail when the commit counter has already been used")
+	}
+}
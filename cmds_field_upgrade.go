@@ -0,0 +1,175 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+// Section 27 - Field Upgrade
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// FieldUpgradeStart executes the TPM2_FieldUpgradeStart command to begin a field upgrade sequence. The fuDigest argument is the
+// digest of the first block of the firmware upgrade image, and manifestSignature is a signature over fuDigest generated by the TPM
+// vendor's field upgrade signing key. The public part of this key must be loaded in to the TPM (eg, with TPMContext.LoadExternal)
+// and is specified by keyContext. The format of the firmware upgrade image and the key used to sign it are TPM vendor specific.
+//
+// The authContext parameter must correspond to HandlePlatform. The command requires authorization with the user auth role for
+// authContext, with session based authorization provided via authContextAuthSession.
+//
+// If keyContext does not correspond to a key that is approved by the TPM vendor for field upgrade, or the signature is not valid,
+// a *TPMParameterError error with an error code of ErrorSignature will be returned for parameter index 2.
+//
+// On success, the TPM will be in field upgrade mode and the firmware upgrade image should be supplied with
+// TPMContext.FieldUpgradeData. While in field upgrade mode, the TPM may not be able to execute other commands.
+func (t *TPMContext) FieldUpgradeStart(authContext, keyContext ResourceContext, fuDigest Digest, manifestSignature *Signature, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandFieldUpgradeStart, sessions,
+		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, keyContext, Delimiter,
+		fuDigest, manifestSignature)
+}
+
+// FieldUpgradeData executes the TPM2_FieldUpgradeData command to supply a single block of the firmware upgrade image to the TPM,
+// after a field upgrade sequence has been started with TPMContext.FieldUpgradeStart. The size and format of each block is TPM
+// vendor specific. TPMContext.FieldUpgradeDataFromReader can be used to supply an entire image from an io.Reader.
+//
+// If the digest of fuData doesn't match the digest expected by the TPM for the next block, a *TPMParameterError error with an error
+// code of ErrorValue will be returned for parameter index 1.
+//
+// On success, the tagged digest of the next block expected by the TPM is returned as nextDigest, along with the tagged digest of
+// the first block of the sequence as firstDigest. If the TPM has received the final block, nextDigest will have an algorithm of
+// HashAlgorithmNull.
+func (t *TPMContext) FieldUpgradeData(fuData MaxBuffer, sessions ...SessionContext) (nextDigest, firstDigest *TaggedHash, err error) {
+	var next taggedHashOrNull
+	var first TaggedHash
+
+	if err := t.RunCommand(CommandFieldUpgradeData, sessions,
+		Delimiter,
+		fuData, Delimiter,
+		Delimiter,
+		&next, &first); err != nil {
+		return nil, nil, err
+	}
+
+	return (*TaggedHash)(&next), &first, nil
+}
+
+// FieldUpgradeDataFromReader supplies the firmware upgrade image read from r to the TPM by executing the TPM2_FieldUpgradeData
+// command (via TPMContext.FieldUpgradeData) repeatedly, after a field upgrade sequence has been started with
+// TPMContext.FieldUpgradeStart. The image is sent in blocks of blockSize bytes, and the final block may be smaller. As the size of
+// each block is TPM vendor specific, blockSize should be set according to the format of the image. If blockSize is zero, the
+// TPM's input buffer size is used.
+//
+// After each block is accepted by the TPM, fn is called with the number of the block and the digests returned by the TPM. If fn
+// returns an error, no more blocks are sent and the error is returned to the caller. The fn argument may be nil.
+//
+// This function stops reading from r and returns once the TPM indicates that it has received the final block of the image. If the
+// end of r is reached before this happens, an error is returned.
+func (t *TPMContext) FieldUpgradeDataFromReader(r io.Reader, blockSize int, fn func(n int, nextDigest, firstDigest *TaggedHash) error, sessions ...SessionContext) error {
+	if blockSize == 0 {
+		if err := t.initPropertiesIfNeeded(); err != nil {
+			return err
+		}
+		blockSize = t.maxBufferSize
+	}
+	if blockSize < 0 {
+		return makeInvalidArgError("blockSize", "invalid block size")
+	}
+
+	block := make([]byte, blockSize)
+
+	for n := 0; ; n++ {
+		sz, err := io.ReadFull(r, block)
+		switch {
+		case err == io.EOF:
+			return errors.New("firmware image ended before the TPM indicated that the field upgrade is complete")
+		case err == io.ErrUnexpectedEOF:
+		case err != nil:
+			return xerrors.Errorf("cannot read block %d of firmware image: %w", n, err)
+		}
+
+		nextDigest, firstDigest, err := t.FieldUpgradeData(block[:sz], sessions...)
+		if err != nil {
+			return xerrors.Errorf("cannot send block %d of firmware image: %w", n, err)
+		}
+
+		if fn != nil {
+			if err := fn(n, nextDigest, firstDigest); err != nil {
+				return err
+			}
+		}
+
+		if nextDigest.HashAlg == HashAlgorithmNull {
+			return nil
+		}
+	}
+}
+
+// FirmwareRead executes the TPM2_FirmwareRead command to read a single block of the TPM's current firmware image, which can be
+// used to restore the firmware to the current version after a subsequent field upgrade. The sequenceNumber argument specifies
+// which block to read, and should be zero on the first call. The size and format of each block is TPM vendor specific.
+// TPMContext.NewFirmwareReader can be used to read the entire image via an io.Reader.
+//
+// If sequenceNumber is out of range, a *TPMParameterError error with an error code of ErrorValue will be returned for parameter
+// index 1.
+//
+// On success, the requested block is returned. An empty block indicates that there is no more data.
+func (t *TPMContext) FirmwareRead(sequenceNumber uint32, sessions ...SessionContext) (MaxBuffer, error) {
+	var fuData MaxBuffer
+
+	if err := t.RunCommand(CommandFirmwareRead, sessions,
+		Delimiter,
+		sequenceNumber, Delimiter,
+		Delimiter,
+		&fuData); err != nil {
+		return nil, err
+	}
+
+	return fuData, nil
+}
+
+type firmwareReader struct {
+	t              *TPMContext
+	sessions       []SessionContext
+	sequenceNumber uint32
+	buf            []byte
+	err            error
+}
+
+func (r *firmwareReader) Read(data []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		fuData, err := r.t.FirmwareRead(r.sequenceNumber, r.sessions...)
+		switch {
+		case r.sequenceNumber > 0 && IsTPMParameterError(err, ErrorValue, CommandFirmwareRead, 1):
+			r.err = io.EOF
+		case err != nil:
+			r.err = err
+		case len(fuData) == 0:
+			r.err = io.EOF
+		default:
+			r.buf = fuData
+			r.sequenceNumber++
+		}
+	}
+
+	n = copy(data, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// NewFirmwareReader returns an io.Reader that reads the TPM's current firmware image by executing the TPM2_FirmwareRead command
+// (via TPMContext.FirmwareRead) with increasing sequence numbers, starting from zero. As a consequence, any SessionContext
+// instances provided should have the AttrContinueSession attribute defined.
+//
+// The end of the image is reached when the TPM returns an empty block, or returns an error indicating that the sequence number is
+// out of range. Any other error returned from the TPM is returned from the Read method.
+func (t *TPMContext) NewFirmwareReader(sessions ...SessionContext) io.Reader {
+	return &firmwareReader{t: t, sessions: sessions}
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha256"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+func TestFieldUpgradeDataFromReader(t *testing.T) {
+	image := make([]byte, 100)
+	for i := range image {
+		image[i] = byte(i)
+	}
+
+	digest := func(data []byte) Digest {
+		h := crypto.SHA256.New()
+		h.Write(data)
+		return h.Sum(nil)
+	}
+	first := &TaggedHash{HashAlg: HashAlgorithmSHA256, Digest: digest(image[0:40])}
+
+	transport := &scriptedTransport{}
+	transport.addResponse(t, Success, &TaggedHash{HashAlg: HashAlgorithmSHA256, Digest: digest(image[40:80])}, first)
+	transport.addResponse(t, Success, &TaggedHash{HashAlg: HashAlgorithmSHA256, Digest: digest(image[80:])}, first)
+	// TPMT_HA+ with TPM_ALG_NULL has no digest.
+	transport.addResponse(t, Success, HashAlgorithmNull, first)
+
+	tpm, _ := NewTPMContext(transport)
+	defer closeTPM(t, tpm)
+
+	var blocks int
+	if err := tpm.FieldUpgradeDataFromReader(bytes.NewReader(image), 40, func(n int, nextDigest, firstDigest *TaggedHash) error {
+		if n != blocks {
+			t.Errorf("Unexpected block number (got %d, expected %d)", n, blocks)
+		}
+		blocks++
+		if firstDigest.HashAlg != HashAlgorithmSHA256 || !bytes.Equal(firstDigest.Digest, first.Digest) {
+			t.Errorf("Unexpected firstDigest")
+		}
+		if n == 2 && nextDigest.HashAlg != HashAlgorithmNull {
+			t.Errorf("Unexpected nextDigest for final block")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("FieldUpgradeDataFromReader failed: %v", err)
+	}
+	if blocks != 3 {
+		t.Errorf("Unexpected number of blocks (%d)", blocks)
+	}
+
+	if len(transport.commands) != 3 {
+		t.Fatalf("Unexpected number of commands (%d)", len(transport.commands))
+	}
+	for i, cmd := range transport.commands {
+		if CommandCode(binary.BigEndian.Uint32(cmd[6:])) != CommandFieldUpgradeData {
+			t.Errorf("Unexpected command code for command %d", i)
+		}
+		var fuData MaxBuffer
+		if _, err := mu.UnmarshalFromBytes(cmd[10:], &fuData); err != nil {
+			t.Fatalf("UnmarshalFromBytes failed: %v", err)
+		}
+		end := (i + 1) * 40
+		if end > len(image) {
+			end = len(image)
+		}
+		if !bytes.Equal(fuData, image[i*40:end]) {
+			t.Errorf("Unexpected data for block %d", i)
+		}
+	}
+
+	t.Run("Truncated", func(t *testing.T) {
+		transport := &scriptedTransport{}
+		transport.addResponse(t, Success, &TaggedHash{HashAlg: HashAlgorithmSHA256, Digest: digest(image[40:80])}, first)
+
+		tpm, _ := NewTPMContext(transport)
+		defer closeTPM(t, tpm)
+
+		if err := tpm.FieldUpgradeDataFromReader(bytes.NewReader(image[:40]), 40, nil); err == nil {
+			t.Errorf("FieldUpgradeDataFromReader should fail if the image is truncated")
+		}
+	})
+}
+
+func TestFirmwareReader(t *testing.T) {
+	transport := &scriptedTransport{}
+	transport.addResponse(t, Success, MaxBuffer("foo"))
+	transport.addResponse(t, Success, MaxBuffer("bar"))
+	transport.addResponse(t, Success, MaxBuffer(nil))
+
+	tpm, _ := NewTPMContext(transport)
+	defer closeTPM(t, tpm)
+
+	data, err := ioutil.ReadAll(tpm.NewFirmwareReader())
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(data, []byte("foobar")) {
+		t.Errorf("Unexpected data (%x)", data)
+	}
+
+	for i, cmd := range transport.commands {
+		if CommandCode(binary.BigEndian.Uint32(cmd[6:])) != CommandFirmwareRead {
+			t.Errorf("Unexpected command code for command %d", i)
+		}
+		if binary.BigEndian.Uint32(cmd[10:]) != uint32(i) {
+			t.Errorf("Unexpected sequence number for command %d", i)
+		}
+	}
+
+	t.Run("OutOfRange", func(t *testing.T) {
+		transport := &scriptedTransport{}
+		transport.addResponse(t, Success, MaxBuffer("foo"))
+		transport.addResponse(t, 0x1c4) // TPM_RC_VALUE + TPM_RC_P + TPM_RC_1
+
+		tpm, _ := NewTPMContext(transport)
+		defer closeTPM(t, tpm)
+
+		data, err := ioutil.ReadAll(tpm.NewFirmwareReader())
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		if !bytes.Equal(data, []byte("foo")) {
+			t.Errorf("Unexpected data (%x)", data)
+		}
+	})
+}
@@ -107,12 +107,8 @@ func (t *TPMContext) SequenceComplete(sequenceContext ResourceContext, buffer Ma
 		return nil, nil, err
 	}
 
-	if validation.Hierarchy == HandleNull && len(validation.Digest) == 0 {
-		validation = nil
-	}
-
 	sequenceContext.(handleContextPrivate).invalidate()
-	return result, validation, nil
+	return result, nullHashcheckToNil(validation), nil
 }
 
 // EventSequenceComplete executes the TPM2_EventSequenceComplete command to add the last part of the data to the event sequence
@@ -169,6 +169,58 @@ func (t *TPMContext) HierarchyControl(authContext ResourceContext, enable Handle
 		enable, state)
 }
 
+// SetPrimaryPolicy executes the TPM2_SetPrimaryPolicy command to set the authorization policy for the hierarchy associated with
+// authContext, which must correspond to HandleOwner, HandleEndorsement, HandlePlatform or HandleLockout. The authPolicy argument
+// specifies the new policy digest, and hashAlg specifies the digest algorithm of the policy. If authPolicy is empty and hashAlg is
+// HashAlgorithmNull, the authorization policy for the hierarchy is cleared. The command requires authorization with the user auth
+// role for authContext, with session based authorization provided via authContextAuthSession.
+//
+// If the length of authPolicy is not consistent with hashAlg, a *TPMParameterError error with an error code of ErrorSize will be
+// returned for parameter index 1.
+//
+// On successful completion, the hierarchy associated with authContext can be authorized with a policy session that satisfies
+// authPolicy. The authorization policy of a hierarchy is not used by this package when authorizing commands, so it is not necessary
+// to update authContext.
+func (t *TPMContext) SetPrimaryPolicy(authContext ResourceContext, authPolicy Digest, hashAlg HashAlgorithmId, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandSetPrimaryPolicy, sessions,
+		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, Delimiter,
+		authPolicy, hashAlg)
+}
+
+// ChangePPS executes the TPM2_ChangePPS command to replace the platform primary seed (PPS) with a new value from the TPM's random
+// number generator. The authContext parameter must correspond to HandlePlatform. The command requires authorization with the user
+// auth role for authContext, with session based authorization provided via authContextAuthSession.
+//
+// On successful completion, all transient and persistent objects in the platform hierarchy are flushed from the TPM, and subsequent
+// use of ResourceContext instances associated with these objects will fail. Primary objects created in the platform hierarchy
+// after this will be different to those created before. The authorization policy of the platform hierarchy is cleared, but its
+// authorization value is unchanged.
+func (t *TPMContext) ChangePPS(authContext ResourceContext, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandChangePPS, sessions, ResourceContextWithSession{Context: authContext, Session: authContextAuthSession})
+}
+
+// ChangeEPS executes the TPM2_ChangeEPS command to replace the endorsement primary seed (EPS) with a new value from the TPM's random
+// number generator. The authContext parameter must correspond to HandlePlatform. The command requires authorization with the user
+// auth role for authContext, with session based authorization provided via authContextAuthSession.
+//
+// On successful completion, all transient and persistent objects in the endorsement hierarchy are flushed from the TPM, and
+// subsequent use of ResourceContext instances associated with these objects will fail. Primary objects created in the endorsement
+// hierarchy after this (including the endorsement key) will be different to those created before. The authorization value and
+// authorization policy of the endorsement hierarchy are cleared. It isn't necessary to update the ResourceContext corresponding to
+// HandleEndorsement by calling ResourceContext.SetAuthValue in order to use it in subsequent commands that require knowledge of the
+// authorization value for the hierarchy.
+func (t *TPMContext) ChangeEPS(authContext ResourceContext, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	if err := t.RunCommand(CommandChangeEPS, sessions, ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}); err != nil {
+		return err
+	}
+
+	if rc, exists := t.permanentResources[HandleEndorsement]; exists {
+		rc.auth = nil
+	}
+
+	return nil
+}
+
 // Clear executes the TPM2_Clear command to remove all context associated with the current owner. The command requires knowledge of
 // the authorization value for either the platform or lockout hierarchy. The hierarchy is specified by passing a ResourceContext
 // corresponding to either HandlePlatform or HandleLockout to authContext. The command requires authorization with the user auth
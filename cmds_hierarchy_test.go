@@ -323,6 +323,114 @@ func TestHierarchyControl(t *testing.T) {
 	})
 }
 
+func TestSetPrimaryPolicy(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	owner := tpm.OwnerHandleContext()
+
+	trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+	trial.PolicyCommandCode(CommandCreatePrimary)
+
+	if err := tpm.SetPrimaryPolicy(owner, trial.GetDigest(), HashAlgorithmSHA256, nil); err != nil {
+		t.Fatalf("SetPrimaryPolicy failed: %v", err)
+	}
+	defer func() {
+		if err := tpm.SetPrimaryPolicy(owner, nil, HashAlgorithmNull, nil); err != nil {
+			t.Errorf("SetPrimaryPolicy failed: %v", err)
+		}
+	}()
+
+	sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	defer verifyContextFlushed(t, tpm, sessionContext)
+
+	if err := tpm.PolicyCommandCode(sessionContext, CommandCreatePrimary); err != nil {
+		t.Fatalf("PolicyCommandCode failed: %v", err)
+	}
+
+	template := Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrSign,
+		Params: PublicParamsU{
+			Data: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}}}
+	objectContext, _, _, _, _, err := tpm.CreatePrimary(owner, nil, &template, nil, nil, sessionContext)
+	if err != nil {
+		t.Fatalf("CreatePrimary failed: %v", err)
+	}
+	flushContext(t, tpm, objectContext)
+
+	err = tpm.SetPrimaryPolicy(owner, make(Digest, 20), HashAlgorithmSHA256, nil)
+	if !IsTPMParameterError(err, ErrorSize, CommandSetPrimaryPolicy, 1) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestChangePPS(t *testing.T) {
+	tpm, _ := openTPMSimulatorForTesting(t)
+	defer closeTPM(t, tpm)
+
+	platform := tpm.PlatformHandleContext()
+
+	template := Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrSign,
+		Params: PublicParamsU{
+			Data: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}}}
+
+	createPrimary := func(t *testing.T) Name {
+		objectContext, _, _, _, _, err := tpm.CreatePrimary(platform, nil, &template, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("CreatePrimary failed: %v", err)
+		}
+		defer flushContext(t, tpm, objectContext)
+		return objectContext.Name()
+	}
+
+	name1 := createPrimary(t)
+
+	if err := tpm.ChangePPS(platform, nil); err != nil {
+		t.Fatalf("ChangePPS failed: %v", err)
+	}
+
+	name2 := createPrimary(t)
+	if bytes.Equal(name1, name2) {
+		t.Errorf("Primary object should have changed")
+	}
+}
+
+func TestChangeEPS(t *testing.T) {
+	tpm, _ := openTPMSimulatorForTesting(t)
+	defer closeTPM(t, tpm)
+
+	endorsement := tpm.EndorsementHandleContext()
+
+	ek := createRSAEkForTesting(t, tpm)
+	name1 := ek.Name()
+	flushContext(t, tpm, ek)
+
+	setHierarchyAuthForTest(t, tpm, endorsement)
+
+	if err := tpm.ChangeEPS(tpm.PlatformHandleContext(), nil); err != nil {
+		resetHierarchyAuth(t, tpm, endorsement)
+		t.Fatalf("ChangeEPS failed: %v", err)
+	}
+
+	if len(endorsement.(TestResourceContext).GetAuthValue()) != 0 {
+		t.Errorf("ChangeEPS didn't clear the auth value for the endorsement hierarchy")
+	}
+
+	ek = createRSAEkForTesting(t, tpm)
+	defer flushContext(t, tpm, ek)
+	if bytes.Equal(name1, ek.Name()) {
+		t.Errorf("EK should have changed")
+	}
+}
+
 func TestClear(t *testing.T) {
 	tpm := openTPMForTesting(t, testCapabilityOwnerPersist|testCapabilityChangeEndorsementAuth|testCapabilityChangeLockoutAuth|testCapabilityChangePlatformAuth|testCapabilityClear)
 	defer closeTPM(t, tpm)
@@ -0,0 +1,39 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+// Section 26 - Miscellaneous Management Functions
+
+// PPCommands executes the TPM2_PP_Commands command to modify the list of commands that require physical presence to be asserted when
+// they are authorized with the platform hierarchy. Commands in setList are added to the list, and commands in clearList are removed
+// from it. The current list can be obtained with TPMContext.GetCapabilityPPCommands.
+//
+// The authContext parameter must correspond to HandlePlatform. The command requires authorization with the user auth role for
+// authContext, with session based authorization provided via authContextAuthSession. This command always requires physical
+// presence to be asserted - if it isn't, a *TPMError error with an error code of ErrorPP will be returned.
+//
+// Commands in setList or clearList that are not implemented by the TPM are ignored. Commands that the TPM requires to always have
+// physical presence asserted (such as TPM2_PP_Commands) or that can never require physical presence are also ignored. If the same
+// command appears in both setList and clearList, a *TPMParameterError error with an error code of ErrorValue will be returned.
+func (t *TPMContext) PPCommands(authContext ResourceContext, setList, clearList CommandCodeList, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandPPCommands, sessions,
+		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, Delimiter,
+		setList, clearList)
+}
+
+// SetAlgorithmSet executes the TPM2_SetAlgorithmSet command to select the set of algorithms that will be available on the TPM, for
+// TPMs that support more than one set. The meaning of algorithmSet is TPM vendor specific. The new setting takes effect on the next
+// execution of TPM2_Clear (via TPMContext.Clear).
+//
+// The authContext parameter must correspond to HandlePlatform. The command requires authorization with the user auth role for
+// authContext, with session based authorization provided via authContextAuthSession.
+//
+// If algorithmSet is not a value supported by the TPM, a *TPMParameterError error with an error code of ErrorValue will be returned
+// for parameter index 1.
+func (t *TPMContext) SetAlgorithmSet(authContext ResourceContext, algorithmSet uint32, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandSetAlgorithmSet, sessions,
+		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, Delimiter,
+		algorithmSet)
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+)
+
+func TestPPCommands(t *testing.T) {
+	tpm, _ := openTPMSimulatorForTesting(t)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandPPCommands)
+
+	// Physical presence is not asserted on the simulator, so this should fail.
+	err := tpm.PPCommands(tpm.PlatformHandleContext(), CommandCodeList{CommandClear}, nil, nil)
+	if !IsTPMError(err, ErrorPP, CommandPPCommands) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	t.Run("WithPhysicalPresence", func(t *testing.T) {
+		pp, ok := tpm.Transport().(PhysicalPresenceTransport)
+		if !ok {
+			t.Fatalf("Transport does not implement PhysicalPresenceTransport")
+		}
+		if err := pp.AssertPhysicalPresence(); err != nil {
+			t.Fatalf("AssertPhysicalPresence failed: %v", err)
+		}
+		defer func() {
+			if err := pp.DeassertPhysicalPresence(); err != nil {
+				t.Errorf("DeassertPhysicalPresence failed: %v", err)
+			}
+		}()
+
+		if err := tpm.PPCommands(tpm.PlatformHandleContext(), CommandCodeList{CommandClear}, nil, nil); err != nil {
+			t.Fatalf("PPCommands failed: %v", err)
+		}
+		if err := tpm.PPCommands(tpm.PlatformHandleContext(), nil, CommandCodeList{CommandClear}, nil); err != nil {
+			t.Errorf("PPCommands failed: %v", err)
+		}
+	})
+}
+
+func TestSetAlgorithmSet(t *testing.T) {
+	tpm, _ := openTPMSimulatorForTesting(t)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandSetAlgorithmSet)
+
+	if err := tpm.SetAlgorithmSet(tpm.PlatformHandleContext(), 0, nil); err != nil {
+		t.Errorf("SetAlgorithmSet failed: %v", err)
+	}
+}
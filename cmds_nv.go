@@ -720,7 +720,62 @@ func (t *TPMContext) NVChangeAuth(nvIndex ResourceContext, newAuth Auth, nvIndex
 	return t.processResponse(ctx, nil, nil)
 }
 
-// func (t *TPMContext) NVCertify(signContext, authContext, nvIndex HandleContext, qualifyingData Data,
-//	inScheme *SigScheme, size, offset uint16, signContextAuth, authContextAuth interface{},
-//	sessions ...SessionContext) (AttestRaw, *Signature, error) {
-// }
+// NVCertify executes the TPM2_NV_Certify command to certify the contents of the NV index associated with nvIndex. The amount of data
+// to certify, and the offset within the index are defined by the size and offset parameters. As the certified data must fit in the
+// returned attestation structure, size must not be larger than the maximum NV buffer size of the TPM.
+//
+// The command requires authorization to read the NV index, defined by the state of the AttrNVPPRead, AttrNVOwnerRead,
+// AttrNVAuthRead and AttrNVPolicyRead attributes. The handle used for authorization is specified via authContext. If the NV index has
+// the AttrNVPPRead attribute, authorization can be satisfied with HandlePlatform. If the NV index has the AttrNVOwnerRead attribute,
+// authorization can be satisfied with HandleOwner. If the NV index has the AttrNVAuthRead or AttrNVPolicyRead attribute,
+// authorization can be satisfied with nvIndex. The command requires authorization with the user auth role for authContext, with
+// session based authorization provided via authContextAuthSession. If the resource associated with authContext is not permitted to
+// authorize this access, a *TPMError error with an error code of ErrorNVAuthorization will be returned.
+//
+// If signContext is not nil, the returned attestation will be signed by the key associated with it. This command requires
+// authorization with the user auth role for signContext, with session based authorization provided via signContextAuthSession.
+//
+// If signContext is not nil and the object associated with signContext is not a signing key, a *TPMHandleError error with an error
+// code of ErrorKey will be returned for handle index 1.
+//
+// If signContext is not nil and if the scheme of the key associated with signContext is AsymSchemeNull, then inScheme must be
+// provided to specify a valid signing scheme for the key. If it isn't, a *TPMParameterError error with an error code of ErrorScheme
+// will be returned for parameter index 2.
+//
+// If signContext is not nil and the scheme of the key associated with signContext is not AsymSchemeNull, then inScheme may be nil. If
+// it is provided, then the specified scheme must match that of the signing key, else a *TPMParameterError error with an error code of
+// ErrorScheme will be returned for parameter index 2.
+//
+// If the index has the AttrNVReadLocked attribute set, a *TPMError error with an error code of ErrorNVLocked will be returned.
+//
+// If the index has not been initialized (ie, the AttrNVWritten attribute is not set), a *TPMError error with an error code of
+// ErrorNVUninitialized will be returned.
+//
+// If the value of size is too large, a *TPMParameterError error with an error code of ErrorValue will be returned for parameter
+// index 3.
+//
+// If the data selection falls outside of the bounds of the index, a *TPMError error with an error code of ErrorNVRange will be
+// returned.
+//
+// On successful completion, it returns an attestation structure containing the name of the NV index, the offset and the requested
+// contents of the index. If signContext is not nil, the attestation structure will be signed by the associated key and returned
+// too. Attest.CheckNVContents can be used to check the certified contents against an expected value once the signature has been
+// verified.
+func (t *TPMContext) NVCertify(signContext, authContext, nvIndex ResourceContext, qualifyingData Data, inScheme *SigScheme, size, offset uint16, signContextAuthSession, authContextAuthSession SessionContext, sessions ...SessionContext) (AttestRaw, *Signature, error) {
+	if inScheme == nil {
+		inScheme = &SigScheme{Scheme: SigSchemeAlgNull}
+	}
+
+	var certifyInfo AttestRaw
+	var signature Signature
+
+	if err := t.RunCommand(CommandNVCertify, sessions,
+		ResourceContextWithSession{Context: signContext, Session: signContextAuthSession}, ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, nvIndex, Delimiter,
+		qualifyingData, inScheme, size, offset, Delimiter,
+		Delimiter,
+		&certifyInfo, &signature); err != nil {
+		return nil, nil, err
+	}
+
+	return certifyInfo, &signature, nil
+}
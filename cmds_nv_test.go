@@ -964,3 +964,89 @@ func TestNVChangeAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestNVCertify(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerPersist|testCapabilityEndorsementHierarchy)
+	defer closeTPM(t, tpm)
+
+	owner := tpm.OwnerHandleContext()
+
+	ek := createRSAEkForTesting(t, tpm)
+	defer flushContext(t, tpm, ek)
+	ak := createAndLoadRSAAkForTesting(t, tpm, ek, nil)
+	defer flushContext(t, tpm, ak)
+
+	run := func(t *testing.T, signContext, index ResourceContext, size, offset uint16, expected []byte) {
+		qualifyingData := make(Data, 32)
+		rand.Read(qualifyingData)
+
+		certifyInfo, signature, err := tpm.NVCertify(signContext, index, index, qualifyingData, nil, size, offset, nil, nil)
+		if err != nil {
+			t.Fatalf("NVCertify failed: %v", err)
+		}
+
+		attest := verifyAttest(t, tpm, certifyInfo, TagAttestNV, signContext, HandleEndorsement, qualifyingData)
+		verifyAttestSignature(t, tpm, signContext, certifyInfo, signature, SigSchemeAlgRSASSA, HashAlgorithmSHA256)
+
+		if err := attest.CheckNVContents(index.Name(), offset, expected); err != nil {
+			t.Errorf("CheckNVContents failed: %v", err)
+		}
+		if err := attest.CheckNVContents(index.Name(), offset, make([]byte, size)); err == nil {
+			t.Errorf("CheckNVContents should have failed for the wrong contents")
+		}
+	}
+
+	t.Run("Ordinary", func(t *testing.T) {
+		pub := NVPublic{
+			Index:   Handle(0x0181ffff),
+			NameAlg: HashAlgorithmSHA256,
+			Attrs:   NVTypeOrdinary.WithAttrs(AttrNVAuthWrite | AttrNVAuthRead),
+			Size:    64}
+		index, err := tpm.NVDefineSpace(owner, nil, &pub, nil)
+		if err != nil {
+			t.Fatalf("NVDefineSpace failed: %v", err)
+		}
+		defer undefineNVSpace(t, tpm, index, owner)
+
+		data := make([]byte, 64)
+		rand.Read(data)
+		if err := tpm.NVWrite(index, index, data, 0, nil); err != nil {
+			t.Fatalf("NVWrite failed: %v", err)
+		}
+
+		t.Run("All", func(t *testing.T) {
+			run(t, ak, index, 64, 0, data)
+		})
+		t.Run("Partial", func(t *testing.T) {
+			run(t, ak, index, 16, 20, data[20:36])
+		})
+		t.Run("NoSignature", func(t *testing.T) {
+			run(t, nil, index, 8, 0, data[:8])
+		})
+	})
+
+	t.Run("Counter", func(t *testing.T) {
+		pub := NVPublic{
+			Index:   Handle(0x0181ffff),
+			NameAlg: HashAlgorithmSHA256,
+			Attrs:   NVTypeCounter.WithAttrs(AttrNVAuthWrite | AttrNVAuthRead),
+			Size:    8}
+		index, err := tpm.NVDefineSpace(owner, nil, &pub, nil)
+		if err != nil {
+			t.Fatalf("NVDefineSpace failed: %v", err)
+		}
+		defer undefineNVSpace(t, tpm, index, owner)
+
+		if err := tpm.NVIncrement(index, index, nil); err != nil {
+			t.Fatalf("NVIncrement failed: %v", err)
+		}
+		count, err := tpm.NVReadCounter(index, index, nil)
+		if err != nil {
+			t.Fatalf("NVReadCounter failed: %v", err)
+		}
+
+		expected := make([]byte, 8)
+		binary.BigEndian.PutUint64(expected, count)
+		run(t, ak, index, 8, 0, expected)
+	})
+}
@@ -473,6 +473,9 @@ func (t *TPMContext) ObjectChangeAuth(objectContext, parentContext ResourceConte
 // object, parentContext should correspond to a hierarchy. To create a new ordinary object, parentContext should correspond to a
 // storage parent. To create a new derived object, parentContext should correspond to a derivation parent.
 //
+// A derivation parent can be created using a template returned from NewDerivationParentTemplate. A template for a derived object
+// that specifies the label and context values used for the derivation can be created with NewDerivedObjectTemplate.
+//
 // The command requires authorization with the user auth role for parentContext, with session based authorization provided via
 // parentContextAuthSession.
 //
@@ -742,3 +742,66 @@ func TestActivateCredential(t *testing.T) {
 		run(t, ak, sessionContext)
 	})
 }
+
+func TestCreateLoadedDerived(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandCreateLoaded)
+
+	parent, _, _, _, _, err := tpm.CreatePrimary(tpm.OwnerHandleContext(), nil,
+		NewDerivationParentTemplate(HashAlgorithmSHA256, HashAlgorithmSHA256), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("CreatePrimary failed: %v", err)
+	}
+	defer flushContext(t, tpm, parent)
+
+	template := Public{
+		Type:    ObjectTypeECC,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrSign,
+		Params: PublicParamsU{
+			Data: &ECCParams{
+				Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+				Scheme: ECCScheme{
+					Scheme:  ECCSchemeECDSA,
+					Details: AsymSchemeU{Data: &SigSchemeECDSA{HashAlg: HashAlgorithmSHA256}}},
+				CurveID: ECCCurveNIST_P256,
+				KDF:     KDFScheme{Scheme: KDFAlgorithmNull}}}}
+
+	run := func(t *testing.T, label, context []byte) *Public {
+		inPublic := NewDerivedObjectTemplate(&template, label, context)
+		if inPublic.Attrs&AttrSensitiveDataOrigin != 0 {
+			t.Errorf("Template has AttrSensitiveDataOrigin set")
+		}
+
+		objectContext, _, outPublic, err := tpm.CreateLoaded(parent, nil, inPublic, nil)
+		if err != nil {
+			t.Fatalf("CreateLoaded failed: %v", err)
+		}
+		defer flushContext(t, tpm, objectContext)
+
+		if outPublic.Type != ObjectTypeECC {
+			t.Errorf("CreateLoaded returned an object with the wrong type")
+		}
+		if outPublic.Attrs != inPublic.Attrs {
+			t.Errorf("CreateLoaded returned an object with the wrong attributes")
+		}
+		return outPublic
+	}
+
+	pub1 := run(t, []byte("tenant"), []byte("foo"))
+	pub2 := run(t, []byte("tenant"), []byte("foo"))
+	pub3 := run(t, []byte("tenant"), []byte("bar"))
+
+	name1, _ := pub1.Name()
+	name2, _ := pub2.Name()
+	name3, _ := pub3.Name()
+
+	if !bytes.Equal(name1, name2) {
+		t.Errorf("Objects derived with the same label and context should be identical")
+	}
+	if bytes.Equal(name1, name3) {
+		t.Errorf("Objects derived with a different context should be different")
+	}
+}
@@ -183,6 +183,112 @@ func (t *TPMContext) PCRRead(pcrSelectionIn PCRSelectionList, sessions ...Sessio
 	return pcrUpdateCounter, pcrValues, nil
 }
 
+// PCRAllocate executes the TPM2_PCR_Allocate command to set the desired PCR allocation for the TPM, which takes effect after the
+// next TPM reset (TPM2_Startup(TPM_SU_CLEAR) after TPM2_Shutdown(TPM_SU_CLEAR) or without a preceding TPM2_Shutdown). The
+// pcrAllocation argument specifies the PCRs that should be allocated in each bank. Any bank that is implemented by the TPM but not
+// included in pcrAllocation is left unchanged. A bank can be disabled by including it in pcrAllocation with an empty selection.
+//
+// The authContext parameter must correspond to HandlePlatform. The command requires authorization with the user auth role for
+// authContext, with session based authorization provided via authContextAuthSession.
+//
+// If pcrAllocation would result in no PCRs being allocated in any bank, or it would result in a PCR being allocated that is
+// required by the platform specification for which the TPM was built to be allocated in some bank but is not, a *TPMParameterError
+// error with an error code of ErrorPCR will be returned for parameter index 1.
+//
+// On success, allocationSuccess will indicate whether the requested allocation was accepted by the TPM. The maximum number of PCRs
+// in any bank is returned as maxPCR. The amount of space required by the TPM to store the requested allocation is returned as
+// sizeNeeded, and the amount of space that the TPM has available for storing PCR allocations is returned as sizeAvailable. If the
+// allocation fails because there is insufficient space, allocationSuccess will be false and sizeNeeded will be larger than
+// sizeAvailable.
+func (t *TPMContext) PCRAllocate(authContext ResourceContext, pcrAllocation PCRSelectionList, authContextAuthSession SessionContext, sessions ...SessionContext) (allocationSuccess bool, maxPCR, sizeNeeded, sizeAvailable uint32, err error) {
+	if err := t.RunCommand(CommandPCRAllocate, sessions,
+		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, Delimiter,
+		pcrAllocation, Delimiter,
+		Delimiter,
+		&allocationSuccess, &maxPCR, &sizeNeeded, &sizeAvailable); err != nil {
+		return false, 0, 0, 0, err
+	}
+
+	return allocationSuccess, maxPCR, sizeNeeded, sizeAvailable, nil
+}
+
+// PCRSetAuthPolicy executes the TPM2_PCR_SetAuthPolicy command to set the authorization policy for the PCR specified by pcrNum and
+// all other PCRs in the same policy group. Once set, the PCRs in the group can be authorized with a policy session that satisfies
+// authPolicy, where hashAlg specifies the digest algorithm of the policy. If authPolicy is empty and hashAlg is HashAlgorithmNull,
+// the policy for the group is cleared.
+//
+// The authContext parameter must correspond to HandlePlatform. The command requires authorization with the user auth role for
+// authContext, with session based authorization provided via authContextAuthSession.
+//
+// If the length of authPolicy is not consistent with hashAlg, a *TPMParameterError error with an error code of ErrorSize will be
+// returned for parameter index 1.
+//
+// If pcrNum does not correspond to a PCR that belongs to a policy group, a *TPMParameterError error with an error code of ErrorValue
+// will be returned for parameter index 3.
+func (t *TPMContext) PCRSetAuthPolicy(authContext ResourceContext, authPolicy Digest, hashAlg HashAlgorithmId, pcrNum Handle, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandPCRSetAuthPolicy, sessions,
+		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, Delimiter,
+		authPolicy, hashAlg, pcrNum)
+}
+
+// PCRSetAuthValue executes the TPM2_PCR_SetAuthValue command to set the authorization value for the PCR associated with pcrContext
+// and all other PCRs in the same authorization group. The command requires authorization with the user auth role for pcrContext,
+// with session based authorization provided via pcrContextAuthSession.
+//
+// If the PCR associated with pcrContext does not belong to an authorization group, a *TPMHandleError error with an error code of
+// ErrorValue will be returned for handle index 1.
+//
+// On successful completion, the authorization value of the PCR associated with pcrContext and the other PCRs in the same
+// authorization group will be set to the value of auth, and pcrContext and the ResourceContext instances returned from
+// PCRHandleContext for the other PCRs in the group will be updated to reflect this - it isn't necessary to update them with
+// ResourceContext.SetAuthValue in order to use them in subsequent commands that require knowledge of the authorization value for the
+// resource. The TPM doesn't indicate which PCRs belong to each authorization group, so this function queries the TPM_PT_PCR_AUTH
+// property before executing the command and assumes that all of the PCRs with an authorization value belong to a single group, which
+// is the case for the reference implementation and for TPMs that conform to the TCG PC Client Platform TPM Profile.
+func (t *TPMContext) PCRSetAuthValue(pcrContext ResourceContext, auth Digest, pcrContextAuthSession SessionContext, sessions ...SessionContext) error {
+	var group PCRSelect
+	if pcrContext != nil && pcrContext.Handle().Type() == HandleTypePCR {
+		props, err := t.GetCapabilityPCRProperties(PropertyPCRAuth, 1)
+		if err != nil {
+			return fmt.Errorf("cannot determine authorization group: %v", err)
+		}
+		if len(props) > 0 && props[0].Tag == PropertyPCRAuth {
+			group = props[0].Select
+		}
+	}
+
+	var s []*sessionParam
+	s, err := t.validateAndAppendAuthSessionParam(s, ResourceContextWithSession{Context: pcrContext, Session: pcrContextAuthSession})
+	if err != nil {
+		return fmt.Errorf("error whilst processing handle with authorization for pcrHandle: %v", err)
+	}
+	s, err = t.validateAndAppendExtraSessionParams(s, sessions)
+	if err != nil {
+		return fmt.Errorf("error whilst processing non-auth sessions: %v", err)
+	}
+
+	ctx, err := t.runCommandWithoutProcessingResponse(CommandPCRSetAuthValue, s, []interface{}{pcrContext}, []interface{}{auth})
+	if err != nil {
+		return err
+	}
+
+	// If the HMAC key for this command includes the auth value for pcrHandle, the TPM will respond with a HMAC generated with a key
+	// that includes the new auth value instead.
+	pcrContext.SetAuthValue(auth)
+
+	if err := t.processResponse(ctx, nil, nil); err != nil {
+		return err
+	}
+
+	for _, pcr := range group {
+		if Handle(pcr) == pcrContext.Handle() {
+			continue
+		}
+		t.PCRHandleContext(pcr).SetAuthValue(auth)
+	}
+	return nil
+}
+
 // PCRReset executes the TPM2_PCR_Reset command to reset the PCR associated with pcrContext in all banks. This command requires
 // authorization with the user auth role for pcrContext, with session based authorization provided via pcrContextAuthSession.
 //
@@ -332,6 +332,147 @@ func TestPCRRead(t *testing.T) {
 	})
 }
 
+func TestPCRAllocate(t *testing.T) {
+	tpm, tcti := openTPMSimulatorForTesting(t)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandPCRAllocate)
+
+	origPcrs, err := tpm.GetCapabilityPCRs()
+	if err != nil {
+		t.Fatalf("GetCapabilityPCRs failed: %v", err)
+	}
+
+	run := func(t *testing.T, pcrAllocation PCRSelectionList) {
+		success, maxPCR, sizeNeeded, sizeAvailable, err := tpm.PCRAllocate(tpm.PlatformHandleContext(), pcrAllocation, nil)
+		if err != nil {
+			t.Fatalf("PCRAllocate failed: %v", err)
+		}
+		if !success {
+			t.Errorf("PCRAllocate indicated failure (sizeNeeded: %d, sizeAvailable: %d)", sizeNeeded, sizeAvailable)
+		}
+		if maxPCR != 24 {
+			t.Errorf("Unexpected maxPCR %d", maxPCR)
+		}
+		if sizeNeeded > sizeAvailable {
+			t.Errorf("Unexpected sizes (sizeNeeded: %d, sizeAvailable: %d)", sizeNeeded, sizeAvailable)
+		}
+
+		resetTPMSimulator(t, tpm, tcti)
+	}
+
+	var pcrAllocation PCRSelectionList
+	for _, s := range origPcrs {
+		switch s.Hash {
+		case HashAlgorithmSHA1:
+			pcrAllocation = append(pcrAllocation, PCRSelection{Hash: s.Hash, Select: []int{}})
+		default:
+			pcrAllocation = append(pcrAllocation, s)
+		}
+	}
+
+	run(t, pcrAllocation)
+	defer run(t, origPcrs)
+
+	pcrs, err := tpm.GetCapabilityPCRs()
+	if err != nil {
+		t.Fatalf("GetCapabilityPCRs failed: %v", err)
+	}
+	for _, s := range pcrs {
+		if s.Hash == HashAlgorithmSHA1 && len(s.Select) > 0 {
+			t.Errorf("SHA-1 bank is still allocated")
+		}
+		if s.Hash == HashAlgorithmSHA256 && len(s.Select) == 0 {
+			t.Errorf("SHA-256 bank is not allocated")
+		}
+	}
+}
+
+func TestPCRSetAuthPolicy(t *testing.T) {
+	tpm, _ := openTPMSimulatorForTesting(t)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandPCRSetAuthPolicy)
+
+	trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+	trial.PolicyCommandCode(CommandPCREvent)
+	authPolicy := trial.GetDigest()
+
+	if err := tpm.PCRSetAuthPolicy(tpm.PlatformHandleContext(), authPolicy, HashAlgorithmSHA256, Handle(20), nil); err != nil {
+		t.Fatalf("PCRSetAuthPolicy failed: %v", err)
+	}
+	defer func() {
+		if err := tpm.PCRSetAuthPolicy(tpm.PlatformHandleContext(), nil, HashAlgorithmNull, Handle(20), nil); err != nil {
+			t.Errorf("PCRSetAuthPolicy failed: %v", err)
+		}
+	}()
+
+	sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	defer verifyContextFlushed(t, tpm, sessionContext)
+
+	if err := tpm.PolicyCommandCode(sessionContext, CommandPCREvent); err != nil {
+		t.Fatalf("PolicyCommandCode failed: %v", err)
+	}
+
+	if _, err := tpm.PCREvent(tpm.PCRHandleContext(20), []byte("foo"), sessionContext); err != nil {
+		t.Errorf("PCREvent failed: %v", err)
+	}
+}
+
+func TestPCRSetAuthValue(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityPCRChange)
+	defer closeTPM(t, tpm)
+
+	skipIfCommandNotSupported(t, tpm, CommandPCRSetAuthValue)
+
+	run := func(t *testing.T, session SessionContext) {
+		pcr := tpm.PCRHandleContext(20)
+
+		if err := tpm.PCRSetAuthValue(pcr, testAuth, session); err != nil {
+			t.Fatalf("PCRSetAuthValue failed: %v", err)
+		}
+		defer func() {
+			if err := tpm.PCRSetAuthValue(pcr, nil, session); err != nil {
+				t.Errorf("PCRSetAuthValue failed: %v", err)
+			}
+		}()
+
+		if !bytes.Equal(pcr.(TestResourceContext).GetAuthValue(), testAuth) {
+			t.Errorf("PCR context has the wrong auth value")
+		}
+
+		if _, err := tpm.PCREvent(pcr, []byte("foo"), session); err != nil {
+			t.Errorf("PCREvent failed: %v", err)
+		}
+
+		// PCR 21 is in the same authorization group as PCR 20, but can't be extended from locality 3, so check that its context can
+		// be used to authorize TPM2_PCR_SetAuthValue instead.
+		sibling := tpm.PCRHandleContext(21)
+		if !bytes.Equal(sibling.(TestResourceContext).GetAuthValue(), testAuth) {
+			t.Errorf("PCR context for other PCR in the group has the wrong auth value")
+		}
+		if err := tpm.PCRSetAuthValue(sibling, testAuth, session); err != nil {
+			t.Errorf("PCRSetAuthValue with other PCR in the group failed: %v", err)
+		}
+	}
+
+	t.Run("UsePasswordAuth", func(t *testing.T) {
+		run(t, nil)
+	})
+
+	t.Run("UseSessionAuth", func(t *testing.T) {
+		sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+		if err != nil {
+			t.Fatalf("StartAuthSession failed: %v", err)
+		}
+		defer flushContext(t, tpm, sessionContext)
+		run(t, sessionContext.WithAttrs(AttrContinueSession))
+	})
+}
+
 func TestPCRReset(t *testing.T) {
 	tpm := openTPMForTesting(t, testCapabilityPCRChange)
 	defer closeTPM(t, tpm)
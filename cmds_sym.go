@@ -0,0 +1,232 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+// Section 15 - Symmetric Primitives
+
+func (t *TPMContext) runEncryptDecrypt(commandCode CommandCode, keyContext ResourceContext, decrypt bool, mode SymModeId, ivIn IV, inData MaxBuffer, keyContextAuthSession SessionContext, sessions ...SessionContext) (MaxBuffer, IV, error) {
+	var outData MaxBuffer
+	var ivOut IV
+
+	args := []interface{}{ResourceContextWithSession{Context: keyContext, Session: keyContextAuthSession}, Delimiter}
+	switch commandCode {
+	case CommandEncryptDecrypt2:
+		args = append(args, inData, decrypt, mode, ivIn)
+	default:
+		args = append(args, decrypt, mode, ivIn, inData)
+	}
+	args = append(args, Delimiter, Delimiter, &outData, &ivOut)
+
+	if err := t.RunCommand(commandCode, sessions, args...); err != nil {
+		return nil, nil, err
+	}
+
+	return outData, ivOut, nil
+}
+
+func (t *TPMContext) encryptDecrypt(commandCode CommandCode, keyContext ResourceContext, decrypt bool, mode SymModeId, ivIn IV, data []byte, keyContextAuthSession SessionContext, sessions ...SessionContext) ([]byte, IV, error) {
+	if err := t.initPropertiesIfNeeded(); err != nil {
+		return nil, nil, err
+	}
+
+	// Each chunk must be a multiple of the cipher block size for block modes. The block sizes of all of the symmetric algorithms
+	// defined by the TPM library specification are factors of 16 bytes.
+	chunkSize := t.maxBufferSize - (t.maxBufferSize % 16)
+
+	var outData []byte
+	iv := ivIn
+
+	total := 0
+	for {
+		b := data[total:]
+		if len(b) > chunkSize {
+			b = b[:chunkSize]
+		}
+
+		out, ivOut, err := t.runEncryptDecrypt(commandCode, keyContext, decrypt, mode, iv, b, keyContextAuthSession, sessions...)
+		if err != nil {
+			return nil, nil, err
+		}
+		outData = append(outData, out...)
+		iv = ivOut
+
+		total += len(b)
+		if total >= len(data) {
+			break
+		}
+	}
+
+	return outData, iv, nil
+}
+
+// EncryptDecrypt executes the TPM2_EncryptDecrypt command to perform symmetric encryption or decryption of the supplied data with
+// the key associated with keyContext. This command uses the original parameter ordering defined by the TPM library specification,
+// which doesn't permit the data to be encrypted with a session. New code should use TPMContext.EncryptDecrypt2 instead, which will
+// fall back to this command where necessary. The command requires authorization with the user auth role for keyContext, with
+// session based authorization provided via keyContextAuthSession.
+//
+// The underlying command may not be able to process all of the data in a single transaction, so this function will re-execute the
+// TPM2_EncryptDecrypt command until all of the data has been processed, carrying the IV from the output of each command to the
+// input of the next one. As a consequence, any SessionContext instances provided should have the AttrContinueSession attribute
+// defined.
+//
+// If keyContext does not correspond to a symmetric key, a *TPMHandleError error with an error code of ErrorKey will be returned for
+// handle index 1. If decrypt is true and the key does not have the AttrDecrypt attribute, or decrypt is false and the key does not
+// have the AttrSign attribute, a *TPMHandleError error with an error code of ErrorAttributes will be returned for handle index 1.
+// If the key has the AttrRestricted attribute, a *TPMHandleError error with an error code of ErrorAttributes will be returned for
+// handle index 1.
+//
+// If the key has a mode other than SymModeNull, then mode must either be SymModeNull or must match the mode of the key. If the key
+// has a mode of SymModeNull, then mode must be a valid mode. If this isn't the case, a *TPMParameterError error with an error code
+// of ErrorMode will be returned for parameter index 2.
+//
+// If the length of ivIn is not the same as the block size of the key, or ivIn is not empty when the selected mode is SymModeECB, a
+// *TPMParameterError error with an error code of ErrorSize will be returned for parameter index 3. If the selected mode is
+// SymModeCBC or SymModeECB and the length of data is not a multiple of the block size of the key, a *TPMParameterError error with
+// an error code of ErrorSize will be returned for parameter index 4.
+//
+// On success, the encrypted or decrypted data is returned, along with the chaining value which can be used as ivIn in a subsequent
+// call in order to continue the operation.
+func (t *TPMContext) EncryptDecrypt(keyContext ResourceContext, decrypt bool, mode SymModeId, ivIn IV, data []byte, keyContextAuthSession SessionContext, sessions ...SessionContext) ([]byte, IV, error) {
+	return t.encryptDecrypt(CommandEncryptDecrypt, keyContext, decrypt, mode, ivIn, data, keyContextAuthSession, sessions...)
+}
+
+// EncryptDecrypt2 executes the TPM2_EncryptDecrypt2 command to perform symmetric encryption or decryption of the supplied data with
+// the key associated with keyContext. It behaves identically to TPMContext.EncryptDecrypt, except that the data is the first
+// command parameter and can therefore be encrypted using a session with the AttrCommandEncrypt attribute set. The returned data can
+// be encrypted using a session with the AttrResponseEncrypt attribute set. The command requires authorization with the user auth
+// role for keyContext, with session based authorization provided via keyContextAuthSession.
+//
+// The underlying command may not be able to process all of the data in a single transaction, so this function will re-execute the
+// TPM2_EncryptDecrypt2 command until all of the data has been processed, carrying the IV from the output of each command to the
+// input of the next one. As a consequence, any SessionContext instances provided should have the AttrContinueSession attribute
+// defined.
+//
+// If the TPM does not implement TPM2_EncryptDecrypt2, this function falls back to using TPM2_EncryptDecrypt. This will fail if any
+// of the supplied sessions has the AttrCommandEncrypt attribute set, as parameter encryption is not supported by that command.
+//
+// The errors returned by this function are the same as those documented for TPMContext.EncryptDecrypt, except that the parameter
+// indices are different. If mode is not valid for the key, a *TPMParameterError error with an error code of ErrorMode will be
+// returned for parameter index 3. If ivIn has an invalid length for the selected mode, a *TPMParameterError error with an error
+// code of ErrorSize will be returned for parameter index 4. If the length of data is not valid for the selected mode, a
+// *TPMParameterError error with an error code of ErrorSize will be returned for parameter index 1.
+//
+// On success, the encrypted or decrypted data is returned, along with the chaining value which can be used as ivIn in a subsequent
+// call in order to continue the operation.
+func (t *TPMContext) EncryptDecrypt2(keyContext ResourceContext, data []byte, decrypt bool, mode SymModeId, ivIn IV, keyContextAuthSession SessionContext, sessions ...SessionContext) ([]byte, IV, error) {
+	outData, ivOut, err := t.encryptDecrypt(CommandEncryptDecrypt2, keyContext, decrypt, mode, ivIn, data, keyContextAuthSession, sessions...)
+	if IsTPMError(err, ErrorCommandCode, CommandEncryptDecrypt2) {
+		return t.EncryptDecrypt(keyContext, decrypt, mode, ivIn, data, keyContextAuthSession, sessions...)
+	}
+	return outData, ivOut, err
+}
+
+// Hash executes the TPM2_Hash command to compute a digest of the supplied data with the algorithm specified by hashAlg.
+//
+// If the length of data is larger than the TPM's input buffer, this function will compute the digest using a hash sequence instead,
+// by executing TPMContext.HashSequenceStart and TPMContext.SequenceExecute. As a consequence, any SessionContext instances provided
+// should have the AttrContinueSession attribute defined.
+//
+// If hashAlg is not a supported digest algorithm, a *TPMParameterError error with an error code of ErrorValue will be returned for
+// parameter index 2.
+//
+// If the data does not start with TPMGeneratedValue and hierarchy is not HandleNull, a ticket will be returned which can be passed
+// to TPMContext.Sign in order to sign the digest with a restricted signing key. The hierarchy argument specifies the hierarchy for
+// the ticket.
+//
+// On success, the digest of the data is returned, along with the ticket if the digest is safe to sign with a restricted signing
+// key.
+func (t *TPMContext) Hash(data []byte, hashAlg HashAlgorithmId, hierarchy Handle, sessions ...SessionContext) (Digest, *TkHashcheck, error) {
+	if err := t.initPropertiesIfNeeded(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(data) > t.maxBufferSize {
+		seq, err := t.HashSequenceStart(nil, hashAlg, sessions...)
+		if err != nil {
+			return nil, nil, err
+		}
+		outHash, validation, err := t.SequenceExecute(seq, data, hierarchy, nil, sessions...)
+		if err != nil {
+			t.FlushContext(seq)
+			return nil, nil, err
+		}
+		return outHash, nullHashcheckToNil(validation), nil
+	}
+
+	var outHash Digest
+	var validation *TkHashcheck
+
+	if err := t.RunCommand(CommandHash, sessions,
+		Delimiter,
+		MaxBuffer(data), hashAlg, hierarchy, Delimiter,
+		Delimiter,
+		&outHash, &validation); err != nil {
+		return nil, nil, err
+	}
+
+	return outHash, nullHashcheckToNil(validation), nil
+}
+
+// nullHashcheckToNil returns nil if the supplied ticket is a NULL ticket, which is returned by the TPM when the digest isn't safe
+// to sign with a restricted signing key.
+func nullHashcheckToNil(ticket *TkHashcheck) *TkHashcheck {
+	if ticket == nil || (ticket.Hierarchy == HandleNull && len(ticket.Digest) == 0) {
+		return nil
+	}
+	return ticket
+}
+
+// HMAC executes the TPM2_HMAC command to compute a HMAC of the supplied data with the key associated with context. The command
+// requires authorization with the user auth role for context, with session based authorization provided via contextAuthSession.
+//
+// If the length of data is larger than the TPM's input buffer, this function will compute the HMAC using a HMAC sequence instead,
+// by executing TPMContext.HMACStart and TPMContext.SequenceExecute. As a consequence, any SessionContext instances provided should
+// have the AttrContinueSession attribute defined.
+//
+// If context does not correspond to an object with the type ObjectTypeKeyedHash, a *TPMHandleError error with an error code of
+// ErrorType will be returned for handle index 1. If context corresponds to an object with the AttrRestricted attribute set, or it
+// doesn't have the AttrSign attribute set, a *TPMHandleError error with an error code of ErrorAttributes will be returned for
+// handle index 1.
+//
+// The hashAlg argument specifies the HMAC algorithm. If the default scheme of the key associated with context is
+// KeyedHashSchemeNull, then hashAlg must not be HashAlgorithmNull. If the default scheme of the key associated with context is not
+// KeyedHashSchemeNull, then hashAlg must either be HashAlgorithmNull or must match the key's default scheme, else a
+// *TPMParameterError error with an error code of ErrorValue will be returned for parameter index 2.
+//
+// The data argument can be encrypted using a session with the AttrCommandEncrypt attribute set if it fits in the TPM's input
+// buffer.
+//
+// On success, the computed HMAC is returned.
+func (t *TPMContext) HMAC(context ResourceContext, data []byte, hashAlg HashAlgorithmId, contextAuthSession SessionContext, sessions ...SessionContext) (Digest, error) {
+	if err := t.initPropertiesIfNeeded(); err != nil {
+		return nil, err
+	}
+
+	if len(data) > t.maxBufferSize {
+		seq, err := t.HMACStart(context, nil, hashAlg, contextAuthSession, sessions...)
+		if err != nil {
+			return nil, err
+		}
+		outHMAC, _, err := t.SequenceExecute(seq, data, HandleNull, nil, sessions...)
+		if err != nil {
+			t.FlushContext(seq)
+			return nil, err
+		}
+		return outHMAC, nil
+	}
+
+	var outHMAC Digest
+
+	if err := t.RunCommand(CommandHMAC, sessions,
+		ResourceContextWithSession{Context: context, Session: contextAuthSession}, Delimiter,
+		MaxBuffer(data), hashAlg, Delimiter,
+		Delimiter,
+		&outHMAC); err != nil {
+		return nil, err
+	}
+
+	return outHMAC, nil
+}
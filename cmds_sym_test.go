@@ -0,0 +1,440 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"hash"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+)
+
+func loadSymmetricKeyForTesting(t *testing.T, tpm *TPMContext, key []byte, mode SymModeId, auth Auth) ResourceContext {
+	seed := make([]byte, 32)
+
+	h := crypto.SHA256.New()
+	h.Write(seed)
+	h.Write(key)
+
+	public := Public{
+		Type:    ObjectTypeSymCipher,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrUserWithAuth | AttrDecrypt | AttrSign,
+		Params: PublicParamsU{
+			Data: &SymCipherParams{
+				Sym: SymDefObject{
+					Algorithm: SymObjectAlgorithmAES,
+					KeyBits:   SymKeyBitsU{Data: uint16(len(key) * 8)},
+					Mode:      SymModeU{Data: mode}}}},
+		Unique: PublicIDU{Data: Digest(h.Sum(nil))}}
+
+	authValue := make(Auth, public.NameAlg.Size())
+	copy(authValue, auth)
+	sensitive := Sensitive{
+		Type:      ObjectTypeSymCipher,
+		AuthValue: authValue,
+		SeedValue: seed,
+		Sensitive: SensitiveCompositeU{Data: SymKey(key)}}
+	rc, err := tpm.LoadExternal(&sensitive, &public, HandleNull)
+	if err != nil {
+		t.Fatalf("LoadExternal failed: %v", err)
+	}
+	rc.SetAuthValue(auth)
+	return rc
+}
+
+func computeExpectedSymmetricResult(t *testing.T, key []byte, mode SymModeId, iv, data []byte) []byte {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("NewCipher failed: %v", err)
+	}
+
+	out := make([]byte, len(data))
+	switch mode {
+	case SymModeCFB:
+		cipher.NewCFBEncrypter(c, iv).XORKeyStream(out, data)
+	case SymModeCTR:
+		cipher.NewCTR(c, iv).XORKeyStream(out, data)
+	case SymModeOFB:
+		cipher.NewOFB(c, iv).XORKeyStream(out, data)
+	case SymModeCBC:
+		cipher.NewCBCEncrypter(c, iv).CryptBlocks(out, data)
+	case SymModeECB:
+		for i := 0; i < len(data); i += c.BlockSize() {
+			c.Encrypt(out[i:], data[i:])
+		}
+	default:
+		t.Fatalf("Unexpected mode")
+	}
+	return out
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	key := make([]byte, 16)
+	rand.Read(key)
+
+	maxBuffer := tpm.GetInputBuffer()
+
+	for _, data := range []struct {
+		desc      string
+		keyMode   SymModeId
+		mode      SymModeId
+		legacy    bool
+		dataSize  int
+		authValue Auth
+	}{
+		{
+			desc:     "CFBKeyMode",
+			keyMode:  SymModeCFB,
+			mode:     SymModeNull,
+			dataSize: 100,
+		},
+		{
+			desc:     "CFB",
+			keyMode:  SymModeNull,
+			mode:     SymModeCFB,
+			dataSize: 100,
+		},
+		{
+			desc:     "CTR",
+			keyMode:  SymModeNull,
+			mode:     SymModeCTR,
+			dataSize: 100,
+		},
+		{
+			desc:     "OFB",
+			keyMode:  SymModeNull,
+			mode:     SymModeOFB,
+			dataSize: 100,
+		},
+		{
+			desc:     "CBC",
+			keyMode:  SymModeNull,
+			mode:     SymModeCBC,
+			dataSize: 128,
+		},
+		{
+			desc:     "ECB",
+			keyMode:  SymModeNull,
+			mode:     SymModeECB,
+			dataSize: 128,
+		},
+		{
+			desc:     "CFBLarge",
+			keyMode:  SymModeCFB,
+			mode:     SymModeNull,
+			dataSize: (maxBuffer * 2) + 47,
+		},
+		{
+			desc:     "CBCLarge",
+			keyMode:  SymModeCBC,
+			mode:     SymModeNull,
+			dataSize: (maxBuffer * 3) + 16 - (maxBuffer * 3 % 16),
+		},
+		{
+			desc:      "WithAuth",
+			keyMode:   SymModeCFB,
+			mode:      SymModeNull,
+			dataSize:  100,
+			authValue: testAuth,
+		},
+		{
+			desc:     "Legacy",
+			keyMode:  SymModeCFB,
+			mode:     SymModeNull,
+			legacy:   true,
+			dataSize: 100,
+		},
+		{
+			desc:     "LegacyLarge",
+			keyMode:  SymModeCFB,
+			mode:     SymModeNull,
+			legacy:   true,
+			dataSize: (maxBuffer * 2) + 47,
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			keyContext := loadSymmetricKeyForTesting(t, tpm, key, data.keyMode, data.authValue)
+			defer flushContext(t, tpm, keyContext)
+
+			mode := data.mode
+			if mode == SymModeNull {
+				mode = data.keyMode
+			}
+
+			var iv IV
+			if mode != SymModeECB {
+				iv = make(IV, aes.BlockSize)
+				rand.Read(iv)
+			}
+
+			plaintext := make([]byte, data.dataSize)
+			rand.Read(plaintext)
+
+			run := func(decrypt bool, in []byte) ([]byte, IV) {
+				var out []byte
+				var ivOut IV
+				var err error
+				if data.legacy {
+					out, ivOut, err = tpm.EncryptDecrypt(keyContext, decrypt, data.mode, iv, in, nil)
+				} else {
+					out, ivOut, err = tpm.EncryptDecrypt2(keyContext, in, decrypt, data.mode, iv, nil)
+				}
+				if err != nil {
+					t.Fatalf("EncryptDecrypt failed: %v", err)
+				}
+				return out, ivOut
+			}
+
+			ciphertext, _ := run(false, plaintext)
+			if !bytes.Equal(ciphertext, computeExpectedSymmetricResult(t, key, mode, iv, plaintext)) {
+				t.Errorf("Unexpected ciphertext")
+			}
+
+			recovered, _ := run(true, ciphertext)
+			if !bytes.Equal(recovered, plaintext) {
+				t.Errorf("Decrypt returned the wrong data")
+			}
+		})
+	}
+
+	t.Run("ChainIV", func(t *testing.T) {
+		keyContext := loadSymmetricKeyForTesting(t, tpm, key, SymModeCBC, nil)
+		defer flushContext(t, tpm, keyContext)
+
+		iv := make(IV, aes.BlockSize)
+		rand.Read(iv)
+
+		plaintext := make([]byte, 64)
+		rand.Read(plaintext)
+
+		out1, ivOut, err := tpm.EncryptDecrypt2(keyContext, plaintext[:32], false, SymModeNull, iv, nil)
+		if err != nil {
+			t.Fatalf("EncryptDecrypt2 failed: %v", err)
+		}
+		out2, _, err := tpm.EncryptDecrypt2(keyContext, plaintext[32:], false, SymModeNull, ivOut, nil)
+		if err != nil {
+			t.Fatalf("EncryptDecrypt2 failed: %v", err)
+		}
+
+		if !bytes.Equal(append(out1, out2...), computeExpectedSymmetricResult(t, key, SymModeCBC, iv, plaintext)) {
+			t.Errorf("Unexpected ciphertext")
+		}
+	})
+
+	t.Run("ParameterEncryption", func(t *testing.T) {
+		keyContext := loadSymmetricKeyForTesting(t, tpm, key, SymModeCFB, nil)
+		defer flushContext(t, tpm, keyContext)
+
+		sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypeHMAC, &SymDef{
+			Algorithm: SymAlgorithmAES,
+			KeyBits:   SymKeyBitsU{Data: uint16(128)},
+			Mode:      SymModeU{Data: SymModeCFB}}, HashAlgorithmSHA256)
+		if err != nil {
+			t.Fatalf("StartAuthSession failed: %v", err)
+		}
+		defer flushContext(t, tpm, sessionContext)
+
+		iv := make(IV, aes.BlockSize)
+		plaintext := []byte("some data to encrypt")
+
+		ciphertext, _, err := tpm.EncryptDecrypt2(keyContext, plaintext, false, SymModeNull, iv,
+			sessionContext.WithAttrs(AttrContinueSession|AttrCommandEncrypt|AttrResponseEncrypt))
+		if err != nil {
+			t.Fatalf("EncryptDecrypt2 failed: %v", err)
+		}
+		if !bytes.Equal(ciphertext, computeExpectedSymmetricResult(t, key, SymModeCFB, iv, plaintext)) {
+			t.Errorf("Unexpected ciphertext")
+		}
+	})
+}
+
+func TestHash(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	maxBuffer := tpm.GetInputBuffer()
+
+	for _, data := range []struct {
+		desc      string
+		size      int
+		alg       HashAlgorithmId
+		hierarchy Handle
+		generated bool
+	}{
+		{
+			desc:      "SHA256",
+			size:      100,
+			alg:       HashAlgorithmSHA256,
+			hierarchy: HandleNull,
+		},
+		{
+			desc:      "SHA1",
+			size:      100,
+			alg:       HashAlgorithmSHA1,
+			hierarchy: HandleNull,
+		},
+		{
+			desc:      "WithTicket",
+			size:      100,
+			alg:       HashAlgorithmSHA256,
+			hierarchy: HandleOwner,
+		},
+		{
+			desc:      "Large",
+			size:      (maxBuffer * 2) + 10,
+			alg:       HashAlgorithmSHA256,
+			hierarchy: HandleOwner,
+		},
+		{
+			desc:      "TPMGenerated",
+			size:      100,
+			alg:       HashAlgorithmSHA256,
+			hierarchy: HandleOwner,
+			generated: true,
+		},
+		{
+			desc:      "LargeNoTicket",
+			size:      (maxBuffer * 2) + 10,
+			alg:       HashAlgorithmSHA256,
+			hierarchy: HandleNull,
+		},
+		{
+			desc:      "LargeTPMGenerated",
+			size:      (maxBuffer * 2) + 10,
+			alg:       HashAlgorithmSHA256,
+			hierarchy: HandleOwner,
+			generated: true,
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			in := make([]byte, data.size)
+			rand.Read(in)
+			if data.generated {
+				copy(in, []byte{0xff, 0x54, 0x43, 0x47})
+			}
+
+			digest, validation, err := tpm.Hash(in, data.alg, data.hierarchy)
+			if err != nil {
+				t.Fatalf("Hash failed: %v", err)
+			}
+
+			h := data.alg.NewHash()
+			h.Write(in)
+			if !bytes.Equal(digest, h.Sum(nil)) {
+				t.Errorf("Unexpected digest")
+			}
+
+			switch {
+			case data.hierarchy == HandleNull || data.generated:
+				if validation != nil {
+					t.Errorf("validation should be nil")
+				}
+			default:
+				if validation == nil {
+					t.Fatalf("validation should not be nil")
+				}
+				if validation.Tag != TagHashcheck {
+					t.Errorf("Unexpected ticket tag")
+				}
+				if validation.Hierarchy != data.hierarchy {
+					t.Errorf("Unexpected ticket hierarchy")
+				}
+			}
+		})
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	maxBuffer := tpm.GetInputBuffer()
+
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	seed := make([]byte, 32)
+
+	h := crypto.SHA256.New()
+	h.Write(seed)
+	h.Write(key)
+	unique := h.Sum(nil)
+
+	loadKey := func(t *testing.T, auth Auth) ResourceContext {
+		public := Public{
+			Type:    ObjectTypeKeyedHash,
+			NameAlg: HashAlgorithmSHA256,
+			Attrs:   AttrUserWithAuth | AttrSign,
+			Params: PublicParamsU{
+				Data: &KeyedHashParams{
+					Scheme: KeyedHashScheme{
+						Scheme:  KeyedHashSchemeHMAC,
+						Details: SchemeKeyedHashU{Data: &SchemeHMAC{HashAlg: HashAlgorithmSHA256}}}}},
+			Unique: PublicIDU{Data: Digest(unique)}}
+
+		authValue := make(Auth, public.NameAlg.Size())
+		copy(authValue, auth)
+		sensitive := Sensitive{
+			Type:      ObjectTypeKeyedHash,
+			AuthValue: authValue,
+			SeedValue: seed,
+			Sensitive: SensitiveCompositeU{Data: SensitiveData(key)}}
+		rc, err := tpm.LoadExternal(&sensitive, &public, HandleNull)
+		if err != nil {
+			t.Fatalf("LoadExternal failed: %v", err)
+		}
+		rc.SetAuthValue(auth)
+		return rc
+	}
+
+	for _, data := range []struct {
+		desc string
+		size int
+		auth Auth
+	}{
+		{
+			desc: "Small",
+			size: 100,
+		},
+		{
+			desc: "Large",
+			size: (maxBuffer * 2) + 10,
+		},
+		{
+			desc: "WithAuth",
+			size: 100,
+			auth: testAuth,
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			keyContext := loadKey(t, data.auth)
+			defer flushContext(t, tpm, keyContext)
+
+			in := make([]byte, data.size)
+			rand.Read(in)
+
+			result, err := tpm.HMAC(keyContext, in, HashAlgorithmNull, nil)
+			if err != nil {
+				t.Fatalf("HMAC failed: %v", err)
+			}
+
+			h := hmac.New(func() hash.Hash { return crypto.SHA256.New() }, key)
+			h.Write(in)
+			if !bytes.Equal(result, h.Sum(nil)) {
+				t.Errorf("Unexpected result")
+			}
+		})
+	}
+}
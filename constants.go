@@ -41,6 +41,16 @@ const (
 	TPMManufacturerGOOG TPMManufacturer = 0x474F4F47 // Google
 )
 
+const (
+	ClockAdjustCoarseSlower ClockAdjust = -3 // TPM_CLOCK_COARSE_SLOWER
+	ClockAdjustMediumSlower ClockAdjust = -2 // TPM_CLOCK_MEDIUM_SLOWER
+	ClockAdjustFineSlower   ClockAdjust = -1 // TPM_CLOCK_FINE_SLOWER
+	ClockAdjustNoChange     ClockAdjust = 0  // TPM_CLOCK_NO_CHANGE
+	ClockAdjustFineFaster   ClockAdjust = 1  // TPM_CLOCK_FINE_FASTER
+	ClockAdjustMediumFaster ClockAdjust = 2  // TPM_CLOCK_MEDIUM_FASTER
+	ClockAdjustCoarseFaster ClockAdjust = 3  // TPM_CLOCK_COARSE_FASTER
+)
+
 const (
 	OpEq         ArithmeticOp = 0x0000 // TPM_EO_EQ
 	OpNeq        ArithmeticOp = 0x0001 // TPM_EO_NEQ
@@ -84,10 +94,19 @@ const (
 	CommandEvictControl               CommandCode = 0x00000120 // TPM_CC_EvictControl
 	CommandHierarchyControl           CommandCode = 0x00000121 // TPM_CC_HierarchyControl
 	CommandNVUndefineSpace            CommandCode = 0x00000122 // TPM_CC_NV_UndefineSpace
+	CommandChangeEPS                  CommandCode = 0x00000124 // TPM_CC_ChangeEPS
+	CommandChangePPS                  CommandCode = 0x00000125 // TPM_CC_ChangePPS
 	CommandClear                      CommandCode = 0x00000126 // TPM_CC_Clear
 	CommandClearControl               CommandCode = 0x00000127 // TPM_CC_ClearControl
+	CommandClockSet                   CommandCode = 0x00000128 // TPM_CC_ClockSet
 	CommandHierarchyChangeAuth        CommandCode = 0x00000129 // TPM_CC_HierarchyChangeAuth
 	CommandNVDefineSpace              CommandCode = 0x0000012A // TPM_CC_NV_DefineSpace
+	CommandPCRAllocate                CommandCode = 0x0000012B // TPM_CC_PCR_Allocate
+	CommandPCRSetAuthPolicy           CommandCode = 0x0000012C // TPM_CC_PCR_SetAuthPolicy
+	CommandPPCommands                 CommandCode = 0x0000012D // TPM_CC_PP_Commands
+	CommandSetPrimaryPolicy           CommandCode = 0x0000012E // TPM_CC_SetPrimaryPolicy
+	CommandFieldUpgradeStart          CommandCode = 0x0000012F // TPM_CC_FieldUpgradeStart
+	CommandClockRateAdjust            CommandCode = 0x00000130 // TPM_CC_ClockRateAdjust
 	CommandCreatePrimary              CommandCode = 0x00000131 // TPM_CC_CreatePrimary
 	CommandNVGlobalWriteLock          CommandCode = 0x00000132 // TPM_CC_NV_GlobalWriteLock
 	CommandGetCommandAuditDigest      CommandCode = 0x00000133 // TPM_CC_GetCommandAuditDigest
@@ -102,7 +121,9 @@ const (
 	CommandPCREvent                   CommandCode = 0x0000013C // TPM_CC_PCR_Event
 	CommandPCRReset                   CommandCode = 0x0000013D // TPM_CC_PCR_Reset
 	CommandSequenceComplete           CommandCode = 0x0000013E // TPM_CC_SequenceComplete
+	CommandSetAlgorithmSet            CommandCode = 0x0000013F // TPM_CC_SetAlgorithmSet
 	CommandSetCommandCodeAuditStatus  CommandCode = 0x00000140 // TPM_CC_SetCommandCodeAuditStatus
+	CommandFieldUpgradeData           CommandCode = 0x00000141 // TPM_CC_FieldUpgradeData
 	CommandIncrementalSelfTest        CommandCode = 0x00000142 // TPM_CC_IncrementalSelfTest
 	CommandSelfTest                   CommandCode = 0x00000143 // TPM_CC_SelfTest
 	CommandStartup                    CommandCode = 0x00000144 // TPM_CC_Startup
@@ -119,10 +140,14 @@ const (
 	CommandNVReadLock                 CommandCode = 0x0000014F // TPM_CC_NV_ReadLock
 	CommandObjectChangeAuth           CommandCode = 0x00000150 // TPM_CC_ObjectChangeAuth
 	CommandPolicySecret               CommandCode = 0x00000151 // TPM_CC_PolicySecret
+	CommandRewrap                     CommandCode = 0x00000152 // TPM_CC_Rewrap
 	CommandCreate                     CommandCode = 0x00000153 // TPM_CC_Create
+	CommandECDHZGen                   CommandCode = 0x00000154 // TPM_CC_ECDH_ZGen
+	CommandHMAC                       CommandCode = 0x00000155 // TPM_CC_HMAC
 	CommandImport                     CommandCode = 0x00000156 // TPM_CC_Import
 	CommandLoad                       CommandCode = 0x00000157 // TPM_CC_Load
 	CommandQuote                      CommandCode = 0x00000158 // TPM_CC_Quote
+	CommandRSADecrypt                 CommandCode = 0x00000159 // TPM_CC_RSA_Decrypt
 	CommandHMACStart                  CommandCode = 0x0000015B // TPM_CC_HMAC_Start
 	CommandSequenceUpdate             CommandCode = 0x0000015C // TPM_CC_SequenceUpdate
 	CommandSign                       CommandCode = 0x0000015D // TPM_CC_Sign
@@ -130,6 +155,8 @@ const (
 	CommandPolicySigned               CommandCode = 0x00000160 // TPM_CC_PolicySigned
 	CommandContextLoad                CommandCode = 0x00000161 // TPM_CC_ContextLoad
 	CommandContextSave                CommandCode = 0x00000162 // TPM_CC_ContextSave
+	CommandECDHKeyGen                 CommandCode = 0x00000163 // TPM_CC_ECDH_KeyGen
+	CommandEncryptDecrypt             CommandCode = 0x00000164 // TPM_CC_EncryptDecrypt
 	CommandFlushContext               CommandCode = 0x00000165 // TPM_CC_FlushContext
 	CommandLoadExternal               CommandCode = 0x00000167 // TPM_CC_LoadExternal
 	CommandMakeCredential             CommandCode = 0x00000168 // TPM_CC_MakeCredential
@@ -139,28 +166,44 @@ const (
 	CommandPolicyCommandCode          CommandCode = 0x0000016C // TPM_CC_PolicyCommandCode
 	CommandPolicyCounterTimer         CommandCode = 0x0000016D // TPM_CC_PolicyCounterTimer
 	CommandPolicyCpHash               CommandCode = 0x0000016E // TPM_CC_PolicyCpHash
+	CommandPolicyLocality             CommandCode = 0x0000016F // TPM_CC_PolicyLocality
 	CommandPolicyNameHash             CommandCode = 0x00000170 // TPM_CC_PolicyNameHash
 	CommandPolicyOR                   CommandCode = 0x00000171 // TPM_CC_PolicyOR
 	CommandPolicyTicket               CommandCode = 0x00000172 // TPM_CC_PolicyTicket
 	CommandReadPublic                 CommandCode = 0x00000173 // TPM_CC_ReadPublic
+	CommandRSAEncrypt                 CommandCode = 0x00000174 // TPM_CC_RSA_Encrypt
 	CommandStartAuthSession           CommandCode = 0x00000176 // TPM_CC_StartAuthSession
 	CommandVerifySignature            CommandCode = 0x00000177 // TPM_CC_VerifySignature
+	CommandECCParameters              CommandCode = 0x00000178 // TPM_CC_ECC_Parameters
+	CommandFirmwareRead               CommandCode = 0x00000179 // TPM_CC_FirmwareRead
 	CommandGetCapability              CommandCode = 0x0000017A // TPM_CC_GetCapability
 	CommandGetRandom                  CommandCode = 0x0000017B // TPM_CC_GetRandom
 	CommandGetTestResult              CommandCode = 0x0000017C // TPM_CC_GetTestResult
+	CommandHash                       CommandCode = 0x0000017D // TPM_CC_Hash
 	CommandPCRRead                    CommandCode = 0x0000017E // TPM_CC_PCR_Read
 	CommandPolicyPCR                  CommandCode = 0x0000017F // TPM_CC_PolicyPCR
 	CommandPolicyRestart              CommandCode = 0x00000180 // TPM_CC_PolicyRestart
 	CommandReadClock                  CommandCode = 0x00000181 // TPM_CC_ReadClock
 	CommandPCRExtend                  CommandCode = 0x00000182 // TPM_CC_PCR_Extend
+	CommandPCRSetAuthValue            CommandCode = 0x00000183 // TPM_CC_PCR_SetAuthValue
+	CommandNVCertify                  CommandCode = 0x00000184 // TPM_CC_NV_Certify
 	CommandEventSequenceComplete      CommandCode = 0x00000185 // TPM_CC_EventSequenceComplete
 	CommandHashSequenceStart          CommandCode = 0x00000186 // TPM_CC_HashSequenceStart
+	CommandPolicyPhysicalPresence     CommandCode = 0x00000187 // TPM_CC_PolicyPhysicalPresence
 	CommandPolicyDuplicationSelect    CommandCode = 0x00000188 // TPM_CC_PolicyDuplicationSelect
 	CommandPolicyGetDigest            CommandCode = 0x00000189 // TPM_CC_PolicyGetDigest
 	CommandTestParms                  CommandCode = 0x0000018A // TPM_CC_TestParms
+	CommandCommit                     CommandCode = 0x0000018B // TPM_CC_Commit
 	CommandPolicyPassword             CommandCode = 0x0000018C // TPM_CC_PolicyPassword
+	CommandZGen2Phase                 CommandCode = 0x0000018D // TPM_CC_ZGen_2Phase
+	CommandECEphemeral                CommandCode = 0x0000018E // TPM_CC_EC_Ephemeral
 	CommandPolicyNvWritten            CommandCode = 0x0000018F // TPM_CC_PolicyNvWritten
+	CommandPolicyTemplate             CommandCode = 0x00000190 // TPM_CC_PolicyTemplate
 	CommandCreateLoaded               CommandCode = 0x00000191 // TPM_CC_CreateLoaded
+	CommandPolicyAuthorizeNV          CommandCode = 0x00000192 // TPM_CC_PolicyAuthorizeNV
+	CommandEncryptDecrypt2            CommandCode = 0x00000193 // TPM_CC_EncryptDecrypt2
+	CommandCertifyX509                CommandCode = 0x00000197 // TPM_CC_CertifyX509
+	CommandACTSetTimeout              CommandCode = 0x00000198 // TPM_CC_ACT_SetTimeout
 )
 
 const (
@@ -442,6 +485,23 @@ const (
 	HandleEndorsement Handle = 0x4000000b // TPM_RH_ENDORSEMENT
 	HandlePlatform    Handle = 0x4000000c // TPM_RH_PLATFORM
 	HandlePlatformNV  Handle = 0x4000000d // TPM_RH_PLATFORM_NV
+
+	HandleACT0 Handle = 0x40000110 // TPM_RH_ACT_0
+	HandleACT1 Handle = 0x40000111 // TPM_RH_ACT_1
+	HandleACT2 Handle = 0x40000112 // TPM_RH_ACT_2
+	HandleACT3 Handle = 0x40000113 // TPM_RH_ACT_3
+	HandleACT4 Handle = 0x40000114 // TPM_RH_ACT_4
+	HandleACT5 Handle = 0x40000115 // TPM_RH_ACT_5
+	HandleACT6 Handle = 0x40000116 // TPM_RH_ACT_6
+	HandleACT7 Handle = 0x40000117 // TPM_RH_ACT_7
+	HandleACT8 Handle = 0x40000118 // TPM_RH_ACT_8
+	HandleACT9 Handle = 0x40000119 // TPM_RH_ACT_9
+	HandleACTA Handle = 0x4000011a // TPM_RH_ACT_A
+	HandleACTB Handle = 0x4000011b // TPM_RH_ACT_B
+	HandleACTC Handle = 0x4000011c // TPM_RH_ACT_C
+	HandleACTD Handle = 0x4000011d // TPM_RH_ACT_D
+	HandleACTE Handle = 0x4000011e // TPM_RH_ACT_E
+	HandleACTF Handle = 0x4000011f // TPM_RH_ACT_F
 )
 
 const (
@@ -641,24 +701,25 @@ const (
 )
 
 const (
-	LocalityZero  Locality = 0 // TPM_LOC_ZERO
-	LocalityOne   Locality = 1 // TPM_LOC_ONE
-	LocalityTwo   Locality = 2 // TPM_LOC_TWO
-	LocalityThree Locality = 3 // TPM_LOC_THREE
-	LocalityFour  Locality = 4 // TPM_LOC_FOUR
+	LocalityZero  Locality = 1 << 0 // TPM_LOC_ZERO
+	LocalityOne   Locality = 1 << 1 // TPM_LOC_ONE
+	LocalityTwo   Locality = 1 << 2 // TPM_LOC_TWO
+	LocalityThree Locality = 1 << 3 // TPM_LOC_THREE
+	LocalityFour  Locality = 1 << 4 // TPM_LOC_FOUR
 )
 
 const (
-	CapabilityAlgs          Capability = 0 // TPM_CAP_ALGS
-	CapabilityHandles       Capability = 1 // TPM_CAP_HANDLES
-	CapabilityCommands      Capability = 2 // TPM_CAP_COMMANDS
-	CapabilityPPCommands    Capability = 3 // TPM_CAP_PP_COMMANDS
-	CapabilityAuditCommands Capability = 4 // TPM_CAP_AUDIT_COMMANDS
-	CapabilityPCRs          Capability = 5 // TPM_CAP_PCRS
-	CapabilityTPMProperties Capability = 6 // TPM_CAP_TPM_PROPERTIES
-	CapabilityPCRProperties Capability = 7 // TPM_CAP_PCR_PROPERTIES
-	CapabilityECCCurves     Capability = 8 // TPM_CAP_ECC_CURVES
-	CapabilityAuthPolicies  Capability = 9 // TPM_CAP_AUTH_POLICIES
+	CapabilityAlgs          Capability = 0  // TPM_CAP_ALGS
+	CapabilityHandles       Capability = 1  // TPM_CAP_HANDLES
+	CapabilityCommands      Capability = 2  // TPM_CAP_COMMANDS
+	CapabilityPPCommands    Capability = 3  // TPM_CAP_PP_COMMANDS
+	CapabilityAuditCommands Capability = 4  // TPM_CAP_AUDIT_COMMANDS
+	CapabilityPCRs          Capability = 5  // TPM_CAP_PCRS
+	CapabilityTPMProperties Capability = 6  // TPM_CAP_TPM_PROPERTIES
+	CapabilityPCRProperties Capability = 7  // TPM_CAP_PCR_PROPERTIES
+	CapabilityECCCurves     Capability = 8  // TPM_CAP_ECC_CURVES
+	CapabilityAuthPolicies  Capability = 9  // TPM_CAP_AUTH_POLICIES
+	CapabilityACT           Capability = 10 // TPM_CAP_ACT
 )
 
 const (
@@ -823,3 +884,8 @@ const (
 	AttrPhEnableNV StartupClearAttributes = 1 << 3  // phEnableNV
 	AttrOrderly    StartupClearAttributes = 1 << 31 // orderly
 )
+
+const (
+	AttrACTSignaled         ACTAttributes = 1 << 0 // signaled
+	AttrACTPreserveSignaled ACTAttributes = 1 << 1 // preserveSignaled
+)
@@ -6,8 +6,10 @@ package tpm2
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"golang.org/x/sys/unix"
 	"golang.org/x/xerrors"
@@ -15,19 +17,47 @@ import (
 
 const (
 	maxCommandSize int = 4096
+
+	// defaultDeviceResponseTimeout is the default maximum time to wait for a response from a TPM device. This is longer than the
+	// longest command duration that the Linux kernel's drivers permit.
+	defaultDeviceResponseTimeout = 5 * time.Minute
 )
 
-// TctiDeviceLinux represents a connection to a Linux TPM character device.
+// TctiDeviceLinux represents a connection to a Linux TPM character device. It implements the Transport and MaxCommandSizeTransport
+// interfaces.
 type TctiDeviceLinux struct {
+	// ResponseTimeout is the maximum time to wait for the response to a command. If it is zero, a default of 5 minutes is used. If
+	// the TPM doesn't respond in time, Read returns an error so that a wedged TPM doesn't block the caller indefinitely.
+	ResponseTimeout time.Duration
+
 	f   *os.File
 	buf *bytes.Reader
 }
 
 func (d *TctiDeviceLinux) readMoreData() error {
+	timeout := d.ResponseTimeout
+	if timeout == 0 {
+		timeout = defaultDeviceResponseTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
 	fds := []unix.PollFd{unix.PollFd{Fd: int32(d.f.Fd()), Events: unix.POLLIN}}
-	_, err := unix.Ppoll(fds, nil, nil)
-	if err != nil {
-		return xerrors.Errorf("polling device failed: %w", err)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return errors.New("timeout waiting for response from device")
+		}
+		ts := unix.NsecToTimespec(remaining.Nanoseconds())
+		n, err := unix.Ppoll(fds, &ts, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return xerrors.Errorf("polling device failed: %w", err)
+		}
+		if n > 0 {
+			break
+		}
 	}
 
 	if fds[0].Events != fds[0].Revents {
@@ -62,6 +92,12 @@ func (d *TctiDeviceLinux) Close() error {
 	return d.f.Close()
 }
 
+// MaxCommandSize returns the maximum size of a command or response that can be transmitted, which is limited by the size of the
+// buffer used by the kernel driver.
+func (d *TctiDeviceLinux) MaxCommandSize() int {
+	return maxCommandSize
+}
+
 // OpenTPMDevice attempts to open a connection to the Linux TPM character device at the specified path. If successful, it returns a
 // new TctiDeviceLinux instance which can be passed to NewTPMContext. Failure to open the TPM character device will result in a
 // wrapped *os.PathError being returned
@@ -0,0 +1,43 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/canonical/go-tpm2"
+)
+
+func TestTctiDeviceLinuxResponseTimeout(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe failed: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	tcti := NewTctiDeviceLinuxForTesting(r)
+	tcti.ResponseTimeout = 10 * time.Millisecond
+
+	buf := make([]byte, 16)
+	_, err = tcti.Read(buf)
+	if err == nil || err.Error() != "timeout waiting for response from device" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if _, err := w.Write([]byte("foo")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	n, err := tcti.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(buf[:n], []byte("foo")) {
+		t.Errorf("Unexpected data: %x", buf[:n])
+	}
+}
@@ -83,6 +83,26 @@ func (e *TctiError) Unwrap() error {
 	return e.err
 }
 
+// CommandCanceledError is returned from any TPMContext method that executes a command on the TPM if the context.Context set with
+// TPMContext.SetCommandContext is canceled or its deadline expires before the command completes. The error returned from the
+// context's Err method can be obtained by unwrapping this error.
+//
+// If the transmission interface implements CancellableTransport, this error is only returned if the TPM acknowledged the request
+// to cancel the command. If it does not, the command may have completed on the TPM after this error was returned, and any
+// HandleContexts associated with resources that the command creates or destroys may not reflect the state of the TPM.
+type CommandCanceledError struct {
+	Command CommandCode // Command code associated with this error
+	err     error
+}
+
+func (e *CommandCanceledError) Error() string {
+	return fmt.Sprintf("command %s was canceled: %v", e.Command, e.err)
+}
+
+func (e *CommandCanceledError) Unwrap() error {
+	return e.err
+}
+
 // TPM1Error is returned from DecodeResponseCode and any TPMContext method that executes a command on the TPM if the TPM response code
 // indicates an error from a TPM 1.2 device.
 type TPM1Error struct {
@@ -0,0 +1,13 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"os"
+)
+
+func NewTctiDeviceLinuxForTesting(f *os.File) *TctiDeviceLinux {
+	return &TctiDeviceLinux{f: f}
+}
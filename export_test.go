@@ -4,6 +4,11 @@
 
 package tpm2
 
+import (
+	"net"
+	"time"
+)
+
 func (r *permanentContext) GetAuthValue() []byte {
 	return r.auth
 }
@@ -42,3 +47,15 @@ type TestSessionContext interface {
 }
 
 var TestComputeBindName = computeBindName
+
+func MockCancelGracePeriod(d time.Duration) (restore func()) {
+	orig := cancelGracePeriod
+	cancelGracePeriod = d
+	return func() {
+		cancelGracePeriod = orig
+	}
+}
+
+func NewSwtpmForTesting(tpm, ctrl net.Conn) *TctiSwtpm {
+	return &TctiSwtpm{tpm: tpm, ctrl: ctrl}
+}
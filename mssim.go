@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"sync"
 
 	"github.com/canonical/go-tpm2/mu"
 
@@ -18,11 +20,18 @@ import (
 
 const (
 	cmdPowerOn        uint32 = 1
+	cmdPowerOff       uint32 = 2
+	cmdPPOn           uint32 = 3
+	cmdPPOff          uint32 = 4
 	cmdTPMSendCommand uint32 = 8
+	cmdCancelOn       uint32 = 9
+	cmdCancelOff      uint32 = 10
 	cmdNVOn           uint32 = 11
+	cmdNVOff          uint32 = 12
 	cmdReset          uint32 = 17
 	cmdSessionEnd     uint32 = 20
 	cmdStop           uint32 = 21
+	cmdFailureMode    uint32 = 30
 )
 
 // PlatformCommandError corresponds to an error code in response to a platform command executed on a TPM simulator.
@@ -35,13 +44,17 @@ func (e PlatformCommandError) Error() string {
 	return fmt.Sprintf("received error code %d in response to platform command %d", e.Code, e.commandCode)
 }
 
-// TctiMssim represents a connection to a TPM simulator that implements the Microsoft TPM2 simulator interface.
+// TctiMssim represents a connection to a TPM simulator that implements the Microsoft TPM2 simulator interface. It implements the
+// Transport, LocalityTransport, CancellableTransport, PowerControlTransport and PhysicalPresenceTransport interfaces.
 type TctiMssim struct {
-	Locality uint8 // Locality of commands submitted to the simulator on this interface
+	Locality uint8 // Locality of commands submitted to the simulator on this interface (see also SetLocality)
 
 	tpm      net.Conn
 	platform net.Conn
 
+	platformMu sync.Mutex
+	cancelled  bool
+
 	buf *bytes.Reader
 }
 
@@ -62,6 +75,7 @@ func (t *TctiMssim) readMoreData() error {
 	if err := binary.Read(t.tpm, binary.BigEndian, &trash); err != nil {
 		return xerrors.Errorf("cannot read zero bytes from TPM command channel after response: %w", err)
 	}
+
 	return nil
 }
 
@@ -75,6 +89,12 @@ func (t *TctiMssim) Read(data []byte) (int, error) {
 }
 
 func (t *TctiMssim) Write(data []byte) (int, error) {
+	// Make sure that a cancel request for a previous command doesn't cancel this one. Cancel may have been called after the response
+	// to the previous command was received.
+	if err := t.clearCancel(); err != nil {
+		return 0, err
+	}
+
 	buf, err := mu.MarshalToBytes(cmdTPMSendCommand, t.Locality, uint32(len(data)), mu.RawBytes(data))
 	if err != nil {
 		panic(fmt.Sprintf("cannot marshal command: %v", err))
@@ -103,6 +123,12 @@ func (t *TctiMssim) Close() (out error) {
 }
 
 func (t *TctiMssim) platformCommand(cmd uint32) error {
+	t.platformMu.Lock()
+	defer t.platformMu.Unlock()
+	return t.platformCommandLocked(cmd)
+}
+
+func (t *TctiMssim) platformCommandLocked(cmd uint32) error {
 	if err := binary.Write(t.platform, binary.BigEndian, cmd); err != nil {
 		return xerrors.Errorf("cannot send command: %w", err)
 	}
@@ -118,12 +144,115 @@ func (t *TctiMssim) platformCommand(cmd uint32) error {
 	return nil
 }
 
+// GetLocality returns the locality from which commands are submitted to the TPM simulator. This is the value of the Locality field.
+func (t *TctiMssim) GetLocality() uint8 {
+	return t.Locality
+}
+
+// SetLocality sets the locality from which subsequent commands are submitted to the TPM simulator. Valid localities are 0 to 4, and
+// the extended localities 32 to 255.
+func (t *TctiMssim) SetLocality(locality uint8) error {
+	if locality > 4 && locality < 32 {
+		return fmt.Errorf("invalid locality %d", locality)
+	}
+	t.Locality = locality
+	return nil
+}
+
+// Cancel submits the cancel on command on the platform connection, which requests that the TPM simulator cancels the command that
+// it is currently executing. The cancel off command is submitted automatically before the next command is sent, or it can be
+// submitted explicitly with CancelOff.
+func (t *TctiMssim) Cancel() error {
+	t.platformMu.Lock()
+	defer t.platformMu.Unlock()
+
+	if err := t.platformCommandLocked(cmdCancelOn); err != nil {
+		return err
+	}
+	t.cancelled = true
+	return nil
+}
+
+// CancelOff submits the cancel off command on the platform connection, which clears a cancel request made with Cancel.
+func (t *TctiMssim) CancelOff() error {
+	t.platformMu.Lock()
+	defer t.platformMu.Unlock()
+
+	if err := t.platformCommandLocked(cmdCancelOff); err != nil {
+		return err
+	}
+	t.cancelled = false
+	return nil
+}
+
+func (t *TctiMssim) clearCancel() error {
+	t.platformMu.Lock()
+	cancelled := t.cancelled
+	t.platformMu.Unlock()
+
+	if !cancelled {
+		return nil
+	}
+	if err := t.CancelOff(); err != nil {
+		return xerrors.Errorf("cannot complete cancel off command: %w", err)
+	}
+	return nil
+}
+
+// PowerOn submits the power on and NV on commands on the platform connection, which applies power to the TPM simulator and makes its
+// NV memory available.
+func (t *TctiMssim) PowerOn() error {
+	if err := t.platformCommand(cmdPowerOn); err != nil {
+		return xerrors.Errorf("cannot complete power on command: %w", err)
+	}
+	if err := t.platformCommand(cmdNVOn); err != nil {
+		return xerrors.Errorf("cannot complete NV on command: %w", err)
+	}
+	return nil
+}
+
+// PowerOff submits the power off command on the platform connection, which removes power from the TPM simulator. This can be used
+// to simulate an unexpected loss of power if it is called without first calling TPMContext.Shutdown, in which case a subsequent
+// call to TPMContext.Startup with StartupState will fail.
+func (t *TctiMssim) PowerOff() error {
+	return t.platformCommand(cmdPowerOff)
+}
+
+// NVOn submits the NV on command on the platform connection, which makes the TPM simulator's NV memory available.
+func (t *TctiMssim) NVOn() error {
+	return t.platformCommand(cmdNVOn)
+}
+
+// NVOff submits the NV off command on the platform connection, which makes the TPM simulator's NV memory unavailable. Subsequent
+// commands that require access to NV memory will fail with a *TPMWarning error with a warning code of WarningNVUnavailable until NVOn
+// is called.
+func (t *TctiMssim) NVOff() error {
+	return t.platformCommand(cmdNVOff)
+}
+
 // Reset submits the reset command on the platform connection, which initiates a reset of the TPM simulator and results in the
 // execution of _TPM_Init().
 func (t *TctiMssim) Reset() error {
 	return t.platformCommand(cmdReset)
 }
 
+// AssertPhysicalPresence submits the physical presence on command on the platform connection.
+func (t *TctiMssim) AssertPhysicalPresence() error {
+	return t.platformCommand(cmdPPOn)
+}
+
+// DeassertPhysicalPresence submits the physical presence off command on the platform connection.
+func (t *TctiMssim) DeassertPhysicalPresence() error {
+	return t.platformCommand(cmdPPOff)
+}
+
+// ForceFailureMode submits the test failure mode command on the platform connection, which forces the TPM simulator to enter
+// failure mode the next time that it performs a self test, such as when TPMContext.SelfTest is called. The TPM simulator will remain
+// in failure mode until it is reset.
+func (t *TctiMssim) ForceFailureMode() error {
+	return t.platformCommand(cmdFailureMode)
+}
+
 func sendStop(conn net.Conn) error {
 	return binary.Write(conn, binary.BigEndian, cmdStop)
 }
@@ -136,7 +265,7 @@ func (t *TctiMssim) Stop() (out error) {
 	if err := sendStop(t.tpm); err != nil {
 		out = xerrors.Errorf("cannot send stop command on TPM command channel: %w", err)
 	}
-	return nil
+	return
 }
 
 // OpenMssim attempts to open a connection to a TPM simulator on the specified host. tpmPort is the port on which the TPM command
@@ -149,8 +278,8 @@ func OpenMssim(host string, tpmPort, platformPort uint) (*TctiMssim, error) {
 		host = "localhost"
 	}
 
-	tpmAddress := fmt.Sprintf("%s:%d", host, tpmPort)
-	platformAddress := fmt.Sprintf("%s:%d", host, platformPort)
+	tpmAddress := net.JoinHostPort(host, strconv.FormatUint(uint64(tpmPort), 10))
+	platformAddress := net.JoinHostPort(host, strconv.FormatUint(uint64(platformPort), 10))
 
 	tcti := new(TctiMssim)
 	tcti.Locality = 3
@@ -168,11 +297,8 @@ func OpenMssim(host string, tpmPort, platformPort uint) (*TctiMssim, error) {
 	}
 	tcti.platform = platform
 
-	if err := tcti.platformCommand(cmdPowerOn); err != nil {
-		return nil, xerrors.Errorf("cannot complete power on command: %w", err)
-	}
-	if err := tcti.platformCommand(cmdNVOn); err != nil {
-		return nil, xerrors.Errorf("cannot complete NV on command: %w", err)
+	if err := tcti.PowerOn(); err != nil {
+		return nil, err
 	}
 
 	return tcti, nil
@@ -0,0 +1,301 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+)
+
+// fakeMssim implements enough of the Microsoft TPM simulator protocol to test TctiMssim without a simulator. The TPM command channel
+// echoes commands back as responses, and the platform channel records the commands that it receives.
+type fakeMssim struct {
+	tpm      net.Listener
+	platform net.Listener
+
+	mu               sync.Mutex
+	platformCommands []uint32
+	// platformCommandsAtTPMCommand contains a copy of platformCommands for each command received on the TPM command channel.
+	platformCommandsAtTPMCommand [][]uint32
+}
+
+func newFakeMssim(t *testing.T) *fakeMssim {
+	tpm, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	platform, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tpm.Close()
+		t.Fatalf("Listen failed: %v", err)
+	}
+	s := &fakeMssim{tpm: tpm, platform: platform}
+	go s.serveTPM()
+	go s.servePlatform()
+	return s
+}
+
+func (s *fakeMssim) ports() (uint, uint) {
+	return uint(s.tpm.Addr().(*net.TCPAddr).Port), uint(s.platform.Addr().(*net.TCPAddr).Port)
+}
+
+func (s *fakeMssim) close() {
+	s.tpm.Close()
+	s.platform.Close()
+}
+
+func (s *fakeMssim) serveTPM() {
+	conn, err := s.tpm.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		var cmd uint32
+		if err := binary.Read(conn, binary.BigEndian, &cmd); err != nil || cmd != 8 {
+			return
+		}
+		var locality uint8
+		var size uint32
+		if err := binary.Read(conn, binary.BigEndian, &locality); err != nil {
+			return
+		}
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.platformCommandsAtTPMCommand = append(s.platformCommandsAtTPMCommand, append([]uint32(nil), s.platformCommands...))
+		s.mu.Unlock()
+
+		binary.Write(conn, binary.BigEndian, size)
+		conn.Write(data)
+		binary.Write(conn, binary.BigEndian, uint32(0))
+	}
+}
+
+func (s *fakeMssim) servePlatform() {
+	conn, err := s.platform.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		var cmd uint32
+		if err := binary.Read(conn, binary.BigEndian, &cmd); err != nil || cmd == 20 {
+			return
+		}
+		s.mu.Lock()
+		s.platformCommands = append(s.platformCommands, cmd)
+		s.mu.Unlock()
+		binary.Write(conn, binary.BigEndian, uint32(0))
+	}
+}
+
+func TestMssimCancelIsClearedBeforeNextCommand(t *testing.T) {
+	server := newFakeMssim(t)
+	defer server.close()
+
+	tpmPort, platformPort := server.ports()
+	tcti, err := OpenMssim("127.0.0.1", tpmPort, platformPort)
+	if err != nil {
+		t.Fatalf("OpenMssim failed: %v", err)
+	}
+	defer tcti.Close()
+
+	cmd := []byte{1, 2, 3, 4}
+	rsp := make([]byte, len(cmd))
+	if _, err := tcti.Write(cmd); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := io.ReadFull(tcti, rsp); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	// Request cancellation after the response has been received, as TPMContext does if its context is canceled at the same time
+	// as the TPM responds.
+	if err := tcti.Cancel(); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+
+	if _, err := tcti.Write(cmd); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := io.ReadFull(tcti, rsp); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.platformCommandsAtTPMCommand) != 2 {
+		t.Fatalf("Unexpected number of TPM commands")
+	}
+	// The platform channel should have received power on, NV on, cancel on and then cancel off before the second command.
+	expected := []uint32{1, 11, 9, 10}
+	platformCommands := server.platformCommandsAtTPMCommand[1]
+	if len(platformCommands) != len(expected) {
+		t.Fatalf("Unexpected platform commands: %v", platformCommands)
+	}
+	for i := range expected {
+		if platformCommands[i] != expected[i] {
+			t.Errorf("Unexpected platform commands: %v", platformCommands)
+			break
+		}
+	}
+}
+
+func TestMssimSetLocality(t *testing.T) {
+	tcti := new(TctiMssim)
+
+	for _, data := range []struct {
+		locality uint8
+		valid    bool
+	}{
+		{0, true},
+		{4, true},
+		{5, false},
+		{31, false},
+		{32, true},
+		{255, true},
+	} {
+		err := tcti.SetLocality(data.locality)
+		switch {
+		case data.valid && err != nil:
+			t.Errorf("SetLocality(%d) failed: %v", data.locality, err)
+		case !data.valid && err == nil:
+			t.Errorf("SetLocality(%d) should have failed", data.locality)
+		case data.valid && tcti.GetLocality() != data.locality:
+			t.Errorf("Unexpected locality %d", tcti.GetLocality())
+		}
+	}
+
+	tcti.Locality = 2
+	if tcti.GetLocality() != 2 {
+		t.Errorf("GetLocality should return the value of the Locality field")
+	}
+}
+
+func TestMssimPowerControl(t *testing.T) {
+	tpm, tcti := openTPMSimulatorForTesting(t)
+	defer closeTPM(t, tpm)
+
+	var transport PowerControlTransport = tcti
+	if tpm.Transport() != transport {
+		t.Errorf("Unexpected transport")
+	}
+
+	if err := tpm.Shutdown(StartupClear); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := transport.PowerOff(); err != nil {
+		t.Fatalf("PowerOff failed: %v", err)
+	}
+	if err := transport.PowerOn(); err != nil {
+		t.Fatalf("PowerOn failed: %v", err)
+	}
+	if err := tpm.Startup(StartupClear); err != nil {
+		t.Fatalf("Startup failed: %v", err)
+	}
+}
+
+func TestMssimLocality(t *testing.T) {
+	tpm, tcti := openMssimForTesting(t)
+	defer closeTPM(t, tpm)
+
+	defer tcti.SetLocality(3)
+
+	for _, locality := range []uint8{0, 3, 4} {
+		if err := tcti.SetLocality(locality); err != nil {
+			t.Fatalf("SetLocality failed: %v", err)
+		}
+		// TPM2_PCR_Reset of PCR 16 (the debug PCR) is permitted from any locality.
+		if err := tpm.PCRReset(tpm.PCRHandleContext(16), nil); err != nil {
+			t.Errorf("PCRReset from locality %d failed: %v", locality, err)
+		}
+	}
+}
+
+func TestMssimPowerLoss(t *testing.T) {
+	tpm, tcti := openTPMSimulatorForTesting(t)
+	defer closeTPM(t, tpm)
+
+	// Remove power without an orderly shutdown.
+	if err := tcti.PowerOff(); err != nil {
+		t.Fatalf("PowerOff failed: %v", err)
+	}
+	if err := tcti.PowerOn(); err != nil {
+		t.Fatalf("PowerOn failed: %v", err)
+	}
+
+	if err := tpm.Startup(StartupState); !IsTPMParameterError(err, ErrorValue, CommandStartup, 1) {
+		t.Errorf("Startup(STATE) after a missed Shutdown should fail: %v", err)
+	}
+	if err := tpm.Startup(StartupClear); err != nil {
+		t.Fatalf("Startup(CLEAR) failed: %v", err)
+	}
+}
+
+func TestMssimNVOff(t *testing.T) {
+	tpm, tcti := openMssimForTesting(t)
+	defer closeTPM(t, tpm)
+
+	if err := tcti.NVOff(); err != nil {
+		t.Fatalf("NVOff failed: %v", err)
+	}
+	defer func() {
+		if err := tcti.NVOn(); err != nil {
+			t.Errorf("NVOn failed: %v", err)
+		}
+	}()
+
+	pub := NVPublic{
+		Index:   Handle(0x0181ff00),
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   NVTypeOrdinary.WithAttrs(AttrNVAuthWrite | AttrNVAuthRead),
+		Size:    8}
+	_, err := tpm.NVDefineSpace(tpm.OwnerHandleContext(), nil, &pub, nil)
+	if !IsTPMWarning(err, WarningNVUnavailable, CommandNVDefineSpace) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestMssimForceFailureMode(t *testing.T) {
+	tpm, tcti := openMssimForTesting(t)
+	defer closeTPM(t, tpm)
+
+	if err := tcti.ForceFailureMode(); err != nil {
+		t.Fatalf("ForceFailureMode failed: %v", err)
+	}
+
+	if err := tpm.SelfTest(true); !IsTPMError(err, ErrorFailure, CommandSelfTest) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	_, rc, err := tpm.GetTestResult()
+	if err != nil {
+		t.Fatalf("GetTestResult failed: %v", err)
+	}
+	if rc == Success {
+		t.Errorf("Unexpected test result")
+	}
+
+	// The TPM doesn't accept TPM2_Shutdown in failure mode, so reset it directly.
+	if err := tcti.Reset(); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if err := tpm.Startup(StartupClear); err != nil {
+		t.Fatalf("Startup failed: %v", err)
+	}
+}
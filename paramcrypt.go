@@ -39,10 +39,31 @@ func hasDecryptSession(sessions []*sessionParam) bool {
 	return s != nil
 }
 
+func hasEncryptSession(sessions []*sessionParam) bool {
+	s, _ := findEncryptSession(sessions)
+	return s != nil
+}
+
 func isParamEncryptable(param interface{}) bool {
 	return mu.DetermineTPMKind(param) == mu.TPMKindSized
 }
 
+func isCommandParamEncryptable(commandCode CommandCode, params []interface{}) bool {
+	if cmd, isVendor := lookupVendorCommand(commandCode); isVendor {
+		if !cmd.CommandParamEncryption {
+			return false
+		}
+	}
+	return len(params) > 0 && isParamEncryptable(params[0])
+}
+
+func isResponseParamEncryptable(commandCode CommandCode) bool {
+	if cmd, isVendor := lookupVendorCommand(commandCode); isVendor {
+		return cmd.ResponseParamEncryption
+	}
+	return true
+}
+
 func (s *sessionParam) computeSessionValue() []byte {
 	var key []byte
 	key = append(key, s.session.scData().SessionKey...)
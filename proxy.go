@@ -0,0 +1,332 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+// ProxyProtocol corresponds to the protocol used by clients of ProxyServer to send commands.
+type ProxyProtocol int
+
+const (
+	// ProxyProtocolMssim corresponds to the TPM command channel of the Microsoft TPM2 simulator interface, as used by TctiMssim.
+	ProxyProtocolMssim ProxyProtocol = iota
+
+	// ProxyProtocolLengthPrefixed is a simple protocol where each command is preceded by its length as a big-endian uint32, and
+	// each response is preceded by its length in the same way.
+	ProxyProtocolLengthPrefixed
+)
+
+// proxyMaxCommandSize is the maximum size of a command accepted from a client. This is much larger than any real TPM accepts, and
+// exists to prevent a client from making the server allocate an arbitrarily large buffer.
+const proxyMaxCommandSize = 64 * 1024
+
+// ProxyServer is a server that shares a TPM between multiple clients connected over sockets. Commands from all clients are
+// forwarded to a single transmission interface, and the server ensures that only one command is executing at a time.
+//
+// If per-client handle isolation is enabled, commands are routed via a ResourceManager so that each client has its own set of
+// virtual handles for transient objects, can only access the transient objects and sessions that it created, and has these flushed
+// when it disconnects.
+type ProxyServer struct {
+	// ErrorLog is used to log errors that cause a client connection to be closed. If this is nil, errors are logged using the
+	// log package's standard logger.
+	ErrorLog *log.Logger
+
+	mu sync.Mutex // Serializes access to the TPM
+
+	tcti Transport
+	tpm  *TPMContext      // Used to execute commands when handle isolation is not enabled
+	rm   *ResourceManager // Used to execute commands when handle isolation is enabled
+
+	connsMu   sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	handlers  sync.WaitGroup // Tracks running connection handlers
+}
+
+// NewProxyServer returns a new ProxyServer that forwards commands to the supplied transmission interface. If isolateHandles is true,
+// each client is isolated from the transient objects and sessions of other clients.
+//
+// The server does not take ownership of tcti, and it must be closed by the caller once the server has been closed.
+func NewProxyServer(tcti Transport, isolateHandles bool) *ProxyServer {
+	s := &ProxyServer{
+		tcti:      tcti,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{})}
+	if isolateHandles {
+		s.rm = NewResourceManager(tcti)
+	} else {
+		s.tpm = newTpmContext(tcti)
+	}
+	return s
+}
+
+// proxyClient corresponds to a client connection.
+type proxyClient struct {
+	server *ProxyServer
+	tpm    *TPMContext // Used to execute commands when handle isolation is enabled
+}
+
+func (c *proxyClient) close() error {
+	if c.tpm == nil {
+		return nil
+	}
+	return c.tpm.Close()
+}
+
+// runCommand forwards the supplied command to the TPM from the specified locality, and returns the response.
+func (c *proxyClient) runCommand(locality uint8, cmd []byte) ([]byte, error) {
+	var hdr commandHeader
+	if _, err := mu.UnmarshalFromBytes(cmd, &hdr); err != nil {
+		return nil, xerrors.Errorf("cannot unmarshal command header: %w", err)
+	}
+
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+
+	if l, ok := c.server.tcti.(LocalityTransport); ok && l.GetLocality() != locality {
+		if err := l.SetLocality(locality); err != nil {
+			return nil, xerrors.Errorf("cannot set locality: %w", err)
+		}
+	}
+
+	tpm := c.server.tpm
+	if c.tpm != nil {
+		tpm = c.tpm
+	}
+	rc, tag, rpBytes, err := tpm.RunCommandBytes(hdr.Tag, hdr.CommandCode, cmd[binary.Size(hdr):])
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := makeResponse(rc, rpBytes)
+	binary.BigEndian.PutUint16(rsp, uint16(tag))
+	return rsp, nil
+}
+
+func readProxyCommand(r io.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > proxyMaxCommandSize {
+		return nil, fmt.Errorf("command too large (%d bytes)", size)
+	}
+	cmd := make([]byte, size)
+	if _, err := io.ReadFull(r, cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// serveMssim handles a client connection using the TPM command channel protocol of the Microsoft TPM2 simulator interface.
+func (c *proxyClient) serveMssim(conn net.Conn) error {
+	for {
+		var cmd uint32
+		if err := binary.Read(conn, binary.BigEndian, &cmd); err != nil {
+			return err
+		}
+
+		switch cmd {
+		case cmdTPMSendCommand:
+			var locality uint8
+			if err := binary.Read(conn, binary.BigEndian, &locality); err != nil {
+				return err
+			}
+			tpmCmd, err := readProxyCommand(conn)
+			if err != nil {
+				return err
+			}
+			rsp, err := c.runCommand(locality, tpmCmd)
+			if err != nil {
+				return err
+			}
+			if _, err := mu.MarshalToWriter(conn, uint32(len(rsp)), mu.RawBytes(rsp), uint32(0)); err != nil {
+				return err
+			}
+		case cmdSessionEnd, cmdStop:
+			return nil
+		default:
+			return fmt.Errorf("unsupported command %d", cmd)
+		}
+	}
+}
+
+// serveLengthPrefixed handles a client connection using the ProxyProtocolLengthPrefixed protocol.
+func (c *proxyClient) serveLengthPrefixed(conn net.Conn) error {
+	for {
+		cmd, err := readProxyCommand(conn)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		rsp, err := c.runCommand(0, cmd)
+		if err != nil {
+			return err
+		}
+		if _, err := mu.MarshalToWriter(conn, uint32(len(rsp)), mu.RawBytes(rsp)); err != nil {
+			return err
+		}
+	}
+}
+
+// servePlatform handles a client connection to the platform channel of the Microsoft TPM2 simulator interface. Platform commands
+// are acknowledged but are not forwarded to the TPM, because it is shared between clients.
+func servePlatform(conn net.Conn) error {
+	for {
+		var cmd uint32
+		if err := binary.Read(conn, binary.BigEndian, &cmd); err != nil {
+			return err
+		}
+		switch cmd {
+		case cmdSessionEnd, cmdStop:
+			return nil
+		default:
+			if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *ProxyServer) trackListener(l net.Listener) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *ProxyServer) trackConn(conn net.Conn) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.handlers.Add(1)
+	return true
+}
+
+func (s *ProxyServer) untrackConn(conn net.Conn) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	delete(s.conns, conn)
+	s.handlers.Done()
+}
+
+func (s *ProxyServer) isClosed() bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	return s.closed
+}
+
+func (s *ProxyServer) logf(format string, v ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+func (s *ProxyServer) serve(l net.Listener, handler func(net.Conn) error) error {
+	if !s.trackListener(l) {
+		l.Close()
+		return errors.New("server is closed")
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+		if !s.trackConn(conn) {
+			conn.Close()
+			return nil
+		}
+
+		go func() {
+			defer func() {
+				s.untrackConn(conn)
+				conn.Close()
+			}()
+			// A client disconnecting without ending the session and connections being closed by Close aren't errors.
+			if err := handler(conn); err != nil && err != io.EOF && !s.isClosed() {
+				s.logf("tpm2: proxy: closing connection from %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Serve accepts client connections on the supplied listener, and services commands from them using the specified protocol. It
+// blocks until the listener returns an error or the server is closed. The listener is closed when the server is closed.
+func (s *ProxyServer) Serve(l net.Listener, protocol ProxyProtocol) error {
+	switch protocol {
+	case ProxyProtocolMssim, ProxyProtocolLengthPrefixed:
+	default:
+		return makeInvalidArgError("protocol", fmt.Sprintf("unrecognized protocol %d", protocol))
+	}
+
+	return s.serve(l, func(conn net.Conn) error {
+		client := &proxyClient{server: s}
+		if s.rm != nil {
+			client.tpm = newTpmContext(s.rm.NewTransport())
+		}
+		defer client.close()
+
+		if protocol == ProxyProtocolMssim {
+			return client.serveMssim(conn)
+		}
+		return client.serveLengthPrefixed(conn)
+	})
+}
+
+// ServePlatform accepts client connections to the platform channel of the Microsoft TPM2 simulator interface on the supplied
+// listener. This is required for clients that use TctiMssim, which connects to the platform channel and the TPM command channel.
+// It blocks until the listener returns an error or the server is closed. The listener is closed when the server is closed.
+func (s *ProxyServer) ServePlatform(l net.Listener) error {
+	return s.serve(l, servePlatform)
+}
+
+// Close closes all of the listeners and client connections associated with this server, and waits for the handlers of the client
+// connections to finish.
+func (s *ProxyServer) Close() (out error) {
+	defer s.handlers.Wait()
+
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	s.closed = true
+	for l := range s.listeners {
+		if err := l.Close(); err != nil {
+			out = err
+		}
+	}
+	for conn := range s.conns {
+		if err := conn.Close(); err != nil {
+			out = err
+		}
+	}
+	return
+}
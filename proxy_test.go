@@ -0,0 +1,235 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+func startProxyServer(t *testing.T, tcti Transport, isolateHandles bool) (*ProxyServer, net.Listener, net.Listener) {
+	server := NewProxyServer(tcti, isolateHandles)
+
+	tpmListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	platformListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	go server.Serve(tpmListener, ProxyProtocolMssim)
+	go server.ServePlatform(platformListener)
+	return server, tpmListener, platformListener
+}
+
+func openMssimProxyClient(tpmListener, platformListener net.Listener) (*TPMContext, error) {
+	port := func(l net.Listener) uint {
+		_, p, _ := net.SplitHostPort(l.Addr().String())
+		n, _ := strconv.ParseUint(p, 10, 16)
+		return uint(n)
+	}
+	tcti, err := OpenMssim("127.0.0.1", port(tpmListener), port(platformListener))
+	if err != nil {
+		return nil, err
+	}
+	return NewTPMContext(tcti)
+}
+
+func TestProxyServerMssim(t *testing.T) {
+	transport := &scriptedTransport{}
+	for i := 0; i < 4; i++ {
+		transport.addResponse(t, Success, Digest("12345678"))
+	}
+
+	server, tpmListener, platformListener := startProxyServer(t, transport, false)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tpm, err := openMssimProxyClient(tpmListener, platformListener)
+			if err != nil {
+				t.Errorf("openMssimProxyClient failed: %v", err)
+				return
+			}
+			defer tpm.Close()
+
+			for j := 0; j < 2; j++ {
+				digest, err := tpm.GetRandom(8)
+				if err != nil {
+					t.Errorf("GetRandom failed: %v", err)
+					return
+				}
+				if !bytes.Equal(digest, []byte("12345678")) {
+					t.Errorf("Unexpected response (%x)", digest)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(transport.commands) != 4 {
+		t.Errorf("Unexpected number of commands (%d)", len(transport.commands))
+	}
+}
+
+func TestProxyServerLengthPrefixed(t *testing.T) {
+	transport := &scriptedTransport{}
+	transport.addResponse(t, Success, Digest("12345678"))
+
+	server := NewProxyServer(transport, false)
+	defer server.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go server.Serve(l, ProxyProtocolLengthPrefixed)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	cmd, _ := mu.MarshalToBytes(TagNoSessions, uint32(12), CommandGetRandom, uint16(8))
+	if _, err := mu.MarshalToWriter(conn, uint32(len(cmd)), mu.RawBytes(cmd)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	var size uint32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	rsp := make([]byte, size)
+	if _, err := io.ReadFull(conn, rsp); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	var hdr struct {
+		Tag          StructTag
+		ResponseSize uint32
+		ResponseCode ResponseCode
+	}
+	var digest Digest
+	if _, err := mu.UnmarshalFromBytes(rsp, &hdr, &digest); err != nil {
+		t.Fatalf("UnmarshalFromBytes failed: %v", err)
+	}
+	if hdr.ResponseCode != Success || int(hdr.ResponseSize) != len(rsp) {
+		t.Errorf("Unexpected response header")
+	}
+	if !bytes.Equal(digest, []byte("12345678")) {
+		t.Errorf("Unexpected response (%x)", digest)
+	}
+	if !bytes.Equal(transport.commands[0], cmd) {
+		t.Errorf("Unexpected command forwarded to the TPM")
+	}
+}
+
+func TestProxyServerIsolateHandles(t *testing.T) {
+	mock := newMockObjectTPM(t, 2)
+	server, tpmListener, platformListener := startProxyServer(t, mock, true)
+	defer server.Close()
+
+	client1, err := openMssimProxyClient(tpmListener, platformListener)
+	if err != nil {
+		t.Fatalf("openMssimProxyClient failed: %v", err)
+	}
+	client2, err := openMssimProxyClient(tpmListener, platformListener)
+	if err != nil {
+		t.Fatalf("openMssimProxyClient failed: %v", err)
+	}
+	defer client2.Close()
+
+	load := func(tpm *TPMContext, id uint32) Handle {
+		rc, _, rpBytes, err := tpm.RunCommandBytes(TagNoSessions, CommandLoadExternal, []byte{0, 0, 0, byte(id)})
+		if err != nil {
+			t.Fatalf("RunCommandBytes failed: %v", err)
+		}
+		if rc != Success {
+			t.Fatalf("LoadExternal failed: 0x%08x", rc)
+		}
+		return Handle(binary.BigEndian.Uint32(rpBytes))
+	}
+
+	load(client1, 1)
+	load(client1, 2)
+	h := load(client2, 3)
+
+	handles, err := client2.GetCapabilityHandles(HandleTypeTransient.BaseHandle(), CapabilityMaxProperties)
+	if err != nil {
+		t.Fatalf("GetCapabilityHandles failed: %v", err)
+	}
+	if len(handles) != 1 || handles[0] != h {
+		t.Errorf("Unexpected handles: %v", handles)
+	}
+
+	// Closing the first client should flush its objects. This happens asynchronously once the server sees the connection close.
+	if err := client1.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	for start := time.Now(); mock.numObjects() != 1; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Objects weren't flushed")
+		}
+	}
+
+	rc, _, rpBytes, err := client2.RunCommandBytes(TagNoSessions, CommandReadPublic, []byte{byte(h >> 24), byte(h >> 16), byte(h >> 8), byte(h)})
+	if err != nil {
+		t.Fatalf("RunCommandBytes failed: %v", err)
+	}
+	if rc != Success || binary.BigEndian.Uint32(rpBytes) != 3 {
+		t.Errorf("Unexpected result from ReadPublic (rc: 0x%08x)", rc)
+	}
+}
+
+func TestProxyServerLogsHandlerErrors(t *testing.T) {
+	server := NewProxyServer(&scriptedTransport{}, false)
+	var logBuf bytes.Buffer
+	server.ErrorLog = log.New(&logBuf, "", 0)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go server.Serve(l, ProxyProtocolMssim)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Send an unsupported command and wait for the server to close the connection.
+	if err := binary.Write(conn, binary.BigEndian, uint32(1000)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := ioutil.ReadAll(conn); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if !strings.Contains(logBuf.String(), "unsupported command 1000") {
+		t.Errorf("Unexpected log output: %q", logBuf.String())
+	}
+}
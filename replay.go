@@ -0,0 +1,259 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+// maxTransportRecordSize is the maximum size of a recorded command or response accepted by ReadTransportRecords. This is much larger
+// than any real TPM supports, and exists to prevent a corrupted record from causing an arbitrarily large allocation.
+const maxTransportRecordSize = 64 * 1024
+
+// TransportRecord corresponds to a single command and response pair recorded by RecordingTransport.
+type TransportRecord struct {
+	Command  []byte        // The complete command packet
+	Response []byte        // The complete response packet
+	Duration time.Duration // The time between the command being sent and the complete response being received
+}
+
+func writeTransportRecord(w io.Writer, record *TransportRecord) error {
+	_, err := mu.MarshalToWriter(w, uint32(len(record.Command)), mu.RawBytes(record.Command), uint32(len(record.Response)),
+		mu.RawBytes(record.Response), uint64(record.Duration))
+	return err
+}
+
+func readTransportRecordBytes(r io.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > maxTransportRecordSize {
+		return nil, fmt.Errorf("record too large (%d bytes)", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ReadTransportRecords reads all of the records written by a RecordingTransport from the supplied reader.
+func ReadTransportRecords(r io.Reader) (out []TransportRecord, err error) {
+	for i := 0; ; i++ {
+		var record TransportRecord
+		record.Command, err = readTransportRecordBytes(r)
+		switch {
+		case err == io.EOF:
+			return out, nil
+		case err != nil:
+			return nil, xerrors.Errorf("cannot read command for record %d: %w", i, err)
+		}
+		record.Response, err = readTransportRecordBytes(r)
+		if err != nil {
+			return nil, xerrors.Errorf("cannot read response for record %d: %w", i, err)
+		}
+		var duration uint64
+		if err := binary.Read(r, binary.BigEndian, &duration); err != nil {
+			return nil, xerrors.Errorf("cannot read duration for record %d: %w", i, err)
+		}
+		record.Duration = time.Duration(duration)
+		out = append(out, record)
+	}
+}
+
+// RecordingTransport is a transmission interface that forwards commands to another transmission interface, and writes a record of
+// every command and response pair to an io.Writer. The records can be read back with ReadTransportRecords and served to a
+// TPMContext with ReplayTransport.
+//
+// Each command is expected to be supplied in a single call to Write, which is how TPMContext sends commands. A record is written
+// once the complete response has been read. If a response is not read completely before the next command is sent, the partial
+// response is recorded.
+type RecordingTransport struct {
+	tcti Transport
+	w    io.Writer
+
+	mu          sync.Mutex
+	current     *TransportRecord
+	start       time.Time
+	responseLen int
+	err         error
+}
+
+// NewRecordingTransport returns a new RecordingTransport that forwards commands to tcti and writes records to w. The returned
+// transport takes ownership of tcti, and will close it when it is closed. The caller is responsible for closing w, if required.
+func NewRecordingTransport(tcti Transport, w io.Writer) *RecordingTransport {
+	return &RecordingTransport{tcti: tcti, w: w}
+}
+
+// flushRecordLocked writes the current record, if there is one.
+func (t *RecordingTransport) flushRecordLocked() {
+	if t.current == nil {
+		return
+	}
+	record := t.current
+	t.current = nil
+	if t.err != nil {
+		return
+	}
+	record.Duration = time.Since(t.start)
+	if err := writeTransportRecord(t.w, record); err != nil {
+		t.err = xerrors.Errorf("cannot write record: %w", err)
+	}
+}
+
+func (t *RecordingTransport) Read(data []byte) (int, error) {
+	n, err := t.tcti.Read(data)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.current == nil {
+		return n, err
+	}
+	t.current.Response = append(t.current.Response, data[:n]...)
+
+	if t.responseLen == 0 && len(t.current.Response) >= binary.Size(responseHeader{}) {
+		t.responseLen = int(binary.BigEndian.Uint32(t.current.Response[2:]))
+	}
+	if t.responseLen > 0 && len(t.current.Response) >= t.responseLen {
+		t.flushRecordLocked()
+		if t.err != nil && err == nil {
+			err = t.err
+		}
+	}
+	return n, err
+}
+
+func (t *RecordingTransport) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	t.flushRecordLocked()
+	if t.err != nil {
+		t.mu.Unlock()
+		return 0, t.err
+	}
+	t.current = &TransportRecord{Command: append([]byte(nil), data...)}
+	t.responseLen = 0
+	t.start = time.Now()
+	t.mu.Unlock()
+
+	return t.tcti.Write(data)
+}
+
+// Close closes the underlying transmission interface. An error will be returned if any record could not be written.
+func (t *RecordingTransport) Close() error {
+	t.mu.Lock()
+	t.flushRecordLocked()
+	recordErr := t.err
+	t.mu.Unlock()
+
+	if err := t.tcti.Close(); err != nil {
+		return err
+	}
+	return recordErr
+}
+
+// ReplayMask describes a range of bytes in commands with the specified command code that should be ignored when ReplayTransport
+// compares a command with a recorded one. This is useful for fields that are expected to differ between runs, such as the
+// nonceCaller parameter of TPM2_StartAuthSession, which is generated randomly.
+//
+// Note that masking a nonce only allows the command to be matched. It doesn't help where the TPM uses the nonce to compute a
+// value that is verified by the caller, such as the HMAC in the response to a command that uses a HMAC or policy session.
+type ReplayMask struct {
+	CommandCode CommandCode // The command code to which this mask applies
+	Offset      int         // The offset of the first ignored byte from the start of the command packet
+	Length      int         // The number of ignored bytes
+}
+
+// ReplayMismatchError is returned from ReplayTransport.Write when a command does not match the corresponding recorded command.
+type ReplayMismatchError struct {
+	Index    int    // The index of the recorded command
+	Expected []byte // The recorded command
+	Actual   []byte // The command that was supplied
+}
+
+func (e *ReplayMismatchError) Error() string {
+	return fmt.Sprintf("command %d does not match the recorded command (expected %x, got %x)", e.Index, e.Expected, e.Actual)
+}
+
+// ReplayTransport is a transmission interface that serves responses from previously recorded command and response pairs, without
+// communicating with a TPM. It verifies that each command matches the corresponding recorded command, and Write returns a
+// *ReplayMismatchError error if it doesn't.
+type ReplayTransport struct {
+	records []TransportRecord
+	masks   []ReplayMask
+	next    int
+	rsp     *bytes.Reader
+}
+
+// NewReplayTransport returns a new ReplayTransport that serves responses from the supplied records, which are expected to be sent
+// in order. Any supplied masks are used to ignore parts of commands when comparing them with the recorded commands.
+func NewReplayTransport(records []TransportRecord, masks ...ReplayMask) *ReplayTransport {
+	return &ReplayTransport{records: records, masks: masks}
+}
+
+// applyMasks returns a copy of cmd with the bytes covered by the configured masks cleared.
+func (t *ReplayTransport) applyMasks(cmd []byte) []byte {
+	var hdr commandHeader
+	if _, err := mu.UnmarshalFromBytes(cmd, &hdr); err != nil {
+		return cmd
+	}
+
+	cmd = append([]byte(nil), cmd...)
+	for _, m := range t.masks {
+		if m.CommandCode != hdr.CommandCode || m.Offset >= len(cmd) {
+			continue
+		}
+		end := m.Offset + m.Length
+		if end > len(cmd) {
+			end = len(cmd)
+		}
+		for i := m.Offset; i < end; i++ {
+			cmd[i] = 0
+		}
+	}
+	return cmd
+}
+
+func (t *ReplayTransport) Read(data []byte) (int, error) {
+	if t.rsp == nil {
+		return 0, io.EOF
+	}
+	return t.rsp.Read(data)
+}
+
+func (t *ReplayTransport) Write(data []byte) (int, error) {
+	if t.next >= len(t.records) {
+		return 0, errors.New("no more recorded commands")
+	}
+
+	record := t.records[t.next]
+	if len(data) != len(record.Command) || !bytes.Equal(t.applyMasks(data), t.applyMasks(record.Command)) {
+		return 0, &ReplayMismatchError{Index: t.next, Expected: record.Command, Actual: append([]byte(nil), data...)}
+	}
+
+	t.next++
+	t.rsp = bytes.NewReader(record.Response)
+	return len(data), nil
+}
+
+func (t *ReplayTransport) Close() error {
+	return nil
+}
+
+// Remaining returns the number of recorded commands that have not been replayed yet.
+func (t *ReplayTransport) Remaining() int {
+	return len(t.records) - t.next
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+
+	"golang.org/x/xerrors"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	transport := &scriptedTransport{}
+	transport.addResponse(t, Success, Digest("12345678"))
+	transport.addResponse(t, Success, Digest("abcd"))
+
+	var buf bytes.Buffer
+	tpm, _ := NewTPMContext(NewRecordingTransport(transport, &buf))
+
+	for _, n := range []uint16{8, 4} {
+		if _, err := tpm.GetRandom(n); err != nil {
+			t.Fatalf("GetRandom failed: %v", err)
+		}
+	}
+	if err := tpm.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	records, err := ReadTransportRecords(&buf)
+	if err != nil {
+		t.Fatalf("ReadTransportRecords failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Unexpected number of records (%d)", len(records))
+	}
+	for i, record := range records {
+		if !bytes.Equal(record.Command, transport.commands[i]) {
+			t.Errorf("Unexpected command for record %d", i)
+		}
+	}
+	if !bytes.Equal(records[1].Response, []byte{0x80, 0x01, 0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 'a', 'b',
+		'c', 'd'}) {
+		t.Errorf("Unexpected response for record 1 (%x)", records[1].Response)
+	}
+
+	t.Run("Replay", func(t *testing.T) {
+		replay := NewReplayTransport(records)
+		tpm, _ := NewTPMContext(replay)
+		defer tpm.Close()
+
+		for i, expected := range []string{"12345678", "abcd"} {
+			digest, err := tpm.GetRandom(uint16(len(expected)))
+			if err != nil {
+				t.Fatalf("GetRandom failed: %v", err)
+			}
+			if string(digest) != expected {
+				t.Errorf("Unexpected response for command %d (%x)", i, digest)
+			}
+		}
+		if replay.Remaining() != 0 {
+			t.Errorf("Unexpected number of remaining records")
+		}
+		if _, err := tpm.GetRandom(8); err == nil {
+			t.Errorf("GetRandom should fail once all records have been replayed")
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		tpm, _ := NewTPMContext(NewReplayTransport(records))
+		defer tpm.Close()
+
+		_, err := tpm.GetRandom(4)
+		var e *ReplayMismatchError
+		if !xerrors.As(err, &e) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if e.Index != 0 || !bytes.Equal(e.Expected, records[0].Command) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Mask", func(t *testing.T) {
+		// Ignore the bytesRequested parameter of the first command.
+		tpm, _ := NewTPMContext(NewReplayTransport(records, ReplayMask{CommandCode: CommandGetRandom, Offset: 10, Length: 2}))
+		defer tpm.Close()
+
+		digest, err := tpm.GetRandom(4)
+		if err != nil {
+			t.Fatalf("GetRandom failed: %v", err)
+		}
+		if string(digest) != "12345678" {
+			t.Errorf("Unexpected response (%x)", digest)
+		}
+	})
+}
+
+func TestReadTransportRecordsTooLarge(t *testing.T) {
+	// A record header indicating a 16MB command.
+	_, err := ReadTransportRecords(bytes.NewReader([]byte{0x01, 0x00, 0x00, 0x00, 0x80, 0x01}))
+	if err == nil || err.Error() != "cannot read command for record 0: record too large (16777216 bytes)" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,670 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	rmHandleError           ResponseCode = 0x08b // TPM_RC_HANDLE
+	rmResponseCodeParameter ResponseCode = 0x040 // TPM_RC_P
+	rmResponseCodeSession   ResponseCode = 0x800 // TPM_RC_S
+	rmResponseCodeIndex1    ResponseCode = 0x100 // TPM_RC_1
+
+	rmWarningContextGap    ResponseCode = 0x901 // TPM_RC_CONTEXT_GAP
+	rmWarningObjectMemory  ResponseCode = 0x902 // TPM_RC_OBJECT_MEMORY
+	rmWarningSessionMemory ResponseCode = 0x903 // TPM_RC_SESSION_MEMORY
+)
+
+var errResourceManagerTransportClosed = errors.New("transport is closed")
+
+// rmObject corresponds to a transient object that belongs to a resource manager client.
+type rmObject struct {
+	owner    *resourceManagerTransport
+	handle   Handle   // The handle of the object on the TPM if it is loaded
+	context  *Context // The saved context of the object if it is not loaded
+	lastUsed uint64
+}
+
+func (o *rmObject) loaded() bool {
+	return o.context == nil
+}
+
+// rmSession corresponds to a session that belongs to a resource manager client. Session handles are not virtualized, as a
+// session keeps the same handle when it is saved and loaded again.
+type rmSession struct {
+	owner    *resourceManagerTransport
+	context  *Context // The saved context of the session if it is not loaded
+	lastUsed uint64
+}
+
+func (s *rmSession) loaded() bool {
+	return s.context == nil
+}
+
+// ResourceManager is a user-space resource manager that virtualizes the handles of transient objects, and which permits multiple
+// clients to share a TPM without needing to be aware of the number of transient objects and sessions that the TPM can hold at any
+// one time. It wraps another transmission interface, and clients communicate with it using the transmission interfaces returned
+// from NewTransport. It behaves similarly to the Linux kernel's resource manager (/dev/tpmrm0), but can be used with any
+// transmission interface.
+//
+// Transient objects created or loaded by a client are assigned virtual handles, and the resource manager saves and flushes them
+// when the TPM runs out of memory for transient objects and loads them again when a client uses them. Sessions are saved when the
+// TPM runs out of session memory and are loaded again on demand. Clients can only access transient objects and sessions that they
+// created. When a client closes its transport, any transient objects and sessions that it created are flushed from the TPM.
+//
+// The resource manager processes one command at a time. Resources that are not owned by a client of the resource manager cannot be
+// evicted, and other users of the TPM should not use it directly at the same time as the resource manager.
+type ResourceManager struct {
+	mu sync.Mutex
+
+	tpm      *TPMContext
+	commands map[CommandCode]CommandAttributes
+
+	objects    map[Handle]*rmObject
+	sessions   map[Handle]*rmSession
+	nextHandle Handle
+	counter    uint64
+}
+
+// NewResourceManager returns a new ResourceManager that uses the supplied transmission interface to communicate with the TPM.
+func NewResourceManager(tcti Transport) *ResourceManager {
+	return &ResourceManager{
+		tpm:        newTpmContext(tcti),
+		objects:    make(map[Handle]*rmObject),
+		sessions:   make(map[Handle]*rmSession),
+		nextHandle: Handle(HandleTypeTransient) << 24}
+}
+
+// NewTransport returns a new transmission interface for a client of this resource manager, which can be passed to NewTPMContext.
+// Each command must be written to the returned transmission interface with a single call to Write. Closing it flushes all of the
+// transient objects and sessions that were created via it.
+func (rm *ResourceManager) NewTransport() Transport {
+	return &resourceManagerTransport{rm: rm}
+}
+
+// Close closes the underlying transmission interface. The transmission interfaces returned from NewTransport should be closed
+// first.
+func (rm *ResourceManager) Close() error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.tpm.Close()
+}
+
+func (rm *ResourceManager) commandAttributes(commandCode CommandCode) (CommandAttributes, bool, error) {
+	if rm.commands == nil {
+		cmds, err := rm.tpm.GetCapabilityCommands(0, CapabilityMaxProperties)
+		if err != nil {
+			return 0, false, xerrors.Errorf("cannot obtain command attributes: %w", err)
+		}
+		rm.commands = make(map[CommandCode]CommandAttributes)
+		for _, attrs := range cmds {
+			rm.commands[attrs.CommandCode()] = attrs
+		}
+	}
+	attrs, ok := rm.commands[commandCode]
+	return attrs, ok, nil
+}
+
+func (rm *ResourceManager) touch() uint64 {
+	rm.counter++
+	return rm.counter
+}
+
+func (rm *ResourceManager) allocateHandle() Handle {
+	for {
+		h := rm.nextHandle
+		rm.nextHandle++
+		if rm.nextHandle.Type() != HandleTypeTransient {
+			rm.nextHandle = Handle(HandleTypeTransient) << 24
+		}
+		if _, exists := rm.objects[h]; !exists {
+			return h
+		}
+	}
+}
+
+func (rm *ResourceManager) runCommand(commandCode CommandCode, params ...interface{}) (ResponseCode, []byte, error) {
+	cpBytes, err := mu.MarshalToBytes(params...)
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal command parameters: %v", err))
+	}
+	rc, _, rpBytes, err := rm.tpm.RunCommandBytes(TagNoSessions, commandCode, cpBytes)
+	return rc, rpBytes, err
+}
+
+func (rm *ResourceManager) contextSave(handle Handle) (*Context, error) {
+	rc, rpBytes, err := rm.runCommand(CommandContextSave, handle)
+	if err != nil {
+		return nil, err
+	}
+	if err := DecodeResponseCode(CommandContextSave, rc); err != nil {
+		return nil, err
+	}
+	var context Context
+	if _, err := mu.UnmarshalFromBytes(rpBytes, &context); err != nil {
+		return nil, &InvalidResponseError{CommandContextSave, err.Error()}
+	}
+	return &context, nil
+}
+
+func (rm *ResourceManager) contextLoad(context *Context) (ResponseCode, Handle, error) {
+	rc, rpBytes, err := rm.runCommand(CommandContextLoad, context)
+	if err != nil || rc != Success {
+		return rc, HandleUnassigned, err
+	}
+	var handle Handle
+	if _, err := mu.UnmarshalFromBytes(rpBytes, &handle); err != nil {
+		return rc, HandleUnassigned, &InvalidResponseError{CommandContextLoad, err.Error()}
+	}
+	return rc, handle, nil
+}
+
+func (rm *ResourceManager) flushContext(handle Handle) error {
+	rc, _, err := rm.runCommand(CommandFlushContext, handle)
+	if err != nil {
+		return err
+	}
+	return DecodeResponseCode(CommandFlushContext, rc)
+}
+
+// evictObject saves and flushes the least recently used loaded transient object that is not in the supplied set of pinned
+// objects. It returns false if there are no objects that can be evicted.
+func (rm *ResourceManager) evictObject(pinned map[Handle]bool) (bool, error) {
+	var victim *rmObject
+	for h, o := range rm.objects {
+		if pinned[h] || !o.loaded() {
+			continue
+		}
+		if victim == nil || o.lastUsed < victim.lastUsed {
+			victim = o
+		}
+	}
+	if victim == nil {
+		return false, nil
+	}
+
+	context, err := rm.contextSave(victim.handle)
+	if err != nil {
+		return false, xerrors.Errorf("cannot save context of object: %w", err)
+	}
+	if err := rm.flushContext(victim.handle); err != nil {
+		return false, xerrors.Errorf("cannot flush object: %w", err)
+	}
+	victim.context = context
+	victim.handle = HandleUnassigned
+	return true, nil
+}
+
+// evictSession saves the least recently used loaded session that is not in the supplied set of pinned sessions. It returns
+// false if there are no sessions that can be evicted.
+func (rm *ResourceManager) evictSession(pinned map[Handle]bool) (bool, error) {
+	var victim *rmSession
+	var victimHandle Handle
+	for h, s := range rm.sessions {
+		if pinned[h] || !s.loaded() {
+			continue
+		}
+		if victim == nil || s.lastUsed < victim.lastUsed {
+			victim = s
+			victimHandle = h
+		}
+	}
+	if victim == nil {
+		return false, nil
+	}
+
+	context, err := rm.contextSave(victimHandle)
+	if err != nil {
+		return false, xerrors.Errorf("cannot save context of session: %w", err)
+	}
+	victim.context = context
+	return true, nil
+}
+
+// refreshOldestSession loads and then saves again the saved session with the oldest context, so that it is assigned a new
+// context ID. The TPM returns TPM_RC_CONTEXT_GAP from TPM2_StartAuthSession and TPM2_ContextLoad when the difference between the
+// context IDs of the oldest saved session and the next session would be too large, and this resolves that. It returns false if
+// there are no saved sessions that can be refreshed.
+func (rm *ResourceManager) refreshOldestSession(pinned map[Handle]bool) (bool, error) {
+	var oldest *rmSession
+	var oldestHandle Handle
+	for h, s := range rm.sessions {
+		if pinned[h] || s.loaded() {
+			continue
+		}
+		if oldest == nil || s.context.Sequence < oldest.context.Sequence {
+			oldest = s
+			oldestHandle = h
+		}
+	}
+	if oldest == nil {
+		return false, nil
+	}
+
+	// Pin the session whilst it is loaded so that it can't be selected again if loading it fails with TPM_RC_CONTEXT_GAP.
+	p := map[Handle]bool{oldestHandle: true}
+	for h := range pinned {
+		p[h] = true
+	}
+
+	rc, _, err := rm.loadContext(oldest.context, p)
+	switch {
+	case err != nil:
+		return false, err
+	case rc == rmWarningObjectMemory || rc == rmWarningSessionMemory || rc == rmWarningContextGap:
+		return false, nil
+	case rc != Success:
+		// The saved context is no longer valid, eg, because the TPM was reset.
+		delete(rm.sessions, oldestHandle)
+		return true, nil
+	}
+	oldest.context = nil
+
+	context, err := rm.contextSave(oldestHandle)
+	if err != nil {
+		return false, xerrors.Errorf("cannot save context of session: %w", err)
+	}
+	oldest.context = context
+	return true, nil
+}
+
+// loadContext loads the supplied context, evicting other resources if the TPM is out of memory and refreshing the oldest saved
+// session if the TPM indicates that the context gap is too large. If the TPM returns any other error, the response code is
+// returned.
+func (rm *ResourceManager) loadContext(context *Context, pinned map[Handle]bool) (ResponseCode, Handle, error) {
+	for {
+		rc, handle, err := rm.contextLoad(context)
+		if err != nil {
+			return 0, HandleUnassigned, err
+		}
+
+		var evicted bool
+		switch rc {
+		case Success:
+			return rc, handle, nil
+		case rmWarningObjectMemory:
+			evicted, err = rm.evictObject(pinned)
+		case rmWarningSessionMemory:
+			evicted, err = rm.evictSession(pinned)
+		case rmWarningContextGap:
+			evicted, err = rm.refreshOldestSession(pinned)
+		}
+		if err != nil {
+			return 0, HandleUnassigned, err
+		}
+		if !evicted {
+			return rc, HandleUnassigned, nil
+		}
+	}
+}
+
+func (rm *ResourceManager) loadObject(o *rmObject, pinned map[Handle]bool) (ResponseCode, error) {
+	o.lastUsed = rm.touch()
+	if o.loaded() {
+		return Success, nil
+	}
+
+	rc, handle, err := rm.loadContext(o.context, pinned)
+	if err != nil || rc != Success {
+		return rc, err
+	}
+	o.handle = handle
+	o.context = nil
+	return Success, nil
+}
+
+func (rm *ResourceManager) loadSession(s *rmSession, pinned map[Handle]bool) (ResponseCode, error) {
+	s.lastUsed = rm.touch()
+	if s.loaded() {
+		return Success, nil
+	}
+
+	rc, _, err := rm.loadContext(s.context, pinned)
+	if err != nil || rc != Success {
+		return rc, err
+	}
+	s.context = nil
+	return Success, nil
+}
+
+func makeResponse(rc ResponseCode, rpBytes []byte) []byte {
+	rsp, err := mu.MarshalToBytes(TagNoSessions, uint32(binary.Size(responseHeader{})+len(rpBytes)), rc, mu.RawBytes(rpBytes))
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal response: %v", err))
+	}
+	return rsp
+}
+
+// getTransientHandles returns a TPM2_GetCapability response containing the virtual handles of the transient objects owned by
+// the specified client.
+func (rm *ResourceManager) getTransientHandles(owner *resourceManagerTransport, first Handle, count uint32) []byte {
+	var handles HandleList
+	for h, o := range rm.objects {
+		if o.owner == owner && h >= first {
+			handles = append(handles, h)
+		}
+	}
+	sort.Slice(handles, func(i, j int) bool { return handles[i] < handles[j] })
+	moreData := false
+	if uint32(len(handles)) > count {
+		handles = handles[:count]
+		moreData = true
+	}
+
+	rpBytes, err := mu.MarshalToBytes(moreData, CapabilityData{Capability: CapabilityHandles, Data: CapabilitiesU{Data: handles}})
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal capability data: %v", err))
+	}
+	return makeResponse(Success, rpBytes)
+}
+
+// flushContextForClient handles a TPM2_FlushContext command from a client.
+func (rm *ResourceManager) flushContextForClient(owner *resourceManagerTransport, cmd []byte) ([]byte, error) {
+	var handle Handle
+	if _, err := mu.UnmarshalFromBytes(cmd[binary.Size(commandHeader{}):], &handle); err != nil {
+		return nil, xerrors.Errorf("cannot unmarshal flushHandle: %w", err)
+	}
+
+	invalidHandle := makeResponse(rmHandleError+rmResponseCodeParameter+rmResponseCodeIndex1, nil)
+
+	switch handle.Type() {
+	case HandleTypeTransient:
+		o, exists := rm.objects[handle]
+		if !exists || o.owner != owner {
+			return invalidHandle, nil
+		}
+		delete(rm.objects, handle)
+		if !o.loaded() {
+			return makeResponse(Success, nil), nil
+		}
+		handle = o.handle
+	case HandleTypeHMACSession, HandleTypePolicySession:
+		s, exists := rm.sessions[handle]
+		if !exists || s.owner != owner {
+			return invalidHandle, nil
+		}
+		delete(rm.sessions, handle)
+	}
+
+	rc, rpBytes, err := rm.runCommand(CommandFlushContext, handle)
+	if err != nil {
+		return nil, err
+	}
+	return makeResponse(rc, rpBytes), nil
+}
+
+// runCommandForClient executes the supplied command on behalf of a client, and returns the response.
+func (rm *ResourceManager) runCommandForClient(owner *resourceManagerTransport, cmd []byte) ([]byte, error) {
+	var hdr commandHeader
+	if _, err := mu.UnmarshalFromBytes(cmd, &hdr); err != nil {
+		return nil, xerrors.Errorf("cannot unmarshal command header: %w", err)
+	}
+	if int(hdr.CommandSize) != len(cmd) {
+		return nil, fmt.Errorf("invalid command size (got %d bytes, header indicates %d bytes)", len(cmd), hdr.CommandSize)
+	}
+
+	attrs, known, err := rm.commandAttributes(hdr.CommandCode)
+	if err != nil {
+		return nil, err
+	}
+	if !known {
+		// Let the TPM return an appropriate error.
+		rc, tag, rpBytes, err := rm.tpm.RunCommandBytes(hdr.Tag, hdr.CommandCode, cmd[binary.Size(hdr):])
+		if err != nil {
+			return nil, err
+		}
+		rsp := makeResponse(rc, rpBytes)
+		binary.BigEndian.PutUint16(rsp, uint16(tag))
+		return rsp, nil
+	}
+
+	if hdr.CommandCode == CommandFlushContext {
+		return rm.flushContextForClient(owner, cmd)
+	}
+
+	cmd = append([]byte(nil), cmd...)
+	r := bytes.NewReader(cmd[binary.Size(hdr):])
+
+	// Translate the command handles.
+	handles := make([]Handle, attrs.NumberOfCommandHandles())
+	pinned := make(map[Handle]bool)
+	for i := range handles {
+		offset := len(cmd) - r.Len()
+		if _, err := mu.UnmarshalFromReader(r, &handles[i]); err != nil {
+			return nil, xerrors.Errorf("cannot unmarshal command handle: %w", err)
+		}
+		h := handles[i]
+		invalidHandle := makeResponse(rmHandleError+(ResponseCode(i+1)<<8), nil)
+
+		switch h.Type() {
+		case HandleTypeTransient:
+			o, exists := rm.objects[h]
+			if !exists || o.owner != owner {
+				return invalidHandle, nil
+			}
+			pinned[h] = true
+			rc, err := rm.loadObject(o, pinned)
+			if err != nil {
+				return nil, err
+			}
+			if rc != Success {
+				if rc != rmWarningObjectMemory && rc != rmWarningSessionMemory {
+					// The saved context is no longer valid, eg, because the TPM was reset.
+					delete(rm.objects, h)
+					return invalidHandle, nil
+				}
+				return makeResponse(rc, nil), nil
+			}
+			binary.BigEndian.PutUint32(cmd[offset:], uint32(o.handle))
+		case HandleTypeHMACSession, HandleTypePolicySession:
+			s, exists := rm.sessions[h]
+			if !exists || s.owner != owner {
+				return invalidHandle, nil
+			}
+			pinned[h] = true
+			rc, err := rm.loadSession(s, pinned)
+			if err != nil {
+				return nil, err
+			}
+			if rc != Success {
+				return makeResponse(rc, nil), nil
+			}
+		}
+	}
+
+	// Load the sessions in the authorization area.
+	var authSessions []authCommand
+	if hdr.Tag == TagSessions {
+		var authSize uint32
+		if _, err := mu.UnmarshalFromReader(r, &authSize); err != nil {
+			return nil, xerrors.Errorf("cannot unmarshal authorization area size: %w", err)
+		}
+		if int(authSize) > r.Len() {
+			return nil, errors.New("invalid authorization area size")
+		}
+		authArea := io.LimitReader(r, int64(authSize))
+		for i := 0; ; i++ {
+			var auth authCommand
+			if _, err := mu.UnmarshalFromReader(authArea, &auth); err != nil {
+				if xerrors.Is(err, io.EOF) {
+					break
+				}
+				return nil, xerrors.Errorf("cannot unmarshal authorization area: %w", err)
+			}
+			authSessions = append(authSessions, auth)
+			if auth.SessionHandle == HandlePW {
+				continue
+			}
+
+			s, exists := rm.sessions[auth.SessionHandle]
+			if !exists || s.owner != owner {
+				return makeResponse(rmHandleError+rmResponseCodeSession+(ResponseCode(i+1)<<8), nil), nil
+			}
+			pinned[auth.SessionHandle] = true
+			rc, err := rm.loadSession(s, pinned)
+			if err != nil {
+				return nil, err
+			}
+			if rc != Success {
+				return makeResponse(rc, nil), nil
+			}
+		}
+	}
+
+	// Return the virtual handles of this client's transient objects if it asks for them.
+	if hdr.CommandCode == CommandGetCapability && hdr.Tag == TagNoSessions {
+		var capability Capability
+		var property, count uint32
+		if _, err := mu.UnmarshalFromReader(r, &capability, &property, &count); err == nil &&
+			capability == CapabilityHandles && Handle(property).Type() == HandleTypeTransient {
+			return rm.getTransientHandles(owner, Handle(property), count), nil
+		}
+	}
+
+	// Execute the command, evicting other resources if the TPM runs out of memory and refreshing the oldest saved session if the
+	// context gap is too large.
+	var rc ResponseCode
+	var tag StructTag
+	var rpBytes []byte
+	for {
+		rc, tag, rpBytes, err = rm.tpm.RunCommandBytes(hdr.Tag, hdr.CommandCode, cmd[binary.Size(hdr):])
+		if err != nil {
+			return nil, err
+		}
+
+		var evicted bool
+		switch rc {
+		case rmWarningObjectMemory:
+			evicted, err = rm.evictObject(pinned)
+		case rmWarningSessionMemory:
+			evicted, err = rm.evictSession(pinned)
+		case rmWarningContextGap:
+			evicted, err = rm.refreshOldestSession(pinned)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !evicted {
+			break
+		}
+	}
+
+	if rc == Success {
+		// Assign a virtual handle to a new transient object, or record the owner of a new session.
+		if attrs&AttrRHandle != 0 && len(rpBytes) >= binary.Size(Handle(0)) {
+			h := Handle(binary.BigEndian.Uint32(rpBytes))
+			switch h.Type() {
+			case HandleTypeTransient:
+				vh := rm.allocateHandle()
+				rm.objects[vh] = &rmObject{owner: owner, handle: h, lastUsed: rm.touch()}
+				binary.BigEndian.PutUint32(rpBytes, uint32(vh))
+			case HandleTypeHMACSession, HandleTypePolicySession:
+				rm.sessions[h] = &rmSession{owner: owner, lastUsed: rm.touch()}
+			}
+		}
+
+		switch hdr.CommandCode {
+		case CommandContextSave:
+			// The client now owns the saved session context.
+			delete(rm.sessions, handles[0])
+		case CommandSequenceComplete:
+			delete(rm.objects, handles[0])
+		case CommandEventSequenceComplete:
+			delete(rm.objects, handles[1])
+		}
+
+		for _, auth := range authSessions {
+			if auth.SessionAttrs&attrContinueSession == 0 {
+				delete(rm.sessions, auth.SessionHandle)
+			}
+		}
+	}
+
+	rsp := makeResponse(rc, rpBytes)
+	binary.BigEndian.PutUint16(rsp, uint16(tag))
+	return rsp, nil
+}
+
+// flushClientResources flushes all of the transient objects and sessions owned by the specified client.
+func (rm *ResourceManager) flushClientResources(owner *resourceManagerTransport) (out error) {
+	for h, o := range rm.objects {
+		if o.owner != owner {
+			continue
+		}
+		delete(rm.objects, h)
+		if !o.loaded() {
+			continue
+		}
+		if err := rm.flushContext(o.handle); err != nil {
+			out = xerrors.Errorf("cannot flush object: %w", err)
+		}
+	}
+	for h, s := range rm.sessions {
+		if s.owner != owner {
+			continue
+		}
+		delete(rm.sessions, h)
+		if err := rm.flushContext(h); err != nil {
+			out = xerrors.Errorf("cannot flush session: %w", err)
+		}
+	}
+	return
+}
+
+// resourceManagerTransport is the transmission interface used by a client of ResourceManager.
+type resourceManagerTransport struct {
+	rm     *ResourceManager
+	rsp    *bytes.Reader
+	closed bool
+}
+
+func (t *resourceManagerTransport) Read(data []byte) (int, error) {
+	if t.closed {
+		return 0, errResourceManagerTransportClosed
+	}
+	if t.rsp == nil {
+		return 0, io.EOF
+	}
+	return t.rsp.Read(data)
+}
+
+func (t *resourceManagerTransport) Write(data []byte) (int, error) {
+	if t.closed {
+		return 0, errResourceManagerTransportClosed
+	}
+
+	t.rm.mu.Lock()
+	defer t.rm.mu.Unlock()
+
+	rsp, err := t.rm.runCommandForClient(t, data)
+	if err != nil {
+		return 0, err
+	}
+	t.rsp = bytes.NewReader(rsp)
+	return len(data), nil
+}
+
+func (t *resourceManagerTransport) Close() error {
+	if t.closed {
+		return errResourceManagerTransportClosed
+	}
+	t.closed = true
+
+	t.rm.mu.Lock()
+	defer t.rm.mu.Unlock()
+	return t.rm.flushClientResources(t)
+}
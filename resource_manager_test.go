@@ -0,0 +1,484 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+// mockObjectTPM is a transmission interface that emulates a TPM with a limited number of transient object slots. TPM2_LoadExternal
+// loads an object with the identifier supplied as the command parameter, and TPM2_ReadPublic returns the identifier of the object
+// associated with the supplied handle.
+type mockObjectTPM struct {
+	t          *testing.T
+	mu         sync.Mutex
+	maxObjects int
+	objects    map[Handle]uint32
+	nextHandle Handle
+	sequence   uint64
+	rsp        *bytes.Reader
+}
+
+func newMockObjectTPM(t *testing.T, maxObjects int) *mockObjectTPM {
+	return &mockObjectTPM{t: t, maxObjects: maxObjects, objects: make(map[Handle]uint32), nextHandle: 0x80000000}
+}
+
+func (m *mockObjectTPM) Read(data []byte) (int, error) {
+	return m.rsp.Read(data)
+}
+
+func (m *mockObjectTPM) loadObject(id uint32) (ResponseCode, []interface{}) {
+	if len(m.objects) >= m.maxObjects {
+		return 0x902, nil // TPM_RC_OBJECT_MEMORY
+	}
+	h := m.nextHandle
+	m.nextHandle++
+	m.objects[h] = id
+	return Success, []interface{}{h}
+}
+
+func (m *mockObjectTPM) numObjects() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.objects)
+}
+
+func (m *mockObjectTPM) Write(data []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var hdr struct {
+		Tag         StructTag
+		CommandSize uint32
+		CommandCode CommandCode
+	}
+	r := bytes.NewReader(data)
+	if _, err := mu.UnmarshalFromReader(r, &hdr); err != nil {
+		m.t.Fatalf("cannot unmarshal command header: %v", err)
+	}
+
+	rc := Success
+	var params []interface{}
+
+	switch hdr.CommandCode {
+	case CommandGetCapability:
+		oneHandle := CommandAttributes(1 << 25)
+		params = []interface{}{false, CapabilityData{
+			Capability: CapabilityCommands,
+			Data: CapabilitiesU{Data: CommandAttributesList{
+				CommandAttributes(CommandLoadExternal) | AttrRHandle,
+				CommandAttributes(CommandReadPublic) | oneHandle,
+				CommandAttributes(CommandContextSave) | oneHandle,
+				CommandAttributes(CommandContextLoad) | AttrRHandle,
+				CommandAttributes(CommandFlushContext),
+				CommandAttributes(CommandGetCapability)}}}}
+	case CommandLoadExternal:
+		var id uint32
+		mu.UnmarshalFromReader(r, &id)
+		rc, params = m.loadObject(id)
+	case CommandReadPublic:
+		var h Handle
+		mu.UnmarshalFromReader(r, &h)
+		id, ok := m.objects[h]
+		if !ok {
+			rc = 0x18b // TPM_RC_HANDLE + TPM_RC_1
+			break
+		}
+		params = []interface{}{id}
+	case CommandContextSave:
+		var h Handle
+		mu.UnmarshalFromReader(r, &h)
+		id, ok := m.objects[h]
+		if !ok {
+			rc = 0x18b // TPM_RC_HANDLE + TPM_RC_1
+			break
+		}
+		m.sequence++
+		blob := make(ContextData, 4)
+		binary.BigEndian.PutUint32(blob, id)
+		params = []interface{}{Context{Sequence: m.sequence, SavedHandle: 0x80000000, Hierarchy: HandleOwner, Blob: blob}}
+	case CommandContextLoad:
+		var context Context
+		mu.UnmarshalFromReader(r, &context)
+		rc, params = m.loadObject(binary.BigEndian.Uint32(context.Blob))
+	case CommandFlushContext:
+		var h Handle
+		mu.UnmarshalFromReader(r, &h)
+		if _, ok := m.objects[h]; !ok {
+			rc = 0x1cb // TPM_RC_HANDLE + TPM_RC_P + TPM_RC_1
+			break
+		}
+		delete(m.objects, h)
+	default:
+		rc = 0x143 // TPM_RC_COMMAND_CODE
+	}
+
+	p, err := mu.MarshalToBytes(params...)
+	if err != nil {
+		m.t.Fatalf("cannot marshal response: %v", err)
+	}
+	rsp, _ := mu.MarshalToBytes(TagNoSessions, uint32(10+len(p)), rc, mu.RawBytes(p))
+	m.rsp = bytes.NewReader(rsp)
+	return len(data), nil
+}
+
+func (m *mockObjectTPM) Close() error {
+	return nil
+}
+
+func TestResourceManager(t *testing.T) {
+	mock := newMockObjectTPM(t, 2)
+	rm := NewResourceManager(mock)
+	defer rm.Close()
+
+	run := func(t *testing.T, tpm *TPMContext, commandCode CommandCode, params ...interface{}) (ResponseCode, []byte) {
+		cpBytes, err := mu.MarshalToBytes(params...)
+		if err != nil {
+			t.Fatalf("MarshalToBytes failed: %v", err)
+		}
+		rc, _, rpBytes, err := tpm.RunCommandBytes(TagNoSessions, commandCode, cpBytes)
+		if err != nil {
+			t.Fatalf("RunCommandBytes failed: %v", err)
+		}
+		return rc, rpBytes
+	}
+
+	load := func(t *testing.T, tpm *TPMContext, id uint32) Handle {
+		rc, rpBytes := run(t, tpm, CommandLoadExternal, id)
+		if rc != Success {
+			t.Fatalf("LoadExternal failed: 0x%08x", rc)
+		}
+		return Handle(binary.BigEndian.Uint32(rpBytes))
+	}
+
+	readPublic := func(t *testing.T, tpm *TPMContext, handle Handle) (ResponseCode, uint32) {
+		rc, rpBytes := run(t, tpm, CommandReadPublic, handle)
+		if rc != Success {
+			return rc, 0
+		}
+		return rc, binary.BigEndian.Uint32(rpBytes)
+	}
+
+	client1, _ := NewTPMContext(rm.NewTransport())
+	client2, _ := NewTPMContext(rm.NewTransport())
+	defer client2.Close()
+
+	// Load more objects than the TPM has space for.
+	var handles1 []Handle
+	for id := uint32(1); id <= 3; id++ {
+		handles1 = append(handles1, load(t, client1, id))
+	}
+	handle2 := load(t, client2, 4)
+	if len(mock.objects) != 2 {
+		t.Errorf("Unexpected number of loaded objects (%d)", len(mock.objects))
+	}
+
+	for i, h := range handles1 {
+		if h.Type() != HandleTypeTransient {
+			t.Errorf("Unexpected handle type")
+		}
+		rc, id := readPublic(t, client1, h)
+		if rc != Success {
+			t.Errorf("ReadPublic failed: 0x%08x", rc)
+		}
+		if id != uint32(i+1) {
+			t.Errorf("Unexpected object for handle 0x%08x (%d)", h, id)
+		}
+	}
+	if rc, id := readPublic(t, client2, handle2); rc != Success || id != 4 {
+		t.Errorf("Unexpected result from ReadPublic (rc: 0x%08x, id: %d)", rc, id)
+	}
+
+	t.Run("NotOwned", func(t *testing.T) {
+		if rc, _ := readPublic(t, client2, handles1[0]); rc != 0x18b {
+			t.Errorf("Unexpected response code 0x%08x", rc)
+		}
+	})
+
+	t.Run("GetCapabilityHandles", func(t *testing.T) {
+		handles, err := client1.GetCapabilityHandles(HandleTypeTransient.BaseHandle(), CapabilityMaxProperties)
+		if err != nil {
+			t.Fatalf("GetCapabilityHandles failed: %v", err)
+		}
+		if len(handles) != len(handles1) {
+			t.Fatalf("Unexpected number of handles (%d)", len(handles))
+		}
+		for i, h := range handles {
+			if h != handles1[i] {
+				t.Errorf("Unexpected handle 0x%08x", h)
+			}
+		}
+	})
+
+	t.Run("FlushContext", func(t *testing.T) {
+		// Ensure that handles1[0] is loaded and handles1[1] is saved.
+		readPublic(t, client1, handles1[0])
+		readPublic(t, client2, handle2)
+
+		for _, h := range handles1[0:2] {
+			if rc, _ := run(t, client1, CommandFlushContext, h); rc != Success {
+				t.Errorf("FlushContext failed: 0x%08x", rc)
+			}
+			if rc, _ := readPublic(t, client1, h); rc != 0x18b {
+				t.Errorf("Unexpected response code 0x%08x", rc)
+			}
+		}
+		handles1 = handles1[2:]
+	})
+
+	if err := client1.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	for _, id := range mock.objects {
+		if id != 4 {
+			t.Errorf("Object %d should have been flushed", id)
+		}
+	}
+	if rc, id := readPublic(t, client2, handle2); rc != Success || id != 4 {
+		t.Errorf("Unexpected result from ReadPublic (rc: 0x%08x, id: %d)", rc, id)
+	}
+}
+
+// mockSessionTPM is a transmission interface that emulates a TPM with a limited number of loaded session slots and a maximum
+// context gap. TPM2_StartAuthSession and TPM2_ContextLoad return TPM_RC_CONTEXT_GAP if the difference between the context
+// counter and the sequence number of the oldest saved session is too large, unless the context being loaded is the oldest.
+type mockSessionTPM struct {
+	t           *testing.T
+	maxLoaded   int
+	maxGap      uint64
+	loaded      map[Handle]bool
+	saved       map[Handle]uint64
+	nextHandle  Handle
+	counter     uint64
+	gapWarnings int
+	rsp         *bytes.Reader
+}
+
+func newMockSessionTPM(t *testing.T, maxLoaded int, maxGap uint64) *mockSessionTPM {
+	return &mockSessionTPM{
+		t:          t,
+		maxLoaded:  maxLoaded,
+		maxGap:     maxGap,
+		loaded:     make(map[Handle]bool),
+		saved:      make(map[Handle]uint64),
+		nextHandle: 0x02000000}
+}
+
+func (m *mockSessionTPM) Read(data []byte) (int, error) {
+	return m.rsp.Read(data)
+}
+
+func (m *mockSessionTPM) oldest() (Handle, bool) {
+	var oldest Handle
+	found := false
+	for h, seq := range m.saved {
+		if !found || seq < m.saved[oldest] {
+			oldest = h
+			found = true
+		}
+	}
+	return oldest, found
+}
+
+func (m *mockSessionTPM) gapTooLarge(h Handle) bool {
+	oldest, ok := m.oldest()
+	if !ok || oldest == h || m.counter-m.saved[oldest] < m.maxGap {
+		return false
+	}
+	m.gapWarnings++
+	return true
+}
+
+func (m *mockSessionTPM) Write(data []byte) (int, error) {
+	var hdr struct {
+		Tag         StructTag
+		CommandSize uint32
+		CommandCode CommandCode
+	}
+	r := bytes.NewReader(data)
+	if _, err := mu.UnmarshalFromReader(r, &hdr); err != nil {
+		m.t.Fatalf("cannot unmarshal command header: %v", err)
+	}
+
+	rc := Success
+	var params []interface{}
+
+	switch hdr.CommandCode {
+	case CommandGetCapability:
+		oneHandle := CommandAttributes(1 << 25)
+		twoHandles := CommandAttributes(2 << 25)
+		params = []interface{}{false, CapabilityData{
+			Capability: CapabilityCommands,
+			Data: CapabilitiesU{Data: CommandAttributesList{
+				CommandAttributes(CommandStartAuthSession) | twoHandles | AttrRHandle,
+				CommandAttributes(CommandPolicyRestart) | oneHandle,
+				CommandAttributes(CommandContextSave) | oneHandle,
+				CommandAttributes(CommandContextLoad) | AttrRHandle,
+				CommandAttributes(CommandFlushContext),
+				CommandAttributes(CommandGetCapability)}}}}
+	case CommandStartAuthSession:
+		switch {
+		case m.gapTooLarge(HandleUnassigned):
+			rc = 0x901 // TPM_RC_CONTEXT_GAP
+		case len(m.loaded) >= m.maxLoaded:
+			rc = 0x903 // TPM_RC_SESSION_MEMORY
+		default:
+			h := m.nextHandle
+			m.nextHandle++
+			m.loaded[h] = true
+			params = []interface{}{h, Nonce(nil)}
+		}
+	case CommandPolicyRestart:
+		var h Handle
+		mu.UnmarshalFromReader(r, &h)
+		if !m.loaded[h] {
+			rc = 0x18b // TPM_RC_HANDLE + TPM_RC_1
+		}
+	case CommandContextSave:
+		var h Handle
+		mu.UnmarshalFromReader(r, &h)
+		if !m.loaded[h] {
+			rc = 0x18b // TPM_RC_HANDLE + TPM_RC_1
+			break
+		}
+		delete(m.loaded, h)
+		m.saved[h] = m.counter
+		params = []interface{}{Context{Sequence: m.counter, SavedHandle: h, Hierarchy: HandleNull, Blob: ContextData{0}}}
+		m.counter++
+	case CommandContextLoad:
+		var context Context
+		mu.UnmarshalFromReader(r, &context)
+		h := context.SavedHandle
+		seq, ok := m.saved[h]
+		switch {
+		case !ok || seq != context.Sequence:
+			rc = 0x18b // TPM_RC_HANDLE + TPM_RC_1
+		case m.gapTooLarge(h):
+			rc = 0x901 // TPM_RC_CONTEXT_GAP
+		case len(m.loaded) >= m.maxLoaded:
+			rc = 0x903 // TPM_RC_SESSION_MEMORY
+		default:
+			delete(m.saved, h)
+			m.loaded[h] = true
+			params = []interface{}{h}
+		}
+	case CommandFlushContext:
+		var h Handle
+		mu.UnmarshalFromReader(r, &h)
+		delete(m.loaded, h)
+		delete(m.saved, h)
+	default:
+		rc = 0x143 // TPM_RC_COMMAND_CODE
+	}
+
+	p, err := mu.MarshalToBytes(params...)
+	if err != nil {
+		m.t.Fatalf("cannot marshal response: %v", err)
+	}
+	rsp, _ := mu.MarshalToBytes(TagNoSessions, uint32(10+len(p)), rc, mu.RawBytes(p))
+	m.rsp = bytes.NewReader(rsp)
+	return len(data), nil
+}
+
+func (m *mockSessionTPM) Close() error {
+	return nil
+}
+
+func TestResourceManagerContextGap(t *testing.T) {
+	mock := newMockSessionTPM(t, 1, 4)
+	rm := NewResourceManager(mock)
+	defer rm.Close()
+
+	client, _ := NewTPMContext(rm.NewTransport())
+	defer client.Close()
+
+	run := func(t *testing.T, commandCode CommandCode, params ...interface{}) []byte {
+		cpBytes, err := mu.MarshalToBytes(params...)
+		if err != nil {
+			t.Fatalf("MarshalToBytes failed: %v", err)
+		}
+		rc, _, rpBytes, err := client.RunCommandBytes(TagNoSessions, commandCode, cpBytes)
+		if err != nil {
+			t.Fatalf("RunCommandBytes failed: %v", err)
+		}
+		if rc != Success {
+			t.Fatalf("Command 0x%08x failed: 0x%08x", commandCode, rc)
+		}
+		return rpBytes
+	}
+
+	startSession := func(t *testing.T) Handle {
+		rpBytes := run(t, CommandStartAuthSession, HandleNull, HandleNull, Nonce(nil), EncryptedSecret(nil), SessionTypeHMAC,
+			SymDef{Algorithm: SymAlgorithmNull}, HashAlgorithmSHA256)
+		return Handle(binary.BigEndian.Uint32(rpBytes))
+	}
+
+	// Start 3 sessions. The first one remains saved whilst the other 2 are used alternately, until the gap between the oldest
+	// saved session and the context counter becomes too large.
+	var sessions []Handle
+	for i := 0; i < 3; i++ {
+		sessions = append(sessions, startSession(t))
+	}
+	for i := 0; i < 8; i++ {
+		run(t, CommandPolicyRestart, sessions[1+i%2])
+	}
+	sessions = append(sessions, startSession(t))
+
+	if mock.gapWarnings == 0 {
+		t.Errorf("TPM didn't return TPM_RC_CONTEXT_GAP")
+	}
+	for _, h := range sessions {
+		run(t, CommandPolicyRestart, h)
+	}
+}
+
+func TestResourceManagerSimulator(t *testing.T) {
+	if !useMssim && !useSimulator {
+		t.SkipNow()
+	}
+	tcti, err := openSimulatorTransport()
+	if err != nil {
+		t.Fatalf("Failed to open simulator connection: %v", err)
+	}
+	rm := NewResourceManager(tcti)
+	defer rm.Close()
+
+	tpm, _ := NewTPMContext(rm.NewTransport())
+
+	// The simulator only has 3 transient object slots.
+	var objects []ResourceContext
+	for i := 0; i < 5; i++ {
+		objects = append(objects, createRSASrkForTesting(t, tpm, nil))
+	}
+	sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+
+	for _, object := range objects {
+		if _, _, _, err := tpm.ReadPublic(object, sessionContext.WithAttrs(AttrContinueSession|AttrAudit)); err != nil {
+			t.Errorf("ReadPublic failed: %v", err)
+		}
+	}
+
+	if err := tpm.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+
+	underlying, _ := NewTPMContext(tcti)
+	handles, err := underlying.GetCapabilityHandles(HandleTypeTransient.BaseHandle(), CapabilityMaxProperties)
+	if err != nil {
+		t.Fatalf("GetCapabilityHandles failed: %v", err)
+	}
+	if len(handles) != 0 {
+		t.Errorf("Objects weren't flushed")
+	}
+}
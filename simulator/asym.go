@@ -0,0 +1,225 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+
+	"github.com/canonical/go-tpm2"
+)
+
+type eccPointSized struct {
+	Ptr *tpm2.ECCPoint `tpm2:"sized"`
+}
+
+// rsaEncryptionScheme returns the scheme that should be used for encryption or decryption with the supplied RSA key, taking in to
+// account the scheme requested by the caller.
+func rsaEncryptionScheme(o *object, in *tpm2.RSAScheme) (*tpm2.RSAScheme, tpm2.ErrorCode) {
+	keyScheme := &o.public.Params.RSADetail().Scheme
+	scheme := &tpm2.RSAScheme{Scheme: tpm2.RSASchemeId(keyScheme.Scheme), Details: keyScheme.Details}
+
+	switch {
+	case scheme.Scheme == tpm2.RSASchemeNull:
+		scheme = in
+	case in.Scheme == tpm2.RSASchemeNull:
+	case in.Scheme != scheme.Scheme:
+		return nil, tpm2.ErrorScheme
+	case in.Scheme == tpm2.RSASchemeOAEP && in.Details.OAEP().HashAlg != scheme.Details.OAEP().HashAlg:
+		return nil, tpm2.ErrorScheme
+	}
+
+	switch scheme.Scheme {
+	case tpm2.RSASchemeNull, tpm2.RSASchemeRSAES:
+	case tpm2.RSASchemeOAEP:
+		if !isSupportedHashAlg(scheme.Details.OAEP().HashAlg) {
+			return nil, tpm2.ErrorHash
+		}
+	default:
+		return nil, tpm2.ErrorScheme
+	}
+	return scheme, 0
+}
+
+// rsaKey returns the RSA key associated with the command handle at the specified index, which must have the decrypt attribute.
+func (c *commandContext) rsaKey(i int) (*object, tpm2.ResponseCode) {
+	o, rc := c.object(i)
+	if rc != tpm2.Success {
+		return nil, rc
+	}
+	if o.public.Type != tpm2.ObjectTypeRSA {
+		return nil, handleRC(tpm2.ErrorKey, i+1)
+	}
+	if o.public.Attrs&tpm2.AttrDecrypt == 0 {
+		return nil, handleRC(tpm2.ErrorAttributes, i+1)
+	}
+	return o, tpm2.Success
+}
+
+func (s *Simulator) rsaEncrypt(c *commandContext) tpm2.ResponseCode {
+	var message tpm2.PublicKeyRSA
+	var inScheme tpm2.RSAScheme
+	var label tpm2.Data
+	if rc := c.unmarshalParams(&message, &inScheme, &label); rc != tpm2.Success {
+		return rc
+	}
+
+	o, rc := c.rsaKey(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	scheme, code := rsaEncryptionScheme(o, &inScheme)
+	if code != 0 {
+		return paramRC(code, 2)
+	}
+	if len(label) > 0 && label[len(label)-1] != 0 {
+		return paramRC(tpm2.ErrorValue, 3)
+	}
+
+	key := rsaPublicKey(o.public)
+	var out []byte
+	var err error
+	switch scheme.Scheme {
+	case tpm2.RSASchemeOAEP:
+		out, err = rsa.EncryptOAEP(scheme.Details.OAEP().HashAlg.NewHash(), rand.Reader, key, message, label)
+	case tpm2.RSASchemeRSAES:
+		out, err = rsa.EncryptPKCS1v15(rand.Reader, key, message)
+	default:
+		m := new(big.Int).SetBytes(message)
+		if m.Cmp(key.N) >= 0 {
+			return paramRC(tpm2.ErrorValue, 1)
+		}
+		out = m.Exp(m, big.NewInt(int64(key.E)), key.N).Bytes()
+	}
+	if err != nil {
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+	return c.respond(tpm2.PublicKeyRSA(padBytes(out, key.Size())))
+}
+
+func (s *Simulator) rsaDecrypt(c *commandContext) tpm2.ResponseCode {
+	var cipherText tpm2.PublicKeyRSA
+	var inScheme tpm2.RSAScheme
+	var label tpm2.Data
+	if rc := c.unmarshalParams(&cipherText, &inScheme, &label); rc != tpm2.Success {
+		return rc
+	}
+
+	o, rc := c.rsaKey(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if o.sensitive == nil {
+		return handleRC(tpm2.ErrorKey, 1)
+	}
+	if o.public.Attrs&tpm2.AttrRestricted != 0 {
+		return handleRC(tpm2.ErrorAttributes, 1)
+	}
+	scheme, code := rsaEncryptionScheme(o, &inScheme)
+	if code != 0 {
+		return paramRC(code, 2)
+	}
+	if len(label) > 0 && label[len(label)-1] != 0 {
+		return paramRC(tpm2.ErrorValue, 3)
+	}
+
+	key := rsaPrivateKey(o.public, o.sensitive)
+	if key == nil {
+		return handleRC(tpm2.ErrorKey, 1)
+	}
+	if len(cipherText) > key.Size() {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+
+	var out []byte
+	var err error
+	switch scheme.Scheme {
+	case tpm2.RSASchemeOAEP:
+		out, err = rsa.DecryptOAEP(scheme.Details.OAEP().HashAlg.NewHash(), nil, key, cipherText, label)
+	case tpm2.RSASchemeRSAES:
+		out, err = rsa.DecryptPKCS1v15(nil, key, cipherText)
+	default:
+		m := new(big.Int).SetBytes(cipherText)
+		if m.Cmp(key.N) >= 0 {
+			return paramRC(tpm2.ErrorValue, 1)
+		}
+		out = padBytes(m.Exp(m, key.D, key.N).Bytes(), key.Size())
+	}
+	if err != nil {
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+	return c.respond(tpm2.PublicKeyRSA(out))
+}
+
+// eccPoint returns the TPMS_ECC_POINT representation of the supplied point on the specified curve.
+func eccPoint(key *ecdsa.PublicKey, x, y *big.Int) *tpm2.ECCPoint {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return &tpm2.ECCPoint{X: padBytes(x.Bytes(), size), Y: padBytes(y.Bytes(), size)}
+}
+
+func (s *Simulator) ecdhKeyGen(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+
+	o, rc := c.object(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if o.public.Type != tpm2.ObjectTypeECC {
+		return handleRC(tpm2.ErrorKey, 1)
+	}
+	pub := eccPublicKey(o.public)
+	if pub == nil {
+		return handleRC(tpm2.ErrorKey, 1)
+	}
+
+	ephemeral, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	if err != nil {
+		return errorRC(tpm2.ErrorFailure)
+	}
+	zx, zy := pub.Curve.ScalarMult(pub.X, pub.Y, ephemeral.D.Bytes())
+	return c.respond(eccPointSized{eccPoint(pub, zx, zy)}, eccPointSized{eccPoint(pub, ephemeral.X, ephemeral.Y)})
+}
+
+func (s *Simulator) ecdhZGen(c *commandContext) tpm2.ResponseCode {
+	var inPoint eccPointSized
+	if rc := c.unmarshalParams(&inPoint); rc != tpm2.Success {
+		return rc
+	}
+
+	o, rc := c.object(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if o.public.Type != tpm2.ObjectTypeECC || o.sensitive == nil {
+		return handleRC(tpm2.ErrorKey, 1)
+	}
+	if o.public.Attrs&tpm2.AttrRestricted != 0 || o.public.Attrs&tpm2.AttrDecrypt == 0 {
+		return handleRC(tpm2.ErrorAttributes, 1)
+	}
+	switch o.public.Params.ECCDetail().Scheme.Scheme {
+	case tpm2.ECCSchemeNull, tpm2.ECCSchemeECDH:
+	default:
+		return handleRC(tpm2.ErrorScheme, 1)
+	}
+	key := eccPrivateKey(o.public, o.sensitive)
+	if key == nil {
+		return handleRC(tpm2.ErrorKey, 1)
+	}
+
+	if inPoint.Ptr == nil {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	x := new(big.Int).SetBytes(inPoint.Ptr.X)
+	y := new(big.Int).SetBytes(inPoint.Ptr.Y)
+	if !key.Curve.IsOnCurve(x, y) {
+		return paramRC(tpm2.ErrorECCPoint, 1)
+	}
+	zx, zy := key.Curve.ScalarMult(x, y, key.D.Bytes())
+	return c.respond(eccPointSized{eccPoint(&key.PublicKey, zx, zy)})
+}
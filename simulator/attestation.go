@@ -0,0 +1,284 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"encoding/binary"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/internal"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+// attestSigningKey returns the key associated with the command handle at the specified index, which is used to sign an
+// attestation structure. It returns nil if the handle is HandleNull, in which case the attestation structure is not signed.
+func (c *commandContext) attestSigningKey(i int) (*object, tpm2.ResponseCode) {
+	if c.handles[i] == tpm2.HandleNull {
+		return nil, tpm2.Success
+	}
+	return c.signingKey(i)
+}
+
+// attest creates an attestation structure of the specified type, and signs it with the supplied key using the supplied scheme
+// if the key is not nil.
+func (s *Simulator) attest(signer *object, scheme *tpm2.SigScheme, typ tpm2.StructTag, extraData tpm2.Data,
+	attested interface{}) (tpm2.AttestRaw, *tpm2.Signature, tpm2.ResponseCode) {
+	attest := tpm2.Attest{
+		Magic:           tpm2.TPMGeneratedValue,
+		Type:            typ,
+		ExtraData:       extraData,
+		ClockInfo:       s.clockInfoLocked(),
+		FirmwareVersion: s.firmware,
+		Attested:        tpm2.AttestU{Data: attested}}
+
+	if signer == nil {
+		attest.QualifiedSigner = handleName(tpm2.HandleNull)
+	} else {
+		attest.QualifiedSigner = signer.qualifiedName
+		if signer.hierarchy != tpm2.HandleEndorsement && signer.hierarchy != tpm2.HandlePlatform {
+			// Obfuscate values that could be used to correlate attestations from keys in different hierarchies.
+			obfuscate := internal.KDFa(crypto.SHA256, s.hierarchyProof(signer.hierarchy), []byte("OBFUSCATE"), signer.name, nil, 128)
+			attest.ClockInfo.ResetCount += binary.BigEndian.Uint32(obfuscate[0:])
+			attest.ClockInfo.RestartCount += binary.BigEndian.Uint32(obfuscate[4:])
+			attest.FirmwareVersion += binary.BigEndian.Uint64(obfuscate[8:])
+		}
+	}
+
+	raw, err := mu.MarshalToBytes(&attest)
+	if err != nil {
+		return nil, nil, errorRC(tpm2.ErrorFailure)
+	}
+
+	if signer == nil {
+		return raw, &tpm2.Signature{SigAlg: tpm2.SigSchemeAlgNull}, tpm2.Success
+	}
+
+	h := scheme.Details.Any().HashAlg.NewHash()
+	h.Write(raw)
+	sig, code := signDigest(signer, scheme, h.Sum(nil))
+	if code != 0 {
+		return nil, nil, errorRC(code)
+	}
+	return raw, sig, tpm2.Success
+}
+
+func (s *Simulator) certify(c *commandContext) tpm2.ResponseCode {
+	var qualifyingData tpm2.Data
+	var inScheme tpm2.SigScheme
+	if rc := c.unmarshalParams(&qualifyingData, &inScheme); rc != tpm2.Success {
+		return rc
+	}
+
+	o, rc := c.object(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	signer, rc := c.attestSigningKey(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	var scheme *tpm2.SigScheme
+	if signer != nil {
+		var code tpm2.ErrorCode
+		scheme, code = signingScheme(signer, &inScheme)
+		if code != 0 {
+			return paramRC(code, 2)
+		}
+	}
+
+	certifyInfo, signature, rc := s.attest(signer, scheme, tpm2.TagAttestCertify, qualifyingData,
+		&tpm2.CertifyInfo{Name: o.name, QualifiedName: o.qualifiedName})
+	if rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(certifyInfo, signature)
+}
+
+func (s *Simulator) certifyCreation(c *commandContext) tpm2.ResponseCode {
+	var qualifyingData tpm2.Data
+	var creationHash tpm2.Digest
+	var inScheme tpm2.SigScheme
+	var creationTicket tpm2.TkCreation
+	if rc := c.unmarshalParams(&qualifyingData, &creationHash, &inScheme, &creationTicket); rc != tpm2.Success {
+		return rc
+	}
+
+	signer, rc := c.attestSigningKey(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	o, rc := c.object(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	var scheme *tpm2.SigScheme
+	if signer != nil {
+		var code tpm2.ErrorCode
+		scheme, code = signingScheme(signer, &inScheme)
+		if code != 0 {
+			return paramRC(code, 3)
+		}
+	}
+
+	expected := s.creationTicket(creationTicket.Hierarchy, o, creationHash)
+	if creationTicket.Tag != tpm2.TagCreation || !hmac.Equal(creationTicket.Digest, expected.Digest) {
+		return paramRC(tpm2.ErrorTicket, 4)
+	}
+
+	certifyInfo, signature, rc := s.attest(signer, scheme, tpm2.TagAttestCreation, qualifyingData,
+		&tpm2.CreationInfo{ObjectName: o.name, CreationHash: creationHash})
+	if rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(certifyInfo, signature)
+}
+
+func (s *Simulator) getSessionAuditDigest(c *commandContext) tpm2.ResponseCode {
+	var qualifyingData tpm2.Data
+	var inScheme tpm2.SigScheme
+	if rc := c.unmarshalParams(&qualifyingData, &inScheme); rc != tpm2.Success {
+		return rc
+	}
+
+	if _, rc := c.authHierarchy(0, tpm2.HandleEndorsement); rc != tpm2.Success {
+		return rc
+	}
+	signer, rc := c.attestSigningKey(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	session := c.entities[2].session
+	if session == nil || session.typ != tpm2.SessionTypeHMAC {
+		return handleRC(tpm2.ErrorType, 3)
+	}
+	var scheme *tpm2.SigScheme
+	if signer != nil {
+		var code tpm2.ErrorCode
+		scheme, code = signingScheme(signer, &inScheme)
+		if code != 0 {
+			return paramRC(code, 2)
+		}
+	}
+
+	digest := session.auditDigest
+	if digest == nil {
+		digest = make(tpm2.Digest, session.hashAlg.Size())
+	}
+
+	auditInfo, signature, rc := s.attest(signer, scheme, tpm2.TagAttestSessionAudit, qualifyingData,
+		&tpm2.SessionAuditInfo{ExclusiveSession: s.exclusiveAuditSession == session.handle, SessionDigest: digest})
+	if rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(auditInfo, signature)
+}
+
+func (s *Simulator) getTime(c *commandContext) tpm2.ResponseCode {
+	var qualifyingData tpm2.Data
+	var inScheme tpm2.SigScheme
+	if rc := c.unmarshalParams(&qualifyingData, &inScheme); rc != tpm2.Success {
+		return rc
+	}
+
+	if _, rc := c.authHierarchy(0, tpm2.HandleEndorsement); rc != tpm2.Success {
+		return rc
+	}
+	signer, rc := c.attestSigningKey(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	var scheme *tpm2.SigScheme
+	if signer != nil {
+		var code tpm2.ErrorCode
+		scheme, code = signingScheme(signer, &inScheme)
+		if code != 0 {
+			return paramRC(code, 2)
+		}
+	}
+
+	timeInfo, signature, rc := s.attest(signer, scheme, tpm2.TagAttestTime, qualifyingData,
+		&tpm2.TimeAttestInfo{Time: s.timeInfoLocked(), FirmwareVersion: s.firmware})
+	if rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(timeInfo, signature)
+}
+
+func (s *Simulator) nvCertify(c *commandContext) tpm2.ResponseCode {
+	var qualifyingData tpm2.Data
+	var inScheme tpm2.SigScheme
+	var size, offset uint16
+	if rc := c.unmarshalParams(&qualifyingData, &inScheme, &size, &offset); rc != tpm2.Success {
+		return rc
+	}
+
+	signer, rc := c.attestSigningKey(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	nv, rc := c.nvIndex(2)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if rc := s.checkNVReadAccess(c, c.entities[1], nv); rc != tpm2.Success {
+		return rc
+	}
+	var scheme *tpm2.SigScheme
+	if signer != nil {
+		var code tpm2.ErrorCode
+		scheme, code = signingScheme(signer, &inScheme)
+		if code != 0 {
+			return paramRC(code, 2)
+		}
+	}
+	if !nv.hasAttr(tpm2.AttrNVWritten) {
+		return errorRC(tpm2.ErrorNVUninitialized)
+	}
+	if size > maxNVBuffer {
+		return paramRC(tpm2.ErrorValue, 3)
+	}
+	if int(offset)+int(size) > len(nv.data) {
+		return errorRC(tpm2.ErrorNVRange)
+	}
+
+	certifyInfo, signature, rc := s.attest(signer, scheme, tpm2.TagAttestNV, qualifyingData,
+		&tpm2.NVCertifyInfo{IndexName: nv.name(), Offset: offset, NVContents: nv.data[offset : offset+size]})
+	if rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(certifyInfo, signature)
+}
+
+func (s *Simulator) quote(c *commandContext) tpm2.ResponseCode {
+	var qualifyingData tpm2.Data
+	var inScheme tpm2.SigScheme
+	var pcrSelect tpm2.PCRSelectionList
+	if rc := c.unmarshalParams(&qualifyingData, &inScheme, &pcrSelect); rc != tpm2.Success {
+		return rc
+	}
+
+	signer, rc := c.signingKey(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	scheme, code := signingScheme(signer, &inScheme)
+	if code != 0 {
+		return paramRC(code, 2)
+	}
+
+	pcrDigest, code := s.computePCRDigest(scheme.Details.Any().HashAlg, pcrSelect)
+	if code != 0 {
+		return paramRC(code, 3)
+	}
+
+	quoted, signature, rc := s.attest(signer, scheme, tpm2.TagAttestQuote, qualifyingData,
+		&tpm2.QuoteInfo{PCRSelect: pcrSelect, PCRDigest: pcrDigest})
+	if rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(quoted, signature)
+}
@@ -0,0 +1,390 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"sort"
+
+	"github.com/canonical/go-tpm2"
+)
+
+const (
+	manufacturer = 0x53494d00 // "SIM"
+	specLevel    = 0
+	specRevision = 159
+	specYear     = 2019
+	specDay      = 312
+)
+
+// algorithms describes the algorithms implemented by the simulator, in order of algorithm ID.
+var algorithms = tpm2.AlgorithmPropertyList{
+	{Alg: tpm2.AlgorithmRSA, Properties: tpm2.AttrAsymmetric | tpm2.AttrObject},
+	{Alg: tpm2.AlgorithmSHA1, Properties: tpm2.AttrHash},
+	{Alg: tpm2.AlgorithmHMAC, Properties: tpm2.AttrHash | tpm2.AttrSigning},
+	{Alg: tpm2.AlgorithmAES, Properties: tpm2.AttrSymmetric},
+	{Alg: tpm2.AlgorithmMGF1, Properties: tpm2.AttrHash | tpm2.AttrMethod},
+	{Alg: tpm2.AlgorithmKeyedHash, Properties: tpm2.AttrHash | tpm2.AttrEncrypting | tpm2.AttrSigning | tpm2.AttrObject},
+	{Alg: tpm2.AlgorithmXOR, Properties: tpm2.AttrHash | tpm2.AttrSymmetric},
+	{Alg: tpm2.AlgorithmSHA256, Properties: tpm2.AttrHash},
+	{Alg: tpm2.AlgorithmSHA384, Properties: tpm2.AttrHash},
+	{Alg: tpm2.AlgorithmSHA512, Properties: tpm2.AttrHash},
+	{Alg: tpm2.AlgorithmNull},
+	{Alg: tpm2.AlgorithmRSASSA, Properties: tpm2.AttrAsymmetric | tpm2.AttrSigning},
+	{Alg: tpm2.AlgorithmRSAES, Properties: tpm2.AttrAsymmetric | tpm2.AttrEncrypting},
+	{Alg: tpm2.AlgorithmRSAPSS, Properties: tpm2.AttrAsymmetric | tpm2.AttrSigning},
+	{Alg: tpm2.AlgorithmOAEP, Properties: tpm2.AttrAsymmetric | tpm2.AttrEncrypting},
+	{Alg: tpm2.AlgorithmECDSA, Properties: tpm2.AttrAsymmetric | tpm2.AttrSigning | tpm2.AttrMethod},
+	{Alg: tpm2.AlgorithmECDH, Properties: tpm2.AttrAsymmetric | tpm2.AttrMethod},
+	{Alg: tpm2.AlgorithmKDF1_SP800_56A, Properties: tpm2.AttrHash | tpm2.AttrMethod},
+	{Alg: tpm2.AlgorithmKDF1_SP800_108, Properties: tpm2.AttrHash | tpm2.AttrMethod},
+	{Alg: tpm2.AlgorithmECC, Properties: tpm2.AttrAsymmetric | tpm2.AttrObject},
+	{Alg: tpm2.AlgorithmSymCipher, Properties: tpm2.AttrObject},
+	{Alg: tpm2.AlgorithmCTR, Properties: tpm2.AttrSymmetric | tpm2.AttrEncrypting},
+	{Alg: tpm2.AlgorithmOFB, Properties: tpm2.AttrSymmetric | tpm2.AttrEncrypting},
+	{Alg: tpm2.AlgorithmCBC, Properties: tpm2.AttrSymmetric | tpm2.AttrEncrypting},
+	{Alg: tpm2.AlgorithmCFB, Properties: tpm2.AttrSymmetric | tpm2.AttrEncrypting},
+	{Alg: tpm2.AlgorithmECB, Properties: tpm2.AttrSymmetric | tpm2.AttrEncrypting}}
+
+// eccCurves are the ECC curves implemented by the simulator, in order of curve ID.
+var eccCurves = tpm2.ECCCurveList{tpm2.ECCCurveNIST_P224, tpm2.ECCCurveNIST_P256, tpm2.ECCCurveNIST_P384, tpm2.ECCCurveNIST_P521}
+
+// permanentHandles are the permanent handles implemented by the simulator, in numerical order.
+var permanentHandles = tpm2.HandleList{tpm2.HandleOwner, tpm2.HandleNull, tpm2.HandlePW, tpm2.HandleLockout, tpm2.HandleEndorsement,
+	tpm2.HandlePlatform, tpm2.HandlePlatformNV}
+
+// capabilityLimit returns the number of elements of the specified size to return from TPM2_GetCapability, given the total number
+// available and the number requested, and indicates whether there are more elements available.
+func capabilityLimit(total int, requested uint32, size int) (int, bool) {
+	n := (maxCapBuffer - 9) / size // The header contains moreData, capability and count.
+	if uint32(n) > requested {
+		n = int(requested)
+	}
+	if total <= n {
+		return total, false
+	}
+	return n, true
+}
+
+// fixedProperties returns the values of the TPM_PT_FIXED properties.
+func (s *Simulator) fixedProperties() tpm2.TaggedTPMPropertyList {
+	return tpm2.TaggedTPMPropertyList{
+		{Property: tpm2.PropertyFamilyIndicator, Value: 0x322e3000}, // "2.0"
+		{Property: tpm2.PropertyLevel, Value: specLevel},
+		{Property: tpm2.PropertyRevision, Value: specRevision},
+		{Property: tpm2.PropertyDayOfYear, Value: specDay},
+		{Property: tpm2.PropertyYear, Value: specYear},
+		{Property: tpm2.PropertyManufacturer, Value: manufacturer},
+		{Property: tpm2.PropertyVendorString1, Value: 0x676f2d74}, // "go-t"
+		{Property: tpm2.PropertyVendorString2, Value: 0x706d3200}, // "pm2"
+		{Property: tpm2.PropertyVendorString3, Value: 0},
+		{Property: tpm2.PropertyVendorString4, Value: 0},
+		{Property: tpm2.PropertyVendorTPMType, Value: 0},
+		{Property: tpm2.PropertyFirmwareVersion1, Value: uint32(s.firmware >> 32)},
+		{Property: tpm2.PropertyFirmwareVersion2, Value: uint32(s.firmware)},
+		{Property: tpm2.PropertyInputBuffer, Value: maxInputBuffer},
+		{Property: tpm2.PropertyHRTransientMin, Value: maxTransientObjects},
+		{Property: tpm2.PropertyHRPersistentMin, Value: maxPersistent},
+		{Property: tpm2.PropertyHRLoadedMin, Value: maxLoadedSessions},
+		{Property: tpm2.PropertyActiveSessionsMax, Value: maxActiveSessions},
+		{Property: tpm2.PropertyPCRCount, Value: numPCRs},
+		{Property: tpm2.PropertyPCRSelectMin, Value: (numPCRs + 7) / 8},
+		{Property: tpm2.PropertyContextGapMax, Value: 0xffff},
+		{Property: tpm2.PropertyNVCountersMax, Value: 0},
+		{Property: tpm2.PropertyNVIndexMax, Value: maxNVIndexSize},
+		{Property: tpm2.PropertyMemory, Value: 0},
+		{Property: tpm2.PropertyClockUpdate, Value: 1000},
+		{Property: tpm2.PropertyContextHash, Value: uint32(tpm2.HashAlgorithmSHA256)},
+		{Property: tpm2.PropertyContextSym, Value: uint32(tpm2.SymAlgorithmAES)},
+		{Property: tpm2.PropertyContextSymSize, Value: 256},
+		{Property: tpm2.PropertyOrderlyCount, Value: 0xff},
+		{Property: tpm2.PropertyMaxCommandSize, Value: maxCommandSize},
+		{Property: tpm2.PropertyMaxResponseSize, Value: maxCommandSize},
+		{Property: tpm2.PropertyMaxDigest, Value: 64},
+		{Property: tpm2.PropertyMaxObjectContext, Value: maxContextSize},
+		{Property: tpm2.PropertyMaxSessionContext, Value: maxContextSize},
+		{Property: tpm2.PropertyPSFamilyIndicator, Value: 1},
+		{Property: tpm2.PropertyPSLevel, Value: 0},
+		{Property: tpm2.PropertyPSRevision, Value: 0x100},
+		{Property: tpm2.PropertyPSDayOfYear, Value: 0},
+		{Property: tpm2.PropertyPSYear, Value: 0},
+		{Property: tpm2.PropertySplitMax, Value: 0},
+		{Property: tpm2.PropertyTotalCommands, Value: uint32(len(commands))},
+		{Property: tpm2.PropertyLibraryCommands, Value: uint32(len(commands))},
+		{Property: tpm2.PropertyVendorCommands, Value: 0},
+		{Property: tpm2.PropertyNVBufferMax, Value: maxNVBuffer},
+		{Property: tpm2.PropertyModes, Value: 0},
+		{Property: tpm2.PropertyMaxCapBuffer, Value: maxCapBuffer}}
+}
+
+// variableProperties returns the values of the TPM_PT_VAR properties.
+func (s *Simulator) variableProperties() tpm2.TaggedTPMPropertyList {
+	var permanent tpm2.PermanentAttributes
+	if len(s.owner.authValue) > 0 {
+		permanent |= tpm2.AttrOwnerAuthSet
+	}
+	if len(s.endorsement.authValue) > 0 {
+		permanent |= tpm2.AttrEndorsementAuthSet
+	}
+	if len(s.lockout.authValue) > 0 {
+		permanent |= tpm2.AttrLockoutAuthSet
+	}
+	if s.disableClear {
+		permanent |= tpm2.AttrDisableClear
+	}
+	if s.isInLockout() {
+		permanent |= tpm2.AttrInLockout
+	}
+	permanent |= tpm2.AttrTPMGeneratedEPS
+
+	var startupClear tpm2.StartupClearAttributes
+	if s.phEnable {
+		startupClear |= tpm2.AttrPhEnable
+	}
+	if s.shEnable {
+		startupClear |= tpm2.AttrShEnable
+	}
+	if s.ehEnable {
+		startupClear |= tpm2.AttrEhEnable
+	}
+	if s.phEnableNV {
+		startupClear |= tpm2.AttrPhEnableNV
+	}
+
+	loaded := 0
+	for _, session := range s.sessions {
+		if session.loaded {
+			loaded++
+		}
+	}
+	counters := 0
+	for _, nv := range s.nvIndices {
+		if nv.public.Attrs.Type() == tpm2.NVTypeCounter {
+			counters++
+		}
+	}
+
+	return tpm2.TaggedTPMPropertyList{
+		{Property: tpm2.PropertyPermanent, Value: uint32(permanent)},
+		{Property: tpm2.PropertyStartupClear, Value: uint32(startupClear)},
+		{Property: tpm2.PropertyHRNVIndex, Value: uint32(len(s.nvIndices))},
+		{Property: tpm2.PropertyHRLoaded, Value: uint32(loaded)},
+		{Property: tpm2.PropertyHRLoadedAvail, Value: uint32(maxLoadedSessions - loaded)},
+		{Property: tpm2.PropertyHRActive, Value: uint32(len(s.sessions))},
+		{Property: tpm2.PropertyHRActiveAvail, Value: uint32(maxActiveSessions - len(s.sessions))},
+		{Property: tpm2.PropertyHRTransientAvail, Value: uint32(maxTransientObjects - len(s.objects))},
+		{Property: tpm2.PropertyHRPersistent, Value: uint32(len(s.persistent))},
+		{Property: tpm2.PropertyHRPersistentAvail, Value: uint32(maxPersistent - len(s.persistent))},
+		{Property: tpm2.PropertyNVCounters, Value: uint32(counters)},
+		{Property: tpm2.PropertyNVCountersAvail, Value: uint32(maxNVIndices - len(s.nvIndices))},
+		{Property: tpm2.PropertyAlgorithmSet, Value: 0},
+		{Property: tpm2.PropertyLoadedCurves, Value: uint32(len(eccCurves))},
+		{Property: tpm2.PropertyLockoutCounter, Value: s.da.failedTries},
+		{Property: tpm2.PropertyMaxAuthFail, Value: s.da.maxTries},
+		{Property: tpm2.PropertyLockoutInterval, Value: s.da.recoveryTime},
+		{Property: tpm2.PropertyLockoutRecovery, Value: s.da.lockoutRecovery},
+		{Property: tpm2.PropertyNVWriteRecovery, Value: 0}}
+}
+
+// pcrProperties returns the values of the PCR properties.
+func pcrProperties() tpm2.TaggedPCRPropertyList {
+	all := make(tpm2.PCRSelect, 0, numPCRs)
+	var noIncrement tpm2.PCRSelect
+	for i := 0; i < numPCRs; i++ {
+		all = append(all, i)
+		if pcrNoIncrement(i) {
+			noIncrement = append(noIncrement, i)
+		}
+	}
+
+	props := tpm2.TaggedPCRPropertyList{{Tag: tpm2.PropertyPCRSave, Select: all}}
+	for l := uint8(0); l <= 4; l++ {
+		var extend, reset tpm2.PCRSelect
+		for i := 0; i < numPCRs; i++ {
+			if pcrExtendLocalities(i)&(1<<l) != 0 {
+				extend = append(extend, i)
+			}
+			if pcrResetLocalities(i)&(1<<l) != 0 {
+				reset = append(reset, i)
+			}
+		}
+		props = append(props,
+			tpm2.TaggedPCRSelect{Tag: tpm2.PropertyPCRExtendL0 + tpm2.PropertyPCR(l*2), Select: extend},
+			tpm2.TaggedPCRSelect{Tag: tpm2.PropertyPCRResetL0 + tpm2.PropertyPCR(l*2), Select: reset})
+	}
+	return append(props, tpm2.TaggedPCRSelect{Tag: tpm2.PropertyPCRNoIncrement, Select: noIncrement})
+}
+
+// sortedHandles returns the handles of the supplied resources in numerical order.
+func sortedHandles(handles []tpm2.Handle) tpm2.HandleList {
+	sort.Slice(handles, func(i, j int) bool { return handles[i] < handles[j] })
+	return handles
+}
+
+// capabilityHandles returns the handles of the specified type, in numerical order.
+func (s *Simulator) capabilityHandles(handleType tpm2.HandleType) (tpm2.HandleList, bool) {
+	var handles []tpm2.Handle
+	switch handleType {
+	case tpm2.HandleTypePCR:
+		for i := 0; i < numPCRs; i++ {
+			handles = append(handles, tpm2.Handle(i))
+		}
+	case tpm2.HandleTypeNVIndex:
+		for h := range s.nvIndices {
+			handles = append(handles, h)
+		}
+	case tpm2.HandleTypeLoadedSession, tpm2.HandleTypeSavedSession:
+		loaded := handleType == tpm2.HandleTypeLoadedSession
+		for h, session := range s.sessions {
+			if session.loaded == loaded {
+				handles = append(handles, h)
+			}
+		}
+	case tpm2.HandleTypePermanent:
+		return append(tpm2.HandleList(nil), permanentHandles...), true
+	case tpm2.HandleTypeTransient:
+		for h := range s.objects {
+			handles = append(handles, h)
+		}
+	case tpm2.HandleTypePersistent:
+		for h := range s.persistent {
+			handles = append(handles, h)
+		}
+	default:
+		return nil, false
+	}
+	return sortedHandles(handles), true
+}
+
+func (s *Simulator) getCapability(c *commandContext) tpm2.ResponseCode {
+	var capability tpm2.Capability
+	var property, propertyCount uint32
+	if rc := c.unmarshalParams(&capability, &property, &propertyCount); rc != tpm2.Success {
+		return rc
+	}
+
+	var data interface{}
+	var moreData bool
+
+	switch capability {
+	case tpm2.CapabilityAlgs:
+		if property > 0xffff {
+			return paramRC(tpm2.ErrorValue, 2)
+		}
+		i := sort.Search(len(algorithms), func(i int) bool { return uint32(algorithms[i].Alg) >= property })
+		list := algorithms[i:]
+		var n int
+		n, moreData = capabilityLimit(len(list), propertyCount, 6)
+		data = append(tpm2.AlgorithmPropertyList(nil), list[:n]...)
+	case tpm2.CapabilityHandles:
+		handles, ok := s.capabilityHandles(tpm2.Handle(property).Type())
+		if !ok {
+			return paramRC(tpm2.ErrorValue, 2)
+		}
+		i := sort.Search(len(handles), func(i int) bool { return handles[i]&0xffffff >= tpm2.Handle(property)&0xffffff })
+		list := handles[i:]
+		var n int
+		n, moreData = capabilityLimit(len(list), propertyCount, 4)
+		data = list[:n]
+	case tpm2.CapabilityCommands:
+		var list tpm2.CommandAttributesList
+		for _, code := range sortedCommandCodes() {
+			if uint32(code) >= property {
+				list = append(list, commands[code].commandAttributes(code))
+			}
+		}
+		var n int
+		n, moreData = capabilityLimit(len(list), propertyCount, 4)
+		data = list[:n]
+	case tpm2.CapabilityPPCommands, tpm2.CapabilityAuditCommands:
+		data = tpm2.CommandCodeList{}
+	case tpm2.CapabilityPCRs:
+		var list tpm2.PCRSelectionList
+		for _, alg := range pcrBanks {
+			selection := tpm2.PCRSelection{Hash: alg}
+			for i := 0; i < numPCRs; i++ {
+				selection.Select = append(selection.Select, i)
+			}
+			list = append(list, selection)
+		}
+		data = list
+	case tpm2.CapabilityTPMProperties:
+		props := append(s.fixedProperties(), s.variableProperties()...)
+		i := sort.Search(len(props), func(i int) bool { return uint32(props[i].Property) >= property })
+		list := props[i:]
+		var n int
+		n, moreData = capabilityLimit(len(list), propertyCount, 8)
+		data = list[:n]
+	case tpm2.CapabilityPCRProperties:
+		props := pcrProperties()
+		i := sort.Search(len(props), func(i int) bool { return uint32(props[i].Tag) >= property })
+		list := props[i:]
+		var n int
+		n, moreData = capabilityLimit(len(list), propertyCount, 8)
+		data = list[:n]
+	case tpm2.CapabilityECCCurves:
+		i := sort.Search(len(eccCurves), func(i int) bool { return uint32(eccCurves[i]) >= property })
+		list := eccCurves[i:]
+		var n int
+		n, moreData = capabilityLimit(len(list), propertyCount, 2)
+		data = append(tpm2.ECCCurveList(nil), list[:n]...)
+	case tpm2.CapabilityAuthPolicies:
+		var list tpm2.TaggedPolicyList
+		for _, h := range []*hierarchy{s.owner, s.lockout, s.endorsement, s.platform} {
+			if h.handle < tpm2.Handle(property) {
+				continue
+			}
+			list = append(list, tpm2.TaggedPolicy{
+				Handle:     h.handle,
+				PolicyHash: tpm2.TaggedHash{HashAlg: h.policyAlg, Digest: h.authPolicy}})
+		}
+		var n int
+		n, moreData = capabilityLimit(len(list), propertyCount, 70)
+		data = list[:n]
+	case tpm2.CapabilityACT:
+		data = tpm2.ACTDataList{}
+	default:
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+
+	return c.respond(moreData, &tpm2.CapabilityData{Capability: capability, Data: tpm2.CapabilitiesU{Data: data}})
+}
+
+func (s *Simulator) testParms(c *commandContext) tpm2.ResponseCode {
+	var parameters tpm2.PublicParams
+	if rc := c.unmarshalParams(&parameters); rc != tpm2.Success {
+		return rc
+	}
+
+	public := &tpm2.Public{
+		Type:    parameters.Type,
+		NameAlg: tpm2.HashAlgorithmSHA256,
+		Params:  parameters.Parameters}
+	switch parameters.Type {
+	case tpm2.ObjectTypeRSA, tpm2.ObjectTypeECC:
+		params := parameters.Parameters.AsymDetail()
+		switch {
+		case params.Symmetric.Algorithm != tpm2.SymObjectAlgorithmNull:
+			public.Attrs = tpm2.AttrRestricted | tpm2.AttrDecrypt
+		case params.Scheme.Scheme == tpm2.AsymSchemeNull:
+			public.Attrs = tpm2.AttrSign | tpm2.AttrDecrypt
+		case params.Scheme.Scheme == tpm2.AsymSchemeRSAES, params.Scheme.Scheme == tpm2.AsymSchemeOAEP,
+			params.Scheme.Scheme == tpm2.AsymSchemeECDH:
+			public.Attrs = tpm2.AttrDecrypt
+		default:
+			public.Attrs = tpm2.AttrSign
+		}
+	case tpm2.ObjectTypeKeyedHash:
+		if parameters.Parameters.KeyedHashDetail().Scheme.Scheme != tpm2.KeyedHashSchemeNull {
+			public.Attrs = tpm2.AttrSign
+		}
+	case tpm2.ObjectTypeSymCipher:
+		public.Attrs = tpm2.AttrDecrypt
+	}
+
+	if code := checkPublic(public, true); code != 0 {
+		return paramRC(code, 1)
+	}
+	return tpm2.Success
+}
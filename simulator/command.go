@@ -0,0 +1,260 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+// authRole describes the authorization role required for a command handle.
+type authRole int
+
+const (
+	authNone  authRole = iota // The handle doesn't require authorization
+	authUser                  // The handle requires authorization with the USER role
+	authAdmin                 // The handle requires authorization with the ADMIN role
+	authDup                   // The handle requires authorization with the DUP role
+)
+
+// commandInfo describes a command implemented by the simulator.
+type commandInfo struct {
+	handles []authRole             // The authorization role for each command handle
+	rHandle bool                   // The response contains a handle
+	decrypt bool                   // The first command parameter can be encrypted
+	encrypt bool                   // The first response parameter can be encrypted
+	attrs   tpm2.CommandAttributes // Additional attributes returned from TPM2_GetCapability(TPM_CAP_COMMANDS)
+	fn      func(*Simulator, *commandContext) tpm2.ResponseCode
+}
+
+// numAuthHandles returns the number of command handles that require authorization.
+func (i *commandInfo) numAuthHandles() (n int) {
+	for _, r := range i.handles {
+		if r != authNone {
+			n++
+		}
+	}
+	return
+}
+
+// commandAttributes returns the TPMA_CC value for the command.
+func (i *commandInfo) commandAttributes(code tpm2.CommandCode) tpm2.CommandAttributes {
+	attrs := tpm2.CommandAttributes(code) | i.attrs | tpm2.CommandAttributes(len(i.handles))<<25
+	if i.rHandle {
+		attrs |= tpm2.AttrRHandle
+	}
+	return attrs
+}
+
+type commandHeader struct {
+	Tag         tpm2.StructTag
+	CommandSize uint32
+	CommandCode tpm2.CommandCode
+}
+
+type responseHeader struct {
+	Tag          tpm2.StructTag
+	ResponseSize uint32
+	ResponseCode tpm2.ResponseCode
+}
+
+// commandContext contains the state associated with the command being executed.
+type commandContext struct {
+	s        *Simulator
+	code     tpm2.CommandCode
+	info     *commandInfo
+	locality uint8
+
+	handles  []tpm2.Handle
+	entities []*entity
+	sessions []*commandSession
+	params   *bytes.Reader
+
+	rHandle tpm2.Handle
+	rParams []interface{}
+}
+
+// unmarshalParams unmarshals the command parameters in to the supplied pointers.
+func (c *commandContext) unmarshalParams(params ...interface{}) tpm2.ResponseCode {
+	for i, p := range params {
+		if _, err := mu.UnmarshalFromReader(c.params, p); err != nil {
+			var e *mu.InvalidSelectorError
+			switch {
+			case xerrors.As(err, &e):
+				return paramRC(tpm2.ErrorSelector, i+1)
+			case xerrors.Is(err, io.EOF) || xerrors.Is(err, io.ErrUnexpectedEOF):
+				return paramRC(tpm2.ErrorInsufficient, i+1)
+			default:
+				return paramRC(tpm2.ErrorSize, i+1)
+			}
+		}
+	}
+	if c.params.Len() > 0 {
+		return errorRC(tpm2.ErrorSize)
+	}
+	return tpm2.Success
+}
+
+// respond sets the response parameters for the command.
+func (c *commandContext) respond(params ...interface{}) tpm2.ResponseCode {
+	c.rParams = params
+	return tpm2.Success
+}
+
+// authSession returns the session used to authorize the command handle at the specified index.
+func (c *commandContext) authSession(handleIndex int) *commandSession {
+	n := 0
+	for i, r := range c.info.handles {
+		if r == authNone {
+			continue
+		}
+		if i == handleIndex {
+			return c.sessions[n]
+		}
+		n++
+	}
+	return nil
+}
+
+// isPolicyAuth indicates whether the command handle at the specified index was authorized with a policy session.
+func (c *commandContext) isPolicyAuth(handleIndex int) bool {
+	session := c.authSession(handleIndex)
+	return session != nil && session.session != nil && session.session.typ == tpm2.SessionTypePolicy
+}
+
+func makeErrorResponse(rc tpm2.ResponseCode) []byte {
+	rsp, _ := mu.MarshalToBytes(responseHeader{Tag: tpm2.TagNoSessions, ResponseSize: 10, ResponseCode: rc})
+	return rsp
+}
+
+// executeCommand executes the supplied command packet and returns the response packet.
+func (s *Simulator) executeCommand(locality uint8, cmd []byte) []byte {
+	var hdr commandHeader
+	if _, err := mu.UnmarshalFromBytes(cmd, &hdr); err != nil {
+		return makeErrorResponse(errorRC(tpm2.ErrorCommandSize))
+	}
+	if int(hdr.CommandSize) != len(cmd) || len(cmd) > maxCommandSize {
+		return makeErrorResponse(errorRC(tpm2.ErrorCommandSize))
+	}
+	switch hdr.Tag {
+	case tpm2.TagNoSessions, tpm2.TagSessions:
+	default:
+		return makeErrorResponse(rcBadTag)
+	}
+
+	if !s.started && hdr.CommandCode != tpm2.CommandStartup {
+		return makeErrorResponse(errorRC(tpm2.ErrorInitialize))
+	}
+
+	info, ok := commands[hdr.CommandCode]
+	if !ok {
+		return makeErrorResponse(errorRC(tpm2.ErrorCommandCode))
+	}
+
+	c := &commandContext{s: s, code: hdr.CommandCode, info: info, locality: locality}
+	rsp, rc := s.runCommand(c, hdr.Tag, cmd[binary.Size(hdr):])
+	if rc != tpm2.Success {
+		return makeErrorResponse(rc)
+	}
+	return rsp
+}
+
+// runCommand executes the command described by c, with the supplied tag and the command bytes that follow the header.
+func (s *Simulator) runCommand(c *commandContext, tag tpm2.StructTag, data []byte) ([]byte, tpm2.ResponseCode) {
+	r := bytes.NewReader(data)
+
+	// Unmarshal and resolve the command handles.
+	for i := range c.info.handles {
+		var h tpm2.Handle
+		if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+			return nil, errorRC(tpm2.ErrorInsufficient)
+		}
+		e, rc := s.resolveHandle(h, i+1)
+		if rc != tpm2.Success {
+			return nil, rc
+		}
+		c.handles = append(c.handles, h)
+		c.entities = append(c.entities, e)
+	}
+
+	// Unmarshal the authorization area.
+	numAuth := c.info.numAuthHandles()
+	switch tag {
+	case tpm2.TagSessions:
+		var authSize uint32
+		if err := binary.Read(r, binary.BigEndian, &authSize); err != nil {
+			return nil, errorRC(tpm2.ErrorAuthsize)
+		}
+		if authSize > uint32(r.Len()) || authSize == 0 {
+			return nil, errorRC(tpm2.ErrorAuthsize)
+		}
+		authArea := make([]byte, authSize)
+		r.Read(authArea)
+		sessions, rc := s.unmarshalSessions(authArea)
+		if rc != tpm2.Success {
+			return nil, rc
+		}
+		c.sessions = sessions
+	default:
+		if numAuth > 0 {
+			return nil, errorRC(tpm2.ErrorAuthMissing)
+		}
+	}
+	if len(c.sessions) < numAuth {
+		return nil, errorRC(tpm2.ErrorAuthMissing)
+	}
+
+	cpBytes := make([]byte, r.Len())
+	r.Read(cpBytes)
+
+	if rc := s.processCommandSessions(c, cpBytes); rc != tpm2.Success {
+		return nil, rc
+	}
+
+	c.params = bytes.NewReader(cpBytes)
+	if rc := c.info.fn(s, c); rc != tpm2.Success {
+		return nil, rc
+	}
+
+	rpBytes, err := mu.MarshalToBytes(c.rParams...)
+	if err != nil {
+		return nil, errorRC(tpm2.ErrorFailure)
+	}
+
+	var rspAuth []byte
+	if len(c.sessions) == 0 {
+		s.exclusiveAuditSession = tpm2.HandleUnassigned
+	} else {
+		var rc tpm2.ResponseCode
+		rspAuth, rc = s.processResponseSessions(c, rpBytes)
+		if rc != tpm2.Success {
+			return nil, rc
+		}
+	}
+
+	var rsp bytes.Buffer
+	if c.info.rHandle {
+		binary.Write(&rsp, binary.BigEndian, c.rHandle)
+	}
+	rspTag := tpm2.TagNoSessions
+	if len(c.sessions) > 0 {
+		rspTag = tpm2.TagSessions
+		binary.Write(&rsp, binary.BigEndian, uint32(len(rpBytes)))
+	}
+	rsp.Write(rpBytes)
+	rsp.Write(rspAuth)
+
+	hdr, _ := mu.MarshalToBytes(responseHeader{
+		Tag:          rspTag,
+		ResponseSize: uint32(binary.Size(responseHeader{}) + rsp.Len()),
+		ResponseCode: tpm2.Success})
+	return append(hdr, rsp.Bytes()...), tpm2.Success
+}
@@ -0,0 +1,215 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"sort"
+
+	"github.com/canonical/go-tpm2"
+)
+
+// commands contains the commands implemented by the simulator. It is populated by init in order to avoid an initialization loop,
+// as some command implementations refer to it.
+var commands map[tpm2.CommandCode]*commandInfo
+
+func init() {
+	commands = map[tpm2.CommandCode]*commandInfo{
+		tpm2.CommandNVUndefineSpaceSpecial: {
+			handles: []authRole{authAdmin, authUser}, attrs: tpm2.AttrNV, fn: (*Simulator).nvUndefineSpaceSpecial},
+		tpm2.CommandEvictControl: {
+			handles: []authRole{authUser, authNone}, attrs: tpm2.AttrNV, fn: (*Simulator).evictControl},
+		tpm2.CommandHierarchyControl: {
+			handles: []authRole{authUser}, attrs: tpm2.AttrNV | tpm2.AttrExtensive, fn: (*Simulator).hierarchyControl},
+		tpm2.CommandNVUndefineSpace: {
+			handles: []authRole{authUser, authNone}, attrs: tpm2.AttrNV, fn: (*Simulator).nvUndefineSpace},
+		tpm2.CommandChangeEPS: {
+			handles: []authRole{authUser}, attrs: tpm2.AttrNV | tpm2.AttrExtensive, fn: (*Simulator).changeEPS},
+		tpm2.CommandChangePPS: {
+			handles: []authRole{authUser}, attrs: tpm2.AttrNV | tpm2.AttrExtensive, fn: (*Simulator).changePPS},
+		tpm2.CommandClear: {
+			handles: []authRole{authUser}, attrs: tpm2.AttrNV | tpm2.AttrExtensive, fn: (*Simulator).clear},
+		tpm2.CommandClearControl: {
+			handles: []authRole{authUser}, attrs: tpm2.AttrNV, fn: (*Simulator).clearControl},
+		tpm2.CommandHierarchyChangeAuth: {
+			handles: []authRole{authUser}, decrypt: true, attrs: tpm2.AttrNV, fn: (*Simulator).hierarchyChangeAuth},
+		tpm2.CommandNVDefineSpace: {
+			handles: []authRole{authUser}, decrypt: true, attrs: tpm2.AttrNV, fn: (*Simulator).nvDefineSpace},
+		tpm2.CommandSetPrimaryPolicy: {
+			handles: []authRole{authUser}, decrypt: true, attrs: tpm2.AttrNV, fn: (*Simulator).setPrimaryPolicy},
+		tpm2.CommandCreatePrimary: {
+			handles: []authRole{authUser}, rHandle: true, decrypt: true, encrypt: true, fn: (*Simulator).createPrimary},
+		tpm2.CommandNVGlobalWriteLock: {
+			handles: []authRole{authUser}, attrs: tpm2.AttrNV, fn: (*Simulator).nvGlobalWriteLock},
+		tpm2.CommandNVIncrement: {
+			handles: []authRole{authUser, authNone}, attrs: tpm2.AttrNV, fn: (*Simulator).nvIncrement},
+		tpm2.CommandNVSetBits: {
+			handles: []authRole{authUser, authNone}, attrs: tpm2.AttrNV, fn: (*Simulator).nvSetBits},
+		tpm2.CommandNVExtend: {
+			handles: []authRole{authUser, authNone}, decrypt: true, attrs: tpm2.AttrNV, fn: (*Simulator).nvExtend},
+		tpm2.CommandNVWrite: {
+			handles: []authRole{authUser, authNone}, decrypt: true, attrs: tpm2.AttrNV, fn: (*Simulator).nvWrite},
+		tpm2.CommandNVWriteLock: {
+			handles: []authRole{authUser, authNone}, attrs: tpm2.AttrNV, fn: (*Simulator).nvWriteLock},
+		tpm2.CommandDictionaryAttackLockReset: {
+			handles: []authRole{authUser}, attrs: tpm2.AttrNV, fn: (*Simulator).dictionaryAttackLockReset},
+		tpm2.CommandDictionaryAttackParameters: {
+			handles: []authRole{authUser}, attrs: tpm2.AttrNV, fn: (*Simulator).dictionaryAttackParameters},
+		tpm2.CommandNVChangeAuth: {
+			handles: []authRole{authAdmin}, decrypt: true, attrs: tpm2.AttrNV, fn: (*Simulator).nvChangeAuth},
+		tpm2.CommandPCREvent: {
+			handles: []authRole{authUser}, decrypt: true, attrs: tpm2.AttrNV, fn: (*Simulator).pcrEvent},
+		tpm2.CommandPCRReset: {
+			handles: []authRole{authUser}, attrs: tpm2.AttrNV, fn: (*Simulator).pcrReset},
+		tpm2.CommandSequenceComplete: {
+			handles: []authRole{authUser}, decrypt: true, encrypt: true, attrs: tpm2.AttrFlushed, fn: (*Simulator).sequenceComplete},
+		tpm2.CommandIncrementalSelfTest: {
+			attrs: tpm2.AttrNV, fn: (*Simulator).incrementalSelfTest},
+		tpm2.CommandSelfTest: {
+			attrs: tpm2.AttrNV, fn: (*Simulator).selfTest},
+		tpm2.CommandStartup: {
+			attrs: tpm2.AttrNV, fn: (*Simulator).startup},
+		tpm2.CommandShutdown: {
+			attrs: tpm2.AttrNV, fn: (*Simulator).shutdown},
+		tpm2.CommandStirRandom: {
+			decrypt: true, attrs: tpm2.AttrNV, fn: (*Simulator).stirRandom},
+		tpm2.CommandActivateCredential: {
+			handles: []authRole{authAdmin, authUser}, decrypt: true, encrypt: true, fn: (*Simulator).activateCredential},
+		tpm2.CommandCertify: {
+			handles: []authRole{authAdmin, authUser}, decrypt: true, encrypt: true, fn: (*Simulator).certify},
+		tpm2.CommandPolicyNV: {
+			handles: []authRole{authUser, authNone, authNone}, decrypt: true, fn: (*Simulator).policyNV},
+		tpm2.CommandCertifyCreation: {
+			handles: []authRole{authUser, authNone}, decrypt: true, encrypt: true, fn: (*Simulator).certifyCreation},
+		tpm2.CommandDuplicate: {
+			handles: []authRole{authDup, authNone}, decrypt: true, encrypt: true, fn: (*Simulator).duplicate},
+		tpm2.CommandGetSessionAuditDigest: {
+			handles: []authRole{authUser, authUser, authNone}, decrypt: true, encrypt: true, fn: (*Simulator).getSessionAuditDigest},
+		tpm2.CommandGetTime: {
+			handles: []authRole{authUser, authUser}, decrypt: true, encrypt: true, fn: (*Simulator).getTime},
+		tpm2.CommandNVRead: {
+			handles: []authRole{authUser, authNone}, encrypt: true, fn: (*Simulator).nvRead},
+		tpm2.CommandNVReadLock: {
+			handles: []authRole{authUser, authNone}, attrs: tpm2.AttrNV, fn: (*Simulator).nvReadLock},
+		tpm2.CommandObjectChangeAuth: {
+			handles: []authRole{authAdmin, authNone}, decrypt: true, encrypt: true, fn: (*Simulator).objectChangeAuth},
+		tpm2.CommandPolicySecret: {
+			handles: []authRole{authUser, authNone}, decrypt: true, encrypt: true, fn: (*Simulator).policySecret},
+		tpm2.CommandRewrap: {
+			handles: []authRole{authUser, authNone}, decrypt: true, encrypt: true, fn: (*Simulator).rewrap},
+		tpm2.CommandCreate: {
+			handles: []authRole{authUser}, decrypt: true, encrypt: true, fn: (*Simulator).create},
+		tpm2.CommandECDHZGen: {
+			handles: []authRole{authUser}, decrypt: true, encrypt: true, fn: (*Simulator).ecdhZGen},
+		tpm2.CommandHMAC: {
+			handles: []authRole{authUser}, decrypt: true, encrypt: true, fn: (*Simulator).hmac},
+		tpm2.CommandImport: {
+			handles: []authRole{authUser}, decrypt: true, encrypt: true, fn: (*Simulator).importObject},
+		tpm2.CommandLoad: {
+			handles: []authRole{authUser}, rHandle: true, decrypt: true, encrypt: true, fn: (*Simulator).load},
+		tpm2.CommandQuote: {
+			handles: []authRole{authUser}, decrypt: true, encrypt: true, fn: (*Simulator).quote},
+		tpm2.CommandRSADecrypt: {
+			handles: []authRole{authUser}, decrypt: true, encrypt: true, fn: (*Simulator).rsaDecrypt},
+		tpm2.CommandHMACStart: {
+			handles: []authRole{authUser}, rHandle: true, decrypt: true, fn: (*Simulator).hmacStart},
+		tpm2.CommandSequenceUpdate: {
+			handles: []authRole{authUser}, decrypt: true, fn: (*Simulator).sequenceUpdate},
+		tpm2.CommandSign: {
+			handles: []authRole{authUser}, decrypt: true, fn: (*Simulator).sign},
+		tpm2.CommandUnseal: {
+			handles: []authRole{authUser}, encrypt: true, fn: (*Simulator).unseal},
+		tpm2.CommandPolicySigned: {
+			handles: []authRole{authNone, authNone}, decrypt: true, encrypt: true, fn: (*Simulator).policySigned},
+		tpm2.CommandContextLoad: {
+			rHandle: true, fn: (*Simulator).contextLoad},
+		tpm2.CommandContextSave: {
+			handles: []authRole{authNone}, fn: (*Simulator).contextSave},
+		tpm2.CommandECDHKeyGen: {
+			handles: []authRole{authNone}, encrypt: true, fn: (*Simulator).ecdhKeyGen},
+		tpm2.CommandEncryptDecrypt: {
+			handles: []authRole{authUser}, encrypt: true, fn: (*Simulator).encryptDecryptLegacy},
+		tpm2.CommandFlushContext: {
+			fn: (*Simulator).flushContext},
+		tpm2.CommandLoadExternal: {
+			rHandle: true, decrypt: true, encrypt: true, fn: (*Simulator).loadExternal},
+		tpm2.CommandMakeCredential: {
+			handles: []authRole{authNone}, decrypt: true, encrypt: true, fn: (*Simulator).makeCredential},
+		tpm2.CommandNVReadPublic: {
+			handles: []authRole{authNone}, encrypt: true, fn: (*Simulator).nvReadPublic},
+		tpm2.CommandPolicyAuthorize: {
+			handles: []authRole{authNone}, decrypt: true, fn: (*Simulator).policyAuthorize},
+		tpm2.CommandPolicyAuthValue: {
+			handles: []authRole{authNone}, fn: (*Simulator).policyAuthValue},
+		tpm2.CommandPolicyCommandCode: {
+			handles: []authRole{authNone}, fn: (*Simulator).policyCommandCode},
+		tpm2.CommandPolicyCounterTimer: {
+			handles: []authRole{authNone}, decrypt: true, fn: (*Simulator).policyCounterTimer},
+		tpm2.CommandPolicyCpHash: {
+			handles: []authRole{authNone}, decrypt: true, fn: (*Simulator).policyCpHash},
+		tpm2.CommandPolicyLocality: {
+			handles: []authRole{authNone}, fn: (*Simulator).policyLocality},
+		tpm2.CommandPolicyNameHash: {
+			handles: []authRole{authNone}, decrypt: true, fn: (*Simulator).policyNameHash},
+		tpm2.CommandPolicyOR: {
+			handles: []authRole{authNone}, fn: (*Simulator).policyOR},
+		tpm2.CommandPolicyTicket: {
+			handles: []authRole{authNone}, decrypt: true, fn: (*Simulator).policyTicket},
+		tpm2.CommandReadPublic: {
+			handles: []authRole{authNone}, encrypt: true, fn: (*Simulator).readPublic},
+		tpm2.CommandRSAEncrypt: {
+			handles: []authRole{authNone}, decrypt: true, encrypt: true, fn: (*Simulator).rsaEncrypt},
+		tpm2.CommandStartAuthSession: {
+			handles: []authRole{authNone, authNone}, rHandle: true, decrypt: true, encrypt: true, fn: (*Simulator).startAuthSession},
+		tpm2.CommandVerifySignature: {
+			handles: []authRole{authNone}, decrypt: true, fn: (*Simulator).verifySignature},
+		tpm2.CommandGetCapability: {
+			fn: (*Simulator).getCapability},
+		tpm2.CommandGetRandom: {
+			encrypt: true, fn: (*Simulator).getRandom},
+		tpm2.CommandGetTestResult: {
+			fn: (*Simulator).getTestResult},
+		tpm2.CommandHash: {
+			decrypt: true, encrypt: true, fn: (*Simulator).hash},
+		tpm2.CommandPCRRead: {
+			fn: (*Simulator).pcrRead},
+		tpm2.CommandPolicyPCR: {
+			handles: []authRole{authNone}, decrypt: true, fn: (*Simulator).policyPCR},
+		tpm2.CommandPolicyRestart: {
+			handles: []authRole{authNone}, fn: (*Simulator).policyRestart},
+		tpm2.CommandReadClock: {
+			fn: (*Simulator).readClock},
+		tpm2.CommandPCRExtend: {
+			handles: []authRole{authUser}, attrs: tpm2.AttrNV, fn: (*Simulator).pcrExtend},
+		tpm2.CommandEventSequenceComplete: {
+			handles: []authRole{authUser, authUser}, decrypt: true, attrs: tpm2.AttrNV | tpm2.AttrFlushed,
+			fn: (*Simulator).eventSequenceComplete},
+		tpm2.CommandHashSequenceStart: {
+			rHandle: true, decrypt: true, fn: (*Simulator).hashSequenceStart},
+		tpm2.CommandPolicyDuplicationSelect: {
+			handles: []authRole{authNone}, decrypt: true, fn: (*Simulator).policyDuplicationSelect},
+		tpm2.CommandPolicyGetDigest: {
+			handles: []authRole{authNone}, encrypt: true, fn: (*Simulator).policyGetDigest},
+		tpm2.CommandNVCertify: {
+			handles: []authRole{authUser, authUser, authNone}, decrypt: true, encrypt: true, fn: (*Simulator).nvCertify},
+		tpm2.CommandTestParms: {
+			fn: (*Simulator).testParms},
+		tpm2.CommandPolicyPassword: {
+			handles: []authRole{authNone}, fn: (*Simulator).policyPassword},
+		tpm2.CommandPolicyNvWritten: {
+			handles: []authRole{authNone}, fn: (*Simulator).policyNvWritten},
+		tpm2.CommandEncryptDecrypt2: {
+			handles: []authRole{authUser}, decrypt: true, encrypt: true, fn: (*Simulator).encryptDecrypt2},
+	}
+}
+
+// sortedCommandCodes returns the codes of the commands implemented by the simulator in ascending order.
+func sortedCommandCodes() []tpm2.CommandCode {
+	var codes []tpm2.CommandCode
+	for code := range commands {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	return codes
+}
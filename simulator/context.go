@@ -0,0 +1,271 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/internal"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+const persistentPlatformFirst tpm2.Handle = 0x81800000 // The first persistent handle in the range reserved for the platform
+
+// objectContext is the serialized form of a saved object context.
+type objectContext struct {
+	Public        publicSized
+	Sensitive     sensitiveSized
+	QualifiedName tpm2.Name
+	External      bool
+}
+
+// contextKeys returns the keys used to protect a saved context with the specified sequence number and hierarchy.
+func (s *Simulator) contextKeys(sequence uint64, hierarchy tpm2.Handle) (symKey, iv, hmacKey []byte) {
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], sequence)
+
+	secret := append(append([]byte(nil), s.contextKey...), s.hierarchyProof(hierarchy)...)
+	k := internal.KDFa(crypto.SHA256, secret, []byte("CONTEXT"), seq[:], nil, (32+aes.BlockSize)*8)
+	hmacKey = internal.KDFa(crypto.SHA256, secret, []byte("INTEGRITY"), nil, nil, sha256.Size*8)
+	return k[:32], k[32:], hmacKey
+}
+
+// contextIntegrity computes the integrity value for a saved context.
+func contextIntegrity(key []byte, context *tpm2.Context, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	mu.MarshalToWriter(h, context.Sequence, context.SavedHandle, context.Hierarchy)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// protectContext encrypts the supplied context data if required, and sets the blob of the supplied context.
+func (s *Simulator) protectContext(context *tpm2.Context, data []byte, encrypt bool) error {
+	symKey, iv, hmacKey := s.contextKeys(context.Sequence, context.Hierarchy)
+	if encrypt {
+		if err := internal.EncryptSymmetricAES(symKey, internal.SymmetricModeCFB, data, iv); err != nil {
+			return err
+		}
+	}
+	blob, err := mu.MarshalToBytes(contextIntegrity(hmacKey, context, data), mu.RawBytes(data))
+	if err != nil {
+		return err
+	}
+	context.Blob = blob
+	return nil
+}
+
+// unprotectContext checks the integrity of the supplied context, and returns the decrypted context data.
+func (s *Simulator) unprotectContext(context *tpm2.Context, decrypt bool) ([]byte, tpm2.ResponseCode) {
+	var integrity []byte
+	n, err := mu.UnmarshalFromBytes(context.Blob, &integrity)
+	if err != nil {
+		return nil, paramRC(tpm2.ErrorSize, 1)
+	}
+	data := append([]byte(nil), context.Blob[n:]...)
+
+	symKey, iv, hmacKey := s.contextKeys(context.Sequence, context.Hierarchy)
+	if !hmac.Equal(integrity, contextIntegrity(hmacKey, context, data)) {
+		return nil, paramRC(tpm2.ErrorIntegrity, 1)
+	}
+	if decrypt {
+		if err := internal.DecryptSymmetricAES(symKey, internal.SymmetricModeCFB, data, iv); err != nil {
+			return nil, errorRC(tpm2.ErrorFailure)
+		}
+	}
+	return data, tpm2.Success
+}
+
+func (s *Simulator) contextSave(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+
+	e := c.entities[0]
+	context := &tpm2.Context{Sequence: s.nextContextID}
+
+	switch c.handles[0].Type() {
+	case tpm2.HandleTypeTransient:
+		o := e.object
+		if o.sequence != nil {
+			// The state of a sequence object isn't serialized, so saving it isn't supported.
+			return errorRC(tpm2.ErrorSequence)
+		}
+		context.SavedHandle = tpm2.HandleTypeTransient.BaseHandle()
+		context.Hierarchy = o.hierarchy
+		data, err := mu.MarshalToBytes(&objectContext{
+			Public:        publicSized{o.public},
+			Sensitive:     sensitiveSized{o.sensitive},
+			QualifiedName: o.qualifiedName,
+			External:      o.external})
+		if err != nil {
+			return errorRC(tpm2.ErrorFailure)
+		}
+		if err := s.protectContext(context, data, true); err != nil {
+			return errorRC(tpm2.ErrorFailure)
+		}
+	case tpm2.HandleTypeHMACSession, tpm2.HandleTypePolicySession:
+		session := e.session
+		context.SavedHandle = session.handle
+		context.Hierarchy = tpm2.HandleNull
+		// The session state remains in the simulator, and the context only identifies it.
+		if err := s.protectContext(context, nil, false); err != nil {
+			return errorRC(tpm2.ErrorFailure)
+		}
+		session.loaded = false
+		session.contextID = context.Sequence
+	default:
+		return handleRC(tpm2.ErrorValue, 1)
+	}
+
+	s.nextContextID++
+	return c.respond(context)
+}
+
+func (s *Simulator) contextLoad(c *commandContext) tpm2.ResponseCode {
+	var context tpm2.Context
+	if rc := c.unmarshalParams(&context); rc != tpm2.Success {
+		return rc
+	}
+
+	switch context.SavedHandle.Type() {
+	case tpm2.HandleTypeTransient:
+		switch context.Hierarchy {
+		case tpm2.HandleOwner, tpm2.HandleEndorsement, tpm2.HandlePlatform, tpm2.HandleNull:
+		default:
+			return paramRC(tpm2.ErrorValue, 1)
+		}
+		if !s.isHierarchyEnabled(context.Hierarchy) {
+			return paramRC(tpm2.ErrorHierarchy, 1)
+		}
+		handle, rc := s.availableObjectHandle()
+		if rc != tpm2.Success {
+			return rc
+		}
+		data, rc := s.unprotectContext(&context, true)
+		if rc != tpm2.Success {
+			return rc
+		}
+		var oc objectContext
+		if _, err := mu.UnmarshalFromBytes(data, &oc); err != nil || oc.Public.Ptr == nil {
+			return paramRC(tpm2.ErrorIntegrity, 1)
+		}
+		name, err := oc.Public.Ptr.Name()
+		if err != nil {
+			return paramRC(tpm2.ErrorIntegrity, 1)
+		}
+		s.objects[handle] = &object{
+			public:        oc.Public.Ptr,
+			sensitive:     oc.Sensitive.Ptr,
+			name:          name,
+			qualifiedName: oc.QualifiedName,
+			hierarchy:     context.Hierarchy,
+			external:      oc.External}
+		c.rHandle = handle
+	case tpm2.HandleTypeHMACSession, tpm2.HandleTypePolicySession:
+		session, ok := s.sessions[context.SavedHandle]
+		if !ok || session.loaded || session.contextID != context.Sequence {
+			return paramRC(tpm2.ErrorHandle, 1)
+		}
+		if _, rc := s.unprotectContext(&context, false); rc != tpm2.Success {
+			return rc
+		}
+		loaded := 0
+		for _, session := range s.sessions {
+			if session.loaded {
+				loaded++
+			}
+		}
+		if loaded >= maxLoadedSessions {
+			return warningRC(tpm2.WarningSessionMemory)
+		}
+		session.loaded = true
+		c.rHandle = session.handle
+	default:
+		return paramRC(tpm2.ErrorHandle, 1)
+	}
+
+	return tpm2.Success
+}
+
+func (s *Simulator) flushContext(c *commandContext) tpm2.ResponseCode {
+	var flushHandle tpm2.Handle
+	if rc := c.unmarshalParams(&flushHandle); rc != tpm2.Success {
+		return rc
+	}
+
+	switch flushHandle.Type() {
+	case tpm2.HandleTypeTransient:
+		if _, ok := s.objects[flushHandle]; !ok {
+			return paramRC(tpm2.ErrorHandle, 1)
+		}
+		delete(s.objects, flushHandle)
+	case tpm2.HandleTypeHMACSession, tpm2.HandleTypePolicySession:
+		if _, ok := s.sessions[flushHandle]; !ok {
+			return paramRC(tpm2.ErrorHandle, 1)
+		}
+		delete(s.sessions, flushHandle)
+	default:
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+	return tpm2.Success
+}
+
+func (s *Simulator) evictControl(c *commandContext) tpm2.ResponseCode {
+	var persistentHandle tpm2.Handle
+	if rc := c.unmarshalParams(&persistentHandle); rc != tpm2.Success {
+		return rc
+	}
+
+	auth, rc := c.authHierarchy(0, tpm2.HandleOwner, tpm2.HandlePlatform)
+	if rc != tpm2.Success {
+		return rc
+	}
+	o, rc := c.object(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	if persistentHandle.Type() != tpm2.HandleTypePersistent {
+		return paramRC(tpm2.ErrorRange, 1)
+	}
+	inPlatformRange := persistentHandle >= persistentPlatformFirst
+	if (auth.handle == tpm2.HandleOwner) == inPlatformRange {
+		return paramRC(tpm2.ErrorRange, 1)
+	}
+
+	if c.handles[1].Type() == tpm2.HandleTypePersistent {
+		if persistentHandle != c.handles[1] {
+			return paramRC(tpm2.ErrorHandle, 1)
+		}
+		delete(s.persistent, persistentHandle)
+		return tpm2.Success
+	}
+
+	switch {
+	case o.external:
+		return handleRC(tpm2.ErrorAttributes, 2)
+	case o.public.Attrs&tpm2.AttrStClear != 0:
+		return handleRC(tpm2.ErrorAttributes, 2)
+	case o.hierarchy == tpm2.HandleNull:
+		return handleRC(tpm2.ErrorHierarchy, 2)
+	case auth.handle == tpm2.HandleOwner && o.hierarchy == tpm2.HandlePlatform:
+		return handleRC(tpm2.ErrorHierarchy, 2)
+	}
+	if _, exists := s.persistent[persistentHandle]; exists {
+		return errorRC(tpm2.ErrorNVDefined)
+	}
+	if len(s.persistent) >= maxPersistent {
+		return errorRC(tpm2.ErrorNVSpace)
+	}
+
+	persistent := *o
+	s.persistent[persistentHandle] = &persistent
+	return tpm2.Success
+}
@@ -0,0 +1,374 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"crypto/aes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/internal"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+func rsaPublicKey(public *tpm2.Public) *rsa.PublicKey {
+	exp := int(public.Params.RSADetail().Exponent)
+	if exp == 0 {
+		exp = tpm2.DefaultRSAExponent
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(public.Unique.RSA()), E: exp}
+}
+
+func rsaPrivateKey(public *tpm2.Public, sensitive *tpm2.Sensitive) *rsa.PrivateKey {
+	pub := rsaPublicKey(public)
+	p := new(big.Int).SetBytes(sensitive.Sensitive.RSA())
+	if p.Sign() <= 0 {
+		return nil
+	}
+	q, r := new(big.Int).QuoRem(pub.N, p, new(big.Int))
+	if r.Sign() != 0 {
+		return nil
+	}
+	one := big.NewInt(1)
+	phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+	d := new(big.Int).ModInverse(big.NewInt(int64(pub.E)), phi)
+	if d == nil {
+		return nil
+	}
+	key := &rsa.PrivateKey{PublicKey: *pub, D: d, Primes: []*big.Int{p, q}}
+	if err := key.Validate(); err != nil {
+		return nil
+	}
+	key.Precompute()
+	return key
+}
+
+func eccPublicKey(public *tpm2.Public) *ecdsa.PublicKey {
+	curve := public.Params.ECCDetail().CurveID.GoCurve()
+	if curve == nil {
+		return nil
+	}
+	x := new(big.Int).SetBytes(public.Unique.ECC().X)
+	y := new(big.Int).SetBytes(public.Unique.ECC().Y)
+	if !curve.IsOnCurve(x, y) {
+		return nil
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+}
+
+func eccPrivateKey(public *tpm2.Public, sensitive *tpm2.Sensitive) *ecdsa.PrivateKey {
+	pub := eccPublicKey(public)
+	if pub == nil {
+		return nil
+	}
+	d := new(big.Int).SetBytes(sensitive.Sensitive.ECC())
+	x, y := pub.Curve.ScalarBaseMult(d.Bytes())
+	if x.Cmp(pub.X) != 0 || y.Cmp(pub.Y) != 0 {
+		return nil
+	}
+	return &ecdsa.PrivateKey{PublicKey: *pub, D: d}
+}
+
+// checkPublicKey checks that the public key of an externally supplied public area is valid.
+func checkPublicKey(public *tpm2.Public) bool {
+	switch public.Type {
+	case tpm2.ObjectTypeRSA:
+		return len(public.Unique.RSA())*8 == int(public.Params.RSADetail().KeyBits)
+	case tpm2.ObjectTypeECC:
+		return eccPublicKey(public) != nil
+	default:
+		return true
+	}
+}
+
+// outerWrap encrypts the supplied data with a key derived from the supplied seed and adds an integrity HMAC, producing an outer
+// wrapper as described in part 1 of the TPM 2.0 Library specification. The name is used to bind the result to an object.
+func outerWrap(hashAlg tpm2.HashAlgorithmId, sym *tpm2.SymDefObject, seed []byte, name tpm2.Name, data []byte) ([]byte, error) {
+	data = append([]byte(nil), data...)
+	symKey := internal.KDFa(hashAlg.GetHash(), seed, []byte("STORAGE"), name, nil, int(sym.KeyBits.Sym()))
+	if err := internal.EncryptSymmetricAES(symKey, internal.SymmetricModeCFB, data, make([]byte, aes.BlockSize)); err != nil {
+		return nil, err
+	}
+
+	hmacKey := internal.KDFa(hashAlg.GetHash(), seed, []byte("INTEGRITY"), nil, nil, hashAlg.Size()*8)
+	h := hmac.New(hashAlg.NewHash, hmacKey)
+	h.Write(data)
+	h.Write(name)
+
+	return mu.MarshalToBytes(h.Sum(nil), mu.RawBytes(data))
+}
+
+// outerUnwrap performs the reverse of outerWrap.
+func outerUnwrap(hashAlg tpm2.HashAlgorithmId, sym *tpm2.SymDefObject, seed []byte, name tpm2.Name, blob []byte) ([]byte, tpm2.ErrorCode) {
+	var integrity []byte
+	n, err := mu.UnmarshalFromBytes(blob, &integrity)
+	if err != nil {
+		return nil, tpm2.ErrorSize
+	}
+	data := append([]byte(nil), blob[n:]...)
+
+	if len(integrity) != hashAlg.Size() {
+		return nil, tpm2.ErrorSize
+	}
+	hmacKey := internal.KDFa(hashAlg.GetHash(), seed, []byte("INTEGRITY"), nil, nil, hashAlg.Size()*8)
+	h := hmac.New(hashAlg.NewHash, hmacKey)
+	h.Write(data)
+	h.Write(name)
+	if !hmac.Equal(h.Sum(nil), integrity) {
+		return nil, tpm2.ErrorIntegrity
+	}
+
+	symKey := internal.KDFa(hashAlg.GetHash(), seed, []byte("STORAGE"), name, nil, int(sym.KeyBits.Sym()))
+	if err := internal.DecryptSymmetricAES(symKey, internal.SymmetricModeCFB, data, make([]byte, aes.BlockSize)); err != nil {
+		return nil, tpm2.ErrorFailure
+	}
+	return data, 0
+}
+
+// wrapSensitive protects the sensitive area of a child object with the supplied parent, producing a TPM2B_PRIVATE structure as
+// described in part 1 of the TPM 2.0 Library specification.
+func wrapSensitive(parent *object, name tpm2.Name, sensitive *tpm2.Sensitive) (tpm2.Private, error) {
+	data, err := mu.MarshalToBytes(sensitiveSized{sensitive})
+	if err != nil {
+		return nil, err
+	}
+	return outerWrap(parent.public.NameAlg, parent.parentSymmetric(), parent.sensitive.SeedValue, name, data)
+}
+
+// unwrapSensitive performs the reverse of wrapSensitive.
+func unwrapSensitive(parent *object, name tpm2.Name, private tpm2.Private) (*tpm2.Sensitive, tpm2.ErrorCode) {
+	data, code := outerUnwrap(parent.public.NameAlg, parent.parentSymmetric(), parent.sensitive.SeedValue, name, private)
+	if code != 0 {
+		return nil, code
+	}
+
+	var sensitive sensitiveSized
+	if _, err := mu.UnmarshalFromBytes(data, &sensitive); err != nil || sensitive.Ptr == nil {
+		return nil, tpm2.ErrorSensitive
+	}
+	return sensitive.Ptr, 0
+}
+
+// decryptSecret recovers a seed that was encrypted to the supplied object for the specified purpose, as described in part 1 of the
+// TPM 2.0 Library specification.
+func decryptSecret(o *object, secret tpm2.EncryptedSecret, label string) ([]byte, tpm2.ErrorCode) {
+	if o.sensitive == nil || o.public.Attrs&tpm2.AttrDecrypt == 0 {
+		return nil, tpm2.ErrorKey
+	}
+
+	switch o.public.Type {
+	case tpm2.ObjectTypeRSA:
+		key := rsaPrivateKey(o.public, o.sensitive)
+		if key == nil {
+			return nil, tpm2.ErrorKey
+		}
+		seed, err := rsa.DecryptOAEP(o.public.NameAlg.NewHash(), nil, key, secret, append([]byte(label), 0))
+		if err != nil {
+			return nil, tpm2.ErrorValue
+		}
+		if len(seed) > o.public.NameAlg.Size() {
+			return nil, tpm2.ErrorValue
+		}
+		return seed, 0
+	case tpm2.ObjectTypeECC:
+		key := eccPrivateKey(o.public, o.sensitive)
+		if key == nil {
+			return nil, tpm2.ErrorKey
+		}
+		var point tpm2.ECCPoint
+		if _, err := mu.UnmarshalFromBytes(secret, &point); err != nil {
+			return nil, tpm2.ErrorSize
+		}
+		x := new(big.Int).SetBytes(point.X)
+		y := new(big.Int).SetBytes(point.Y)
+		if !key.Curve.IsOnCurve(x, y) {
+			return nil, tpm2.ErrorECCPoint
+		}
+		z, _ := key.Curve.ScalarMult(x, y, key.D.Bytes())
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return internal.KDFe(o.public.NameAlg.GetHash(), padBytes(z.Bytes(), size), []byte(label), point.X, o.public.Unique.ECC().X,
+			o.public.NameAlg.Size()*8), 0
+	default:
+		return nil, tpm2.ErrorKey
+	}
+}
+
+// encryptSecret generates a seed and encrypts it to the supplied object for the specified purpose, as described in part 1 of the
+// TPM 2.0 Library specification. It is the reverse of decryptSecret.
+func encryptSecret(o *object, label string) ([]byte, tpm2.EncryptedSecret, tpm2.ErrorCode) {
+	nameAlg := o.public.NameAlg
+
+	switch o.public.Type {
+	case tpm2.ObjectTypeRSA:
+		seed := random(nameAlg.Size())
+		secret, err := rsa.EncryptOAEP(nameAlg.NewHash(), rand.Reader, rsaPublicKey(o.public), seed, append([]byte(label), 0))
+		if err != nil {
+			return nil, nil, tpm2.ErrorKey
+		}
+		return seed, secret, 0
+	case tpm2.ObjectTypeECC:
+		pub := eccPublicKey(o.public)
+		if pub == nil {
+			return nil, nil, tpm2.ErrorKey
+		}
+		ephemeral, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+		if err != nil {
+			return nil, nil, tpm2.ErrorFailure
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		point := tpm2.ECCPoint{X: padBytes(ephemeral.X.Bytes(), size), Y: padBytes(ephemeral.Y.Bytes(), size)}
+		secret, err := mu.MarshalToBytes(point)
+		if err != nil {
+			return nil, nil, tpm2.ErrorFailure
+		}
+		z, _ := pub.Curve.ScalarMult(pub.X, pub.Y, ephemeral.D.Bytes())
+		seed := internal.KDFe(nameAlg.GetHash(), padBytes(z.Bytes(), size), []byte(label), point.X, o.public.Unique.ECC().X,
+			nameAlg.Size()*8)
+		return seed, secret, 0
+	default:
+		return nil, nil, tpm2.ErrorKey
+	}
+}
+
+// signingScheme returns the scheme that should be used for signing with the supplied key, taking in to account the scheme
+// requested by the caller.
+func signingScheme(o *object, in *tpm2.SigScheme) (*tpm2.SigScheme, tpm2.ErrorCode) {
+	var keyScheme tpm2.SigScheme
+	switch o.public.Type {
+	case tpm2.ObjectTypeRSA, tpm2.ObjectTypeECC:
+		scheme := &o.public.Params.AsymDetail().Scheme
+		keyScheme.Scheme = tpm2.SigSchemeId(scheme.Scheme)
+		if scheme.Scheme != tpm2.AsymSchemeNull {
+			keyScheme.Details.Data = &tpm2.SchemeHash{HashAlg: scheme.Details.Any().HashAlg}
+		}
+	case tpm2.ObjectTypeKeyedHash:
+		scheme := &o.public.Params.KeyedHashDetail().Scheme
+		if scheme.Scheme == tpm2.KeyedHashSchemeHMAC {
+			keyScheme.Scheme = tpm2.SigSchemeAlgHMAC
+			keyScheme.Details.Data = &tpm2.SchemeHash{HashAlg: scheme.Details.HMAC().HashAlg}
+		} else {
+			keyScheme.Scheme = tpm2.SigSchemeAlgNull
+		}
+	default:
+		return nil, tpm2.ErrorKey
+	}
+
+	switch {
+	case keyScheme.Scheme == tpm2.SigSchemeAlgNull:
+		if in.Scheme == tpm2.SigSchemeAlgNull {
+			return nil, tpm2.ErrorScheme
+		}
+		keyScheme = *in
+	case in.Scheme != tpm2.SigSchemeAlgNull:
+		if in.Scheme != keyScheme.Scheme || in.Details.Any().HashAlg != keyScheme.Details.Any().HashAlg {
+			return nil, tpm2.ErrorScheme
+		}
+	}
+
+	switch {
+	case o.public.Type == tpm2.ObjectTypeRSA && (keyScheme.Scheme == tpm2.SigSchemeAlgRSASSA || keyScheme.Scheme == tpm2.SigSchemeAlgRSAPSS):
+	case o.public.Type == tpm2.ObjectTypeECC && keyScheme.Scheme == tpm2.SigSchemeAlgECDSA:
+	case o.public.Type == tpm2.ObjectTypeKeyedHash && keyScheme.Scheme == tpm2.SigSchemeAlgHMAC:
+	default:
+		return nil, tpm2.ErrorScheme
+	}
+	if !isSupportedHashAlg(keyScheme.Details.Any().HashAlg) {
+		return nil, tpm2.ErrorScheme
+	}
+	return &keyScheme, 0
+}
+
+// signDigest signs the supplied digest with the supplied key and scheme.
+func signDigest(o *object, scheme *tpm2.SigScheme, digest []byte) (*tpm2.Signature, tpm2.ErrorCode) {
+	hashAlg := scheme.Details.Any().HashAlg
+	sig := &tpm2.Signature{SigAlg: scheme.Scheme}
+
+	switch scheme.Scheme {
+	case tpm2.SigSchemeAlgRSASSA, tpm2.SigSchemeAlgRSAPSS:
+		key := rsaPrivateKey(o.public, o.sensitive)
+		if key == nil {
+			return nil, tpm2.ErrorKey
+		}
+		var s []byte
+		var err error
+		if scheme.Scheme == tpm2.SigSchemeAlgRSASSA {
+			s, err = rsa.SignPKCS1v15(rand.Reader, key, hashAlg.GetHash(), digest)
+			sig.Signature.Data = &tpm2.SignatureRSASSA{Hash: hashAlg, Sig: s}
+		} else {
+			s, err = rsa.SignPSS(rand.Reader, key, hashAlg.GetHash(), digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			sig.Signature.Data = &tpm2.SignatureRSAPSS{Hash: hashAlg, Sig: s}
+		}
+		if err != nil {
+			return nil, tpm2.ErrorValue
+		}
+	case tpm2.SigSchemeAlgECDSA:
+		key := eccPrivateKey(o.public, o.sensitive)
+		if key == nil {
+			return nil, tpm2.ErrorKey
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			return nil, tpm2.ErrorValue
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		sig.Signature.Data = &tpm2.SignatureECDSA{Hash: hashAlg, SignatureR: padBytes(r.Bytes(), size), SignatureS: padBytes(s.Bytes(), size)}
+	case tpm2.SigSchemeAlgHMAC:
+		h := hmac.New(hashAlg.NewHash, o.sensitive.Sensitive.Bits())
+		h.Write(digest)
+		sig.Signature.Data = &tpm2.TaggedHash{HashAlg: hashAlg, Digest: h.Sum(nil)}
+	default:
+		return nil, tpm2.ErrorScheme
+	}
+
+	return sig, 0
+}
+
+// verifyDigest verifies the supplied signature of the supplied digest with the supplied key.
+func verifyDigest(o *object, digest []byte, sig *tpm2.Signature) bool {
+	switch sig.SigAlg {
+	case tpm2.SigSchemeAlgRSASSA, tpm2.SigSchemeAlgRSAPSS:
+		if o.public.Type != tpm2.ObjectTypeRSA {
+			return false
+		}
+		var s *tpm2.SignatureRSA
+		if sig.SigAlg == tpm2.SigSchemeAlgRSASSA {
+			s = (*tpm2.SignatureRSA)(sig.Signature.RSASSA())
+		} else {
+			s = (*tpm2.SignatureRSA)(sig.Signature.RSAPSS())
+		}
+		if !isSupportedHashAlg(s.Hash) {
+			return false
+		}
+		key := rsaPublicKey(o.public)
+		if sig.SigAlg == tpm2.SigSchemeAlgRSASSA {
+			return rsa.VerifyPKCS1v15(key, s.Hash.GetHash(), digest, s.Sig) == nil
+		}
+		return rsa.VerifyPSS(key, s.Hash.GetHash(), digest, s.Sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}) == nil
+	case tpm2.SigSchemeAlgECDSA:
+		if o.public.Type != tpm2.ObjectTypeECC {
+			return false
+		}
+		key := eccPublicKey(o.public)
+		if key == nil {
+			return false
+		}
+		s := sig.Signature.ECDSA()
+		return ecdsa.Verify(key, digest, new(big.Int).SetBytes(s.SignatureR), new(big.Int).SetBytes(s.SignatureS))
+	case tpm2.SigSchemeAlgHMAC:
+		if o.public.Type != tpm2.ObjectTypeKeyedHash || o.sensitive == nil {
+			return false
+		}
+		s := sig.Signature.HMAC()
+		h := hmac.New(s.HashAlg.NewHash, o.sensitive.Sensitive.Bits())
+		h.Write(digest)
+		return hmac.Equal(h.Sum(nil), s.Digest)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"github.com/canonical/go-tpm2"
+)
+
+// daState contains the state associated with the dictionary attack protection logic.
+type daState struct {
+	failedTries     uint32
+	maxTries        uint32
+	recoveryTime    uint32 // Seconds
+	lockoutRecovery uint32 // Seconds
+	lastFailure     uint64 // Clock value at which failedTries was last incremented or decremented
+
+	lockoutAuthLocked  bool
+	lockoutAuthFailure uint64 // Clock value of the last failed authorization of the lockout hierarchy
+}
+
+// updateDA performs dictionary attack recovery based on the time elapsed since the last failure.
+func (s *Simulator) updateDA() {
+	now := s.clockValueLocked()
+
+	if s.da.failedTries > 0 && s.da.recoveryTime > 0 {
+		interval := uint64(s.da.recoveryTime) * 1000
+		n := (now - s.da.lastFailure) / interval
+		if n >= uint64(s.da.failedTries) {
+			s.da.failedTries = 0
+		} else {
+			s.da.failedTries -= uint32(n)
+		}
+		s.da.lastFailure += n * interval
+	}
+
+	if s.da.lockoutAuthLocked && s.da.lockoutRecovery > 0 && now-s.da.lockoutAuthFailure >= uint64(s.da.lockoutRecovery)*1000 {
+		s.da.lockoutAuthLocked = false
+	}
+}
+
+// isInLockout indicates whether DA protected entities are currently unavailable for authorization.
+func (s *Simulator) isInLockout() bool {
+	s.updateDA()
+	return s.da.maxTries == 0 || s.da.failedTries >= s.da.maxTries
+}
+
+// checkAuthValue checks an authorization that depends on the authorization value of the specified entity, using the supplied
+// callback, and applies dictionary attack protection. The index of the associated session is n.
+func (s *Simulator) checkAuthValue(e *entity, n int, check func() bool) tpm2.ResponseCode {
+	switch {
+	case e.handle == tpm2.HandleLockout:
+		s.updateDA()
+		if s.da.lockoutAuthLocked {
+			return warningRC(tpm2.WarningLockout)
+		}
+		if !check() {
+			s.da.lockoutAuthLocked = true
+			s.da.lockoutAuthFailure = s.clockValueLocked()
+			return sessionRC(tpm2.ErrorAuthFail, n)
+		}
+	case e.isDAProtected():
+		if s.isInLockout() {
+			return warningRC(tpm2.WarningLockout)
+		}
+		if !check() {
+			if s.da.failedTries == 0 {
+				s.da.lastFailure = s.clockValueLocked()
+			}
+			s.da.failedTries++
+			return sessionRC(tpm2.ErrorAuthFail, n)
+		}
+	default:
+		if !check() {
+			return sessionRC(tpm2.ErrorBadAuth, n)
+		}
+	}
+	return tpm2.Success
+}
+
+func (s *Simulator) dictionaryAttackLockReset(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	s.da.failedTries = 0
+	return tpm2.Success
+}
+
+func (s *Simulator) dictionaryAttackParameters(c *commandContext) tpm2.ResponseCode {
+	var newMaxTries, newRecoveryTime, lockoutRecovery uint32
+	if rc := c.unmarshalParams(&newMaxTries, &newRecoveryTime, &lockoutRecovery); rc != tpm2.Success {
+		return rc
+	}
+	s.da.maxTries = newMaxTries
+	s.da.recoveryTime = newRecoveryTime
+	s.da.lockoutRecovery = lockoutRecovery
+	s.da.failedTries = 0
+	return tpm2.Success
+}
@@ -0,0 +1,292 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"bytes"
+	"crypto/aes"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/internal"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+// checkInnerSymmetric checks that the supplied algorithm can be used for the inner wrapper of a duplicated object.
+func checkInnerSymmetric(sym *tpm2.SymDefObject) tpm2.ErrorCode {
+	if code := checkSymDefObject(sym); code != 0 {
+		return code
+	}
+	if sym.Algorithm != tpm2.SymObjectAlgorithmNull && sym.Mode.Sym() != tpm2.SymModeCFB {
+		return tpm2.ErrorMode
+	}
+	return 0
+}
+
+// innerWrap adds the inner wrapper to the supplied marshalled sensitive area, as described in part 1 of the TPM 2.0 Library
+// specification.
+func innerWrap(hashAlg tpm2.HashAlgorithmId, key []byte, name tpm2.Name, data []byte) ([]byte, error) {
+	h := hashAlg.NewHash()
+	h.Write(data)
+	h.Write(name)
+
+	blob, err := mu.MarshalToBytes(h.Sum(nil), mu.RawBytes(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := internal.EncryptSymmetricAES(key, internal.SymmetricModeCFB, blob, make([]byte, aes.BlockSize)); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// innerUnwrap performs the reverse of innerWrap.
+func innerUnwrap(hashAlg tpm2.HashAlgorithmId, key []byte, name tpm2.Name, blob []byte) ([]byte, tpm2.ErrorCode) {
+	blob = append([]byte(nil), blob...)
+	if err := internal.DecryptSymmetricAES(key, internal.SymmetricModeCFB, blob, make([]byte, aes.BlockSize)); err != nil {
+		return nil, tpm2.ErrorFailure
+	}
+
+	var integrity []byte
+	n, err := mu.UnmarshalFromBytes(blob, &integrity)
+	if err != nil {
+		return nil, tpm2.ErrorSize
+	}
+	data := blob[n:]
+
+	h := hashAlg.NewHash()
+	h.Write(data)
+	h.Write(name)
+	if !bytes.Equal(h.Sum(nil), integrity) {
+		return nil, tpm2.ErrorIntegrity
+	}
+	return data, 0
+}
+
+func (s *Simulator) duplicate(c *commandContext) tpm2.ResponseCode {
+	var encryptionKeyIn tpm2.Data
+	var symmetricAlg tpm2.SymDefObject
+	if rc := c.unmarshalParams(&encryptionKeyIn, &symmetricAlg); rc != tpm2.Success {
+		return rc
+	}
+
+	o, rc := c.object(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if o.sensitive == nil {
+		return handleRC(tpm2.ErrorType, 1)
+	}
+	if o.public.Attrs&tpm2.AttrFixedParent != 0 {
+		return handleRC(tpm2.ErrorAttributes, 1)
+	}
+
+	var newParent *object
+	if c.handles[1] != tpm2.HandleNull {
+		// The new parent doesn't need a sensitive area, as only its public key is used.
+		newParent, rc = c.object(1)
+		if rc != tpm2.Success {
+			return rc
+		}
+		if !isStorageKey(newParent.public) {
+			return handleRC(tpm2.ErrorType, 2)
+		}
+	}
+
+	if code := checkInnerSymmetric(&symmetricAlg); code != 0 {
+		return paramRC(code, 2)
+	}
+	if o.public.Attrs&tpm2.AttrEncryptedDuplication != 0 {
+		if symmetricAlg.Algorithm == tpm2.SymObjectAlgorithmNull {
+			return paramRC(tpm2.ErrorSymmetric, 2)
+		}
+		if newParent == nil {
+			return handleRC(tpm2.ErrorHierarchy, 2)
+		}
+	}
+
+	// The authorization value is padded to the size of the name algorithm so that its length isn't leaked.
+	sensitive := *o.sensitive
+	sensitive.AuthValue = padBytes(nil, o.public.NameAlg.Size())
+	copy(sensitive.AuthValue, o.sensitive.AuthValue)
+
+	data, err := mu.MarshalToBytes(sensitiveSized{&sensitive})
+	if err != nil {
+		return errorRC(tpm2.ErrorFailure)
+	}
+
+	var encryptionKeyOut tpm2.Data
+	if symmetricAlg.Algorithm != tpm2.SymObjectAlgorithmNull {
+		key := []byte(encryptionKeyIn)
+		switch {
+		case len(key) == 0:
+			key = random(int(symmetricAlg.KeyBits.Sym()) / 8)
+			encryptionKeyOut = key
+		case len(key) != int(symmetricAlg.KeyBits.Sym())/8:
+			return paramRC(tpm2.ErrorSize, 1)
+		}
+		data, err = innerWrap(o.public.NameAlg, key, o.name, data)
+		if err != nil {
+			return errorRC(tpm2.ErrorFailure)
+		}
+	}
+
+	var outSymSeed tpm2.EncryptedSecret
+	if newParent != nil {
+		var seed []byte
+		var code tpm2.ErrorCode
+		seed, outSymSeed, code = encryptSecret(newParent, "DUPLICATE")
+		if code != 0 {
+			return handleRC(code, 2)
+		}
+		data, err = outerWrap(newParent.public.NameAlg, newParent.parentSymmetric(), seed, o.name, data)
+		if err != nil {
+			return errorRC(tpm2.ErrorFailure)
+		}
+	}
+
+	return c.respond(encryptionKeyOut, tpm2.Private(data), outSymSeed)
+}
+
+func (s *Simulator) rewrap(c *commandContext) tpm2.ResponseCode {
+	var inDuplicate tpm2.Private
+	var name tpm2.Name
+	var inSymSeed tpm2.EncryptedSecret
+	if rc := c.unmarshalParams(&inDuplicate, &name, &inSymSeed); rc != tpm2.Success {
+		return rc
+	}
+
+	var oldParent *object
+	if c.handles[0] != tpm2.HandleNull {
+		var rc tpm2.ResponseCode
+		oldParent, rc = c.storageParent(0)
+		if rc != tpm2.Success {
+			return rc
+		}
+	}
+	var newParent *object
+	if c.handles[1] != tpm2.HandleNull {
+		// The new parent doesn't need a sensitive area, as only its public key is used.
+		var rc tpm2.ResponseCode
+		newParent, rc = c.object(1)
+		if rc != tpm2.Success {
+			return rc
+		}
+		if !isStorageKey(newParent.public) {
+			return handleRC(tpm2.ErrorType, 2)
+		}
+	}
+	if name.Algorithm() == tpm2.HashAlgorithmNull {
+		return paramRC(tpm2.ErrorSize, 2)
+	}
+
+	data := []byte(inDuplicate)
+	if oldParent != nil {
+		seed, code := decryptSecret(oldParent, inSymSeed, "DUPLICATE")
+		if code != 0 {
+			return paramRC(code, 3)
+		}
+		data, code = outerUnwrap(oldParent.public.NameAlg, oldParent.parentSymmetric(), seed, name, data)
+		if code != 0 {
+			return paramRC(code, 1)
+		}
+	}
+
+	var outSymSeed tpm2.EncryptedSecret
+	if newParent != nil {
+		var seed []byte
+		var code tpm2.ErrorCode
+		seed, outSymSeed, code = encryptSecret(newParent, "DUPLICATE")
+		if code != 0 {
+			return handleRC(code, 2)
+		}
+		var err error
+		data, err = outerWrap(newParent.public.NameAlg, newParent.parentSymmetric(), seed, name, data)
+		if err != nil {
+			return errorRC(tpm2.ErrorFailure)
+		}
+	}
+
+	return c.respond(tpm2.Private(data), outSymSeed)
+}
+
+func (s *Simulator) importObject(c *commandContext) tpm2.ResponseCode {
+	var encryptionKey tpm2.Data
+	var objectPublic publicSized
+	var duplicate tpm2.Private
+	var inSymSeed tpm2.EncryptedSecret
+	var symmetricAlg tpm2.SymDefObject
+	if rc := c.unmarshalParams(&encryptionKey, &objectPublic, &duplicate, &inSymSeed, &symmetricAlg); rc != tpm2.Success {
+		return rc
+	}
+
+	parent, rc := c.storageParent(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if objectPublic.Ptr == nil {
+		return paramRC(tpm2.ErrorSize, 2)
+	}
+	public := objectPublic.Ptr
+	if public.Attrs&(tpm2.AttrFixedTPM|tpm2.AttrFixedParent) != 0 {
+		return paramRC(tpm2.ErrorAttributes, 2)
+	}
+	if code := checkPublic(public, parent.public.Attrs&tpm2.AttrFixedTPM != 0); code != 0 {
+		return paramRC(code, 2)
+	}
+	if code := checkInnerSymmetric(&symmetricAlg); code != 0 {
+		return paramRC(code, 5)
+	}
+	if public.Attrs&tpm2.AttrEncryptedDuplication != 0 {
+		if symmetricAlg.Algorithm == tpm2.SymObjectAlgorithmNull {
+			return paramRC(tpm2.ErrorAttributes, 5)
+		}
+		if len(inSymSeed) == 0 {
+			return paramRC(tpm2.ErrorAttributes, 4)
+		}
+	}
+
+	name, err := public.Name()
+	if err != nil {
+		return paramRC(tpm2.ErrorHash, 2)
+	}
+
+	data := []byte(duplicate)
+	if len(inSymSeed) > 0 {
+		seed, code := decryptSecret(parent, inSymSeed, "DUPLICATE")
+		if code != 0 {
+			return paramRC(code, 4)
+		}
+		data, code = outerUnwrap(parent.public.NameAlg, parent.parentSymmetric(), seed, name, data)
+		if code != 0 {
+			return paramRC(code, 3)
+		}
+	}
+
+	if symmetricAlg.Algorithm != tpm2.SymObjectAlgorithmNull {
+		if len(encryptionKey) != int(symmetricAlg.KeyBits.Sym())/8 {
+			return paramRC(tpm2.ErrorSize, 1)
+		}
+		var code tpm2.ErrorCode
+		data, code = innerUnwrap(public.NameAlg, encryptionKey, name, data)
+		if code != 0 {
+			return paramRC(code, 3)
+		}
+	}
+
+	var sensitive sensitiveSized
+	if _, err := mu.UnmarshalFromBytes(data, &sensitive); err != nil || sensitive.Ptr == nil {
+		return paramRC(tpm2.ErrorSensitive, 3)
+	}
+	sensitive.Ptr.AuthValue = trimAuthValue(sensitive.Ptr.AuthValue)
+	if !checkSensitive(public, sensitive.Ptr) {
+		return paramRC(tpm2.ErrorBinding, 3)
+	}
+
+	private, err := wrapSensitive(parent, name, sensitive.Ptr)
+	if err != nil {
+		return errorRC(tpm2.ErrorFailure)
+	}
+	return c.respond(tpm2.Private(private))
+}
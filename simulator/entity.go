@@ -0,0 +1,260 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/canonical/go-tpm2"
+)
+
+// hierarchy contains the state associated with a permanent handle that can be authorized.
+type hierarchy struct {
+	handle     tpm2.Handle
+	authValue  tpm2.Auth
+	authPolicy tpm2.Digest
+	policyAlg  tpm2.HashAlgorithmId
+	seed       []byte // The primary seed, for hierarchies that have one
+	proof      []byte // The secret value used for tickets and context protection
+}
+
+func newHierarchy(handle tpm2.Handle) *hierarchy {
+	h := &hierarchy{handle: handle, policyAlg: tpm2.HashAlgorithmNull}
+	h.changeSeed()
+	return h
+}
+
+// changeSeed generates a new primary seed and proof value for this hierarchy.
+func (h *hierarchy) changeSeed() {
+	h.seed = random(64)
+	h.proof = random(64)
+}
+
+// entity describes a resource referenced by a command handle.
+type entity struct {
+	handle    tpm2.Handle
+	object    *object
+	nv        *nvIndex
+	hierarchy *hierarchy
+	session   *session
+}
+
+// handleName returns the name of a resource that is identified by its handle.
+func handleName(handle tpm2.Handle) tpm2.Name {
+	name := make(tpm2.Name, 4)
+	binary.BigEndian.PutUint32(name, uint32(handle))
+	return name
+}
+
+func (e *entity) name() tpm2.Name {
+	switch {
+	case e.object != nil:
+		return e.object.name
+	case e.nv != nil:
+		return e.nv.name()
+	default:
+		return handleName(e.handle)
+	}
+}
+
+func (e *entity) authValue() tpm2.Auth {
+	switch {
+	case e.object != nil:
+		if e.object.sensitive == nil {
+			return nil
+		}
+		return e.object.sensitive.AuthValue
+	case e.nv != nil:
+		return e.nv.authValue
+	case e.hierarchy != nil:
+		return e.hierarchy.authValue
+	default:
+		return nil
+	}
+}
+
+func (e *entity) authPolicy() (tpm2.HashAlgorithmId, tpm2.Digest) {
+	switch {
+	case e.object != nil:
+		return e.object.public.NameAlg, e.object.public.AuthPolicy
+	case e.nv != nil:
+		return e.nv.public.NameAlg, e.nv.public.AuthPolicy
+	case e.hierarchy != nil:
+		return e.hierarchy.policyAlg, e.hierarchy.authPolicy
+	default:
+		return tpm2.HashAlgorithmNull, nil
+	}
+}
+
+// isDAProtected indicates whether authorization failures for this entity are subject to dictionary attack protection.
+func (e *entity) isDAProtected() bool {
+	switch {
+	case e.object != nil:
+		return e.object.public.Attrs&tpm2.AttrNoDA == 0
+	case e.nv != nil:
+		return e.nv.public.Attrs&tpm2.AttrNVNoDA == 0
+	default:
+		return false
+	}
+}
+
+// isAuthValueAvailable indicates whether the authorization value of this entity can be used for authorization with the specified
+// role for the specified command.
+func (e *entity) isAuthValueAvailable(role authRole, code tpm2.CommandCode) bool {
+	switch {
+	case e.object != nil:
+		if e.object.sensitive == nil {
+			return false
+		}
+		switch role {
+		case authUser:
+			return e.object.public.Attrs&tpm2.AttrUserWithAuth != 0
+		case authAdmin:
+			return e.object.public.Attrs&tpm2.AttrAdminWithPolicy == 0
+		default:
+			return false
+		}
+	case e.nv != nil:
+		if role != authUser {
+			return false
+		}
+		if isNVWriteCommand(code) {
+			return e.nv.public.Attrs&tpm2.AttrNVAuthWrite != 0
+		}
+		return e.nv.public.Attrs&tpm2.AttrNVAuthRead != 0
+	case e.session != nil:
+		return false
+	default:
+		return true
+	}
+}
+
+// isPolicyRequired indicates whether authorization of this entity with the specified role requires a policy session.
+func (e *entity) isPolicyRequired(role authRole) bool {
+	switch {
+	case role == authDup:
+		return true
+	case role != authAdmin:
+		return false
+	case e.object != nil:
+		return e.object.public.Attrs&tpm2.AttrAdminWithPolicy != 0
+	default:
+		return e.nv != nil
+	}
+}
+
+// isPolicyAvailable indicates whether a policy session can be used to authorize this entity with the specified role for the
+// specified command.
+func (e *entity) isPolicyAvailable(role authRole, code tpm2.CommandCode) bool {
+	switch {
+	case e.object != nil:
+		return role != authAdmin || e.object.public.Attrs&tpm2.AttrAdminWithPolicy != 0
+	case e.nv != nil:
+		if role != authUser {
+			return true
+		}
+		if isNVWriteCommand(code) {
+			return e.nv.public.Attrs&tpm2.AttrNVPolicyWrite != 0
+		}
+		return e.nv.public.Attrs&tpm2.AttrNVPolicyRead != 0
+	case e.hierarchy != nil:
+		return e.hierarchy.policyAlg != tpm2.HashAlgorithmNull
+	default:
+		return false
+	}
+}
+
+// isHierarchyEnabled indicates whether the specified hierarchy is enabled.
+func (s *Simulator) isHierarchyEnabled(handle tpm2.Handle) bool {
+	switch handle {
+	case tpm2.HandleOwner:
+		return s.shEnable
+	case tpm2.HandleEndorsement:
+		return s.ehEnable
+	case tpm2.HandlePlatform:
+		return s.phEnable
+	default:
+		return true
+	}
+}
+
+// permanentHierarchy returns the hierarchy associated with the specified permanent handle.
+func (s *Simulator) permanentHierarchy(handle tpm2.Handle) *hierarchy {
+	switch handle {
+	case tpm2.HandleOwner:
+		return s.owner
+	case tpm2.HandleEndorsement:
+		return s.endorsement
+	case tpm2.HandlePlatform:
+		return s.platform
+	case tpm2.HandleLockout:
+		return s.lockout
+	case tpm2.HandleNull:
+		return s.null
+	default:
+		return nil
+	}
+}
+
+// resolveHandle returns the entity associated with the command handle at index n. It returns an error if the handle doesn't
+// reference a resource that is currently available.
+func (s *Simulator) resolveHandle(handle tpm2.Handle, n int) (*entity, tpm2.ResponseCode) {
+	e := &entity{handle: handle}
+
+	switch handle.Type() {
+	case tpm2.HandleTypePCR:
+		if handle >= numPCRs {
+			return nil, handleRC(tpm2.ErrorValue, n)
+		}
+	case tpm2.HandleTypeNVIndex:
+		nv, ok := s.nvIndices[handle]
+		if !ok || !s.isNVIndexAccessible(nv) {
+			return nil, handleRC(tpm2.ErrorHandle, n)
+		}
+		e.nv = nv
+	case tpm2.HandleTypeHMACSession, tpm2.HandleTypePolicySession:
+		session, ok := s.sessions[handle]
+		if !ok || !session.loaded {
+			return nil, warningRC(tpm2.WarningReferenceH0 + tpm2.WarningCode(n-1))
+		}
+		e.session = session
+	case tpm2.HandleTypePermanent:
+		switch handle {
+		case tpm2.HandleOwner, tpm2.HandleEndorsement, tpm2.HandlePlatform, tpm2.HandleLockout, tpm2.HandleNull:
+			e.hierarchy = s.permanentHierarchy(handle)
+			if !s.isHierarchyEnabled(handle) {
+				return nil, handleRC(tpm2.ErrorHierarchy, n)
+			}
+		case tpm2.HandlePlatformNV:
+		default:
+			return nil, handleRC(tpm2.ErrorValue, n)
+		}
+	case tpm2.HandleTypeTransient:
+		object, ok := s.objects[handle]
+		if !ok {
+			return nil, handleRC(tpm2.ErrorHandle, n)
+		}
+		e.object = object
+	case tpm2.HandleTypePersistent:
+		object, ok := s.persistent[handle]
+		if !ok {
+			return nil, handleRC(tpm2.ErrorHandle, n)
+		}
+		if !s.isHierarchyEnabled(object.hierarchy) {
+			return nil, handleRC(tpm2.ErrorHierarchy, n)
+		}
+		e.object = object
+	default:
+		return nil, handleRC(tpm2.ErrorValue, n)
+	}
+
+	return e, tpm2.Success
+}
+
+// trimAuthValue returns the supplied authorization value with trailing zeros removed.
+func trimAuthValue(auth tpm2.Auth) tpm2.Auth {
+	return bytes.TrimRight(auth, "\x00")
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"github.com/canonical/go-tpm2"
+)
+
+const (
+	rcBadTag tpm2.ResponseCode = 0x1e // TPM_RC_BAD_TAG
+
+	rcFmt0Base    tpm2.ResponseCode = 0x100 // RC_VER1
+	rcWarningBase tpm2.ResponseCode = 0x900 // RC_WARN
+	rcParameter   tpm2.ResponseCode = 0x040 // TPM_RC_P
+	rcSession     tpm2.ResponseCode = 0x800 // TPM_RC_S
+	rcIndexShift                    = 8
+)
+
+// errorRC returns the response code for the specified error code, without any handle, parameter or session index.
+func errorRC(code tpm2.ErrorCode) tpm2.ResponseCode {
+	if code < 0x80 {
+		return rcFmt0Base | tpm2.ResponseCode(code)
+	}
+	// The format 1 error codes are represented in tpm2 as 0x80 + the error number, which happens to be the same as the response
+	// code.
+	return tpm2.ResponseCode(code)
+}
+
+// warningRC returns the response code for the specified warning code.
+func warningRC(code tpm2.WarningCode) tpm2.ResponseCode {
+	return rcWarningBase | tpm2.ResponseCode(code)
+}
+
+// handleRC returns the response code for the specified format 1 error code, associated with the command handle at index n. The
+// index starts from 1.
+func handleRC(code tpm2.ErrorCode, n int) tpm2.ResponseCode {
+	return errorRC(code) | tpm2.ResponseCode(n)<<rcIndexShift
+}
+
+// paramRC returns the response code for the specified format 1 error code, associated with the command parameter at index n. The
+// index starts from 1.
+func paramRC(code tpm2.ErrorCode, n int) tpm2.ResponseCode {
+	return errorRC(code) | rcParameter | tpm2.ResponseCode(n)<<rcIndexShift
+}
+
+// sessionRC returns the response code for the specified format 1 error code, associated with the session at index n. The index
+// starts from 1.
+func sessionRC(code tpm2.ErrorCode, n int) tpm2.ResponseCode {
+	return errorRC(code) | rcSession | tpm2.ResponseCode(n)<<rcIndexShift
+}
@@ -0,0 +1,317 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/binary"
+	"hash"
+
+	"github.com/canonical/go-tpm2"
+)
+
+// hashSequence contains the state of a hash, HMAC or event sequence object.
+type hashSequence struct {
+	hashAlg tpm2.HashAlgorithmId   // The digest algorithm, or TPM_ALG_NULL for an event sequence
+	isHMAC  bool                   // Whether this is a HMAC sequence
+	hashes  []hash.Hash            // The digest of the data, or one digest for each PCR bank for an event sequence
+	algs    []tpm2.HashAlgorithmId // The algorithm for each entry in hashes
+	prefix  []byte                 // The first bytes of the data, used to determine whether a ticket can be produced
+}
+
+func (s *hashSequence) update(data []byte) {
+	if n := binary.Size(tpm2.TPMGeneratedValue) - len(s.prefix); n > 0 {
+		if n > len(data) {
+			n = len(data)
+		}
+		s.prefix = append(s.prefix, data[:n]...)
+	}
+	for _, h := range s.hashes {
+		h.Write(data)
+	}
+}
+
+// isTPMGenerated indicates whether the supplied data starts with TPM_GENERATED_VALUE. A ticket is never produced for such data, so
+// that a restricted signing key can't be used to sign something that looks like an attestation structure produced by the TPM.
+func isTPMGenerated(data []byte) bool {
+	var magic [4]byte
+	binary.BigEndian.PutUint32(magic[:], uint32(tpm2.TPMGeneratedValue))
+	return bytes.HasPrefix(data, magic[:])
+}
+
+// hashcheckTicket returns a ticket which indicates that the supplied digest was computed by the TPM over data that doesn't start
+// with TPM_GENERATED_VALUE. A NULL ticket is returned for the null hierarchy.
+func (s *Simulator) hashcheckTicket(hierarchy tpm2.Handle, alg tpm2.HashAlgorithmId, digest tpm2.Digest) *tpm2.TkHashcheck {
+	ticket := &tpm2.TkHashcheck{Tag: tpm2.TagHashcheck, Hierarchy: hierarchy}
+	if hierarchy != tpm2.HandleNull {
+		ticket.Digest = s.computeTicket(hierarchy, alg, tpm2.TagHashcheck, digest)
+	}
+	return ticket
+}
+
+// checkHashcheckTicket indicates whether the supplied ticket is valid for the specified digest.
+func (s *Simulator) checkHashcheckTicket(ticket *tpm2.TkHashcheck, alg tpm2.HashAlgorithmId, digest tpm2.Digest) bool {
+	if ticket.Tag != tpm2.TagHashcheck || ticket.Hierarchy == tpm2.HandleNull {
+		return false
+	}
+	return hmac.Equal(ticket.Digest, s.computeTicket(ticket.Hierarchy, alg, tpm2.TagHashcheck, digest))
+}
+
+// checkTicketHierarchy checks that the supplied hierarchy can be used to produce a ticket.
+func (s *Simulator) checkTicketHierarchy(hierarchy tpm2.Handle) tpm2.ErrorCode {
+	switch hierarchy {
+	case tpm2.HandleOwner, tpm2.HandleEndorsement, tpm2.HandlePlatform, tpm2.HandleNull:
+	default:
+		return tpm2.ErrorValue
+	}
+	if !s.isHierarchyEnabled(hierarchy) {
+		return tpm2.ErrorHierarchy
+	}
+	return 0
+}
+
+// hmacKey returns the HMAC key associated with the command handle at the specified index, and the digest algorithm that should be
+// used with it, taking in to account the algorithm requested by the caller.
+func (c *commandContext) hmacKey(i int, hashAlg tpm2.HashAlgorithmId) (*object, tpm2.HashAlgorithmId, tpm2.ResponseCode) {
+	o, rc := c.object(i)
+	if rc != tpm2.Success {
+		return nil, tpm2.HashAlgorithmNull, rc
+	}
+	if o.public.Type != tpm2.ObjectTypeKeyedHash || o.sensitive == nil {
+		return nil, tpm2.HashAlgorithmNull, handleRC(tpm2.ErrorType, i+1)
+	}
+	if o.public.Attrs&tpm2.AttrRestricted != 0 {
+		return nil, tpm2.HashAlgorithmNull, handleRC(tpm2.ErrorAttributes, i+1)
+	}
+	if o.public.Attrs&tpm2.AttrSign == 0 {
+		return nil, tpm2.HashAlgorithmNull, handleRC(tpm2.ErrorKey, i+1)
+	}
+
+	scheme := &o.public.Params.KeyedHashDetail().Scheme
+	switch {
+	case scheme.Scheme == tpm2.KeyedHashSchemeHMAC && hashAlg == tpm2.HashAlgorithmNull:
+		hashAlg = scheme.Details.HMAC().HashAlg
+	case scheme.Scheme == tpm2.KeyedHashSchemeHMAC && hashAlg != scheme.Details.HMAC().HashAlg:
+		return nil, tpm2.HashAlgorithmNull, paramRC(tpm2.ErrorValue, 2)
+	case hashAlg == tpm2.HashAlgorithmNull:
+		return nil, tpm2.HashAlgorithmNull, paramRC(tpm2.ErrorValue, 2)
+	}
+	if !isSupportedHashAlg(hashAlg) {
+		return nil, tpm2.HashAlgorithmNull, paramRC(tpm2.ErrorHash, 2)
+	}
+	return o, hashAlg, tpm2.Success
+}
+
+// sequence returns the sequence object associated with the command handle at the specified index.
+func (c *commandContext) sequence(i int) (*hashSequence, tpm2.ResponseCode) {
+	o := c.entities[i].object
+	if o == nil || o.sequence == nil {
+		return nil, handleRC(tpm2.ErrorMode, i+1)
+	}
+	return o.sequence, tpm2.Success
+}
+
+// startSequence creates a new sequence object with the supplied authorization value.
+func (s *Simulator) startSequence(c *commandContext, auth tpm2.Auth, seq *hashSequence) tpm2.ResponseCode {
+	handle, rc := s.availableObjectHandle()
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	// Sequence objects have an empty name, and their authorization value can always be used.
+	s.objects[handle] = &object{
+		public: &tpm2.Public{
+			Type:    tpm2.ObjectTypeKeyedHash,
+			NameAlg: tpm2.HashAlgorithmNull,
+			Attrs:   tpm2.AttrUserWithAuth | tpm2.AttrNoDA,
+			Params:  tpm2.PublicParamsU{Data: &tpm2.KeyedHashParams{Scheme: tpm2.KeyedHashScheme{Scheme: tpm2.KeyedHashSchemeNull}}}},
+		sensitive: &tpm2.Sensitive{Type: tpm2.ObjectTypeKeyedHash, AuthValue: trimAuthValue(auth)},
+		hierarchy: tpm2.HandleNull,
+		sequence:  seq}
+	c.rHandle = handle
+	return c.respond()
+}
+
+func (s *Simulator) hash(c *commandContext) tpm2.ResponseCode {
+	var data tpm2.MaxBuffer
+	var hashAlg tpm2.HashAlgorithmId
+	var hierarchy tpm2.Handle
+	if rc := c.unmarshalParams(&data, &hashAlg, &hierarchy); rc != tpm2.Success {
+		return rc
+	}
+	if len(data) > maxInputBuffer {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	if !isSupportedHashAlg(hashAlg) {
+		return paramRC(tpm2.ErrorHash, 2)
+	}
+	if code := s.checkTicketHierarchy(hierarchy); code != 0 {
+		return paramRC(code, 3)
+	}
+
+	h := hashAlg.NewHash()
+	h.Write(data)
+	digest := h.Sum(nil)
+
+	if isTPMGenerated(data) {
+		hierarchy = tpm2.HandleNull
+	}
+	return c.respond(tpm2.Digest(digest), s.hashcheckTicket(hierarchy, hashAlg, digest))
+}
+
+func (s *Simulator) hmac(c *commandContext) tpm2.ResponseCode {
+	var buffer tpm2.MaxBuffer
+	var hashAlg tpm2.HashAlgorithmId
+	if rc := c.unmarshalParams(&buffer, &hashAlg); rc != tpm2.Success {
+		return rc
+	}
+	if len(buffer) > maxInputBuffer {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+
+	o, hashAlg, rc := c.hmacKey(0, hashAlg)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	h := hmac.New(hashAlg.NewHash, o.sensitive.Sensitive.Bits())
+	h.Write(buffer)
+	return c.respond(tpm2.Digest(h.Sum(nil)))
+}
+
+func (s *Simulator) hmacStart(c *commandContext) tpm2.ResponseCode {
+	var auth tpm2.Auth
+	var hashAlg tpm2.HashAlgorithmId
+	if rc := c.unmarshalParams(&auth, &hashAlg); rc != tpm2.Success {
+		return rc
+	}
+
+	o, hashAlg, rc := c.hmacKey(0, hashAlg)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	return s.startSequence(c, auth, &hashSequence{
+		hashAlg: hashAlg,
+		isHMAC:  true,
+		hashes:  []hash.Hash{hmac.New(hashAlg.NewHash, o.sensitive.Sensitive.Bits())},
+		algs:    []tpm2.HashAlgorithmId{hashAlg}})
+}
+
+func (s *Simulator) hashSequenceStart(c *commandContext) tpm2.ResponseCode {
+	var auth tpm2.Auth
+	var hashAlg tpm2.HashAlgorithmId
+	if rc := c.unmarshalParams(&auth, &hashAlg); rc != tpm2.Success {
+		return rc
+	}
+
+	seq := &hashSequence{hashAlg: hashAlg}
+	switch {
+	case hashAlg == tpm2.HashAlgorithmNull:
+		for _, alg := range pcrBanks {
+			seq.hashes = append(seq.hashes, alg.NewHash())
+			seq.algs = append(seq.algs, alg)
+		}
+	case isSupportedHashAlg(hashAlg):
+		seq.hashes = []hash.Hash{hashAlg.NewHash()}
+		seq.algs = []tpm2.HashAlgorithmId{hashAlg}
+	default:
+		return paramRC(tpm2.ErrorHash, 2)
+	}
+
+	return s.startSequence(c, auth, seq)
+}
+
+func (s *Simulator) sequenceUpdate(c *commandContext) tpm2.ResponseCode {
+	var buffer tpm2.MaxBuffer
+	if rc := c.unmarshalParams(&buffer); rc != tpm2.Success {
+		return rc
+	}
+
+	seq, rc := c.sequence(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if len(buffer) > maxInputBuffer {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	seq.update(buffer)
+	return tpm2.Success
+}
+
+func (s *Simulator) sequenceComplete(c *commandContext) tpm2.ResponseCode {
+	var buffer tpm2.MaxBuffer
+	var hierarchy tpm2.Handle
+	if rc := c.unmarshalParams(&buffer, &hierarchy); rc != tpm2.Success {
+		return rc
+	}
+
+	seq, rc := c.sequence(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if seq.hashAlg == tpm2.HashAlgorithmNull {
+		return handleRC(tpm2.ErrorMode, 1)
+	}
+	if len(buffer) > maxInputBuffer {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	if code := s.checkTicketHierarchy(hierarchy); code != 0 {
+		return paramRC(code, 2)
+	}
+
+	seq.update(buffer)
+	digest := seq.hashes[0].Sum(nil)
+	delete(s.objects, c.handles[0])
+
+	if seq.isHMAC || isTPMGenerated(seq.prefix) {
+		hierarchy = tpm2.HandleNull
+	}
+	return c.respond(tpm2.Digest(digest), s.hashcheckTicket(hierarchy, seq.hashAlg, digest))
+}
+
+func (s *Simulator) eventSequenceComplete(c *commandContext) tpm2.ResponseCode {
+	var buffer tpm2.MaxBuffer
+	if rc := c.unmarshalParams(&buffer); rc != tpm2.Success {
+		return rc
+	}
+
+	pcr := -1
+	switch {
+	case c.handles[0] == tpm2.HandleNull:
+	case c.handles[0].Type() == tpm2.HandleTypePCR:
+		pcr = int(c.handles[0])
+		if pcrExtendLocalities(pcr)&(1<<c.locality) == 0 {
+			return warningRC(tpm2.WarningLocality)
+		}
+	default:
+		return handleRC(tpm2.ErrorValue, 1)
+	}
+	seq, rc := c.sequence(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if seq.hashAlg != tpm2.HashAlgorithmNull {
+		return handleRC(tpm2.ErrorMode, 2)
+	}
+	if len(buffer) > maxInputBuffer {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+
+	seq.update(buffer)
+	var results tpm2.TaggedHashList
+	for i, h := range seq.hashes {
+		results = append(results, tpm2.TaggedHash{HashAlg: seq.algs[i], Digest: h.Sum(nil)})
+	}
+	if pcr >= 0 {
+		for _, d := range results {
+			s.extendPCR(pcr, d.HashAlg, d.Digest)
+		}
+	}
+	delete(s.objects, c.handles[1])
+
+	return c.respond(results)
+}
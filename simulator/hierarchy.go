@@ -0,0 +1,217 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"github.com/canonical/go-tpm2"
+)
+
+const maxAuthSize = 64 // The size of the largest digest implemented by the simulator
+
+// flushObjects flushes the transient objects that belong to the specified hierarchy, and evicts the persistent ones if evict is
+// true.
+func (s *Simulator) flushObjects(hierarchy tpm2.Handle, evict bool) {
+	for h, o := range s.objects {
+		if o.hierarchy == hierarchy {
+			delete(s.objects, h)
+		}
+	}
+	if !evict {
+		return
+	}
+	for h, o := range s.persistent {
+		if o.hierarchy == hierarchy {
+			delete(s.persistent, h)
+		}
+	}
+}
+
+// resetHierarchyAuth clears the authorization value and authorization policy of the supplied hierarchy.
+func resetHierarchyAuth(h *hierarchy) {
+	h.authValue = nil
+	h.authPolicy = nil
+	h.policyAlg = tpm2.HashAlgorithmNull
+}
+
+// authHierarchy returns the hierarchy associated with the command handle at the specified index, checking that it is one of the
+// specified handles.
+func (c *commandContext) authHierarchy(i int, allowed ...tpm2.Handle) (*hierarchy, tpm2.ResponseCode) {
+	h := c.entities[i].hierarchy
+	if h != nil {
+		for _, a := range allowed {
+			if h.handle == a {
+				return h, tpm2.Success
+			}
+		}
+	}
+	return nil, handleRC(tpm2.ErrorValue, i+1)
+}
+
+func (s *Simulator) hierarchyChangeAuth(c *commandContext) tpm2.ResponseCode {
+	var newAuth tpm2.Auth
+	if rc := c.unmarshalParams(&newAuth); rc != tpm2.Success {
+		return rc
+	}
+	h, rc := c.authHierarchy(0, tpm2.HandleLockout, tpm2.HandleEndorsement, tpm2.HandleOwner, tpm2.HandlePlatform)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if len(newAuth) > maxAuthSize {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+
+	// The response HMAC is computed using the new authorization value.
+	h.authValue = newAuth
+	return tpm2.Success
+}
+
+func (s *Simulator) setPrimaryPolicy(c *commandContext) tpm2.ResponseCode {
+	var authPolicy tpm2.Digest
+	var hashAlg tpm2.HashAlgorithmId
+	if rc := c.unmarshalParams(&authPolicy, &hashAlg); rc != tpm2.Success {
+		return rc
+	}
+	h, rc := c.authHierarchy(0, tpm2.HandleLockout, tpm2.HandleEndorsement, tpm2.HandleOwner, tpm2.HandlePlatform)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	switch {
+	case hashAlg == tpm2.HashAlgorithmNull:
+		if len(authPolicy) > 0 {
+			return paramRC(tpm2.ErrorSize, 1)
+		}
+	case !isSupportedHashAlg(hashAlg):
+		return paramRC(tpm2.ErrorHash, 2)
+	case len(authPolicy) != hashAlg.Size():
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+
+	h.authPolicy = authPolicy
+	h.policyAlg = hashAlg
+	return tpm2.Success
+}
+
+func (s *Simulator) hierarchyControl(c *commandContext) tpm2.ResponseCode {
+	var enable tpm2.Handle
+	var state bool
+	if rc := c.unmarshalParams(&enable, &state); rc != tpm2.Success {
+		return rc
+	}
+	auth, rc := c.authHierarchy(0, tpm2.HandleOwner, tpm2.HandleEndorsement, tpm2.HandlePlatform)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	var flag *bool
+	switch enable {
+	case tpm2.HandlePlatform:
+		flag = &s.phEnable
+	case tpm2.HandlePlatformNV:
+		flag = &s.phEnableNV
+	case tpm2.HandleOwner:
+		flag = &s.shEnable
+	case tpm2.HandleEndorsement:
+		flag = &s.ehEnable
+	default:
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+
+	switch {
+	case enable == tpm2.HandlePlatform || enable == tpm2.HandlePlatformNV:
+		if auth.handle != tpm2.HandlePlatform {
+			return errorRC(tpm2.ErrorAuthType)
+		}
+	case auth.handle != tpm2.HandlePlatform && auth.handle != enable:
+		return errorRC(tpm2.ErrorAuthType)
+	}
+	if state && auth.handle != tpm2.HandlePlatform {
+		return errorRC(tpm2.ErrorAuthType)
+	}
+
+	*flag = state
+	if !state && enable != tpm2.HandlePlatformNV {
+		s.flushObjects(enable, false)
+	}
+	return tpm2.Success
+}
+
+func (s *Simulator) clear(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	if _, rc := c.authHierarchy(0, tpm2.HandleLockout, tpm2.HandlePlatform); rc != tpm2.Success {
+		return rc
+	}
+	if s.disableClear {
+		return errorRC(tpm2.ErrorDisabled)
+	}
+
+	s.owner.changeSeed()
+	s.endorsement.proof = random(len(s.endorsement.proof))
+	s.flushObjects(tpm2.HandleOwner, true)
+	s.flushObjects(tpm2.HandleEndorsement, true)
+	for h, nv := range s.nvIndices {
+		if !nv.hasAttr(tpm2.AttrNVPlatformCreate) {
+			delete(s.nvIndices, h)
+		}
+	}
+
+	// The response HMAC is computed using the new (empty) lockout authorization value.
+	resetHierarchyAuth(s.owner)
+	resetHierarchyAuth(s.endorsement)
+	resetHierarchyAuth(s.lockout)
+
+	s.shEnable = true
+	s.ehEnable = true
+	s.resetCount = 0
+	s.restartCount = 0
+	return tpm2.Success
+}
+
+func (s *Simulator) clearControl(c *commandContext) tpm2.ResponseCode {
+	var disable bool
+	if rc := c.unmarshalParams(&disable); rc != tpm2.Success {
+		return rc
+	}
+	auth, rc := c.authHierarchy(0, tpm2.HandleLockout, tpm2.HandlePlatform)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if auth.handle == tpm2.HandleLockout && !disable {
+		return errorRC(tpm2.ErrorAuthFail)
+	}
+	s.disableClear = disable
+	return tpm2.Success
+}
+
+func (s *Simulator) changePPS(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	if _, rc := c.authHierarchy(0, tpm2.HandlePlatform); rc != tpm2.Success {
+		return rc
+	}
+
+	s.platform.changeSeed()
+	s.flushObjects(tpm2.HandlePlatform, true)
+	s.platform.authPolicy = nil
+	s.platform.policyAlg = tpm2.HashAlgorithmNull
+	return tpm2.Success
+}
+
+func (s *Simulator) changeEPS(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	if _, rc := c.authHierarchy(0, tpm2.HandlePlatform); rc != tpm2.Success {
+		return rc
+	}
+
+	s.endorsement.changeSeed()
+	s.flushObjects(tpm2.HandleEndorsement, true)
+	resetHierarchyAuth(s.endorsement)
+	return tpm2.Success
+}
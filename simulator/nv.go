@@ -0,0 +1,490 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"encoding/binary"
+
+	"github.com/canonical/go-tpm2"
+)
+
+type nvPublicSized struct {
+	Ptr *tpm2.NVPublic `tpm2:"sized"`
+}
+
+// nvIndex contains the state associated with a NV index.
+type nvIndex struct {
+	public    *tpm2.NVPublic
+	authValue tpm2.Auth
+	data      []byte
+}
+
+func (nv *nvIndex) name() tpm2.Name {
+	name, _ := nv.public.Name()
+	return name
+}
+
+func (nv *nvIndex) setAttr(attr tpm2.NVAttributes) {
+	nv.public.Attrs |= attr
+}
+
+func (nv *nvIndex) clearAttr(attr tpm2.NVAttributes) {
+	nv.public.Attrs &^= attr
+}
+
+func (nv *nvIndex) hasAttr(attr tpm2.NVAttributes) bool {
+	return nv.public.Attrs&attr != 0
+}
+
+// isNVWriteCommand indicates whether the specified command is one that writes to a NV index, which determines the attributes
+// that control access to the index.
+func isNVWriteCommand(code tpm2.CommandCode) bool {
+	switch code {
+	case tpm2.CommandNVWrite, tpm2.CommandNVIncrement, tpm2.CommandNVSetBits, tpm2.CommandNVExtend, tpm2.CommandNVWriteLock:
+		return true
+	default:
+		return false
+	}
+}
+
+// isNVIndexAccessible indicates whether the specified NV index is accessible, based on the state of the hierarchy that created
+// it.
+func (s *Simulator) isNVIndexAccessible(nv *nvIndex) bool {
+	if nv.hasAttr(tpm2.AttrNVPlatformCreate) {
+		return s.phEnableNV
+	}
+	return s.shEnable
+}
+
+// checkNVWriteAccess checks that the NV index can be written to using the supplied authorization entity.
+func (s *Simulator) checkNVWriteAccess(c *commandContext, auth *entity, nv *nvIndex) tpm2.ResponseCode {
+	if nv.hasAttr(tpm2.AttrNVWriteLocked) {
+		return errorRC(tpm2.ErrorNVLocked)
+	}
+
+	switch auth.handle {
+	case tpm2.HandleOwner:
+		if !nv.hasAttr(tpm2.AttrNVOwnerWrite) {
+			return errorRC(tpm2.ErrorNVAuthorization)
+		}
+	case tpm2.HandlePlatform:
+		if !nv.hasAttr(tpm2.AttrNVPPWrite) {
+			return errorRC(tpm2.ErrorNVAuthorization)
+		}
+	case nv.public.Index:
+		if !nv.hasAttr(tpm2.AttrNVAuthWrite) && !nv.hasAttr(tpm2.AttrNVPolicyWrite) {
+			return errorRC(tpm2.ErrorNVAuthorization)
+		}
+	default:
+		return errorRC(tpm2.ErrorNVAuthorization)
+	}
+	return tpm2.Success
+}
+
+// checkNVReadAccess checks that the NV index can be read using the supplied authorization entity.
+func (s *Simulator) checkNVReadAccess(c *commandContext, auth *entity, nv *nvIndex) tpm2.ResponseCode {
+	if nv.hasAttr(tpm2.AttrNVReadLocked) {
+		return errorRC(tpm2.ErrorNVLocked)
+	}
+
+	switch auth.handle {
+	case tpm2.HandleOwner:
+		if !nv.hasAttr(tpm2.AttrNVOwnerRead) {
+			return errorRC(tpm2.ErrorNVAuthorization)
+		}
+	case tpm2.HandlePlatform:
+		if !nv.hasAttr(tpm2.AttrNVPPRead) {
+			return errorRC(tpm2.ErrorNVAuthorization)
+		}
+	case nv.public.Index:
+		if !nv.hasAttr(tpm2.AttrNVAuthRead) && !nv.hasAttr(tpm2.AttrNVPolicyRead) {
+			return errorRC(tpm2.ErrorNVAuthorization)
+		}
+	default:
+		return errorRC(tpm2.ErrorNVAuthorization)
+	}
+	return tpm2.Success
+}
+
+// nvIndex returns the NV index referenced by the command handle at the specified index.
+func (c *commandContext) nvIndex(i int) (*nvIndex, tpm2.ResponseCode) {
+	nv := c.entities[i].nv
+	if nv == nil {
+		return nil, handleRC(tpm2.ErrorValue, i+1)
+	}
+	return nv, tpm2.Success
+}
+
+// checkNVProvisionHandle checks that the handle at the specified index is one that can be used to define or undefine NV indices.
+func (c *commandContext) checkNVProvisionHandle(i int) tpm2.ResponseCode {
+	switch c.handles[i] {
+	case tpm2.HandleOwner, tpm2.HandlePlatform:
+		return tpm2.Success
+	default:
+		return handleRC(tpm2.ErrorValue, i+1)
+	}
+}
+
+// nvStartup performs the actions for NV indices during TPM2_Startup.
+func (s *Simulator) nvStartup(clear bool) {
+	for _, nv := range s.nvIndices {
+		if (nv.hasAttr(tpm2.AttrNVWriteStClear) || nv.hasAttr(tpm2.AttrNVGlobalLock)) &&
+			!(nv.hasAttr(tpm2.AttrNVWriteDefine) && nv.hasAttr(tpm2.AttrNVWritten)) {
+			nv.clearAttr(tpm2.AttrNVWriteLocked)
+		}
+		if nv.hasAttr(tpm2.AttrNVReadStClear) {
+			nv.clearAttr(tpm2.AttrNVReadLocked)
+		}
+		if clear && nv.hasAttr(tpm2.AttrNVClearStClear) {
+			nv.clearAttr(tpm2.AttrNVWritten)
+		}
+	}
+}
+
+func (s *Simulator) nvDefineSpace(c *commandContext) tpm2.ResponseCode {
+	var auth tpm2.Auth
+	var publicInfo nvPublicSized
+	if rc := c.unmarshalParams(&auth, &publicInfo); rc != tpm2.Success {
+		return rc
+	}
+	if rc := c.checkNVProvisionHandle(0); rc != tpm2.Success {
+		return rc
+	}
+	public := publicInfo.Ptr
+	if public == nil {
+		return paramRC(tpm2.ErrorSize, 2)
+	}
+
+	if public.Index.Type() != tpm2.HandleTypeNVIndex {
+		return paramRC(tpm2.ErrorValue, 2)
+	}
+	if !isSupportedHashAlg(public.NameAlg) {
+		return paramRC(tpm2.ErrorHash, 2)
+	}
+	if len(public.AuthPolicy) > 0 && len(public.AuthPolicy) != public.NameAlg.Size() {
+		return paramRC(tpm2.ErrorSize, 2)
+	}
+	auth = trimAuthValue(auth)
+	if len(auth) > public.NameAlg.Size() {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+
+	attrs := public.Attrs
+	switch attrs.Type() {
+	case tpm2.NVTypeOrdinary:
+		if public.Size > maxNVIndexSize {
+			return paramRC(tpm2.ErrorSize, 2)
+		}
+	case tpm2.NVTypeCounter, tpm2.NVTypeBits, tpm2.NVTypePinFail, tpm2.NVTypePinPass:
+		if public.Size != 8 {
+			return paramRC(tpm2.ErrorSize, 2)
+		}
+	case tpm2.NVTypeExtend:
+		if int(public.Size) != public.NameAlg.Size() {
+			return paramRC(tpm2.ErrorSize, 2)
+		}
+	default:
+		return paramRC(tpm2.ErrorAttributes, 2)
+	}
+
+	switch {
+	case attrs&(tpm2.AttrNVPPWrite|tpm2.AttrNVOwnerWrite|tpm2.AttrNVAuthWrite|tpm2.AttrNVPolicyWrite) == 0:
+		return paramRC(tpm2.ErrorAttributes, 2)
+	case attrs&(tpm2.AttrNVPPRead|tpm2.AttrNVOwnerRead|tpm2.AttrNVAuthRead|tpm2.AttrNVPolicyRead) == 0:
+		return paramRC(tpm2.ErrorAttributes, 2)
+	case attrs&(tpm2.AttrNVWriteLocked|tpm2.AttrNVReadLocked|tpm2.AttrNVWritten) != 0:
+		return paramRC(tpm2.ErrorAttributes, 2)
+	case (attrs&tpm2.AttrNVPlatformCreate != 0) != (c.handles[0] == tpm2.HandlePlatform):
+		return paramRC(tpm2.ErrorAttributes, 2)
+	case attrs&tpm2.AttrNVPolicyDelete != 0 && c.handles[0] != tpm2.HandlePlatform:
+		return paramRC(tpm2.ErrorAttributes, 2)
+	case attrs&tpm2.AttrNVClearStClear != 0 && attrs.Type() == tpm2.NVTypeCounter:
+		return paramRC(tpm2.ErrorAttributes, 2)
+	case attrs&tpm2.AttrNVWriteAll != 0 && attrs.Type() != tpm2.NVTypeOrdinary:
+		return paramRC(tpm2.ErrorAttributes, 2)
+	}
+
+	if _, exists := s.nvIndices[public.Index]; exists {
+		return errorRC(tpm2.ErrorNVDefined)
+	}
+	if len(s.nvIndices) >= maxNVIndices {
+		return errorRC(tpm2.ErrorNVSpace)
+	}
+
+	// The contents of a newly defined ordinary index are unspecified until it is written. Initialize them to the erased
+	// state of flash memory, as the reference implementation does.
+	data := make([]byte, public.Size)
+	for i := range data {
+		data[i] = 0xff
+	}
+	s.nvIndices[public.Index] = &nvIndex{public: public, authValue: auth, data: data}
+	return tpm2.Success
+}
+
+func (s *Simulator) nvUndefineSpace(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	if rc := c.checkNVProvisionHandle(0); rc != tpm2.Success {
+		return rc
+	}
+	nv, rc := c.nvIndex(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if nv.hasAttr(tpm2.AttrNVPolicyDelete) {
+		return handleRC(tpm2.ErrorAttributes, 2)
+	}
+	if (c.handles[0] == tpm2.HandlePlatform) != nv.hasAttr(tpm2.AttrNVPlatformCreate) {
+		return errorRC(tpm2.ErrorNVAuthorization)
+	}
+	delete(s.nvIndices, nv.public.Index)
+	return tpm2.Success
+}
+
+func (s *Simulator) nvUndefineSpaceSpecial(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	nv, rc := c.nvIndex(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if c.handles[1] != tpm2.HandlePlatform {
+		return handleRC(tpm2.ErrorValue, 2)
+	}
+	if !nv.hasAttr(tpm2.AttrNVPolicyDelete) {
+		return handleRC(tpm2.ErrorAttributes, 1)
+	}
+	delete(s.nvIndices, nv.public.Index)
+	// The response HMAC is computed using an empty authorization value for the deleted index.
+	nv.authValue = nil
+	return tpm2.Success
+}
+
+func (s *Simulator) nvReadPublic(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	nv, rc := c.nvIndex(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(nvPublicSized{nv.public}, nv.name())
+}
+
+func (s *Simulator) nvWrite(c *commandContext) tpm2.ResponseCode {
+	var data tpm2.MaxNVBuffer
+	var offset uint16
+	if rc := c.unmarshalParams(&data, &offset); rc != tpm2.Success {
+		return rc
+	}
+	nv, rc := c.nvIndex(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if rc := s.checkNVWriteAccess(c, c.entities[0], nv); rc != tpm2.Success {
+		return rc
+	}
+	switch nv.public.Attrs.Type() {
+	case tpm2.NVTypeOrdinary, tpm2.NVTypePinFail, tpm2.NVTypePinPass:
+	default:
+		return errorRC(tpm2.ErrorAttributes)
+	}
+	if len(data) > maxNVBuffer {
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+	if int(offset)+len(data) > int(nv.public.Size) {
+		return errorRC(tpm2.ErrorNVRange)
+	}
+	if nv.hasAttr(tpm2.AttrNVWriteAll) && (offset != 0 || len(data) != int(nv.public.Size)) {
+		return errorRC(tpm2.ErrorNVRange)
+	}
+
+	copy(nv.data[offset:], data)
+	s.nvWritten(nv)
+	return tpm2.Success
+}
+
+// nvWritten marks a NV index as having been written.
+func (s *Simulator) nvWritten(nv *nvIndex) {
+	nv.setAttr(tpm2.AttrNVWritten)
+}
+
+func (s *Simulator) nvIncrement(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	nv, rc := c.nvIndex(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if rc := s.checkNVWriteAccess(c, c.entities[0], nv); rc != tpm2.Success {
+		return rc
+	}
+	if nv.public.Attrs.Type() != tpm2.NVTypeCounter {
+		return handleRC(tpm2.ErrorAttributes, 2)
+	}
+
+	// A counter is initialized to the largest value of any counter on the TPM when it is first incremented.
+	value := s.maxNVCounter
+	if nv.hasAttr(tpm2.AttrNVWritten) {
+		value = binary.BigEndian.Uint64(nv.data)
+	}
+	value++
+	if value > s.maxNVCounter {
+		s.maxNVCounter = value
+	}
+	binary.BigEndian.PutUint64(nv.data, value)
+	s.nvWritten(nv)
+	return tpm2.Success
+}
+
+func (s *Simulator) nvExtend(c *commandContext) tpm2.ResponseCode {
+	var data tpm2.MaxNVBuffer
+	if rc := c.unmarshalParams(&data); rc != tpm2.Success {
+		return rc
+	}
+	nv, rc := c.nvIndex(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if rc := s.checkNVWriteAccess(c, c.entities[0], nv); rc != tpm2.Success {
+		return rc
+	}
+	if nv.public.Attrs.Type() != tpm2.NVTypeExtend {
+		return handleRC(tpm2.ErrorAttributes, 2)
+	}
+
+	h := nv.public.NameAlg.NewHash()
+	if nv.hasAttr(tpm2.AttrNVWritten) {
+		h.Write(nv.data)
+	} else {
+		h.Write(make([]byte, nv.public.NameAlg.Size()))
+	}
+	h.Write(data)
+	nv.data = h.Sum(nil)
+	s.nvWritten(nv)
+	return tpm2.Success
+}
+
+func (s *Simulator) nvSetBits(c *commandContext) tpm2.ResponseCode {
+	var bits uint64
+	if rc := c.unmarshalParams(&bits); rc != tpm2.Success {
+		return rc
+	}
+	nv, rc := c.nvIndex(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if rc := s.checkNVWriteAccess(c, c.entities[0], nv); rc != tpm2.Success {
+		return rc
+	}
+	if nv.public.Attrs.Type() != tpm2.NVTypeBits {
+		return handleRC(tpm2.ErrorAttributes, 2)
+	}
+
+	if nv.hasAttr(tpm2.AttrNVWritten) {
+		bits |= binary.BigEndian.Uint64(nv.data)
+	}
+	binary.BigEndian.PutUint64(nv.data, bits)
+	s.nvWritten(nv)
+	return tpm2.Success
+}
+
+func (s *Simulator) nvWriteLock(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	nv, rc := c.nvIndex(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if !nv.hasAttr(tpm2.AttrNVWriteDefine) && !nv.hasAttr(tpm2.AttrNVWriteStClear) {
+		return handleRC(tpm2.ErrorAttributes, 2)
+	}
+	if nv.hasAttr(tpm2.AttrNVWriteLocked) {
+		return tpm2.Success
+	}
+	if rc := s.checkNVWriteAccess(c, c.entities[0], nv); rc != tpm2.Success {
+		return rc
+	}
+	nv.setAttr(tpm2.AttrNVWriteLocked)
+	return tpm2.Success
+}
+
+func (s *Simulator) nvGlobalWriteLock(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	if rc := c.checkNVProvisionHandle(0); rc != tpm2.Success {
+		return rc
+	}
+	for _, nv := range s.nvIndices {
+		if nv.hasAttr(tpm2.AttrNVGlobalLock) {
+			nv.setAttr(tpm2.AttrNVWriteLocked)
+		}
+	}
+	return tpm2.Success
+}
+
+func (s *Simulator) nvRead(c *commandContext) tpm2.ResponseCode {
+	var size, offset uint16
+	if rc := c.unmarshalParams(&size, &offset); rc != tpm2.Success {
+		return rc
+	}
+	nv, rc := c.nvIndex(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if rc := s.checkNVReadAccess(c, c.entities[0], nv); rc != tpm2.Success {
+		return rc
+	}
+	if !nv.hasAttr(tpm2.AttrNVWritten) {
+		return errorRC(tpm2.ErrorNVUninitialized)
+	}
+	if size > maxNVBuffer {
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+	if int(offset)+int(size) > int(nv.public.Size) {
+		return errorRC(tpm2.ErrorNVRange)
+	}
+	return c.respond(tpm2.MaxNVBuffer(nv.data[offset : offset+size]))
+}
+
+func (s *Simulator) nvReadLock(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	nv, rc := c.nvIndex(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if !nv.hasAttr(tpm2.AttrNVReadStClear) {
+		return handleRC(tpm2.ErrorAttributes, 2)
+	}
+	if rc := s.checkNVReadAccess(c, c.entities[0], nv); rc != tpm2.Success {
+		return rc
+	}
+	nv.setAttr(tpm2.AttrNVReadLocked)
+	return tpm2.Success
+}
+
+func (s *Simulator) nvChangeAuth(c *commandContext) tpm2.ResponseCode {
+	var newAuth tpm2.Auth
+	if rc := c.unmarshalParams(&newAuth); rc != tpm2.Success {
+		return rc
+	}
+	nv, rc := c.nvIndex(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	newAuth = trimAuthValue(newAuth)
+	if len(newAuth) > nv.public.NameAlg.Size() {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	nv.authValue = newAuth
+	return tpm2.Success
+}
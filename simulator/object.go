@@ -0,0 +1,932 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+type publicSized struct {
+	Ptr *tpm2.Public `tpm2:"sized"`
+}
+
+type sensitiveSized struct {
+	Ptr *tpm2.Sensitive `tpm2:"sized"`
+}
+
+type sensitiveCreateSized struct {
+	Ptr *tpm2.SensitiveCreate `tpm2:"sized"`
+}
+
+type creationDataSized struct {
+	Ptr *tpm2.CreationData `tpm2:"sized"`
+}
+
+// object contains the state associated with a loaded or persistent object.
+type object struct {
+	public        *tpm2.Public
+	sensitive     *tpm2.Sensitive // The sensitive area, or nil if only the public area is loaded
+	name          tpm2.Name
+	qualifiedName tpm2.Name
+	hierarchy     tpm2.Handle   // The hierarchy that the object belongs to
+	external      bool          // Whether the object was loaded with TPM2_LoadExternal
+	sequence      *hashSequence // The state of a sequence object, or nil if this isn't a sequence object
+}
+
+// primarySecrets contains the secret values for a primary object. The TPM derives these from the primary seed and the template,
+// but the simulator generates them randomly and caches them so that the same template always produces the same object for the
+// lifetime of a primary seed.
+type primarySecrets struct {
+	unique    tpm2.PublicIDU
+	seedValue tpm2.Digest
+	sensitive tpm2.SensitiveCompositeU
+}
+
+func newObject(public *tpm2.Public, sensitive *tpm2.Sensitive, hierarchy tpm2.Handle, parentQN tpm2.Name) (*object, error) {
+	name, err := public.Name()
+	if err != nil {
+		return nil, err
+	}
+	return &object{
+		public:        public,
+		sensitive:     sensitive,
+		name:          name,
+		qualifiedName: computeQualifiedName(public.NameAlg, parentQN, name),
+		hierarchy:     hierarchy}, nil
+}
+
+// computeQualifiedName computes the qualified name of an object with the specified name and parent qualified name.
+func computeQualifiedName(alg tpm2.HashAlgorithmId, parentQN, name tpm2.Name) tpm2.Name {
+	h := alg.NewHash()
+	h.Write(parentQN)
+	h.Write(name)
+	qn, _ := mu.MarshalToBytes(alg, mu.RawBytes(h.Sum(nil)))
+	return qn
+}
+
+// isStorageKey indicates whether the supplied public area is that of an asymmetric storage key, which is a restricted decrypt key
+// that can be used to protect secrets.
+func isStorageKey(public *tpm2.Public) bool {
+	switch public.Type {
+	case tpm2.ObjectTypeRSA, tpm2.ObjectTypeECC:
+	default:
+		return false
+	}
+	return public.Attrs&(tpm2.AttrRestricted|tpm2.AttrDecrypt|tpm2.AttrSign) == tpm2.AttrRestricted|tpm2.AttrDecrypt
+}
+
+// isStorageParent indicates whether this object can be used as a parent for creating and loading other objects.
+func (o *object) isStorageParent() bool {
+	return o.sensitive != nil && isStorageKey(o.public)
+}
+
+// parentSymmetric returns the symmetric algorithm used to protect the children of this object.
+func (o *object) parentSymmetric() *tpm2.SymDefObject {
+	return &o.public.Params.AsymDetail().Symmetric
+}
+
+func isSupportedHashAlg(alg tpm2.HashAlgorithmId) bool {
+	switch alg {
+	case tpm2.HashAlgorithmSHA1, tpm2.HashAlgorithmSHA256, tpm2.HashAlgorithmSHA384, tpm2.HashAlgorithmSHA512:
+		return true
+	default:
+		return false
+	}
+}
+
+func isSupportedCurve(curve tpm2.ECCCurve) bool {
+	switch curve {
+	case tpm2.ECCCurveNIST_P224, tpm2.ECCCurveNIST_P256, tpm2.ECCCurveNIST_P384, tpm2.ECCCurveNIST_P521:
+		return true
+	default:
+		return false
+	}
+}
+
+func checkSymDefObject(sym *tpm2.SymDefObject) tpm2.ErrorCode {
+	switch sym.Algorithm {
+	case tpm2.SymObjectAlgorithmNull:
+		return 0
+	case tpm2.SymObjectAlgorithmAES:
+		switch sym.KeyBits.Sym() {
+		case 128, 192, 256:
+		default:
+			return tpm2.ErrorKeySize
+		}
+		switch sym.Mode.Sym() {
+		case tpm2.SymModeCFB, tpm2.SymModeCBC, tpm2.SymModeCTR, tpm2.SymModeOFB, tpm2.SymModeECB, tpm2.SymModeNull:
+		default:
+			return tpm2.ErrorMode
+		}
+		return 0
+	default:
+		return tpm2.ErrorSymmetric
+	}
+}
+
+// checkSigScheme checks that the supplied signing scheme is valid for the specified object type.
+func checkSigScheme(typ tpm2.ObjectTypeId, scheme tpm2.AsymSchemeId, details *tpm2.AsymSchemeU) tpm2.ErrorCode {
+	switch {
+	case scheme == tpm2.AsymSchemeNull:
+		return 0
+	case typ == tpm2.ObjectTypeRSA && (scheme == tpm2.AsymSchemeRSASSA || scheme == tpm2.AsymSchemeRSAPSS):
+	case typ == tpm2.ObjectTypeECC && scheme == tpm2.AsymSchemeECDSA:
+	default:
+		return tpm2.ErrorScheme
+	}
+	if !isSupportedHashAlg(details.Any().HashAlg) {
+		return tpm2.ErrorHash
+	}
+	return 0
+}
+
+// checkPublic validates the supplied public area for an object that is being created.
+func checkPublic(public *tpm2.Public, parentFixedTPM bool) tpm2.ErrorCode {
+	if !isSupportedHashAlg(public.NameAlg) {
+		return tpm2.ErrorHash
+	}
+	if len(public.AuthPolicy) > 0 && len(public.AuthPolicy) != public.NameAlg.Size() {
+		return tpm2.ErrorSize
+	}
+
+	attrs := public.Attrs
+	if attrs&tpm2.AttrFixedTPM != 0 && (attrs&tpm2.AttrFixedParent == 0 || !parentFixedTPM) {
+		return tpm2.ErrorAttributes
+	}
+	if attrs&tpm2.AttrEncryptedDuplication != 0 && attrs&tpm2.AttrFixedParent != 0 {
+		return tpm2.ErrorAttributes
+	}
+	if attrs&tpm2.AttrRestricted != 0 && attrs&tpm2.AttrSign != 0 && attrs&tpm2.AttrDecrypt != 0 {
+		return tpm2.ErrorAttributes
+	}
+
+	switch public.Type {
+	case tpm2.ObjectTypeRSA, tpm2.ObjectTypeECC:
+		if attrs&(tpm2.AttrSign|tpm2.AttrDecrypt) == 0 {
+			return tpm2.ErrorAttributes
+		}
+		params := public.Params.AsymDetail()
+		if attrs&tpm2.AttrRestricted != 0 && attrs&tpm2.AttrDecrypt != 0 {
+			if params.Symmetric.Algorithm == tpm2.SymObjectAlgorithmNull {
+				return tpm2.ErrorSymmetric
+			}
+			if params.Symmetric.Mode.Sym() != tpm2.SymModeCFB {
+				return tpm2.ErrorMode
+			}
+			if params.Scheme.Scheme != tpm2.AsymSchemeNull {
+				return tpm2.ErrorScheme
+			}
+		} else if params.Symmetric.Algorithm != tpm2.SymObjectAlgorithmNull {
+			return tpm2.ErrorSymmetric
+		}
+		if rc := checkSymDefObject(&params.Symmetric); rc != 0 {
+			return rc
+		}
+
+		switch {
+		case attrs&tpm2.AttrSign != 0 && attrs&tpm2.AttrDecrypt != 0:
+			if params.Scheme.Scheme != tpm2.AsymSchemeNull {
+				return tpm2.ErrorScheme
+			}
+		case attrs&tpm2.AttrSign != 0:
+			if rc := checkSigScheme(public.Type, params.Scheme.Scheme, &params.Scheme.Details); rc != 0 {
+				return rc
+			}
+			if attrs&tpm2.AttrRestricted != 0 && params.Scheme.Scheme == tpm2.AsymSchemeNull {
+				return tpm2.ErrorScheme
+			}
+		default:
+			switch {
+			case params.Scheme.Scheme == tpm2.AsymSchemeNull:
+			case public.Type == tpm2.ObjectTypeRSA && params.Scheme.Scheme == tpm2.AsymSchemeRSAES:
+			case public.Type == tpm2.ObjectTypeRSA && params.Scheme.Scheme == tpm2.AsymSchemeOAEP:
+				if !isSupportedHashAlg(params.Scheme.Details.OAEP().HashAlg) {
+					return tpm2.ErrorHash
+				}
+			case public.Type == tpm2.ObjectTypeECC && params.Scheme.Scheme == tpm2.AsymSchemeECDH:
+			default:
+				return tpm2.ErrorScheme
+			}
+		}
+
+		if public.Type == tpm2.ObjectTypeRSA {
+			rsaParams := public.Params.RSADetail()
+			switch rsaParams.KeyBits {
+			case 1024, 2048, 3072:
+			default:
+				return tpm2.ErrorValue
+			}
+			if rsaParams.Exponent != 0 && rsaParams.Exponent != tpm2.DefaultRSAExponent {
+				return tpm2.ErrorValue
+			}
+		} else {
+			eccParams := public.Params.ECCDetail()
+			if !isSupportedCurve(eccParams.CurveID) {
+				return tpm2.ErrorCurve
+			}
+			if eccParams.KDF.Scheme != tpm2.KDFAlgorithmNull {
+				return tpm2.ErrorKDF
+			}
+		}
+	case tpm2.ObjectTypeKeyedHash:
+		scheme := &public.Params.KeyedHashDetail().Scheme
+		switch {
+		case attrs&tpm2.AttrSign != 0 && attrs&tpm2.AttrDecrypt != 0:
+			// An unrestricted key can be used for signing and decryption, but can't have a scheme.
+			if scheme.Scheme != tpm2.KeyedHashSchemeNull {
+				return tpm2.ErrorScheme
+			}
+		case attrs&tpm2.AttrSign != 0:
+			switch scheme.Scheme {
+			case tpm2.KeyedHashSchemeHMAC:
+				if !isSupportedHashAlg(scheme.Details.HMAC().HashAlg) {
+					return tpm2.ErrorHash
+				}
+			case tpm2.KeyedHashSchemeNull:
+				if attrs&tpm2.AttrRestricted != 0 {
+					return tpm2.ErrorScheme
+				}
+			default:
+				return tpm2.ErrorScheme
+			}
+		case attrs&tpm2.AttrDecrypt != 0:
+			// A decrypt key, such as a derivation parent, must have the XOR scheme.
+			if scheme.Scheme != tpm2.KeyedHashSchemeXOR {
+				return tpm2.ErrorScheme
+			}
+			xor := scheme.Details.XOR()
+			if !isSupportedHashAlg(xor.HashAlg) {
+				return tpm2.ErrorHash
+			}
+			if xor.KDF != tpm2.KDFAlgorithmKDF1_SP800_108 {
+				return tpm2.ErrorKDF
+			}
+		default:
+			if scheme.Scheme != tpm2.KeyedHashSchemeNull || attrs&tpm2.AttrRestricted != 0 {
+				return tpm2.ErrorAttributes
+			}
+		}
+	case tpm2.ObjectTypeSymCipher:
+		sym := &public.Params.SymDetail().Sym
+		if sym.Algorithm == tpm2.SymObjectAlgorithmNull {
+			return tpm2.ErrorSymmetric
+		}
+		if rc := checkSymDefObject(sym); rc != 0 {
+			return rc
+		}
+	default:
+		return tpm2.ErrorType
+	}
+
+	return 0
+}
+
+// generateSensitive creates the sensitive area for a new object from the supplied template and sensitive parameters, and
+// updates the unique field of the template. For primary objects, secrets is non-nil and the generated secrets are recorded
+// there.
+func generateSensitive(public *tpm2.Public, inSensitive *tpm2.SensitiveCreate, cached *primarySecrets) (*tpm2.Sensitive, *primarySecrets, tpm2.ErrorCode) {
+	sensitive := &tpm2.Sensitive{Type: public.Type, AuthValue: trimAuthValue(inSensitive.UserAuth)}
+	if len(sensitive.AuthValue) > public.NameAlg.Size() {
+		return nil, nil, tpm2.ErrorSize
+	}
+
+	attrs := public.Attrs
+	sensitiveDataOrigin := attrs&tpm2.AttrSensitiveDataOrigin != 0
+
+	switch public.Type {
+	case tpm2.ObjectTypeRSA, tpm2.ObjectTypeECC:
+		if len(inSensitive.Data) > 0 {
+			return nil, nil, tpm2.ErrorSize
+		}
+		if !sensitiveDataOrigin {
+			return nil, nil, tpm2.ErrorAttributes
+		}
+	case tpm2.ObjectTypeKeyedHash:
+		sealed := attrs&(tpm2.AttrSign|tpm2.AttrDecrypt) == 0
+		if sensitiveDataOrigin && (sealed || len(inSensitive.Data) > 0) {
+			return nil, nil, tpm2.ErrorAttributes
+		}
+		if !sensitiveDataOrigin && !sealed && len(inSensitive.Data) == 0 {
+			return nil, nil, tpm2.ErrorSize
+		}
+	case tpm2.ObjectTypeSymCipher:
+		if sensitiveDataOrigin == (len(inSensitive.Data) > 0) {
+			return nil, nil, tpm2.ErrorAttributes
+		}
+		if len(inSensitive.Data) > 0 && len(inSensitive.Data) != int(public.Params.SymDetail().Sym.KeyBits.Sym()/8) {
+			return nil, nil, tpm2.ErrorSize
+		}
+	}
+
+	if cached != nil {
+		sensitive.SeedValue = cached.seedValue
+		sensitive.Sensitive = cached.sensitive
+		public.Unique = cached.unique
+		return sensitive, cached, 0
+	}
+
+	switch public.Type {
+	case tpm2.ObjectTypeRSA:
+		params := public.Params.RSADetail()
+		key, err := rsa.GenerateKey(rand.Reader, int(params.KeyBits))
+		if err != nil {
+			return nil, nil, tpm2.ErrorFailure
+		}
+		public.Unique.Data = tpm2.PublicKeyRSA(key.N.Bytes())
+		sensitive.Sensitive.Data = tpm2.PrivateKeyRSA(padBytes(key.Primes[0].Bytes(), int(params.KeyBits)/16))
+		if attrs&tpm2.AttrRestricted != 0 && attrs&tpm2.AttrDecrypt != 0 {
+			sensitive.SeedValue = random(public.NameAlg.Size())
+		}
+	case tpm2.ObjectTypeECC:
+		curve := public.Params.ECCDetail().CurveID.GoCurve()
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, tpm2.ErrorFailure
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		public.Unique.Data = &tpm2.ECCPoint{X: padBytes(key.X.Bytes(), size), Y: padBytes(key.Y.Bytes(), size)}
+		sensitive.Sensitive.Data = tpm2.ECCParameter(padBytes(key.D.Bytes(), size))
+		if attrs&tpm2.AttrRestricted != 0 && attrs&tpm2.AttrDecrypt != 0 {
+			sensitive.SeedValue = random(public.NameAlg.Size())
+		}
+	case tpm2.ObjectTypeKeyedHash:
+		data := inSensitive.Data
+		if sensitiveDataOrigin {
+			alg := public.NameAlg
+			if scheme := &public.Params.KeyedHashDetail().Scheme; scheme.Scheme == tpm2.KeyedHashSchemeHMAC {
+				alg = scheme.Details.HMAC().HashAlg
+			}
+			data = random(alg.Size())
+		}
+		sensitive.SeedValue = random(public.NameAlg.Size())
+		sensitive.Sensitive.Data = tpm2.SensitiveData(data)
+		h := public.NameAlg.NewHash()
+		h.Write(sensitive.SeedValue)
+		h.Write(data)
+		public.Unique.Data = tpm2.Digest(h.Sum(nil))
+	case tpm2.ObjectTypeSymCipher:
+		key := inSensitive.Data
+		if sensitiveDataOrigin {
+			key = random(int(public.Params.SymDetail().Sym.KeyBits.Sym() / 8))
+		}
+		sensitive.SeedValue = random(public.NameAlg.Size())
+		sensitive.Sensitive.Data = tpm2.SymKey(key)
+		h := public.NameAlg.NewHash()
+		h.Write(sensitive.SeedValue)
+		h.Write(key)
+		public.Unique.Data = tpm2.Digest(h.Sum(nil))
+	}
+
+	return sensitive, &primarySecrets{unique: public.Unique, seedValue: sensitive.SeedValue, sensitive: sensitive.Sensitive}, 0
+}
+
+// primaryCacheKey returns the key used to cache the secrets for the primary object created from the supplied seed, template and
+// sensitive data.
+func primaryCacheKey(seed []byte, template *tpm2.Public, data tpm2.SensitiveData) string {
+	h := sha256.New()
+	h.Write(seed)
+	mu.MarshalToWriter(h, template, data)
+	return string(h.Sum(nil))
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
+
+// checkSensitive checks that the supplied sensitive area is consistent with the supplied public area.
+func checkSensitive(public *tpm2.Public, sensitive *tpm2.Sensitive) bool {
+	if sensitive.Type != public.Type || len(sensitive.AuthValue) > public.NameAlg.Size() {
+		return false
+	}
+	switch public.Type {
+	case tpm2.ObjectTypeRSA:
+		key := rsaPrivateKey(public, sensitive)
+		return key != nil
+	case tpm2.ObjectTypeECC:
+		key := eccPrivateKey(public, sensitive)
+		return key != nil
+	default:
+		h := public.NameAlg.NewHash()
+		h.Write(sensitive.SeedValue)
+		h.Write(sensitive.Sensitive.Any())
+		return bytes.Equal(h.Sum(nil), public.Unique.Data.(tpm2.Digest))
+	}
+}
+
+// computeTicket computes the HMAC for a ticket using the proof value of the specified hierarchy.
+func (s *Simulator) computeTicket(hierarchy tpm2.Handle, alg tpm2.HashAlgorithmId, data ...interface{}) tpm2.Digest {
+	h := hmac.New(alg.NewHash, s.hierarchyProof(hierarchy))
+	mu.MarshalToWriter(h, data...)
+	return h.Sum(nil)
+}
+
+// hierarchyProof returns the proof value for the specified hierarchy.
+func (s *Simulator) hierarchyProof(hierarchy tpm2.Handle) []byte {
+	if h := s.permanentHierarchy(hierarchy); h != nil {
+		return h.proof
+	}
+	return s.null.proof
+}
+
+// object returns the object associated with the command handle at the specified index.
+func (c *commandContext) object(i int) (*object, tpm2.ResponseCode) {
+	o := c.entities[i].object
+	if o == nil {
+		return nil, handleRC(tpm2.ErrorValue, i+1)
+	}
+	if o.sequence != nil {
+		return nil, errorRC(tpm2.ErrorSequence)
+	}
+	return o, tpm2.Success
+}
+
+// storageParent returns the storage parent associated with the command handle at the specified index.
+func (c *commandContext) storageParent(i int) (*object, tpm2.ResponseCode) {
+	o, rc := c.object(i)
+	if rc != tpm2.Success {
+		return nil, rc
+	}
+	if !o.isStorageParent() {
+		return nil, handleRC(tpm2.ErrorType, i+1)
+	}
+	return o, tpm2.Success
+}
+
+// availableObjectHandle returns a free transient object handle.
+func (s *Simulator) availableObjectHandle() (tpm2.Handle, tpm2.ResponseCode) {
+	if len(s.objects) >= maxTransientObjects {
+		return tpm2.HandleUnassigned, warningRC(tpm2.WarningObjectMemory)
+	}
+	for h := tpm2.HandleTypeTransient.BaseHandle(); ; h++ {
+		if _, inUse := s.objects[h]; !inUse {
+			return h, tpm2.Success
+		}
+	}
+}
+
+// generateErrorRC returns the response code for an error returned from generateSensitive.
+func generateErrorRC(code tpm2.ErrorCode) tpm2.ResponseCode {
+	if code == tpm2.ErrorAttributes {
+		return paramRC(code, 2)
+	}
+	return paramRC(code, 1)
+}
+
+// createCreationData creates the creation data for a new object with the specified public area and parent, and returns it along
+// with its digest.
+func (s *Simulator) createCreationData(c *commandContext, public *tpm2.Public, parent *entity, outsideInfo tpm2.Data,
+	creationPCR tpm2.PCRSelectionList) (*tpm2.CreationData, tpm2.Digest, tpm2.ResponseCode) {
+	pcrDigest, code := s.computePCRDigest(public.NameAlg, creationPCR)
+	if code != 0 {
+		return nil, nil, paramRC(code, 4)
+	}
+
+	data := &tpm2.CreationData{
+		PCRSelect:           creationPCR,
+		PCRDigest:           pcrDigest,
+		Locality:            tpm2.Locality(1 << c.locality),
+		ParentNameAlg:       tpm2.AlgorithmNull,
+		ParentName:          parent.name(),
+		ParentQualifiedName: parent.name(),
+		OutsideInfo:         outsideInfo}
+	if parent.object != nil {
+		data.ParentNameAlg = tpm2.AlgorithmId(parent.object.public.NameAlg)
+		data.ParentQualifiedName = parent.object.qualifiedName
+	}
+
+	h := public.NameAlg.NewHash()
+	if _, err := mu.MarshalToWriter(h, data); err != nil {
+		return nil, nil, errorRC(tpm2.ErrorFailure)
+	}
+	return data, h.Sum(nil), tpm2.Success
+}
+
+// creationTicket returns a ticket that associates the supplied creation data digest with the specified object.
+func (s *Simulator) creationTicket(hierarchy tpm2.Handle, o *object, creationHash tpm2.Digest) *tpm2.TkCreation {
+	return &tpm2.TkCreation{
+		Tag:       tpm2.TagCreation,
+		Hierarchy: hierarchy,
+		Digest:    s.computeTicket(hierarchy, o.public.NameAlg, tpm2.TagCreation, o.name, creationHash)}
+}
+
+func (s *Simulator) createPrimary(c *commandContext) tpm2.ResponseCode {
+	var inSensitive sensitiveCreateSized
+	var inPublic publicSized
+	var outsideInfo tpm2.Data
+	var creationPCR tpm2.PCRSelectionList
+	if rc := c.unmarshalParams(&inSensitive, &inPublic, &outsideInfo, &creationPCR); rc != tpm2.Success {
+		return rc
+	}
+
+	hierarchy := c.entities[0].hierarchy
+	if hierarchy == nil || hierarchy.handle == tpm2.HandleLockout {
+		return handleRC(tpm2.ErrorValue, 1)
+	}
+	if inSensitive.Ptr == nil {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	if inPublic.Ptr == nil {
+		return paramRC(tpm2.ErrorSize, 2)
+	}
+	public := inPublic.Ptr
+	if code := checkPublic(public, true); code != 0 {
+		return paramRC(code, 2)
+	}
+
+	handle, rc := s.availableObjectHandle()
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	cacheKey := primaryCacheKey(hierarchy.seed, public, inSensitive.Ptr.Data)
+	sensitive, secrets, code := generateSensitive(public, inSensitive.Ptr, s.primaryKeys[cacheKey])
+	if code != 0 {
+		return generateErrorRC(code)
+	}
+	s.primaryKeys[cacheKey] = secrets
+
+	o, err := newObject(public, sensitive, hierarchy.handle, handleName(hierarchy.handle))
+	if err != nil {
+		return errorRC(tpm2.ErrorFailure)
+	}
+
+	creationData, creationHash, rc := s.createCreationData(c, public, c.entities[0], outsideInfo, creationPCR)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	s.objects[handle] = o
+	c.rHandle = handle
+	return c.respond(publicSized{public}, creationDataSized{creationData}, creationHash,
+		s.creationTicket(hierarchy.handle, o, creationHash), o.name)
+}
+
+func (s *Simulator) create(c *commandContext) tpm2.ResponseCode {
+	var inSensitive sensitiveCreateSized
+	var inPublic publicSized
+	var outsideInfo tpm2.Data
+	var creationPCR tpm2.PCRSelectionList
+	if rc := c.unmarshalParams(&inSensitive, &inPublic, &outsideInfo, &creationPCR); rc != tpm2.Success {
+		return rc
+	}
+
+	parent, rc := c.storageParent(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if inSensitive.Ptr == nil {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	if inPublic.Ptr == nil {
+		return paramRC(tpm2.ErrorSize, 2)
+	}
+	public := inPublic.Ptr
+	if code := checkPublic(public, parent.public.Attrs&tpm2.AttrFixedTPM != 0); code != 0 {
+		return paramRC(code, 2)
+	}
+
+	sensitive, _, code := generateSensitive(public, inSensitive.Ptr, nil)
+	if code != 0 {
+		return generateErrorRC(code)
+	}
+
+	o, err := newObject(public, sensitive, parent.hierarchy, parent.qualifiedName)
+	if err != nil {
+		return errorRC(tpm2.ErrorFailure)
+	}
+	private, err := wrapSensitive(parent, o.name, sensitive)
+	if err != nil {
+		return errorRC(tpm2.ErrorFailure)
+	}
+
+	creationData, creationHash, rc := s.createCreationData(c, public, c.entities[0], outsideInfo, creationPCR)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	return c.respond(tpm2.Private(private), publicSized{public}, creationDataSized{creationData}, creationHash,
+		s.creationTicket(parent.hierarchy, o, creationHash))
+}
+
+func (s *Simulator) load(c *commandContext) tpm2.ResponseCode {
+	var inPrivate tpm2.Private
+	var inPublic publicSized
+	if rc := c.unmarshalParams(&inPrivate, &inPublic); rc != tpm2.Success {
+		return rc
+	}
+
+	parent, rc := c.storageParent(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if inPublic.Ptr == nil {
+		return paramRC(tpm2.ErrorSize, 2)
+	}
+	public := inPublic.Ptr
+	if code := checkPublic(public, parent.public.Attrs&tpm2.AttrFixedTPM != 0); code != 0 {
+		return paramRC(code, 2)
+	}
+
+	handle, rc := s.availableObjectHandle()
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	name, err := public.Name()
+	if err != nil {
+		return paramRC(tpm2.ErrorHash, 2)
+	}
+	sensitive, code := unwrapSensitive(parent, name, inPrivate)
+	if code != 0 {
+		return paramRC(code, 1)
+	}
+	if !checkSensitive(public, sensitive) {
+		return paramRC(tpm2.ErrorBinding, 2)
+	}
+
+	o, err := newObject(public, sensitive, parent.hierarchy, parent.qualifiedName)
+	if err != nil {
+		return errorRC(tpm2.ErrorFailure)
+	}
+	s.objects[handle] = o
+	c.rHandle = handle
+	return c.respond(o.name)
+}
+
+func (s *Simulator) loadExternal(c *commandContext) tpm2.ResponseCode {
+	var inPrivate sensitiveSized
+	var inPublic publicSized
+	var hierarchy tpm2.Handle
+	if rc := c.unmarshalParams(&inPrivate, &inPublic, &hierarchy); rc != tpm2.Success {
+		return rc
+	}
+
+	switch hierarchy {
+	case tpm2.HandleOwner, tpm2.HandleEndorsement, tpm2.HandlePlatform, tpm2.HandleNull:
+	default:
+		return paramRC(tpm2.ErrorValue, 3)
+	}
+	if !s.isHierarchyEnabled(hierarchy) {
+		return paramRC(tpm2.ErrorHierarchy, 3)
+	}
+	if inPublic.Ptr == nil {
+		return paramRC(tpm2.ErrorSize, 2)
+	}
+	public := inPublic.Ptr
+	if code := checkPublic(public, true); code != 0 {
+		return paramRC(code, 2)
+	}
+
+	if inPrivate.Ptr != nil {
+		if hierarchy != tpm2.HandleNull {
+			return paramRC(tpm2.ErrorHierarchy, 3)
+		}
+		if public.Attrs&(tpm2.AttrFixedTPM|tpm2.AttrFixedParent|tpm2.AttrRestricted) != 0 {
+			return paramRC(tpm2.ErrorAttributes, 2)
+		}
+		if !checkSensitive(public, inPrivate.Ptr) {
+			return paramRC(tpm2.ErrorBinding, 2)
+		}
+	} else if !checkPublicKey(public) {
+		return paramRC(tpm2.ErrorKey, 2)
+	}
+
+	handle, rc := s.availableObjectHandle()
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	o, err := newObject(public, inPrivate.Ptr, hierarchy, handleName(hierarchy))
+	if err != nil {
+		return errorRC(tpm2.ErrorFailure)
+	}
+	o.external = true
+	s.objects[handle] = o
+	c.rHandle = handle
+	return c.respond(o.name)
+}
+
+func (s *Simulator) readPublic(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	o, rc := c.object(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(publicSized{o.public}, o.name, o.qualifiedName)
+}
+
+func (s *Simulator) objectChangeAuth(c *commandContext) tpm2.ResponseCode {
+	var newAuth tpm2.Auth
+	if rc := c.unmarshalParams(&newAuth); rc != tpm2.Success {
+		return rc
+	}
+
+	o, rc := c.object(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if o.sensitive == nil {
+		return handleRC(tpm2.ErrorType, 1)
+	}
+	parent, rc := c.storageParent(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if !bytes.Equal(computeQualifiedName(o.public.NameAlg, parent.qualifiedName, o.name), o.qualifiedName) {
+		return handleRC(tpm2.ErrorType, 2)
+	}
+
+	newAuth = trimAuthValue(newAuth)
+	if len(newAuth) > o.public.NameAlg.Size() {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+
+	sensitive := *o.sensitive
+	sensitive.AuthValue = newAuth
+	private, err := wrapSensitive(parent, o.name, &sensitive)
+	if err != nil {
+		return errorRC(tpm2.ErrorFailure)
+	}
+	return c.respond(tpm2.Private(private))
+}
+
+// credentialKey returns the key associated with the command handle at the specified index, which is used to protect credentials.
+// This must be an asymmetric restricted decrypt key.
+func (c *commandContext) credentialKey(i int) (*object, tpm2.ResponseCode) {
+	o, rc := c.object(i)
+	if rc != tpm2.Success {
+		return nil, rc
+	}
+	if !isStorageKey(o.public) {
+		return nil, handleRC(tpm2.ErrorType, i+1)
+	}
+	return o, tpm2.Success
+}
+
+func (s *Simulator) activateCredential(c *commandContext) tpm2.ResponseCode {
+	var credentialBlob tpm2.IDObjectRaw
+	var secret tpm2.EncryptedSecret
+	if rc := c.unmarshalParams(&credentialBlob, &secret); rc != tpm2.Success {
+		return rc
+	}
+
+	activate, rc := c.object(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	key, rc := c.credentialKey(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if key.sensitive == nil {
+		return handleRC(tpm2.ErrorType, 2)
+	}
+
+	seed, code := decryptSecret(key, secret, "IDENTITY")
+	if code != 0 {
+		return paramRC(code, 2)
+	}
+	data, code := outerUnwrap(key.public.NameAlg, key.parentSymmetric(), seed, activate.name, credentialBlob)
+	if code != 0 {
+		return paramRC(code, 1)
+	}
+
+	var credential tpm2.Digest
+	if _, err := mu.UnmarshalFromBytes(data, &credential); err != nil {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	return c.respond(credential)
+}
+
+func (s *Simulator) makeCredential(c *commandContext) tpm2.ResponseCode {
+	var credential tpm2.Digest
+	var objectName tpm2.Name
+	if rc := c.unmarshalParams(&credential, &objectName); rc != tpm2.Success {
+		return rc
+	}
+
+	// Only the public part of the key is required.
+	key, rc := c.credentialKey(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if len(credential) > key.public.NameAlg.Size() {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+
+	seed, secret, code := encryptSecret(key, "IDENTITY")
+	if code != 0 {
+		return handleRC(code, 1)
+	}
+	data, err := mu.MarshalToBytes(credential)
+	if err != nil {
+		return errorRC(tpm2.ErrorFailure)
+	}
+	credentialBlob, err := outerWrap(key.public.NameAlg, key.parentSymmetric(), seed, objectName, data)
+	if err != nil {
+		return errorRC(tpm2.ErrorFailure)
+	}
+	return c.respond(tpm2.IDObjectRaw(credentialBlob), secret)
+}
+
+func (s *Simulator) unseal(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	o, rc := c.object(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if o.public.Type != tpm2.ObjectTypeKeyedHash {
+		return handleRC(tpm2.ErrorType, 1)
+	}
+	if o.public.Attrs&(tpm2.AttrRestricted|tpm2.AttrSign|tpm2.AttrDecrypt) != 0 {
+		return handleRC(tpm2.ErrorAttributes, 1)
+	}
+	return c.respond(tpm2.SensitiveData(o.sensitive.Sensitive.Bits()))
+}
+
+// signingKey returns the signing key associated with the command handle at the specified index.
+func (c *commandContext) signingKey(i int) (*object, tpm2.ResponseCode) {
+	o, rc := c.object(i)
+	if rc != tpm2.Success {
+		return nil, rc
+	}
+	if o.sensitive == nil || o.public.Attrs&tpm2.AttrSign == 0 {
+		return nil, handleRC(tpm2.ErrorKey, i+1)
+	}
+	return o, tpm2.Success
+}
+
+func (s *Simulator) sign(c *commandContext) tpm2.ResponseCode {
+	var digest tpm2.Digest
+	var inScheme tpm2.SigScheme
+	var validation tpm2.TkHashcheck
+	if rc := c.unmarshalParams(&digest, &inScheme, &validation); rc != tpm2.Success {
+		return rc
+	}
+
+	o, rc := c.signingKey(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	scheme, code := signingScheme(o, &inScheme)
+	if code != 0 {
+		return paramRC(code, 2)
+	}
+	if len(digest) != scheme.Details.Any().HashAlg.Size() {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	if o.public.Attrs&tpm2.AttrRestricted != 0 && !s.checkHashcheckTicket(&validation, scheme.Details.Any().HashAlg, digest) {
+		// Restricted keys can only sign digests produced by the TPM from data that doesn't start with TPM_GENERATED_VALUE.
+		return paramRC(tpm2.ErrorTicket, 3)
+	}
+
+	sig, code := signDigest(o, scheme, digest)
+	if code != 0 {
+		return errorRC(code)
+	}
+	return c.respond(sig)
+}
+
+func (s *Simulator) verifySignature(c *commandContext) tpm2.ResponseCode {
+	var digest tpm2.Digest
+	var signature tpm2.Signature
+	if rc := c.unmarshalParams(&digest, &signature); rc != tpm2.Success {
+		return rc
+	}
+
+	o, rc := c.object(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if o.public.Attrs&tpm2.AttrSign == 0 {
+		return handleRC(tpm2.ErrorAttributes, 1)
+	}
+	if !verifyDigest(o, digest, &signature) {
+		return paramRC(tpm2.ErrorSignature, 2)
+	}
+
+	ticket := &tpm2.TkVerified{Tag: tpm2.TagVerified, Hierarchy: o.hierarchy}
+	if o.hierarchy != tpm2.HandleNull {
+		ticket.Digest = s.computeTicket(o.hierarchy, o.public.NameAlg, tpm2.TagVerified, digest, o.name)
+	}
+	return c.respond(ticket)
+}
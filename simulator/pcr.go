@@ -0,0 +1,236 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"sort"
+
+	"github.com/canonical/go-tpm2"
+)
+
+const maxPCRReadDigests = 8 // The maximum number of digests returned from TPM2_PCR_Read
+
+// pcrExtendLocalities returns a bitmask of the localities from which the specified PCR can be extended, based on the PC Client
+// platform specification.
+func pcrExtendLocalities(pcr int) uint8 {
+	switch pcr {
+	case 17, 18:
+		return 1<<3 | 1<<4
+	case 19:
+		return 1<<2 | 1<<3 | 1<<4
+	case 20:
+		return 1<<1 | 1<<2 | 1<<3 | 1<<4
+	case 21, 22:
+		return 1 << 2
+	default:
+		return 0x1f
+	}
+}
+
+// pcrResetLocalities returns a bitmask of the localities from which the specified PCR can be reset with TPM2_PCR_Reset, based on
+// the PC Client platform specification.
+func pcrResetLocalities(pcr int) uint8 {
+	switch pcr {
+	case 16, 23:
+		return 0x1f
+	case 20:
+		return 1 << 2
+	case 21, 22:
+		return 1 << 2
+	default:
+		return 0
+	}
+}
+
+// pcrNoIncrement indicates whether changes to the specified PCR increment the PCR update counter.
+func pcrNoIncrement(pcr int) bool {
+	switch pcr {
+	case 16, 21, 22, 23:
+		return true
+	default:
+		return false
+	}
+}
+
+// pcrInitialValue returns the value of the specified PCR after TPM2_Startup(TPM_SU_CLEAR).
+func pcrInitialValue(alg tpm2.HashAlgorithmId, pcr int) []byte {
+	v := make([]byte, alg.Size())
+	if pcr >= 17 && pcr <= 22 {
+		for i := range v {
+			v[i] = 0xff
+		}
+	}
+	return v
+}
+
+// resetPCRs initializes all PCRs to their default values.
+func (s *Simulator) resetPCRs() {
+	s.pcrs = make(map[tpm2.HashAlgorithmId][][]byte)
+	for _, alg := range pcrBanks {
+		bank := make([][]byte, numPCRs)
+		for i := range bank {
+			bank[i] = pcrInitialValue(alg, i)
+		}
+		s.pcrs[alg] = bank
+	}
+	s.pcrCounter = 0
+}
+
+// copyPCRs returns a copy of the current PCR values.
+func (s *Simulator) copyPCRs() map[tpm2.HashAlgorithmId][][]byte {
+	out := make(map[tpm2.HashAlgorithmId][][]byte)
+	for alg, bank := range s.pcrs {
+		b := make([][]byte, len(bank))
+		for i, v := range bank {
+			b[i] = append([]byte(nil), v...)
+		}
+		out[alg] = b
+	}
+	return out
+}
+
+// pcrChanged increments the PCR update counter if required after the specified PCR has been modified.
+func (s *Simulator) pcrChanged(pcr int) {
+	if !pcrNoIncrement(pcr) {
+		s.pcrCounter++
+	}
+}
+
+// extendPCR extends the specified PCR in the bank for the specified algorithm, if it exists. As with the reference
+// implementation, the PCR update counter is incremented once for each bank that is extended.
+func (s *Simulator) extendPCR(pcr int, alg tpm2.HashAlgorithmId, digest []byte) {
+	bank, ok := s.pcrs[alg]
+	if !ok {
+		return
+	}
+	h := alg.NewHash()
+	h.Write(bank[pcr])
+	h.Write(digest)
+	bank[pcr] = h.Sum(nil)
+	s.pcrChanged(pcr)
+}
+
+// computePCRDigest computes the digest of the selected PCRs with the specified algorithm.
+func (s *Simulator) computePCRDigest(alg tpm2.HashAlgorithmId, pcrs tpm2.PCRSelectionList) (tpm2.Digest, tpm2.ErrorCode) {
+	h := alg.NewHash()
+	for _, selection := range pcrs {
+		bank, ok := s.pcrs[selection.Hash]
+		if !ok {
+			return nil, tpm2.ErrorPCR
+		}
+		sel := append([]int(nil), selection.Select...)
+		sort.Ints(sel)
+		for _, i := range sel {
+			if i >= numPCRs {
+				return nil, tpm2.ErrorPCR
+			}
+			h.Write(bank[i])
+		}
+	}
+	return h.Sum(nil), 0
+}
+
+func (s *Simulator) pcrRead(c *commandContext) tpm2.ResponseCode {
+	var pcrSelectionIn tpm2.PCRSelectionList
+	if rc := c.unmarshalParams(&pcrSelectionIn); rc != tpm2.Success {
+		return rc
+	}
+
+	var pcrSelectionOut tpm2.PCRSelectionList
+	var values tpm2.DigestList
+	for _, selection := range pcrSelectionIn {
+		out := tpm2.PCRSelection{Hash: selection.Hash, Select: tpm2.PCRSelect{}}
+		bank, ok := s.pcrs[selection.Hash]
+		if ok {
+			sel := append([]int(nil), selection.Select...)
+			sort.Ints(sel)
+			for _, i := range sel {
+				if i >= numPCRs || len(values) >= maxPCRReadDigests {
+					continue
+				}
+				out.Select = append(out.Select, i)
+				values = append(values, append(tpm2.Digest(nil), bank[i]...))
+			}
+		}
+		pcrSelectionOut = append(pcrSelectionOut, out)
+	}
+
+	return c.respond(s.pcrCounter, pcrSelectionOut, values)
+}
+
+func (s *Simulator) pcrExtend(c *commandContext) tpm2.ResponseCode {
+	var digests tpm2.TaggedHashList
+	if rc := c.unmarshalParams(&digests); rc != tpm2.Success {
+		return rc
+	}
+
+	if c.handles[0] == tpm2.HandleNull {
+		return tpm2.Success
+	}
+	if c.handles[0].Type() != tpm2.HandleTypePCR {
+		return handleRC(tpm2.ErrorValue, 1)
+	}
+	pcr := int(c.handles[0])
+	if pcrExtendLocalities(pcr)&(1<<c.locality) == 0 {
+		return warningRC(tpm2.WarningLocality)
+	}
+
+	for _, d := range digests {
+		s.extendPCR(pcr, d.HashAlg, d.Digest)
+	}
+	return tpm2.Success
+}
+
+func (s *Simulator) pcrEvent(c *commandContext) tpm2.ResponseCode {
+	var eventData tpm2.Event
+	if rc := c.unmarshalParams(&eventData); rc != tpm2.Success {
+		return rc
+	}
+	if len(eventData) > tpm2.EventMaxSize {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+
+	var digests tpm2.TaggedHashList
+	for _, alg := range pcrBanks {
+		h := alg.NewHash()
+		h.Write(eventData)
+		digests = append(digests, tpm2.TaggedHash{HashAlg: alg, Digest: h.Sum(nil)})
+	}
+
+	if c.handles[0] != tpm2.HandleNull {
+		if c.handles[0].Type() != tpm2.HandleTypePCR {
+			return handleRC(tpm2.ErrorValue, 1)
+		}
+		pcr := int(c.handles[0])
+		if pcrExtendLocalities(pcr)&(1<<c.locality) == 0 {
+			return warningRC(tpm2.WarningLocality)
+		}
+		for _, d := range digests {
+			s.extendPCR(pcr, d.HashAlg, d.Digest)
+		}
+	}
+
+	return c.respond(digests)
+}
+
+func (s *Simulator) pcrReset(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+
+	if c.handles[0].Type() != tpm2.HandleTypePCR {
+		return handleRC(tpm2.ErrorValue, 1)
+	}
+	pcr := int(c.handles[0])
+	if pcrResetLocalities(pcr)&(1<<c.locality) == 0 {
+		return warningRC(tpm2.WarningLocality)
+	}
+
+	for alg, bank := range s.pcrs {
+		bank[pcr] = make([]byte, alg.Size())
+	}
+	s.pcrChanged(pcr)
+	return tpm2.Success
+}
@@ -0,0 +1,746 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"time"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+// extendPolicy updates the policy digest of this session with the supplied command code and data.
+func (s *session) extendPolicy(code tpm2.CommandCode, data ...[]byte) {
+	h := s.hashAlg.NewHash()
+	h.Write(s.policyDigest)
+	binary.Write(h, binary.BigEndian, code)
+	for _, d := range data {
+		h.Write(d)
+	}
+	s.policyDigest = h.Sum(nil)
+}
+
+// extendPolicyRef performs the second step of the policy update for TPM2_PolicySigned, TPM2_PolicySecret and
+// TPM2_PolicyAuthorize.
+func (s *session) extendPolicyRef(policyRef tpm2.Nonce) {
+	h := s.hashAlg.NewHash()
+	h.Write(s.policyDigest)
+	h.Write(policyRef)
+	s.policyDigest = h.Sum(nil)
+}
+
+// setExpiration records the expiration time of a policy session.
+func (s *session) setExpiration(expiration int32, relativeToStart bool) {
+	if expiration == 0 {
+		return
+	}
+	if expiration < 0 {
+		expiration = -expiration
+	}
+	base := time.Now()
+	if relativeToStart {
+		base = s.startTime
+	}
+	timeout := base.Add(time.Duration(expiration) * time.Second)
+	if s.timeout.IsZero() || timeout.Before(s.timeout) {
+		s.timeout = timeout
+	}
+}
+
+// policySession returns the policy session associated with the command handle at the specified index.
+func (c *commandContext) policySession(i int) (*session, tpm2.ResponseCode) {
+	session := c.entities[i].session
+	if session == nil || session.typ != tpm2.SessionTypePolicy {
+		return nil, handleRC(tpm2.ErrorValue, i+1)
+	}
+	return session, tpm2.Success
+}
+
+// entityHierarchy returns the hierarchy associated with the specified entity, for the purposes of producing tickets.
+func entityHierarchy(e *entity) tpm2.Handle {
+	switch {
+	case e.object != nil:
+		return e.object.hierarchy
+	case e.nv != nil:
+		if e.nv.public.Attrs&tpm2.AttrNVPlatformCreate != 0 {
+			return tpm2.HandlePlatform
+		}
+		return tpm2.HandleOwner
+	case e.hierarchy != nil:
+		return e.hierarchy.handle
+	default:
+		return tpm2.HandleNull
+	}
+}
+
+// checkPolicySession checks that the state of the policy session at the specified index in the authorization area satisfies the
+// authorization policy of the associated entity.
+func (s *Simulator) checkPolicySession(c *commandContext, index int, cpBytes []byte) tpm2.ResponseCode {
+	cs := c.sessions[index]
+	session := cs.session
+	n := index + 1
+
+	if !session.timeout.IsZero() && time.Now().After(session.timeout) {
+		return sessionRC(tpm2.ErrorExpired, n)
+	}
+	alg, policy := cs.entity.authPolicy()
+	if alg != session.hashAlg || !bytes.Equal(policy, session.policyDigest) {
+		return sessionRC(tpm2.ErrorPolicyFail, n)
+	}
+	if session.commandCode != 0 && session.commandCode != c.code {
+		return sessionRC(tpm2.ErrorPolicyCC, n)
+	}
+	if (cs.role == authAdmin || cs.role == authDup) && session.commandCode == 0 {
+		return sessionRC(tpm2.ErrorPolicyFail, n)
+	}
+	if session.checkPCR && session.pcrCounter != s.pcrCounter {
+		return errorRC(tpm2.ErrorPCRChanged)
+	}
+	if session.locality != 0 && !localityMatches(session.locality, c.locality) {
+		return warningRC(tpm2.WarningLocality)
+	}
+	if len(session.cpHash) > 0 && !bytes.Equal(session.cpHash, c.cpHash(session.hashAlg, cpBytes)) {
+		return sessionRC(tpm2.ErrorPolicyFail, n)
+	}
+	if len(session.nameHash) > 0 && !bytes.Equal(session.nameHash, c.nameHash(session.hashAlg)) {
+		return sessionRC(tpm2.ErrorPolicyFail, n)
+	}
+	if session.nvWritten != nvWrittenUnset {
+		if cs.entity.nv == nil {
+			return sessionRC(tpm2.ErrorPolicyFail, n)
+		}
+		written := cs.entity.nv.public.Attrs&tpm2.AttrNVWritten != 0
+		if written != (session.nvWritten == nvWrittenSet) {
+			return sessionRC(tpm2.ErrorPolicyFail, n)
+		}
+	}
+	return tpm2.Success
+}
+
+// localityMatches indicates whether the supplied locality is included in the TPMA_LOCALITY value.
+func localityMatches(locality tpm2.Locality, l uint8) bool {
+	if locality >= 32 {
+		return uint8(locality) == l
+	}
+	return l < 5 && locality&(1<<l) != 0
+}
+
+// compareOperands performs the comparison operation for TPM2_PolicyNV and TPM2_PolicyCounterTimer.
+func compareOperands(a, b []byte, op tpm2.ArithmeticOp) (bool, tpm2.ErrorCode) {
+	signed := func(x []byte) *big.Int {
+		v := new(big.Int).SetBytes(x)
+		if len(x) > 0 && x[0]&0x80 != 0 {
+			v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(len(x)*8)))
+		}
+		return v
+	}
+	unsigned := func(x []byte) *big.Int {
+		return new(big.Int).SetBytes(x)
+	}
+
+	switch op {
+	case tpm2.OpEq:
+		return bytes.Equal(a, b), 0
+	case tpm2.OpNeq:
+		return !bytes.Equal(a, b), 0
+	case tpm2.OpSignedGT:
+		return signed(a).Cmp(signed(b)) > 0, 0
+	case tpm2.OpUnsignedGT:
+		return unsigned(a).Cmp(unsigned(b)) > 0, 0
+	case tpm2.OpSignedLT:
+		return signed(a).Cmp(signed(b)) < 0, 0
+	case tpm2.OpUnsignedLT:
+		return unsigned(a).Cmp(unsigned(b)) < 0, 0
+	case tpm2.OpSignedGE:
+		return signed(a).Cmp(signed(b)) >= 0, 0
+	case tpm2.OpUnsignedGE:
+		return unsigned(a).Cmp(unsigned(b)) >= 0, 0
+	case tpm2.OpSignedLE:
+		return signed(a).Cmp(signed(b)) <= 0, 0
+	case tpm2.OpUnsignedLE:
+		return unsigned(a).Cmp(unsigned(b)) <= 0, 0
+	case tpm2.OpBitset:
+		for i := range a {
+			if a[i]&b[i] != b[i] {
+				return false, 0
+			}
+		}
+		return true, 0
+	case tpm2.OpBitclear:
+		for i := range a {
+			if a[i]&b[i] != 0 {
+				return false, 0
+			}
+		}
+		return true, 0
+	default:
+		return false, tpm2.ErrorValue
+	}
+}
+
+func computeOperandArgs(alg tpm2.HashAlgorithmId, operandB tpm2.Operand, offset uint16, operation tpm2.ArithmeticOp) []byte {
+	h := alg.NewHash()
+	h.Write(operandB)
+	binary.Write(h, binary.BigEndian, offset)
+	binary.Write(h, binary.BigEndian, operation)
+	return h.Sum(nil)
+}
+
+func (s *Simulator) policyRestart(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	session.resetPolicy()
+	return tpm2.Success
+}
+
+func (s *Simulator) policyGetDigest(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(session.policyDigest)
+}
+
+func (s *Simulator) policyAuthValue(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	session.extendPolicy(tpm2.CommandPolicyAuthValue)
+	session.authValueNeeded = true
+	session.passwordNeeded = false
+	return tpm2.Success
+}
+
+func (s *Simulator) policyPassword(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	// TPM2_PolicyPassword extends the same value as TPM2_PolicyAuthValue.
+	session.extendPolicy(tpm2.CommandPolicyAuthValue)
+	session.passwordNeeded = true
+	session.authValueNeeded = false
+	return tpm2.Success
+}
+
+func (s *Simulator) policyCommandCode(c *commandContext) tpm2.ResponseCode {
+	var code tpm2.CommandCode
+	if rc := c.unmarshalParams(&code); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if session.commandCode != 0 && session.commandCode != code {
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+	if _, ok := commands[code]; !ok {
+		return paramRC(tpm2.ErrorPolicyCC, 1)
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(code))
+	session.extendPolicy(tpm2.CommandPolicyCommandCode, b[:])
+	session.commandCode = code
+	return tpm2.Success
+}
+
+func (s *Simulator) policyOR(c *commandContext) tpm2.ResponseCode {
+	var pHashList tpm2.DigestList
+	if rc := c.unmarshalParams(&pHashList); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if len(pHashList) < 2 || len(pHashList) > 8 {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+
+	found := session.isTrial
+	for _, d := range pHashList {
+		if len(d) != session.hashAlg.Size() {
+			return paramRC(tpm2.ErrorSize, 1)
+		}
+		if bytes.Equal(d, session.policyDigest) {
+			found = true
+		}
+	}
+	if !found {
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+
+	session.policyDigest = make(tpm2.Digest, session.hashAlg.Size())
+	var digests []byte
+	for _, d := range pHashList {
+		digests = append(digests, d...)
+	}
+	session.extendPolicy(tpm2.CommandPolicyOR, digests)
+	return tpm2.Success
+}
+
+func (s *Simulator) policyPCR(c *commandContext) tpm2.ResponseCode {
+	var pcrDigest tpm2.Digest
+	var pcrs tpm2.PCRSelectionList
+	if rc := c.unmarshalParams(&pcrDigest, &pcrs); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	digest, err := s.computePCRDigest(session.hashAlg, pcrs)
+	if err != 0 {
+		return paramRC(tpm2.ErrorValue, 2)
+	}
+	switch {
+	case session.isTrial && len(pcrDigest) > 0:
+		if len(pcrDigest) != session.hashAlg.Size() {
+			return paramRC(tpm2.ErrorSize, 1)
+		}
+		digest = pcrDigest
+	case len(pcrDigest) > 0 && !bytes.Equal(pcrDigest, digest):
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+
+	pcrBytes, _ := mu.MarshalToBytes(pcrs)
+	session.extendPolicy(tpm2.CommandPolicyPCR, pcrBytes, digest)
+	if !session.isTrial {
+		session.checkPCR = true
+		session.pcrCounter = s.pcrCounter
+	}
+	return tpm2.Success
+}
+
+func (s *Simulator) policyLocality(c *commandContext) tpm2.ResponseCode {
+	var locality tpm2.Locality
+	if rc := c.unmarshalParams(&locality); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	switch {
+	case locality == 0:
+		return paramRC(tpm2.ErrorRange, 1)
+	case locality < 32:
+		if session.locality >= 32 {
+			return paramRC(tpm2.ErrorRange, 1)
+		}
+		if session.locality != 0 {
+			locality &= session.locality
+			if locality == 0 {
+				return paramRC(tpm2.ErrorRange, 1)
+			}
+		}
+	default:
+		if session.locality != 0 && session.locality != locality {
+			return paramRC(tpm2.ErrorRange, 1)
+		}
+	}
+
+	session.extendPolicy(tpm2.CommandPolicyLocality, []byte{uint8(locality)})
+	session.locality = locality
+	return tpm2.Success
+}
+
+func (s *Simulator) policyCpHash(c *commandContext) tpm2.ResponseCode {
+	var cpHashA tpm2.Digest
+	if rc := c.unmarshalParams(&cpHashA); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if len(cpHashA) != session.hashAlg.Size() {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	if len(session.cpHash) > 0 && !bytes.Equal(session.cpHash, cpHashA) {
+		return errorRC(tpm2.ErrorCpHash)
+	}
+	if len(session.nameHash) > 0 {
+		return errorRC(tpm2.ErrorCpHash)
+	}
+	session.extendPolicy(tpm2.CommandPolicyCpHash, cpHashA)
+	session.cpHash = cpHashA
+	return tpm2.Success
+}
+
+func (s *Simulator) policyNameHash(c *commandContext) tpm2.ResponseCode {
+	var nameHash tpm2.Digest
+	if rc := c.unmarshalParams(&nameHash); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if len(nameHash) != session.hashAlg.Size() {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	if len(session.cpHash) > 0 || len(session.nameHash) > 0 {
+		return errorRC(tpm2.ErrorCpHash)
+	}
+	session.extendPolicy(tpm2.CommandPolicyNameHash, nameHash)
+	session.nameHash = nameHash
+	return tpm2.Success
+}
+
+func (s *Simulator) policyDuplicationSelect(c *commandContext) tpm2.ResponseCode {
+	var objectName tpm2.Name
+	var newParentName tpm2.Name
+	var includeObject bool
+	if rc := c.unmarshalParams(&objectName, &newParentName, &includeObject); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if len(session.cpHash) > 0 || len(session.nameHash) > 0 {
+		return errorRC(tpm2.ErrorCpHash)
+	}
+	if session.commandCode != 0 && session.commandCode != tpm2.CommandDuplicate {
+		return errorRC(tpm2.ErrorCommandCode)
+	}
+
+	h := session.hashAlg.NewHash()
+	h.Write(objectName)
+	h.Write(newParentName)
+
+	var data [][]byte
+	var b byte
+	if includeObject {
+		data = append(data, objectName)
+		b = 1
+	}
+	data = append(data, newParentName, []byte{b})
+	session.extendPolicy(tpm2.CommandPolicyDuplicationSelect, data...)
+	session.nameHash = h.Sum(nil)
+	session.commandCode = tpm2.CommandDuplicate
+	return tpm2.Success
+}
+
+func (s *Simulator) policyNvWritten(c *commandContext) tpm2.ResponseCode {
+	var writtenSet bool
+	if rc := c.unmarshalParams(&writtenSet); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	state := nvWrittenClear
+	if writtenSet {
+		state = nvWrittenSet
+	}
+	if session.nvWritten != nvWrittenUnset && session.nvWritten != state {
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+	b := byte(0)
+	if writtenSet {
+		b = 1
+	}
+	session.extendPolicy(tpm2.CommandPolicyNvWritten, []byte{b})
+	session.nvWritten = state
+	return tpm2.Success
+}
+
+// policyAuthCommon performs the checks and updates that are common to TPM2_PolicySigned and TPM2_PolicySecret.
+func (s *Simulator) policyAuthCommon(session *session, nonceTPM tpm2.Nonce, cpHashA tpm2.Digest, expiration int32) tpm2.ResponseCode {
+	if len(nonceTPM) > 0 && !bytes.Equal(nonceTPM, session.nonceTPM) {
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+	if expiration < 0 && len(nonceTPM) == 0 {
+		return paramRC(tpm2.ErrorExpired, 4)
+	}
+	if len(cpHashA) > 0 {
+		if len(cpHashA) != session.hashAlg.Size() {
+			return paramRC(tpm2.ErrorSize, 2)
+		}
+		if len(session.cpHash) > 0 && !bytes.Equal(session.cpHash, cpHashA) {
+			return paramRC(tpm2.ErrorCpHash, 2)
+		}
+	}
+	if expiration != 0 && len(nonceTPM) > 0 {
+		elapsed := time.Since(session.startTime)
+		abs := expiration
+		if abs < 0 {
+			abs = -abs
+		}
+		if elapsed > time.Duration(abs)*time.Second {
+			return paramRC(tpm2.ErrorExpired, 4)
+		}
+	}
+	return tpm2.Success
+}
+
+// authTicket produces the timeout and ticket returned from TPM2_PolicySigned and TPM2_PolicySecret.
+func (s *Simulator) authTicket(session *session, tag tpm2.StructTag, hierarchy tpm2.Handle, expiration int32, cpHashA tpm2.Digest,
+	policyRef tpm2.Nonce, authName tpm2.Name) (tpm2.Timeout, *tpm2.TkAuth) {
+	if expiration >= 0 {
+		return nil, &tpm2.TkAuth{Tag: tag, Hierarchy: tpm2.HandleNull}
+	}
+	timeout := make(tpm2.Timeout, 8)
+	expires := session.startTime.Add(time.Duration(-expiration)*time.Second).Sub(s.timeStart) / time.Millisecond
+	binary.BigEndian.PutUint64(timeout, uint64(expires))
+	digest := s.computeTicket(hierarchy, session.hashAlg, tag, timeout, cpHashA, policyRef, authName)
+	return timeout, &tpm2.TkAuth{Tag: tag, Hierarchy: hierarchy, Digest: digest}
+}
+
+func (s *Simulator) policySecret(c *commandContext) tpm2.ResponseCode {
+	var nonceTPM tpm2.Nonce
+	var cpHashA tpm2.Digest
+	var policyRef tpm2.Nonce
+	var expiration int32
+	if rc := c.unmarshalParams(&nonceTPM, &cpHashA, &policyRef, &expiration); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if rc := s.policyAuthCommon(session, nonceTPM, cpHashA, expiration); rc != tpm2.Success {
+		return rc
+	}
+
+	authName := c.entities[0].name()
+	session.extendPolicy(tpm2.CommandPolicySecret, authName)
+	session.extendPolicyRef(policyRef)
+	if len(cpHashA) > 0 {
+		session.cpHash = cpHashA
+	}
+	session.setExpiration(expiration, len(nonceTPM) > 0)
+
+	timeout, ticket := s.authTicket(session, tpm2.TagAuthSecret, entityHierarchy(c.entities[0]), expiration, cpHashA, policyRef,
+		authName)
+	return c.respond(timeout, ticket)
+}
+
+func (s *Simulator) policySigned(c *commandContext) tpm2.ResponseCode {
+	var nonceTPM tpm2.Nonce
+	var cpHashA tpm2.Digest
+	var policyRef tpm2.Nonce
+	var expiration int32
+	var auth tpm2.Signature
+	if rc := c.unmarshalParams(&nonceTPM, &cpHashA, &policyRef, &expiration, &auth); rc != tpm2.Success {
+		return rc
+	}
+	authObject := c.entities[0].object
+	if authObject == nil {
+		return handleRC(tpm2.ErrorValue, 1)
+	}
+	session, rc := c.policySession(1)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if rc := s.policyAuthCommon(session, nonceTPM, cpHashA, expiration); rc != tpm2.Success {
+		return rc
+	}
+
+	if !session.isTrial {
+		if authObject.public.Attrs&tpm2.AttrSign == 0 {
+			return handleRC(tpm2.ErrorAttributes, 1)
+		}
+		if auth.SigAlg == tpm2.SigSchemeAlgNull {
+			return paramRC(tpm2.ErrorScheme, 5)
+		}
+		hashAlg := auth.Signature.Any().HashAlg
+		if !isSupportedHashAlg(hashAlg) {
+			return paramRC(tpm2.ErrorHash, 5)
+		}
+		h := hashAlg.NewHash()
+		h.Write(nonceTPM)
+		binary.Write(h, binary.BigEndian, expiration)
+		h.Write(cpHashA)
+		h.Write(policyRef)
+		if !verifyDigest(authObject, h.Sum(nil), &auth) {
+			return paramRC(tpm2.ErrorSignature, 5)
+		}
+	}
+
+	session.extendPolicy(tpm2.CommandPolicySigned, authObject.name)
+	session.extendPolicyRef(policyRef)
+	if len(cpHashA) > 0 {
+		session.cpHash = cpHashA
+	}
+	session.setExpiration(expiration, len(nonceTPM) > 0)
+
+	timeout, ticket := s.authTicket(session, tpm2.TagAuthSigned, authObject.hierarchy, expiration, cpHashA, policyRef,
+		authObject.name)
+	return c.respond(timeout, ticket)
+}
+
+func (s *Simulator) policyTicket(c *commandContext) tpm2.ResponseCode {
+	var timeout tpm2.Timeout
+	var cpHashA tpm2.Digest
+	var policyRef tpm2.Nonce
+	var authName tpm2.Name
+	var ticket tpm2.TkAuth
+	if rc := c.unmarshalParams(&timeout, &cpHashA, &policyRef, &authName, &ticket); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	var code tpm2.CommandCode
+	switch ticket.Tag {
+	case tpm2.TagAuthSecret:
+		code = tpm2.CommandPolicySecret
+	case tpm2.TagAuthSigned:
+		code = tpm2.CommandPolicySigned
+	default:
+		return paramRC(tpm2.ErrorTag, 5)
+	}
+	if len(timeout) != 8 {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	if ticket.Hierarchy == tpm2.HandleNull ||
+		!bytes.Equal(ticket.Digest, s.computeTicket(ticket.Hierarchy, session.hashAlg, ticket.Tag, timeout, cpHashA, policyRef, authName)) {
+		return paramRC(tpm2.ErrorTicket, 5)
+	}
+	if time.Duration(binary.BigEndian.Uint64(timeout))*time.Millisecond < time.Since(s.timeStart) {
+		return paramRC(tpm2.ErrorExpired, 1)
+	}
+	if len(cpHashA) > 0 {
+		if len(session.cpHash) > 0 && !bytes.Equal(session.cpHash, cpHashA) {
+			return paramRC(tpm2.ErrorCpHash, 2)
+		}
+		session.cpHash = cpHashA
+	}
+
+	session.extendPolicy(code, authName)
+	session.extendPolicyRef(policyRef)
+	return tpm2.Success
+}
+
+func (s *Simulator) policyAuthorize(c *commandContext) tpm2.ResponseCode {
+	var approvedPolicy tpm2.Digest
+	var policyRef tpm2.Nonce
+	var keySign tpm2.Name
+	var checkTicket tpm2.TkVerified
+	if rc := c.unmarshalParams(&approvedPolicy, &policyRef, &keySign, &checkTicket); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	if !session.isTrial {
+		if !bytes.Equal(approvedPolicy, session.policyDigest) {
+			return paramRC(tpm2.ErrorValue, 1)
+		}
+		alg := keySign.Algorithm()
+		if alg == tpm2.HashAlgorithmNull {
+			return paramRC(tpm2.ErrorHash, 3)
+		}
+		h := alg.NewHash()
+		h.Write(approvedPolicy)
+		h.Write(policyRef)
+		aHash := h.Sum(nil)
+		if checkTicket.Tag != tpm2.TagVerified || checkTicket.Hierarchy == tpm2.HandleNull ||
+			!bytes.Equal(checkTicket.Digest, s.computeTicket(checkTicket.Hierarchy, alg, checkTicket.Tag, tpm2.Digest(aHash), keySign)) {
+			return paramRC(tpm2.ErrorValue, 4)
+		}
+	}
+
+	session.policyDigest = make(tpm2.Digest, session.hashAlg.Size())
+	session.extendPolicy(tpm2.CommandPolicyAuthorize, keySign)
+	session.extendPolicyRef(policyRef)
+	return tpm2.Success
+}
+
+func (s *Simulator) policyNV(c *commandContext) tpm2.ResponseCode {
+	var operandB tpm2.Operand
+	var offset uint16
+	var operation tpm2.ArithmeticOp
+	if rc := c.unmarshalParams(&operandB, &offset, &operation); rc != tpm2.Success {
+		return rc
+	}
+	nv := c.entities[1].nv
+	if nv == nil {
+		return handleRC(tpm2.ErrorValue, 2)
+	}
+	session, rc := c.policySession(2)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if rc := s.checkNVReadAccess(c, c.entities[0], nv); rc != tpm2.Success {
+		return rc
+	}
+	if int(offset)+len(operandB) > int(nv.public.Size) {
+		return paramRC(tpm2.ErrorNVRange, 2)
+	}
+
+	if !session.isTrial {
+		ok, err := compareOperands(nv.data[offset:int(offset)+len(operandB)], operandB, operation)
+		if err != 0 {
+			return paramRC(err, 3)
+		}
+		if !ok {
+			return errorRC(tpm2.ErrorPolicy)
+		}
+	}
+
+	session.extendPolicy(tpm2.CommandPolicyNV, computeOperandArgs(session.hashAlg, operandB, offset, operation), nv.name())
+	return tpm2.Success
+}
+
+func (s *Simulator) policyCounterTimer(c *commandContext) tpm2.ResponseCode {
+	var operandB tpm2.Operand
+	var offset uint16
+	var operation tpm2.ArithmeticOp
+	if rc := c.unmarshalParams(&operandB, &offset, &operation); rc != tpm2.Success {
+		return rc
+	}
+	session, rc := c.policySession(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	timeInfo, _ := mu.MarshalToBytes(s.timeInfoLocked())
+	if int(offset)+len(operandB) > len(timeInfo) {
+		return paramRC(tpm2.ErrorRange, 2)
+	}
+
+	if !session.isTrial {
+		ok, err := compareOperands(timeInfo[offset:int(offset)+len(operandB)], operandB, operation)
+		if err != 0 {
+			return paramRC(err, 3)
+		}
+		if !ok {
+			return errorRC(tpm2.ErrorPolicy)
+		}
+	}
+
+	session.extendPolicy(tpm2.CommandPolicyCounterTimer, computeOperandArgs(session.hashAlg, operandB, offset, operation))
+	return tpm2.Success
+}
@@ -0,0 +1,629 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"encoding/binary"
+	"time"
+
+	"github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/internal"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+// sessionAttrs corresponds to the TPMA_SESSION type.
+type sessionAttrs uint8
+
+const (
+	attrContinueSession sessionAttrs = 0x01
+	attrAuditExclusive  sessionAttrs = 0x02
+	attrAuditReset      sessionAttrs = 0x04
+	attrDecrypt         sessionAttrs = 0x20
+	attrEncrypt         sessionAttrs = 0x40
+	attrAudit           sessionAttrs = 0x80
+)
+
+// nvWrittenState describes the state of a TPM2_PolicyNvWritten assertion.
+type nvWrittenState uint8
+
+const (
+	nvWrittenUnset nvWrittenState = iota
+	nvWrittenClear
+	nvWrittenSet
+)
+
+// session contains the state associated with a HMAC or policy session.
+type session struct {
+	handle      tpm2.Handle
+	typ         tpm2.SessionType
+	hashAlg     tpm2.HashAlgorithmId
+	symmetric   tpm2.SymDef
+	sessionKey  []byte
+	nonceTPM    tpm2.Nonce
+	nonceCaller tpm2.Nonce
+	isBound     bool
+	boundEntity tpm2.Name
+	loaded      bool
+	contextID   uint64 // The sequence number of the most recently saved context
+	startTime   time.Time
+	resetCount  uint32
+	auditDigest tpm2.Digest // The session audit digest, or nil if the session hasn't been used for audit yet
+
+	policyDigest    tpm2.Digest
+	isTrial         bool
+	commandCode     tpm2.CommandCode
+	cpHash          tpm2.Digest
+	nameHash        tpm2.Digest
+	checkPCR        bool
+	pcrCounter      uint32
+	locality        tpm2.Locality
+	nvWritten       nvWrittenState
+	authValueNeeded bool
+	passwordNeeded  bool
+	timeout         time.Time
+}
+
+// resetPolicy resets the policy state of this session.
+func (s *session) resetPolicy() {
+	s.policyDigest = make(tpm2.Digest, s.hashAlg.Size())
+	s.commandCode = 0
+	s.cpHash = nil
+	s.nameHash = nil
+	s.checkPCR = false
+	s.locality = 0
+	s.nvWritten = nvWrittenUnset
+	s.authValueNeeded = false
+	s.passwordNeeded = false
+	s.timeout = time.Time{}
+}
+
+// commandSession corresponds to a session in the authorization area of a command.
+type commandSession struct {
+	handle      tpm2.Handle
+	nonceCaller tpm2.Nonce
+	attrs       sessionAttrs
+	hmac        tpm2.Auth
+
+	session     *session // The HMAC or policy session, or nil for a password authorization
+	entity      *entity  // The entity being authorized, or nil if this isn't an authorization session
+	role        authRole // The role for which the entity is being authorized
+	includeAuth bool     // Whether the HMAC key includes the authorization value of the entity
+	cpHash      []byte   // The command parameter digest, if this session is used for audit
+}
+
+type authCommand struct {
+	SessionHandle tpm2.Handle
+	Nonce         tpm2.Nonce
+	SessionAttrs  sessionAttrs
+	HMAC          tpm2.Auth
+}
+
+type authResponse struct {
+	Nonce        tpm2.Nonce
+	SessionAttrs sessionAttrs
+	HMAC         tpm2.Auth
+}
+
+// computeBindName returns the value used to determine whether an entity is the one that a HMAC session is bound to.
+func computeBindName(name tpm2.Name, auth tpm2.Auth) tpm2.Name {
+	if len(auth) > len(name) {
+		auth = auth[0:len(name)]
+	}
+	r := make(tpm2.Name, len(name))
+	copy(r, name)
+	j := 0
+	for i := len(name) - len(auth); i < len(name); i++ {
+		r[i] ^= auth[j]
+		j++
+	}
+	return r
+}
+
+func (s *Simulator) unmarshalSessions(area []byte) ([]*commandSession, tpm2.ResponseCode) {
+	var sessions []*commandSession
+	r := bytes.NewReader(area)
+	for r.Len() > 0 {
+		if len(sessions) == 3 {
+			return nil, errorRC(tpm2.ErrorAuthsize)
+		}
+		var auth authCommand
+		if _, err := mu.UnmarshalFromReader(r, &auth); err != nil {
+			return nil, errorRC(tpm2.ErrorAuthsize)
+		}
+		sessions = append(sessions, &commandSession{
+			handle:      auth.SessionHandle,
+			nonceCaller: auth.Nonce,
+			attrs:       auth.SessionAttrs,
+			hmac:        auth.HMAC})
+	}
+	return sessions, tpm2.Success
+}
+
+// sessionIndex returns the index of the first session in the command's authorization area with the specified attribute, or -1.
+func (c *commandContext) sessionIndex(attr sessionAttrs) int {
+	for i, session := range c.sessions {
+		if session.attrs&attr != 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// cpHash computes the command parameter digest for the specified algorithm.
+func (c *commandContext) cpHash(alg tpm2.HashAlgorithmId, cpBytes []byte) tpm2.Digest {
+	h := alg.NewHash()
+	binary.Write(h, binary.BigEndian, c.code)
+	for _, e := range c.entities {
+		h.Write(e.name())
+	}
+	h.Write(cpBytes)
+	return h.Sum(nil)
+}
+
+// nameHash computes the digest of the names of the command handles, for comparison with a TPM2_PolicyNameHash assertion.
+func (c *commandContext) nameHash(alg tpm2.HashAlgorithmId) tpm2.Digest {
+	h := alg.NewHash()
+	for _, e := range c.entities {
+		h.Write(e.name())
+	}
+	return h.Sum(nil)
+}
+
+func computeSessionHMAC(alg tpm2.HashAlgorithmId, key, pHash []byte, nonceNewer, nonceOlder, nonceDecrypt, nonceEncrypt tpm2.Nonce,
+	attrs sessionAttrs) []byte {
+	if len(key) == 0 {
+		return nil
+	}
+	h := hmac.New(alg.NewHash, key)
+	h.Write(pHash)
+	h.Write(nonceNewer)
+	h.Write(nonceOlder)
+	h.Write(nonceDecrypt)
+	h.Write(nonceEncrypt)
+	h.Write([]byte{uint8(attrs)})
+	return h.Sum(nil)
+}
+
+// hmacKey returns the key used for the HMAC of the supplied session.
+func (cs *commandSession) hmacKey() []byte {
+	key := append([]byte(nil), cs.session.sessionKey...)
+	if cs.includeAuth && cs.entity != nil {
+		key = append(key, trimAuthValue(cs.entity.authValue())...)
+	}
+	return key
+}
+
+// sessionValue returns the key used for parameter encryption with the supplied session.
+func (cs *commandSession) sessionValue() []byte {
+	key := append([]byte(nil), cs.session.sessionKey...)
+	if cs.entity != nil {
+		key = append(key, trimAuthValue(cs.entity.authValue())...)
+	}
+	return key
+}
+
+func (s *Simulator) computeCommandHMAC(c *commandContext, index int, cpBytes []byte) []byte {
+	cs := c.sessions[index]
+
+	var nonceDecrypt, nonceEncrypt tpm2.Nonce
+	if index == 0 {
+		decryptIndex := c.sessionIndex(attrDecrypt)
+		encryptIndex := c.sessionIndex(attrEncrypt)
+		if decryptIndex > 0 {
+			nonceDecrypt = c.sessions[decryptIndex].session.nonceTPM
+		}
+		if encryptIndex > 0 && encryptIndex != decryptIndex {
+			nonceEncrypt = c.sessions[encryptIndex].session.nonceTPM
+		}
+	}
+
+	return computeSessionHMAC(cs.session.hashAlg, cs.hmacKey(), c.cpHash(cs.session.hashAlg, cpBytes), cs.nonceCaller,
+		cs.session.nonceTPM, nonceDecrypt, nonceEncrypt, cs.attrs)
+}
+
+// processCommandSessions validates the sessions in the authorization area of a command, checks the authorizations and decrypts
+// the first command parameter if required.
+func (s *Simulator) processCommandSessions(c *commandContext, cpBytes []byte) tpm2.ResponseCode {
+	numAuth := c.info.numAuthHandles()
+	j := 0
+	for i, role := range c.info.handles {
+		if role == authNone {
+			continue
+		}
+		c.sessions[j].entity = c.entities[i]
+		c.sessions[j].role = role
+		j++
+	}
+
+	decryptIndex, encryptIndex, auditIndex := -1, -1, -1
+	for i, cs := range c.sessions {
+		n := i + 1
+		if cs.handle == tpm2.HandlePW {
+			if i >= numAuth {
+				return sessionRC(tpm2.ErrorHandle, n)
+			}
+			if len(cs.nonceCaller) > 0 {
+				return sessionRC(tpm2.ErrorNonce, n)
+			}
+			if cs.attrs&^attrContinueSession != 0 {
+				return sessionRC(tpm2.ErrorAttributes, n)
+			}
+			continue
+		}
+
+		switch cs.handle.Type() {
+		case tpm2.HandleTypeHMACSession, tpm2.HandleTypePolicySession:
+		default:
+			return sessionRC(tpm2.ErrorHandle, n)
+		}
+		session, ok := s.sessions[cs.handle]
+		if !ok || !session.loaded {
+			return warningRC(tpm2.WarningReferenceS0 + tpm2.WarningCode(i))
+		}
+		for _, other := range c.sessions[:i] {
+			if other.handle == cs.handle {
+				return sessionRC(tpm2.ErrorHandle, n)
+			}
+		}
+		cs.session = session
+
+		if len(cs.nonceCaller) < 16 || len(cs.nonceCaller) > session.hashAlg.Size() {
+			return sessionRC(tpm2.ErrorSize, n)
+		}
+		switch {
+		case cs.attrs&attrAudit != 0:
+			if auditIndex >= 0 {
+				return sessionRC(tpm2.ErrorAttributes, n)
+			}
+			if cs.attrs&attrAuditExclusive != 0 && s.exclusiveAuditSession != session.handle {
+				return errorRC(tpm2.ErrorExclusive)
+			}
+			auditIndex = i
+		case cs.attrs&(attrAuditExclusive|attrAuditReset) != 0:
+			return sessionRC(tpm2.ErrorAttributes, n)
+		}
+		if cs.attrs&attrDecrypt != 0 {
+			if decryptIndex >= 0 || !c.info.decrypt {
+				return sessionRC(tpm2.ErrorAttributes, n)
+			}
+			if session.symmetric.Algorithm == tpm2.SymAlgorithmNull {
+				return sessionRC(tpm2.ErrorSymmetric, n)
+			}
+			decryptIndex = i
+		}
+		if cs.attrs&attrEncrypt != 0 {
+			if encryptIndex >= 0 || !c.info.encrypt {
+				return sessionRC(tpm2.ErrorAttributes, n)
+			}
+			if session.symmetric.Algorithm == tpm2.SymAlgorithmNull {
+				return sessionRC(tpm2.ErrorSymmetric, n)
+			}
+			encryptIndex = i
+		}
+		if session.isTrial && i < numAuth {
+			return sessionRC(tpm2.ErrorAttributes, n)
+		}
+	}
+
+	for i := 0; i < numAuth; i++ {
+		if rc := s.checkAuthorization(c, i, cpBytes); rc != tpm2.Success {
+			return rc
+		}
+	}
+
+	if auditIndex >= 0 {
+		cs := c.sessions[auditIndex]
+		cs.cpHash = c.cpHash(cs.session.hashAlg, cpBytes)
+	}
+
+	if decryptIndex >= 0 {
+		cs := c.sessions[decryptIndex]
+		if len(cpBytes) < 2 {
+			return paramRC(tpm2.ErrorSize, 1)
+		}
+		size := int(binary.BigEndian.Uint16(cpBytes))
+		if len(cpBytes) < size+2 {
+			return paramRC(tpm2.ErrorSize, 1)
+		}
+		if err := cs.session.cryptParameter(cs.sessionValue(), cs.nonceCaller, cs.session.nonceTPM, cpBytes[2:size+2], true); err != nil {
+			return errorRC(tpm2.ErrorFailure)
+		}
+	}
+
+	return tpm2.Success
+}
+
+// cryptParameter encrypts or decrypts a parameter. For commands, nonceNewer is the caller's nonce. For responses, nonceNewer is
+// the TPM's nonce.
+func (s *session) cryptParameter(sessionValue []byte, nonceNewer, nonceOlder tpm2.Nonce, data []byte, decrypt bool) error {
+	switch s.symmetric.Algorithm {
+	case tpm2.SymAlgorithmAES:
+		keyBits := int(s.symmetric.KeyBits.Sym())
+		k := internal.KDFa(s.hashAlg.GetHash(), sessionValue, []byte("CFB"), nonceNewer, nonceOlder, keyBits+(aes.BlockSize*8))
+		offset := (keyBits + 7) / 8
+		mode := internal.SymmetricMode(s.symmetric.Mode.Sym())
+		if decrypt {
+			return internal.DecryptSymmetricAES(k[:offset], mode, data, k[offset:])
+		}
+		return internal.EncryptSymmetricAES(k[:offset], mode, data, k[offset:])
+	case tpm2.SymAlgorithmXOR:
+		internal.XORObfuscation(s.hashAlg.GetHash(), sessionValue, nonceNewer, nonceOlder, data)
+	}
+	return nil
+}
+
+// checkAuthorization checks the authorization for the session at the specified index.
+func (s *Simulator) checkAuthorization(c *commandContext, index int, cpBytes []byte) tpm2.ResponseCode {
+	cs := c.sessions[index]
+	n := index + 1
+	e := cs.entity
+
+	if (cs.session == nil || cs.session.typ == tpm2.SessionTypeHMAC) && e.isPolicyRequired(cs.role) {
+		return errorRC(tpm2.ErrorAuthType)
+	}
+
+	if cs.session == nil {
+		if !e.isAuthValueAvailable(cs.role, c.code) {
+			return errorRC(tpm2.ErrorAuthUnavailable)
+		}
+		return s.checkAuthValue(e, n, func() bool {
+			return hmac.Equal(trimAuthValue(cs.hmac), trimAuthValue(e.authValue()))
+		})
+	}
+
+	session := cs.session
+	switch session.typ {
+	case tpm2.SessionTypeHMAC:
+		if !e.isAuthValueAvailable(cs.role, c.code) {
+			return errorRC(tpm2.ErrorAuthUnavailable)
+		}
+		cs.includeAuth = !session.isBound || !bytes.Equal(session.boundEntity, computeBindName(e.name(), trimAuthValue(e.authValue())))
+		return s.checkAuthValue(e, n, func() bool {
+			return hmac.Equal(cs.hmac, s.computeCommandHMAC(c, index, cpBytes))
+		})
+	default:
+		if !e.isPolicyAvailable(cs.role, c.code) {
+			return errorRC(tpm2.ErrorAuthUnavailable)
+		}
+		if rc := s.checkPolicySession(c, index, cpBytes); rc != tpm2.Success {
+			return rc
+		}
+		switch {
+		case session.passwordNeeded:
+			return s.checkAuthValue(e, n, func() bool {
+				return hmac.Equal(trimAuthValue(cs.hmac), trimAuthValue(e.authValue()))
+			})
+		case session.authValueNeeded:
+			cs.includeAuth = true
+			return s.checkAuthValue(e, n, func() bool {
+				return hmac.Equal(cs.hmac, s.computeCommandHMAC(c, index, cpBytes))
+			})
+		default:
+			if !hmac.Equal(cs.hmac, s.computeCommandHMAC(c, index, cpBytes)) {
+				return sessionRC(tpm2.ErrorAuthFail, n)
+			}
+			return tpm2.Success
+		}
+	}
+}
+
+// processResponseSessions encrypts the first response parameter if required, and returns the authorization area of the response.
+func (s *Simulator) processResponseSessions(c *commandContext, rpBytes []byte) ([]byte, tpm2.ResponseCode) {
+	if i := c.sessionIndex(attrAudit); i < 0 || c.sessions[i].handle != s.exclusiveAuditSession {
+		s.exclusiveAuditSession = tpm2.HandleUnassigned
+	}
+
+	for _, cs := range c.sessions {
+		if cs.session == nil {
+			continue
+		}
+		cs.session.nonceTPM = random(len(cs.session.nonceTPM))
+	}
+
+	if i := c.sessionIndex(attrEncrypt); i >= 0 && len(rpBytes) >= 2 {
+		cs := c.sessions[i]
+		size := int(binary.BigEndian.Uint16(rpBytes))
+		if err := cs.session.cryptParameter(cs.sessionValue(), cs.session.nonceTPM, cs.nonceCaller, rpBytes[2:size+2], false); err != nil {
+			return nil, errorRC(tpm2.ErrorFailure)
+		}
+	}
+
+	h := func(alg tpm2.HashAlgorithmId) []byte {
+		h := alg.NewHash()
+		binary.Write(h, binary.BigEndian, tpm2.Success)
+		binary.Write(h, binary.BigEndian, c.code)
+		h.Write(rpBytes)
+		return h.Sum(nil)
+	}
+
+	var out bytes.Buffer
+	for i, cs := range c.sessions {
+		rsp := authResponse{SessionAttrs: cs.attrs &^ attrAuditReset}
+		if cs.session != nil {
+			session := cs.session
+			rsp.Nonce = session.nonceTPM
+			if cs.attrs&attrAudit != 0 {
+				s.updateAuditDigest(cs, h(session.hashAlg))
+				rsp.SessionAttrs &^= attrAuditExclusive
+				if s.exclusiveAuditSession == session.handle {
+					rsp.SessionAttrs |= attrAuditExclusive
+				}
+			}
+			if session.typ != tpm2.SessionTypePolicy || !session.passwordNeeded || cs.entity == nil {
+				rsp.HMAC = computeSessionHMAC(session.hashAlg, cs.hmacKey(), h(session.hashAlg), session.nonceTPM, cs.nonceCaller, nil,
+					nil, rsp.SessionAttrs)
+			}
+
+			switch {
+			case cs.attrs&attrContinueSession == 0:
+				delete(s.sessions, session.handle)
+			case session.typ == tpm2.SessionTypePolicy && i < c.info.numAuthHandles():
+				session.resetPolicy()
+			}
+		}
+		if _, err := mu.MarshalToWriter(&out, rsp); err != nil {
+			return nil, errorRC(tpm2.ErrorFailure)
+		}
+	}
+
+	return out.Bytes(), tpm2.Success
+}
+
+// updateAuditDigest extends the audit digest of the supplied session with the command and response parameter digests. Starting
+// or resetting the audit digest makes the session the exclusive audit session.
+func (s *Simulator) updateAuditDigest(cs *commandSession, rpHash []byte) {
+	session := cs.session
+	if session.auditDigest == nil || cs.attrs&attrAuditReset != 0 {
+		session.auditDigest = make(tpm2.Digest, session.hashAlg.Size())
+		s.exclusiveAuditSession = session.handle
+	}
+
+	h := session.hashAlg.NewHash()
+	h.Write(session.auditDigest)
+	h.Write(cs.cpHash)
+	h.Write(rpHash)
+	session.auditDigest = h.Sum(nil)
+}
+
+// availableSessionHandle returns a free session handle of the specified type.
+func (s *Simulator) availableSessionHandle(typ tpm2.SessionType) (tpm2.Handle, tpm2.ResponseCode) {
+	loaded := 0
+	for _, session := range s.sessions {
+		if session.loaded {
+			loaded++
+		}
+	}
+	if loaded >= maxLoadedSessions {
+		return tpm2.HandleUnassigned, warningRC(tpm2.WarningSessionMemory)
+	}
+
+	base := tpm2.HandleTypeHMACSession.BaseHandle()
+	if typ == tpm2.SessionTypePolicy || typ == tpm2.SessionTypeTrial {
+		base = tpm2.HandleTypePolicySession.BaseHandle()
+	}
+
+	for i := tpm2.Handle(0); i < maxActiveSessions; i++ {
+		inUse := false
+		for h := range s.sessions {
+			if h&0xffffff == i {
+				inUse = true
+				break
+			}
+		}
+		if !inUse {
+			return base | i, tpm2.Success
+		}
+	}
+	return tpm2.HandleUnassigned, warningRC(tpm2.WarningSessionHandles)
+}
+
+func (s *Simulator) startAuthSession(c *commandContext) tpm2.ResponseCode {
+	var nonceCaller tpm2.Nonce
+	var encryptedSalt tpm2.EncryptedSecret
+	var sessionType tpm2.SessionType
+	var symmetric tpm2.SymDef
+	var authHash tpm2.HashAlgorithmId
+	if rc := c.unmarshalParams(&nonceCaller, &encryptedSalt, &sessionType, &symmetric, &authHash); rc != tpm2.Success {
+		return rc
+	}
+
+	tpmKey := c.entities[0]
+	bind := c.entities[1]
+
+	if tpmKey.handle != tpm2.HandleNull && tpmKey.object == nil {
+		return handleRC(tpm2.ErrorValue, 1)
+	}
+	if bind.session != nil || bind.handle.Type() == tpm2.HandleTypePermanent && bind.hierarchy == nil {
+		return handleRC(tpm2.ErrorValue, 2)
+	}
+
+	switch sessionType {
+	case tpm2.SessionTypeHMAC, tpm2.SessionTypePolicy, tpm2.SessionTypeTrial:
+	default:
+		return paramRC(tpm2.ErrorValue, 3)
+	}
+	if !isSupportedHashAlg(authHash) {
+		return paramRC(tpm2.ErrorHash, 5)
+	}
+	if len(nonceCaller) < 16 || len(nonceCaller) > authHash.Size() {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	switch symmetric.Algorithm {
+	case tpm2.SymAlgorithmNull:
+	case tpm2.SymAlgorithmXOR:
+		if !isSupportedHashAlg(symmetric.KeyBits.XOR()) {
+			return paramRC(tpm2.ErrorHash, 4)
+		}
+	case tpm2.SymAlgorithmAES:
+		switch symmetric.KeyBits.Sym() {
+		case 128, 192, 256:
+		default:
+			return paramRC(tpm2.ErrorKeySize, 4)
+		}
+		if symmetric.Mode.Sym() != tpm2.SymModeCFB {
+			return paramRC(tpm2.ErrorMode, 4)
+		}
+	default:
+		return paramRC(tpm2.ErrorSymmetric, 4)
+	}
+
+	var salt []byte
+	switch {
+	case tpmKey.handle == tpm2.HandleNull:
+		if len(encryptedSalt) > 0 {
+			return paramRC(tpm2.ErrorValue, 2)
+		}
+	default:
+		if len(encryptedSalt) == 0 {
+			return paramRC(tpm2.ErrorValue, 2)
+		}
+		var code tpm2.ErrorCode
+		salt, code = decryptSecret(tpmKey.object, encryptedSalt, "SECRET")
+		if code != 0 {
+			return paramRC(code, 2)
+		}
+	}
+
+	handle, rc := s.availableSessionHandle(sessionType)
+	if rc != tpm2.Success {
+		return rc
+	}
+
+	session := &session{
+		handle:      handle,
+		typ:         sessionType,
+		hashAlg:     authHash,
+		symmetric:   symmetric,
+		nonceTPM:    random(authHash.Size()),
+		nonceCaller: nonceCaller,
+		loaded:      true,
+		startTime:   time.Now(),
+		resetCount:  s.resetCount}
+	if sessionType == tpm2.SessionTypeTrial {
+		session.typ = tpm2.SessionTypePolicy
+		session.isTrial = true
+	}
+	session.resetPolicy()
+
+	var bindAuth []byte
+	if bind.handle != tpm2.HandleNull {
+		bindAuth = trimAuthValue(bind.authValue())
+		if sessionType == tpm2.SessionTypeHMAC {
+			session.isBound = true
+			session.boundEntity = computeBindName(bind.name(), bindAuth)
+		}
+	}
+	if tpmKey.handle != tpm2.HandleNull || bind.handle != tpm2.HandleNull {
+		key := append(append([]byte(nil), bindAuth...), salt...)
+		session.sessionKey = internal.KDFa(authHash.GetHash(), key, []byte("ATH"), session.nonceTPM, nonceCaller, authHash.Size()*8)
+	}
+
+	s.sessions[handle] = session
+	c.rHandle = handle
+	return c.respond(session.nonceTPM)
+}
@@ -0,0 +1,321 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+/*
+Package simulator provides an in-process TPM 2.0 implementation that can be used to test code that depends on a TPM without
+requiring a TPM device or an external simulator.
+
+The simulator implements the subset of TPM 2.0 commands that are wrapped by the tpm2 package and which are commonly used by
+applications - startup and shutdown, HMAC and policy sessions (including salted and bound sessions and parameter encryption),
+object creation and loading, duplication and import, credential protection, signing and signature verification, RSA and ECDH
+operations, symmetric encryption, hashing and HMAC (including sequences), NV indices, PCRs, hierarchy management, attestation with
+TPM2_Quote and TPM2_Certify, dictionary attack protection and context management. Commands that aren't implemented fail with a
+TPM_RC_COMMAND_CODE error. The following commands that are wrapped by the tpm2 package aren't implemented:
+  - TPM2_ACT_SetTimeout.
+  - TPM2_CertifyX509, TPM2_Commit, TPM2_EC_Ephemeral, TPM2_ECC_Parameters and TPM2_ZGen_2Phase.
+  - TPM2_ClockSet, TPM2_ClockRateAdjust, TPM2_GetCommandAuditDigest and TPM2_SetCommandCodeAuditStatus.
+  - TPM2_CreateLoaded.
+  - TPM2_FieldUpgradeStart, TPM2_FieldUpgradeData and TPM2_FirmwareRead.
+  - TPM2_PCR_Allocate, TPM2_PCR_SetAuthPolicy and TPM2_PCR_SetAuthValue.
+  - TPM2_PolicyAuthorizeNV, TPM2_PolicyPhysicalPresence and TPM2_PolicyTemplate.
+  - TPM2_PP_Commands and TPM2_SetAlgorithmSet.
+
+The simulator has no physical presence, NV availability or failure mode controls, and the context of a hash or HMAC sequence object
+can't be saved with TPM2_ContextSave.
+
+The simulator is not a faithful implementation of the reference TPM, and it makes no attempt to protect any of the secrets that it
+holds. It should only be used for testing. State is held in memory and is lost when the Simulator is garbage collected.
+
+A Simulator is created with New, and is in the same state as a newly manufactured TPM that has just been powered on. TPM2_Startup
+must be executed before any other command. Commands are submitted via a Transport, which can be passed to tpm2.NewTPMContext:
+
+	sim := simulator.New()
+	tpm, _ := tpm2.NewTPMContext(sim.NewTransport())
+	if err := tpm.Startup(tpm2.StartupClear); err != nil {
+		...
+	}
+*/
+package simulator
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-tpm2"
+)
+
+const (
+	maxTransientObjects = 3  // The number of transient object slots
+	maxLoadedSessions   = 3  // The number of loaded session slots
+	maxActiveSessions   = 64 // The number of active (loaded or saved) sessions
+	maxPersistent       = 32 // The number of persistent objects
+	maxNVIndices        = 64 // The number of NV indices
+
+	maxNVBuffer    = 1024 // TPM_PT_NV_BUFFER_MAX
+	maxInputBuffer = 1024 // TPM_PT_INPUT_BUFFER
+	maxNVIndexSize = 2048 // TPM_PT_NV_INDEX_MAX
+	maxCapBuffer   = 1024 // TPM_PT_MAX_CAP_BUFFER
+	maxCommandSize = 4096 // TPM_PT_MAX_COMMAND_SIZE and TPM_PT_MAX_RESPONSE_SIZE
+	maxContextSize = 2048 // TPM_PT_MAX_OBJECT_CONTEXT and TPM_PT_MAX_SESSION_CONTEXT
+
+	numPCRs = 24
+)
+
+// pcrBanks are the PCR banks implemented by the simulator.
+var pcrBanks = []tpm2.HashAlgorithmId{tpm2.HashAlgorithmSHA1, tpm2.HashAlgorithmSHA256}
+
+// Simulator is an in-process TPM 2.0 implementation. It is safe to use from multiple goroutines, and commands submitted from
+// multiple Transports are executed one at a time.
+type Simulator struct {
+	mu sync.Mutex
+
+	powered bool
+	started bool
+
+	// State that persists across TPM resets.
+	owner         *hierarchy
+	endorsement   *hierarchy
+	platform      *hierarchy
+	lockout       *hierarchy
+	null          *hierarchy
+	disableClear  bool
+	da            daState
+	nvIndices     map[tpm2.Handle]*nvIndex
+	maxNVCounter  uint64 // The largest value of any NV counter, which new counters are initialized from
+	persistent    map[tpm2.Handle]*object
+	clock         uint64 // Value of TPMS_CLOCK_INFO.clock at clockStart
+	clockStart    time.Time
+	resetCount    uint32
+	restartCount  uint32
+	orderly       bool
+	savedState    *savedState
+	contextKey    []byte // Secret used to protect saved contexts for the current TPM reset cycle
+	primaryKeys   map[string]*primarySecrets
+	firmware      uint64
+	phEnable      bool
+	shEnable      bool
+	ehEnable      bool
+	phEnableNV    bool
+	nextContextID uint64
+
+	// Volatile state
+	timeStart  time.Time
+	objects    map[tpm2.Handle]*object
+	sessions   map[tpm2.Handle]*session
+	pcrs       map[tpm2.HashAlgorithmId][][]byte
+	pcrCounter uint32
+
+	exclusiveAuditSession tpm2.Handle
+}
+
+// savedState contains the state preserved by TPM2_Shutdown(TPM_SU_STATE), which is restored by TPM2_Startup(TPM_SU_STATE).
+type savedState struct {
+	pcrs       map[tpm2.HashAlgorithmId][][]byte
+	pcrCounter uint32
+}
+
+// New returns a new Simulator, which is in the same state as a newly manufactured TPM that has just been powered on. All
+// hierarchies have empty authorization values and policies, and there are no persistent objects or NV indices.
+func New() *Simulator {
+	s := &Simulator{
+		owner:       newHierarchy(tpm2.HandleOwner),
+		endorsement: newHierarchy(tpm2.HandleEndorsement),
+		platform:    newHierarchy(tpm2.HandlePlatform),
+		lockout:     newHierarchy(tpm2.HandleLockout),
+		null:        newHierarchy(tpm2.HandleNull),
+		da: daState{
+			maxTries:        3,
+			recoveryTime:    1000,
+			lockoutRecovery: 1000},
+		nvIndices:   make(map[tpm2.Handle]*nvIndex),
+		persistent:  make(map[tpm2.Handle]*object),
+		primaryKeys: make(map[string]*primarySecrets),
+		clockStart:  time.Now(),
+		firmware:    0x0001000000000000}
+	s.init()
+	return s
+}
+
+// init performs the actions associated with the _TPM_Init indication.
+func (s *Simulator) init() {
+	s.powered = true
+	s.started = false
+	s.timeStart = time.Now()
+	s.objects = make(map[tpm2.Handle]*object)
+	s.exclusiveAuditSession = tpm2.HandleUnassigned
+
+	// Loaded sessions are lost. Saved sessions remain valid until the next TPM reset.
+	for h, session := range s.sessions {
+		if session.loaded {
+			delete(s.sessions, h)
+		}
+	}
+}
+
+// PowerOn applies power to the simulator, which results in the execution of _TPM_Init. This does nothing if the simulator is
+// already powered on. TPM2_Startup must be executed before any other commands can be executed.
+func (s *Simulator) PowerOn() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.powered {
+		return
+	}
+	s.init()
+}
+
+// PowerOff removes power from the simulator. If this happens without a prior TPM2_Shutdown, the simulator will perform a TPM reset
+// on the next TPM2_Startup. Transports will return an error for any commands submitted until the simulator is powered on again.
+func (s *Simulator) PowerOff() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.powerOffLocked()
+}
+
+func (s *Simulator) powerOffLocked() {
+	if !s.powered {
+		return
+	}
+	s.clock = s.clockValueLocked()
+	s.clockStart = time.Now()
+	s.powered = false
+	s.objects = nil
+	for h, session := range s.sessions {
+		if session.loaded {
+			delete(s.sessions, h)
+		}
+	}
+}
+
+// Reset initiates a reset of the simulator, which results in the execution of _TPM_Init.
+func (s *Simulator) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.powerOffLocked()
+	s.init()
+}
+
+// NewTransport returns a new connection to this simulator, which can be passed to tpm2.NewTPMContext. Commands are submitted
+// from locality 0 initially. Closing the transport does not affect the state of the simulator.
+func (s *Simulator) NewTransport() *Transport {
+	return &Transport{s: s}
+}
+
+func (s *Simulator) clockValueLocked() uint64 {
+	if !s.powered {
+		return s.clock
+	}
+	return s.clock + uint64(time.Since(s.clockStart)/time.Millisecond)
+}
+
+func (s *Simulator) clockInfoLocked() tpm2.ClockInfo {
+	return tpm2.ClockInfo{
+		Clock:        s.clockValueLocked(),
+		ResetCount:   s.resetCount,
+		RestartCount: s.restartCount,
+		Safe:         true}
+}
+
+func (s *Simulator) timeInfoLocked() tpm2.TimeInfo {
+	return tpm2.TimeInfo{
+		Time:      uint64(time.Since(s.timeStart) / time.Millisecond),
+		ClockInfo: s.clockInfoLocked()}
+}
+
+func (s *Simulator) submitCommand(locality uint8, cmd []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.powered {
+		return nil, errors.New("the simulator is not powered on")
+	}
+	return s.executeCommand(locality, cmd), nil
+}
+
+// Transport is a connection to a Simulator. It implements the tpm2.Transport, tpm2.LocalityTransport and
+// tpm2.PowerControlTransport interfaces.
+//
+// Each command must be supplied in a single call to Write, which is how tpm2.TPMContext sends commands. The command is executed
+// before Write returns, and the response can be obtained by calling Read.
+type Transport struct {
+	s        *Simulator
+	locality uint8
+	rsp      *bytes.Reader
+	closed   bool
+}
+
+func (t *Transport) Read(data []byte) (int, error) {
+	if t.closed {
+		return 0, errors.New("transport already closed")
+	}
+	if t.rsp == nil {
+		return 0, io.EOF
+	}
+	return t.rsp.Read(data)
+}
+
+func (t *Transport) Write(data []byte) (int, error) {
+	if t.closed {
+		return 0, errors.New("transport already closed")
+	}
+	rsp, err := t.s.submitCommand(t.locality, data)
+	if err != nil {
+		return 0, err
+	}
+	t.rsp = bytes.NewReader(rsp)
+	return len(data), nil
+}
+
+// Close closes this connection. It doesn't affect the state of the simulator.
+func (t *Transport) Close() error {
+	if t.closed {
+		return errors.New("transport already closed")
+	}
+	t.closed = true
+	return nil
+}
+
+// GetLocality returns the locality from which commands are submitted to the simulator.
+func (t *Transport) GetLocality() uint8 {
+	return t.locality
+}
+
+// SetLocality sets the locality from which subsequent commands are submitted to the simulator. Localities 0 to 4 are supported.
+func (t *Transport) SetLocality(locality uint8) error {
+	if locality > 4 {
+		return errors.New("invalid locality")
+	}
+	t.locality = locality
+	return nil
+}
+
+// PowerOn applies power to the simulator. See Simulator.PowerOn.
+func (t *Transport) PowerOn() error {
+	t.s.PowerOn()
+	return nil
+}
+
+// PowerOff removes power from the simulator. See Simulator.PowerOff.
+func (t *Transport) PowerOff() error {
+	t.s.PowerOff()
+	return nil
+}
+
+// Reset initiates a reset of the simulator. See Simulator.Reset.
+func (t *Transport) Reset() error {
+	t.s.Reset()
+	return nil
+}
+
+// random returns n random bytes.
+func random(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
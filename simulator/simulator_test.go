@@ -0,0 +1,519 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/canonical/go-tpm2"
+	. "github.com/canonical/go-tpm2/simulator"
+)
+
+var (
+	eccStorageTemplate = tpm2.Public{
+		Type:    tpm2.ObjectTypeECC,
+		NameAlg: tpm2.HashAlgorithmSHA256,
+		Attrs: tpm2.AttrFixedTPM | tpm2.AttrFixedParent | tpm2.AttrSensitiveDataOrigin | tpm2.AttrUserWithAuth | tpm2.AttrNoDA |
+			tpm2.AttrRestricted | tpm2.AttrDecrypt,
+		Params: tpm2.PublicParamsU{
+			Data: &tpm2.ECCParams{
+				Symmetric: tpm2.SymDefObject{
+					Algorithm: tpm2.SymObjectAlgorithmAES,
+					KeyBits:   tpm2.SymKeyBitsU{Data: uint16(128)},
+					Mode:      tpm2.SymModeU{Data: tpm2.SymModeCFB}},
+				Scheme:  tpm2.ECCScheme{Scheme: tpm2.ECCSchemeNull},
+				CurveID: tpm2.ECCCurveNIST_P256,
+				KDF:     tpm2.KDFScheme{Scheme: tpm2.KDFAlgorithmNull}}}}
+
+	eccSigningTemplate = tpm2.Public{
+		Type:    tpm2.ObjectTypeECC,
+		NameAlg: tpm2.HashAlgorithmSHA256,
+		Attrs: tpm2.AttrFixedTPM | tpm2.AttrFixedParent | tpm2.AttrSensitiveDataOrigin | tpm2.AttrUserWithAuth | tpm2.AttrNoDA |
+			tpm2.AttrRestricted | tpm2.AttrSign,
+		Params: tpm2.PublicParamsU{
+			Data: &tpm2.ECCParams{
+				Symmetric: tpm2.SymDefObject{Algorithm: tpm2.SymObjectAlgorithmNull},
+				Scheme: tpm2.ECCScheme{
+					Scheme:  tpm2.ECCSchemeECDSA,
+					Details: tpm2.AsymSchemeU{Data: &tpm2.SigSchemeECDSA{HashAlg: tpm2.HashAlgorithmSHA256}}},
+				CurveID: tpm2.ECCCurveNIST_P256,
+				KDF:     tpm2.KDFScheme{Scheme: tpm2.KDFAlgorithmNull}}}}
+)
+
+func startSimulator(t *testing.T) (*Simulator, *tpm2.TPMContext) {
+	sim := New()
+	tpm, _ := tpm2.NewTPMContext(sim.NewTransport())
+	if err := tpm.Startup(tpm2.StartupClear); err != nil {
+		t.Fatalf("Startup failed: %v", err)
+	}
+	return sim, tpm
+}
+
+func createPrimary(t *testing.T, tpm *tpm2.TPMContext, template *tpm2.Public) tpm2.ResourceContext {
+	rc, _, _, _, _, err := tpm.CreatePrimary(tpm.OwnerHandleContext(), nil, template, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("CreatePrimary failed: %v", err)
+	}
+	return rc
+}
+
+func TestStartup(t *testing.T) {
+	sim := New()
+	tpm, _ := tpm2.NewTPMContext(sim.NewTransport())
+	defer tpm.Close()
+
+	if _, err := tpm.GetRandom(8); !tpm2.IsTPMError(err, tpm2.ErrorInitialize, tpm2.CommandGetRandom) {
+		t.Errorf("Unexpected error before startup: %v", err)
+	}
+	if err := tpm.Startup(tpm2.StartupClear); err != nil {
+		t.Fatalf("Startup failed: %v", err)
+	}
+	if err := tpm.Startup(tpm2.StartupClear); !tpm2.IsTPMError(err, tpm2.ErrorInitialize, tpm2.CommandStartup) {
+		t.Errorf("Unexpected error for second startup: %v", err)
+	}
+	if _, err := tpm.GetRandom(8); err != nil {
+		t.Errorf("GetRandom failed: %v", err)
+	}
+}
+
+func TestUnimplementedCommand(t *testing.T) {
+	_, tpm := startSimulator(t)
+	defer tpm.Close()
+
+	_, err := tpm.ECCParameters(tpm2.ECCCurveNIST_P256)
+	if !tpm2.IsTPMError(err, tpm2.ErrorCommandCode, tpm2.CommandECCParameters) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestCreatePrimaryIsDeterministic(t *testing.T) {
+	_, tpm := startSimulator(t)
+	defer tpm.Close()
+
+	rc1 := createPrimary(t, tpm, &eccStorageTemplate)
+	rc2 := createPrimary(t, tpm, &eccStorageTemplate)
+	if !bytes.Equal(rc1.Name(), rc2.Name()) {
+		t.Errorf("Primary keys created from the same template should be identical")
+	}
+
+	if err := tpm.Clear(tpm.LockoutHandleContext(), nil); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if _, _, _, err := tpm.ReadPublic(rc1); err == nil {
+		t.Errorf("Clear should have flushed the owner object")
+	}
+
+	rc3 := createPrimary(t, tpm, &eccStorageTemplate)
+	if bytes.Equal(rc1.Name(), rc3.Name()) {
+		t.Errorf("Clear should have changed the storage primary seed")
+	}
+}
+
+func TestCreateObjectAttributes(t *testing.T) {
+	_, tpm := startSimulator(t)
+	defer tpm.Close()
+
+	createPrimary(t, tpm, tpm2.NewDerivationParentTemplate(tpm2.HashAlgorithmSHA256, tpm2.HashAlgorithmSHA256))
+
+	symTemplate := tpm2.Public{
+		Type:    tpm2.ObjectTypeSymCipher,
+		NameAlg: tpm2.HashAlgorithmSHA256,
+		Attrs:   tpm2.AttrFixedTPM | tpm2.AttrFixedParent | tpm2.AttrSensitiveDataOrigin | tpm2.AttrUserWithAuth | tpm2.AttrSign | tpm2.AttrDecrypt,
+		Params: tpm2.PublicParamsU{
+			Data: &tpm2.SymCipherParams{
+				Sym: tpm2.SymDefObject{
+					Algorithm: tpm2.SymObjectAlgorithmAES,
+					KeyBits:   tpm2.SymKeyBitsU{Data: uint16(128)},
+					Mode:      tpm2.SymModeU{Data: tpm2.SymModeCFB}}}}}
+	createPrimary(t, tpm, &symTemplate)
+
+	hmacTemplate := tpm2.Public{
+		Type:    tpm2.ObjectTypeKeyedHash,
+		NameAlg: tpm2.HashAlgorithmSHA256,
+		Attrs:   tpm2.AttrFixedTPM | tpm2.AttrFixedParent | tpm2.AttrSensitiveDataOrigin | tpm2.AttrUserWithAuth | tpm2.AttrSign | tpm2.AttrDecrypt,
+		Params: tpm2.PublicParamsU{
+			Data: &tpm2.KeyedHashParams{
+				Scheme: tpm2.KeyedHashScheme{
+					Scheme:  tpm2.KeyedHashSchemeHMAC,
+					Details: tpm2.SchemeKeyedHashU{Data: &tpm2.SchemeHMAC{HashAlg: tpm2.HashAlgorithmSHA256}}}}}}
+	_, _, _, _, _, err := tpm.CreatePrimary(tpm.OwnerHandleContext(), nil, &hmacTemplate, nil, nil, nil)
+	if !tpm2.IsTPMParameterError(err, tpm2.ErrorScheme, tpm2.CommandCreatePrimary, 2) {
+		t.Errorf("Unexpected error for a sign and decrypt keyed hash object with a scheme: %v", err)
+	}
+}
+
+func TestSealWithPCRPolicy(t *testing.T) {
+	_, tpm := startSimulator(t)
+	defer tpm.Close()
+
+	srk := createPrimary(t, tpm, &eccStorageTemplate)
+
+	pcrs := tpm2.PCRSelectionList{{Hash: tpm2.HashAlgorithmSHA256, Select: []int{7}}}
+	_, values, err := tpm.PCRRead(pcrs)
+	if err != nil {
+		t.Fatalf("PCRRead failed: %v", err)
+	}
+	pcrDigest, err := tpm2.ComputePCRDigest(tpm2.HashAlgorithmSHA256, pcrs, values)
+	if err != nil {
+		t.Fatalf("ComputePCRDigest failed: %v", err)
+	}
+
+	trial, _ := tpm2.ComputeAuthPolicy(tpm2.HashAlgorithmSHA256)
+	trial.PolicyPCR(pcrDigest, pcrs)
+
+	secret := []byte("super secret data")
+	template := tpm2.Public{
+		Type:       tpm2.ObjectTypeKeyedHash,
+		NameAlg:    tpm2.HashAlgorithmSHA256,
+		Attrs:      tpm2.AttrFixedTPM | tpm2.AttrFixedParent,
+		AuthPolicy: trial.GetDigest(),
+		Params:     tpm2.PublicParamsU{Data: &tpm2.KeyedHashParams{Scheme: tpm2.KeyedHashScheme{Scheme: tpm2.KeyedHashSchemeNull}}}}
+	priv, pub, _, _, _, err := tpm.Create(srk, &tpm2.SensitiveCreate{Data: secret}, &template, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	key, err := tpm.Load(srk, priv, pub, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	unseal := func() (tpm2.SensitiveData, error) {
+		session, err := tpm.StartAuthSession(nil, nil, tpm2.SessionTypePolicy, nil, tpm2.HashAlgorithmSHA256)
+		if err != nil {
+			t.Fatalf("StartAuthSession failed: %v", err)
+		}
+		if err := tpm.PolicyPCR(session, nil, pcrs); err != nil {
+			t.Fatalf("PolicyPCR failed: %v", err)
+		}
+		return tpm.Unseal(key, session)
+	}
+
+	data, err := unseal()
+	if err != nil {
+		t.Fatalf("Unseal failed: %v", err)
+	}
+	if !bytes.Equal(data, secret) {
+		t.Errorf("Unexpected unsealed data: %x", data)
+	}
+
+	h := sha256.Sum256([]byte("event"))
+	if err := tpm.PCRExtend(tpm.PCRHandleContext(7), tpm2.TaggedHashList{{HashAlg: tpm2.HashAlgorithmSHA256, Digest: h[:]}}, nil); err != nil {
+		t.Fatalf("PCRExtend failed: %v", err)
+	}
+	if _, err := unseal(); !tpm2.IsTPMSessionError(err, tpm2.ErrorPolicyFail, tpm2.CommandUnseal, 1) {
+		t.Errorf("Unexpected error after PCR change: %v", err)
+	}
+}
+
+func TestSaltedSessionWithParameterEncryption(t *testing.T) {
+	_, tpm := startSimulator(t)
+	defer tpm.Close()
+
+	srk := createPrimary(t, tpm, &eccStorageTemplate)
+
+	owner := tpm.OwnerHandleContext()
+	if err := tpm.HierarchyChangeAuth(owner, []byte("owner"), nil); err != nil {
+		t.Fatalf("HierarchyChangeAuth failed: %v", err)
+	}
+
+	symmetric := tpm2.SymDef{
+		Algorithm: tpm2.SymAlgorithmAES,
+		KeyBits:   tpm2.SymKeyBitsU{Data: uint16(128)},
+		Mode:      tpm2.SymModeU{Data: tpm2.SymModeCFB}}
+	session, err := tpm.StartAuthSession(srk, owner, tpm2.SessionTypeHMAC, &symmetric, tpm2.HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	defer tpm.FlushContext(session)
+	session.SetAttrs(tpm2.AttrContinueSession)
+
+	pub := tpm2.NVPublic{
+		Index:   0x01800000,
+		NameAlg: tpm2.HashAlgorithmSHA256,
+		Attrs:   tpm2.NVTypeOrdinary.WithAttrs(tpm2.AttrNVOwnerWrite | tpm2.AttrNVOwnerRead),
+		Size:    16}
+	index, err := tpm.NVDefineSpace(owner, nil, &pub, session.IncludeAttrs(tpm2.AttrCommandEncrypt))
+	if err != nil {
+		t.Fatalf("NVDefineSpace failed: %v", err)
+	}
+
+	data := []byte("0123456789abcdef")
+	if err := tpm.NVWrite(owner, index, data, 0, session.IncludeAttrs(tpm2.AttrCommandEncrypt)); err != nil {
+		t.Fatalf("NVWrite failed: %v", err)
+	}
+	read, err := tpm.NVRead(owner, index, uint16(len(data)), 0, session.IncludeAttrs(tpm2.AttrResponseEncrypt))
+	if err != nil {
+		t.Fatalf("NVRead failed: %v", err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Errorf("Unexpected data read back: %x", read)
+	}
+
+	owner.SetAuthValue([]byte("wrong"))
+	if _, err := tpm.NVRead(owner, index, uint16(len(data)), 0, nil); !tpm2.IsTPMSessionError(err, tpm2.ErrorBadAuth, tpm2.CommandNVRead, 1) {
+		t.Errorf("Unexpected error with the wrong authorization value: %v", err)
+	}
+}
+
+func TestNVCounter(t *testing.T) {
+	_, tpm := startSimulator(t)
+	defer tpm.Close()
+
+	owner := tpm.OwnerHandleContext()
+	pub := tpm2.NVPublic{
+		Index:   0x01800001,
+		NameAlg: tpm2.HashAlgorithmSHA256,
+		Attrs:   tpm2.NVTypeCounter.WithAttrs(tpm2.AttrNVAuthWrite | tpm2.AttrNVAuthRead),
+		Size:    8}
+	index, err := tpm.NVDefineSpace(owner, []byte("foo"), &pub, nil)
+	if err != nil {
+		t.Fatalf("NVDefineSpace failed: %v", err)
+	}
+
+	if _, err := tpm.NVReadCounter(index, index, nil); !tpm2.IsTPMError(err, tpm2.ErrorNVUninitialized, tpm2.CommandNVRead) {
+		t.Errorf("Unexpected error reading an uninitialized counter: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := tpm.NVIncrement(index, index, nil); err != nil {
+			t.Fatalf("NVIncrement failed: %v", err)
+		}
+	}
+	count, err := tpm.NVReadCounter(index, index, nil)
+	if err != nil {
+		t.Fatalf("NVReadCounter failed: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Unexpected counter value: %d", count)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	_, tpm := startSimulator(t)
+	defer tpm.Close()
+
+	ak := createPrimary(t, tpm, &eccSigningTemplate)
+
+	pcrs := tpm2.PCRSelectionList{{Hash: tpm2.HashAlgorithmSHA256, Select: []int{0, 1, 2}}}
+	_, values, err := tpm.PCRRead(pcrs)
+	if err != nil {
+		t.Fatalf("PCRRead failed: %v", err)
+	}
+	expectedDigest, _ := tpm2.ComputePCRDigest(tpm2.HashAlgorithmSHA256, pcrs, values)
+
+	quoted, signature, err := tpm.Quote(ak, []byte("nonce"), nil, pcrs, nil)
+	if err != nil {
+		t.Fatalf("Quote failed: %v", err)
+	}
+
+	attest, err := quoted.Decode()
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if attest.Magic != tpm2.TPMGeneratedValue || attest.Type != tpm2.TagAttestQuote {
+		t.Errorf("Unexpected attestation header")
+	}
+	if !bytes.Equal(attest.ExtraData, []byte("nonce")) {
+		t.Errorf("Unexpected extra data")
+	}
+	if !bytes.Equal(attest.Attested.Quote().PCRDigest, expectedDigest) {
+		t.Errorf("Unexpected PCR digest")
+	}
+
+	pub, _, _, err := tpm.ReadPublic(ak)
+	if err != nil {
+		t.Fatalf("ReadPublic failed: %v", err)
+	}
+	key := ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(pub.Unique.ECC().X),
+		Y:     new(big.Int).SetBytes(pub.Unique.ECC().Y)}
+	digest := sha256.Sum256(quoted)
+	sig := signature.Signature.ECDSA()
+	if !ecdsa.Verify(&key, digest[:], new(big.Int).SetBytes(sig.SignatureR), new(big.Int).SetBytes(sig.SignatureS)) {
+		t.Errorf("Invalid signature")
+	}
+}
+
+func TestDuplicateAndImport(t *testing.T) {
+	_, tpm := startSimulator(t)
+	defer tpm.Close()
+
+	srk := createPrimary(t, tpm, &eccStorageTemplate)
+
+	parentTemplate := eccStorageTemplate
+	parentTemplate.Attrs &^= tpm2.AttrFixedTPM | tpm2.AttrFixedParent
+	priv, pub, _, _, _, err := tpm.Create(srk, nil, &parentTemplate, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	newParent, err := tpm.Load(srk, priv, pub, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	trial, _ := tpm2.ComputeAuthPolicy(tpm2.HashAlgorithmSHA256)
+	trial.PolicyCommandCode(tpm2.CommandDuplicate)
+
+	template := eccSigningTemplate
+	template.Attrs = tpm2.AttrEncryptedDuplication | tpm2.AttrSensitiveDataOrigin | tpm2.AttrUserWithAuth | tpm2.AttrSign
+	template.AuthPolicy = trial.GetDigest()
+	priv, pub, _, _, _, err = tpm.Create(srk, &tpm2.SensitiveCreate{UserAuth: []byte("foo")}, &template, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	object, err := tpm.Load(srk, priv, pub, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	session, err := tpm.StartAuthSession(nil, nil, tpm2.SessionTypePolicy, nil, tpm2.HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	if err := tpm.PolicyCommandCode(session, tpm2.CommandDuplicate); err != nil {
+		t.Fatalf("PolicyCommandCode failed: %v", err)
+	}
+
+	// Objects with the encryptedDuplication attribute require both an inner and an outer wrapper.
+	symmetricAlg := tpm2.SymDefObject{
+		Algorithm: tpm2.SymObjectAlgorithmAES,
+		KeyBits:   tpm2.SymKeyBitsU{Data: uint16(128)},
+		Mode:      tpm2.SymModeU{Data: tpm2.SymModeCFB}}
+	encryptionKey, duplicate, symSeed, err := tpm.Duplicate(object, newParent, nil, &symmetricAlg, session)
+	if err != nil {
+		t.Fatalf("Duplicate failed: %v", err)
+	}
+	name := object.Name()
+	if err := tpm.FlushContext(object); err != nil {
+		t.Fatalf("FlushContext failed: %v", err)
+	}
+
+	if _, err := tpm.Import(newParent, encryptionKey, pub, duplicate, nil, &symmetricAlg, nil); !tpm2.IsTPMParameterError(err,
+		tpm2.ErrorAttributes, tpm2.CommandImport, 4) {
+		t.Errorf("Unexpected error importing without the outer wrapper: %v", err)
+	}
+
+	priv, err = tpm.Import(newParent, encryptionKey, pub, duplicate, symSeed, &symmetricAlg, nil)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	imported, err := tpm.Load(newParent, priv, pub, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !bytes.Equal(imported.Name(), name) {
+		t.Errorf("Imported object has the wrong name")
+	}
+
+	imported.SetAuthValue([]byte("foo"))
+	digest := sha256.Sum256([]byte("data"))
+	if _, err := tpm.Sign(imported, digest[:], nil, nil, nil); err != nil {
+		t.Errorf("Imported object can't be used: %v", err)
+	}
+}
+
+func TestContextSaveAndLoad(t *testing.T) {
+	sim, tpm := startSimulator(t)
+	defer tpm.Close()
+
+	srk := createPrimary(t, tpm, &eccStorageTemplate)
+	name := srk.Name()
+
+	objectContext, err := tpm.ContextSave(srk)
+	if err != nil {
+		t.Fatalf("ContextSave failed: %v", err)
+	}
+	if err := tpm.FlushContext(srk); err != nil {
+		t.Fatalf("FlushContext failed: %v", err)
+	}
+
+	session, err := tpm.StartAuthSession(nil, nil, tpm2.SessionTypeHMAC, nil, tpm2.HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	sessionContext, err := tpm.ContextSave(session)
+	if err != nil {
+		t.Fatalf("ContextSave failed: %v", err)
+	}
+
+	restored, err := tpm.ContextLoad(objectContext)
+	if err != nil {
+		t.Fatalf("ContextLoad failed: %v", err)
+	}
+	if !bytes.Equal(restored.Name(), name) {
+		t.Errorf("Restored object has the wrong name")
+	}
+	session2, err := tpm.ContextLoad(sessionContext)
+	if err != nil {
+		t.Fatalf("ContextLoad failed: %v", err)
+	}
+	if _, err := tpm.GetRandom(8, session2.(tpm2.SessionContext).WithAttrs(tpm2.AttrContinueSession|tpm2.AttrAudit)); err != nil {
+		t.Errorf("Restored session can't be used: %v", err)
+	}
+	if _, err := tpm.ContextSave(session2); err != nil {
+		t.Fatalf("ContextSave failed: %v", err)
+	}
+
+	// Contexts saved before a TPM reset can't be loaded afterwards.
+	sim.Reset()
+	if err := tpm.Startup(tpm2.StartupClear); err != nil {
+		t.Fatalf("Startup failed: %v", err)
+	}
+	if _, err := tpm.ContextLoad(objectContext); !tpm2.IsTPMParameterError(err, tpm2.ErrorIntegrity, tpm2.CommandContextLoad, 1) {
+		t.Errorf("Unexpected error loading an object context after a TPM reset: %v", err)
+	}
+}
+
+func TestShutdownAndResume(t *testing.T) {
+	sim, tpm := startSimulator(t)
+	defer tpm.Close()
+
+	h := sha256.Sum256([]byte("event"))
+	if err := tpm.PCRExtend(tpm.PCRHandleContext(0), tpm2.TaggedHashList{{HashAlg: tpm2.HashAlgorithmSHA256, Digest: h[:]}}, nil); err != nil {
+		t.Fatalf("PCRExtend failed: %v", err)
+	}
+	pcrs := tpm2.PCRSelectionList{{Hash: tpm2.HashAlgorithmSHA256, Select: []int{0}}}
+	_, expected, err := tpm.PCRRead(pcrs)
+	if err != nil {
+		t.Fatalf("PCRRead failed: %v", err)
+	}
+
+	if err := tpm.Shutdown(tpm2.StartupState); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	sim.Reset()
+	if err := tpm.Startup(tpm2.StartupState); err != nil {
+		t.Fatalf("Startup failed: %v", err)
+	}
+	_, values, err := tpm.PCRRead(pcrs)
+	if err != nil {
+		t.Fatalf("PCRRead failed: %v", err)
+	}
+	if !bytes.Equal(values[tpm2.HashAlgorithmSHA256][0], expected[tpm2.HashAlgorithmSHA256][0]) {
+		t.Errorf("PCR value wasn't preserved across a TPM resume")
+	}
+
+	// A TPM resume isn't possible without an orderly shutdown.
+	sim.Reset()
+	if err := tpm.Startup(tpm2.StartupState); !tpm2.IsTPMParameterError(err, tpm2.ErrorValue, tpm2.CommandStartup, 1) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := tpm.Startup(tpm2.StartupClear); err != nil {
+		t.Fatalf("Startup failed: %v", err)
+	}
+	_, values, err = tpm.PCRRead(pcrs)
+	if err != nil {
+		t.Fatalf("PCRRead failed: %v", err)
+	}
+	if !bytes.Equal(values[tpm2.HashAlgorithmSHA256][0], make([]byte, 32)) {
+		t.Errorf("PCR wasn't reset by a TPM reset")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"github.com/canonical/go-tpm2"
+)
+
+const maxRandomBytes = 64 // The maximum number of bytes returned from TPM2_GetRandom, which is the size of the largest digest
+
+func (s *Simulator) startup(c *commandContext) tpm2.ResponseCode {
+	var startupType tpm2.StartupType
+	if rc := c.unmarshalParams(&startupType); rc != tpm2.Success {
+		return rc
+	}
+	if s.started {
+		return errorRC(tpm2.ErrorInitialize)
+	}
+	if c.locality > 3 {
+		return warningRC(tpm2.WarningLocality)
+	}
+
+	saved := s.savedState
+	if !s.orderly {
+		saved = nil
+	}
+
+	switch startupType {
+	case tpm2.StartupClear:
+		if saved == nil {
+			// TPM reset. Saved sessions and contexts are invalidated, and the null hierarchy gets a new seed.
+			s.resetCount++
+			s.restartCount = 0
+			s.sessions = make(map[tpm2.Handle]*session)
+			s.contextKey = random(32)
+			s.null = newHierarchy(tpm2.HandleNull)
+		} else {
+			// TPM restart.
+			s.restartCount++
+		}
+		s.resetPCRs()
+		s.shEnable = true
+		s.ehEnable = true
+		s.platform.authValue = nil
+		s.platform.authPolicy = nil
+		s.platform.policyAlg = tpm2.HashAlgorithmNull
+	case tpm2.StartupState:
+		if saved == nil {
+			return paramRC(tpm2.ErrorValue, 1)
+		}
+		// TPM resume.
+		s.restartCount++
+		s.pcrs = saved.pcrs
+		s.pcrCounter = saved.pcrCounter
+	default:
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+
+	s.nvStartup(startupType == tpm2.StartupClear)
+	s.phEnable = true
+	s.phEnableNV = true
+	s.orderly = false
+	s.savedState = nil
+	s.started = true
+	return tpm2.Success
+}
+
+func (s *Simulator) shutdown(c *commandContext) tpm2.ResponseCode {
+	var shutdownType tpm2.StartupType
+	if rc := c.unmarshalParams(&shutdownType); rc != tpm2.Success {
+		return rc
+	}
+
+	switch shutdownType {
+	case tpm2.StartupClear:
+		s.savedState = nil
+	case tpm2.StartupState:
+		s.savedState = &savedState{pcrs: s.copyPCRs(), pcrCounter: s.pcrCounter}
+	default:
+		return paramRC(tpm2.ErrorValue, 1)
+	}
+	s.orderly = true
+	return tpm2.Success
+}
+
+func (s *Simulator) selfTest(c *commandContext) tpm2.ResponseCode {
+	var fullTest bool
+	return c.unmarshalParams(&fullTest)
+}
+
+func (s *Simulator) incrementalSelfTest(c *commandContext) tpm2.ResponseCode {
+	var toTest tpm2.AlgorithmList
+	if rc := c.unmarshalParams(&toTest); rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(tpm2.AlgorithmList{})
+}
+
+func (s *Simulator) getTestResult(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(tpm2.MaxBuffer{}, tpm2.ResponseCode(tpm2.Success))
+}
+
+func (s *Simulator) getRandom(c *commandContext) tpm2.ResponseCode {
+	var bytesRequested uint16
+	if rc := c.unmarshalParams(&bytesRequested); rc != tpm2.Success {
+		return rc
+	}
+	if bytesRequested > maxRandomBytes {
+		bytesRequested = maxRandomBytes
+	}
+	return c.respond(tpm2.Digest(random(int(bytesRequested))))
+}
+
+func (s *Simulator) stirRandom(c *commandContext) tpm2.ResponseCode {
+	var inData tpm2.SensitiveData
+	if rc := c.unmarshalParams(&inData); rc != tpm2.Success {
+		return rc
+	}
+	if len(inData) > 128 {
+		return paramRC(tpm2.ErrorSize, 1)
+	}
+	return tpm2.Success
+}
+
+func (s *Simulator) readClock(c *commandContext) tpm2.ResponseCode {
+	if rc := c.unmarshalParams(); rc != tpm2.Success {
+		return rc
+	}
+	return c.respond(s.timeInfoLocked())
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package simulator
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+
+	"github.com/canonical/go-tpm2"
+)
+
+// cryptSymmetric encrypts or decrypts the supplied data with the supplied block cipher in the specified mode, and returns the
+// result and the chaining value that can be supplied as the IV of a subsequent call in order to continue the operation. For CFB,
+// CTR and OFB, a partial final block is permitted, but the returned chaining value isn't useful in this case.
+func cryptSymmetric(block cipher.Block, mode tpm2.SymModeId, iv, data []byte, decrypt bool) ([]byte, []byte) {
+	bs := block.BlockSize()
+	out := make([]byte, len(data))
+	chain := append([]byte(nil), iv...)
+	ks := make([]byte, bs)
+
+	for i := 0; i < len(data); i += bs {
+		end := i + bs
+		if end > len(data) {
+			end = len(data)
+		}
+		in := data[i:end]
+
+		switch mode {
+		case tpm2.SymModeECB:
+			if decrypt {
+				block.Decrypt(out[i:end], in)
+			} else {
+				block.Encrypt(out[i:end], in)
+			}
+		case tpm2.SymModeCBC:
+			if decrypt {
+				block.Decrypt(out[i:end], in)
+				for j := range in {
+					out[i+j] ^= chain[j]
+				}
+				copy(chain, in)
+			} else {
+				for j := range in {
+					ks[j] = in[j] ^ chain[j]
+				}
+				block.Encrypt(out[i:end], ks)
+				copy(chain, out[i:end])
+			}
+		case tpm2.SymModeCFB:
+			block.Encrypt(ks, chain)
+			for j := range in {
+				out[i+j] = in[j] ^ ks[j]
+			}
+			if decrypt {
+				copy(chain, in)
+			} else {
+				copy(chain, out[i:end])
+			}
+		case tpm2.SymModeOFB:
+			block.Encrypt(chain, chain)
+			for j := range in {
+				out[i+j] = in[j] ^ chain[j]
+			}
+		case tpm2.SymModeCTR:
+			block.Encrypt(ks, chain)
+			for j := range in {
+				out[i+j] = in[j] ^ ks[j]
+			}
+			for j := bs - 1; j >= 0; j-- {
+				chain[j]++
+				if chain[j] != 0 {
+					break
+				}
+			}
+		}
+	}
+
+	return out, chain
+}
+
+// encryptDecrypt implements the common part of TPM2_EncryptDecrypt and TPM2_EncryptDecrypt2. The parameter indices are those of
+// TPM2_EncryptDecrypt2.
+func (s *Simulator) encryptDecrypt(c *commandContext, inData tpm2.MaxBuffer, decrypt bool, mode tpm2.SymModeId, ivIn tpm2.IV,
+	paramIndex func(int) int) tpm2.ResponseCode {
+	o, rc := c.object(0)
+	if rc != tpm2.Success {
+		return rc
+	}
+	if o.public.Type != tpm2.ObjectTypeSymCipher || o.sensitive == nil {
+		return handleRC(tpm2.ErrorKey, 1)
+	}
+	attrs := o.public.Attrs
+	switch {
+	case attrs&tpm2.AttrRestricted != 0:
+		return handleRC(tpm2.ErrorAttributes, 1)
+	case decrypt && attrs&tpm2.AttrDecrypt == 0:
+		return handleRC(tpm2.ErrorAttributes, 1)
+	case !decrypt && attrs&tpm2.AttrSign == 0:
+		return handleRC(tpm2.ErrorAttributes, 1)
+	}
+
+	sym := &o.public.Params.SymDetail().Sym
+	keyMode := sym.Mode.Sym()
+	switch {
+	case keyMode == tpm2.SymModeNull:
+	case mode == tpm2.SymModeNull:
+		mode = keyMode
+	case mode != keyMode:
+		return paramRC(tpm2.ErrorMode, paramIndex(3))
+	}
+
+	block, err := aes.NewCipher(o.sensitive.Sensitive.Sym())
+	if err != nil {
+		return errorRC(tpm2.ErrorFailure)
+	}
+
+	switch mode {
+	case tpm2.SymModeECB, tpm2.SymModeCBC:
+		if len(inData)%block.BlockSize() != 0 {
+			return paramRC(tpm2.ErrorSize, paramIndex(1))
+		}
+	case tpm2.SymModeCFB, tpm2.SymModeOFB, tpm2.SymModeCTR:
+	default:
+		return paramRC(tpm2.ErrorMode, paramIndex(3))
+	}
+	if mode != tpm2.SymModeECB && len(ivIn) != block.BlockSize() {
+		return paramRC(tpm2.ErrorSize, paramIndex(4))
+	}
+
+	outData, ivOut := cryptSymmetric(block, mode, ivIn, inData, decrypt)
+	return c.respond(tpm2.MaxBuffer(outData), tpm2.IV(ivOut))
+}
+
+func (s *Simulator) encryptDecryptLegacy(c *commandContext) tpm2.ResponseCode {
+	var decrypt bool
+	var mode tpm2.SymModeId
+	var ivIn tpm2.IV
+	var inData tpm2.MaxBuffer
+	if rc := c.unmarshalParams(&decrypt, &mode, &ivIn, &inData); rc != tpm2.Success {
+		return rc
+	}
+	return s.encryptDecrypt(c, inData, decrypt, mode, ivIn, func(n int) int {
+		// Map the parameter indices of TPM2_EncryptDecrypt2 to those of TPM2_EncryptDecrypt.
+		if n == 1 {
+			return 4
+		}
+		return n - 1
+	})
+}
+
+func (s *Simulator) encryptDecrypt2(c *commandContext) tpm2.ResponseCode {
+	var inData tpm2.MaxBuffer
+	var decrypt bool
+	var mode tpm2.SymModeId
+	var ivIn tpm2.IV
+	if rc := c.unmarshalParams(&inData, &decrypt, &mode, &ivIn); rc != tpm2.Success {
+		return rc
+	}
+	return s.encryptDecrypt(c, inData, decrypt, mode, ivIn, func(n int) int { return n })
+}
@@ -95,14 +95,32 @@ func (c CommandCode) String() string {
 		return "TPM_CC_HierarchyControl"
 	case CommandNVUndefineSpace:
 		return "TPM_CC_NV_UndefineSpace"
+	case CommandChangeEPS:
+		return "TPM_CC_ChangeEPS"
+	case CommandChangePPS:
+		return "TPM_CC_ChangePPS"
 	case CommandClear:
 		return "TPM_CC_Clear"
 	case CommandClearControl:
 		return "TPM_CC_ClearControl"
+	case CommandClockSet:
+		return "TPM_CC_ClockSet"
 	case CommandHierarchyChangeAuth:
 		return "TPM_CC_HierarchyChangeAuth"
 	case CommandNVDefineSpace:
 		return "TPM_CC_NV_DefineSpace"
+	case CommandPCRAllocate:
+		return "TPM_CC_PCR_Allocate"
+	case CommandPCRSetAuthPolicy:
+		return "TPM_CC_PCR_SetAuthPolicy"
+	case CommandPPCommands:
+		return "TPM_CC_PP_Commands"
+	case CommandSetPrimaryPolicy:
+		return "TPM_CC_SetPrimaryPolicy"
+	case CommandFieldUpgradeStart:
+		return "TPM_CC_FieldUpgradeStart"
+	case CommandClockRateAdjust:
+		return "TPM_CC_ClockRateAdjust"
 	case CommandCreatePrimary:
 		return "TPM_CC_CreatePrimary"
 	case CommandNVGlobalWriteLock:
@@ -131,8 +149,12 @@ func (c CommandCode) String() string {
 		return "TPM_CC_PCR_Reset"
 	case CommandSequenceComplete:
 		return "TPM_CC_SequenceComplete"
+	case CommandSetAlgorithmSet:
+		return "TPM_CC_SetAlgorithmSet"
 	case CommandSetCommandCodeAuditStatus:
 		return "TPM_CC_SetCommandCodeAuditStatus"
+	case CommandFieldUpgradeData:
+		return "TPM_CC_FieldUpgradeData"
 	case CommandIncrementalSelfTest:
 		return "TPM_CC_IncrementalSelfTest"
 	case CommandSelfTest:
@@ -165,14 +187,22 @@ func (c CommandCode) String() string {
 		return "TPM_CC_ObjectChangeAuth"
 	case CommandPolicySecret:
 		return "TPM_CC_PolicySecret"
+	case CommandRewrap:
+		return "TPM_CC_Rewrap"
 	case CommandCreate:
 		return "TPM_CC_Create"
+	case CommandECDHZGen:
+		return "TPM_CC_ECDH_ZGen"
+	case CommandHMAC:
+		return "TPM_CC_HMAC"
 	case CommandImport:
 		return "TPM_CC_Import"
 	case CommandLoad:
 		return "TPM_CC_Load"
 	case CommandQuote:
 		return "TPM_CC_Quote"
+	case CommandRSADecrypt:
+		return "TPM_CC_RSA_Decrypt"
 	case CommandHMACStart:
 		return "TPM_CC_HMAC_Start"
 	case CommandSequenceUpdate:
@@ -187,6 +217,10 @@ func (c CommandCode) String() string {
 		return "TPM_CC_ContextLoad"
 	case CommandContextSave:
 		return "TPM_CC_ContextSave"
+	case CommandECDHKeyGen:
+		return "TPM_CC_ECDH_KeyGen"
+	case CommandEncryptDecrypt:
+		return "TPM_CC_EncryptDecrypt"
 	case CommandFlushContext:
 		return "TPM_CC_FlushContext"
 	case CommandLoadExternal:
@@ -205,6 +239,8 @@ func (c CommandCode) String() string {
 		return "TPM_CC_PolicyCounterTimer"
 	case CommandPolicyCpHash:
 		return "TPM_CC_PolicyCpHash"
+	case CommandPolicyLocality:
+		return "TPM_CC_PolicyLocality"
 	case CommandPolicyNameHash:
 		return "TPM_CC_PolicyNameHash"
 	case CommandPolicyOR:
@@ -213,16 +249,24 @@ func (c CommandCode) String() string {
 		return "TPM_CC_PolicyTicket"
 	case CommandReadPublic:
 		return "TPM_CC_ReadPublic"
+	case CommandRSAEncrypt:
+		return "TPM_CC_RSA_Encrypt"
 	case CommandStartAuthSession:
 		return "TPM_CC_StartAuthSession"
 	case CommandVerifySignature:
 		return "TPM_CC_VerifySignature"
+	case CommandECCParameters:
+		return "TPM_CC_ECC_Parameters"
+	case CommandFirmwareRead:
+		return "TPM_CC_FirmwareRead"
 	case CommandGetCapability:
 		return "TPM_CC_GetCapability"
 	case CommandGetRandom:
 		return "TPM_CC_GetRandom"
 	case CommandGetTestResult:
 		return "TPM_CC_GetTestResult"
+	case CommandHash:
+		return "TPM_CC_Hash"
 	case CommandPCRRead:
 		return "TPM_CC_PCR_Read"
 	case CommandPolicyPCR:
@@ -233,23 +277,48 @@ func (c CommandCode) String() string {
 		return "TPM_CC_ReadClock"
 	case CommandPCRExtend:
 		return "TPM_CC_PCR_Extend"
+	case CommandPCRSetAuthValue:
+		return "TPM_CC_PCR_SetAuthValue"
+	case CommandNVCertify:
+		return "TPM_CC_NV_Certify"
 	case CommandEventSequenceComplete:
 		return "TPM_CC_EventSequenceComplete"
 	case CommandHashSequenceStart:
 		return "TPM_CC_HashSequenceStart"
+	case CommandPolicyPhysicalPresence:
+		return "TPM_CC_PolicyPhysicalPresence"
 	case CommandPolicyDuplicationSelect:
 		return "TPM_CC_PolicyDuplicationSelect"
 	case CommandPolicyGetDigest:
 		return "TPM_CC_PolicyGetDigest"
 	case CommandTestParms:
 		return "TPM_CC_TestParms"
+	case CommandCommit:
+		return "TPM_CC_Commit"
 	case CommandPolicyPassword:
 		return "TPM_CC_PolicyPassword"
+	case CommandZGen2Phase:
+		return "TPM_CC_ZGen_2Phase"
+	case CommandECEphemeral:
+		return "TPM_CC_EC_Ephemeral"
 	case CommandPolicyNvWritten:
 		return "TPM_CC_PolicyNvWritten"
+	case CommandPolicyTemplate:
+		return "TPM_CC_PolicyTemplate"
 	case CommandCreateLoaded:
 		return "TPM_CC_CreateLoaded"
+	case CommandPolicyAuthorizeNV:
+		return "TPM_CC_PolicyAuthorizeNV"
+	case CommandEncryptDecrypt2:
+		return "TPM_CC_EncryptDecrypt2"
+	case CommandCertifyX509:
+		return "TPM_CC_CertifyX509"
+	case CommandACTSetTimeout:
+		return "TPM_CC_ACT_SetTimeout"
 	default:
+		if cmd, isVendor := lookupVendorCommand(c); isVendor {
+			return cmd.Name
+		}
 		return fmt.Sprintf("0x%08x", uint32(c))
 	}
 }
@@ -263,6 +332,36 @@ func (c CommandCode) Format(s fmt.State, f rune) {
 	}
 }
 
+func (a ClockAdjust) String() string {
+	switch a {
+	case ClockAdjustCoarseSlower:
+		return "TPM_CLOCK_COARSE_SLOWER"
+	case ClockAdjustMediumSlower:
+		return "TPM_CLOCK_MEDIUM_SLOWER"
+	case ClockAdjustFineSlower:
+		return "TPM_CLOCK_FINE_SLOWER"
+	case ClockAdjustNoChange:
+		return "TPM_CLOCK_NO_CHANGE"
+	case ClockAdjustFineFaster:
+		return "TPM_CLOCK_FINE_FASTER"
+	case ClockAdjustMediumFaster:
+		return "TPM_CLOCK_MEDIUM_FASTER"
+	case ClockAdjustCoarseFaster:
+		return "TPM_CLOCK_COARSE_FASTER"
+	default:
+		return fmt.Sprintf("%d", int8(a))
+	}
+}
+
+func (a ClockAdjust) Format(s fmt.State, f rune) {
+	switch f {
+	case 's', 'v':
+		fmt.Fprintf(s, "%s", a.String())
+	default:
+		fmt.Fprintf(s, makeDefaultFormatter(s, f), int8(a))
+	}
+}
+
 func (e ErrorCode) String() string {
 	switch e {
 	case ErrorInitialize:
@@ -500,6 +599,38 @@ func (h Handle) String() string {
 		return "TPM_RH_PLATFORM"
 	case HandlePlatformNV:
 		return "TPM_RH_PLATFORM_NV"
+	case HandleACT0:
+		return "TPM_RH_ACT_0"
+	case HandleACT1:
+		return "TPM_RH_ACT_1"
+	case HandleACT2:
+		return "TPM_RH_ACT_2"
+	case HandleACT3:
+		return "TPM_RH_ACT_3"
+	case HandleACT4:
+		return "TPM_RH_ACT_4"
+	case HandleACT5:
+		return "TPM_RH_ACT_5"
+	case HandleACT6:
+		return "TPM_RH_ACT_6"
+	case HandleACT7:
+		return "TPM_RH_ACT_7"
+	case HandleACT8:
+		return "TPM_RH_ACT_8"
+	case HandleACT9:
+		return "TPM_RH_ACT_9"
+	case HandleACTA:
+		return "TPM_RH_ACT_A"
+	case HandleACTB:
+		return "TPM_RH_ACT_B"
+	case HandleACTC:
+		return "TPM_RH_ACT_C"
+	case HandleACTD:
+		return "TPM_RH_ACT_D"
+	case HandleACTE:
+		return "TPM_RH_ACT_E"
+	case HandleACTF:
+		return "TPM_RH_ACT_F"
 	default:
 		return fmt.Sprintf("0x%08x", uint32(h))
 	}
@@ -664,6 +795,8 @@ func (c Capability) String() string {
 		return "TPM_CAP_ECC_CURVES"
 	case CapabilityAuthPolicies:
 		return "TPM_CAP_AUTH_POLICIES"
+	case CapabilityACT:
+		return "TPM_CAP_ACT"
 	default:
 		return fmt.Sprintf("0x%08x", uint32(c))
 	}
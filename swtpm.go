@@ -0,0 +1,282 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	swtpmCmdInit                uint32 = 2
+	swtpmCmdShutdown            uint32 = 3
+	swtpmCmdGetTPMEstablished   uint32 = 4
+	swtpmCmdSetLocality         uint32 = 5
+	swtpmCmdCancelTPMCmd        uint32 = 9
+	swtpmCmdResetTPMEstablished uint32 = 11
+	swtpmCmdGetStateBlob        uint32 = 12
+	swtpmCmdSetStateBlob        uint32 = 13
+	swtpmCmdStop                uint32 = 14
+)
+
+const (
+	swtpmInitFlagDeleteVolatile uint32 = 1 << 0
+
+	swtpmStateFlagEncrypted uint32 = 1 << 1
+)
+
+// SwtpmStateBlobType corresponds to the type of a state blob that can be obtained from or loaded in to swtpm.
+type SwtpmStateBlobType uint32
+
+const (
+	SwtpmStateBlobPermanent SwtpmStateBlobType = 1 // Permanent state, corresponding to the TPM's NV memory
+	SwtpmStateBlobVolatile  SwtpmStateBlobType = 2 // Volatile state, corresponding to the TPM's RAM
+	SwtpmStateBlobSaveState SwtpmStateBlobType = 3 // State saved by TPM2_Shutdown(STATE)
+)
+
+// SwtpmStateBlob is a state blob obtained from swtpm with TctiSwtpm.GetStateBlob, and which can be loaded back in to swtpm with
+// TctiSwtpm.SetStateBlob.
+type SwtpmStateBlob struct {
+	Type      SwtpmStateBlobType
+	Encrypted bool // Whether Data is encrypted with swtpm's state encryption key
+	Data      []byte
+}
+
+// SwtpmControlError corresponds to an error code in response to a command executed on the control channel of swtpm.
+type SwtpmControlError struct {
+	commandCode uint32
+	Code        uint32
+}
+
+func (e *SwtpmControlError) Error() string {
+	return fmt.Sprintf("received error code 0x%08x in response to swtpm control command %d", e.Code, e.commandCode)
+}
+
+// TctiSwtpm represents a connection to swtpm using its socket interface, where TPM commands are sent on the data channel and
+// other operations are performed using the control channel protocol. It implements the Transport, LocalityTransport,
+// CancellableTransport and PowerControlTransport interfaces.
+type TctiSwtpm struct {
+	locality uint8
+
+	tpm  net.Conn
+	ctrl net.Conn
+
+	ctrlMu sync.Mutex
+}
+
+func (t *TctiSwtpm) Read(data []byte) (int, error) {
+	return t.tpm.Read(data)
+}
+
+func (t *TctiSwtpm) Write(data []byte) (int, error) {
+	return t.tpm.Write(data)
+}
+
+func (t *TctiSwtpm) Close() (out error) {
+	if err := t.ctrl.Close(); err != nil {
+		out = xerrors.Errorf("cannot close control channel: %w", err)
+	}
+	if err := t.tpm.Close(); err != nil {
+		out = xerrors.Errorf("cannot close data channel: %w", err)
+	}
+	return
+}
+
+// sendControlCommand marshals the supplied command and parameters and sends them on the control channel. swtpm expects each
+// command to be received in a single read, so it must be sent in a single write.
+func (t *TctiSwtpm) sendControlCommand(cmd uint32, params ...interface{}) error {
+	buf, err := mu.MarshalToBytes(append([]interface{}{cmd}, params...)...)
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal command: %v", err))
+	}
+	if _, err := t.ctrl.Write(buf); err != nil {
+		return xerrors.Errorf("cannot send command: %w", err)
+	}
+	return nil
+}
+
+// controlCommand executes the specified command on the control channel with the supplied parameters. The rest of the response
+// after the response code is unmarshalled in to the supplied response parameters, and then the response code is checked.
+func (t *TctiSwtpm) controlCommand(cmd uint32, params []interface{}, responseParams ...interface{}) error {
+	t.ctrlMu.Lock()
+	defer t.ctrlMu.Unlock()
+
+	if err := t.sendControlCommand(cmd, params...); err != nil {
+		return err
+	}
+
+	// The complete response is returned even if the command fails.
+	var rc uint32
+	if err := binary.Read(t.ctrl, binary.BigEndian, &rc); err != nil {
+		return xerrors.Errorf("cannot read response to command: %w", err)
+	}
+	if _, err := mu.UnmarshalFromReader(t.ctrl, responseParams...); err != nil {
+		return xerrors.Errorf("cannot read response to command: %w", err)
+	}
+	if rc != 0 {
+		return &SwtpmControlError{cmd, rc}
+	}
+	return nil
+}
+
+// Init executes the CMD_INIT command on the control channel, which initializes the TPM and results in the execution of _TPM_Init().
+// Unless swtpm was started with the not-need-init or startup-* flags, this must be called before any TPM commands can be executed.
+// If deleteVolatile is true, any volatile state that swtpm has stored is deleted.
+func (t *TctiSwtpm) Init(deleteVolatile bool) error {
+	var flags uint32
+	if deleteVolatile {
+		flags |= swtpmInitFlagDeleteVolatile
+	}
+	return t.controlCommand(swtpmCmdInit, []interface{}{flags})
+}
+
+// Shutdown executes the CMD_SHUTDOWN command on the control channel, which causes swtpm to shut down the TPM and then exit. No
+// further commands can be executed after this.
+func (t *TctiSwtpm) Shutdown() error {
+	return t.controlCommand(swtpmCmdShutdown, nil)
+}
+
+// Stop executes the CMD_STOP command on the control channel, which stops the TPM without causing swtpm to exit. The TPM can be
+// restarted with Init. The TPM must be stopped before calling SetStateBlob.
+func (t *TctiSwtpm) Stop() error {
+	return t.controlCommand(swtpmCmdStop, nil)
+}
+
+// PowerOn initializes the TPM by executing the CMD_INIT command on the control channel. It is equivalent to Init(false).
+func (t *TctiSwtpm) PowerOn() error {
+	return t.Init(false)
+}
+
+// PowerOff stops the TPM by executing the CMD_STOP command on the control channel. It is equivalent to Stop.
+func (t *TctiSwtpm) PowerOff() error {
+	return t.Stop()
+}
+
+// Reset initiates a reset of the TPM by executing the CMD_INIT command on the control channel, which results in the execution of
+// _TPM_Init().
+func (t *TctiSwtpm) Reset() error {
+	return t.Init(false)
+}
+
+// Cancel executes the CMD_CANCEL_TPM_CMD command on the control channel, which requests that the TPM cancels the command that it is
+// currently executing.
+func (t *TctiSwtpm) Cancel() error {
+	return t.controlCommand(swtpmCmdCancelTPMCmd, nil)
+}
+
+// GetLocality returns the locality from which commands are submitted to the TPM.
+func (t *TctiSwtpm) GetLocality() uint8 {
+	return t.locality
+}
+
+// SetLocality executes the CMD_SET_LOCALITY command on the control channel, which sets the locality from which subsequent commands
+// are submitted to the TPM. Valid localities are 0 to 4.
+func (t *TctiSwtpm) SetLocality(locality uint8) error {
+	if locality > 4 {
+		return fmt.Errorf("invalid locality %d", locality)
+	}
+	if err := t.controlCommand(swtpmCmdSetLocality, []interface{}{locality}); err != nil {
+		return err
+	}
+	t.locality = locality
+	return nil
+}
+
+// GetTPMEstablished executes the CMD_GET_TPMESTABLISHED command on the control channel, and returns the value of the TPM's
+// tpmEstablished flag.
+func (t *TctiSwtpm) GetTPMEstablished() (bool, error) {
+	// The response contains a single byte followed by 3 bytes of padding.
+	resp := make(mu.RawBytes, 4)
+	if err := t.controlCommand(swtpmCmdGetTPMEstablished, nil, &resp); err != nil {
+		return false, err
+	}
+	return resp[0] != 0, nil
+}
+
+// ResetTPMEstablished executes the CMD_RESET_TPMESTABLISHED command on the control channel, which clears the TPM's tpmEstablished
+// flag. The command is executed from the specified locality, which must be 3 or 4.
+func (t *TctiSwtpm) ResetTPMEstablished(locality uint8) error {
+	return t.controlCommand(swtpmCmdResetTPMEstablished, []interface{}{locality})
+}
+
+// GetStateBlob executes the CMD_GET_STATEBLOB command on the control channel, and returns the state blob of the specified type. The
+// blob is returned in the form that swtpm stores it, which means that it will be encrypted if swtpm is configured with a state
+// encryption key. In order to obtain a consistent snapshot of the TPM, it should be stopped with Stop first.
+func (t *TctiSwtpm) GetStateBlob(blobType SwtpmStateBlobType) (*SwtpmStateBlob, error) {
+	var resp struct {
+		StateFlags uint32
+		TotLength  uint32
+		Length     uint32
+	}
+	// The data is written to the control channel after the response header, so hold the lock until it has been read.
+	t.ctrlMu.Lock()
+	defer t.ctrlMu.Unlock()
+
+	if err := t.sendControlCommand(swtpmCmdGetStateBlob, uint32(0), blobType, uint32(0)); err != nil {
+		return nil, err
+	}
+
+	var rc uint32
+	if err := binary.Read(t.ctrl, binary.BigEndian, &rc); err != nil {
+		return nil, xerrors.Errorf("cannot read response to command: %w", err)
+	}
+	if _, err := mu.UnmarshalFromReader(t.ctrl, &resp); err != nil {
+		return nil, xerrors.Errorf("cannot read response to command: %w", err)
+	}
+	data := make([]byte, resp.Length)
+	if _, err := io.ReadFull(t.ctrl, data); err != nil {
+		return nil, xerrors.Errorf("cannot read state blob: %w", err)
+	}
+	if rc != 0 {
+		return nil, &SwtpmControlError{swtpmCmdGetStateBlob, rc}
+	}
+
+	return &SwtpmStateBlob{Type: blobType, Encrypted: resp.StateFlags&swtpmStateFlagEncrypted != 0, Data: data}, nil
+}
+
+// SetStateBlob executes the CMD_SET_STATEBLOB command on the control channel, which loads the supplied state blob in to swtpm. The
+// TPM must be stopped with Stop before calling this, and it must be restarted afterwards with Init.
+func (t *TctiSwtpm) SetStateBlob(blob *SwtpmStateBlob) error {
+	var flags uint32
+	if blob.Encrypted {
+		flags |= swtpmStateFlagEncrypted
+	}
+	return t.controlCommand(swtpmCmdSetStateBlob, []interface{}{flags, blob.Type, uint32(len(blob.Data)), mu.RawBytes(blob.Data)})
+}
+
+// OpenSwtpm attempts to open a connection to swtpm using its socket interface. The network argument is the network type accepted by
+// net.Dial, and is "tcp" for swtpm's TCP socket interface or "unix" for its UNIX domain socket interface. tpmAddress is the address
+// of the data channel, which is configured with swtpm's --server option. ctrlAddress is the address of the control channel, which is
+// configured with swtpm's --ctrl option.
+//
+// This function does not initialize the TPM. If swtpm was not started with a flag that causes it to initialize the TPM, Init must
+// be called on the returned TctiSwtpm before any TPM commands can be executed.
+//
+// If successful, it returns a new TctiSwtpm instance which can be passed to NewTPMContext.
+func OpenSwtpm(network, tpmAddress, ctrlAddress string) (*TctiSwtpm, error) {
+	tcti := new(TctiSwtpm)
+
+	tpm, err := net.Dial(network, tpmAddress)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot connect to data channel: %w", err)
+	}
+	tcti.tpm = tpm
+
+	ctrl, err := net.Dial(network, ctrlAddress)
+	if err != nil {
+		tcti.tpm.Close()
+		return nil, xerrors.Errorf("cannot connect to control channel: %w", err)
+	}
+	tcti.ctrl = ctrl
+
+	return tcti, nil
+}
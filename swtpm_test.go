@@ -0,0 +1,329 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+// pipeListener is a net.Listener that accepts connections created with net.Pipe. Unlike a TCP connection, each read from a pipe
+// returns the data from at most one write, which makes it possible to check how the data was written.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (l *pipeListener) dial() net.Conn {
+	client, server := net.Pipe()
+	l.conns <- server
+	return client
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// mockSwtpm implements enough of swtpm's socket interface to test TctiSwtpm. Like swtpm, it expects each command on the control
+// channel to be received in a single read.
+type mockSwtpm struct {
+	t *testing.T
+
+	tpmListener  net.Listener
+	ctrlListener net.Listener
+
+	initFlags   uint32
+	locality    uint8
+	established bool
+	blobs       map[uint32][]byte
+}
+
+func newMockSwtpm(t *testing.T, tpmListener, ctrlListener net.Listener) *mockSwtpm {
+	s := &mockSwtpm{
+		t:            t,
+		tpmListener:  tpmListener,
+		ctrlListener: ctrlListener,
+		established:  true,
+		blobs:        map[uint32][]byte{1: []byte("permanent state")}}
+	go s.serveTPM()
+	go s.serveCtrl()
+	return s
+}
+
+func (s *mockSwtpm) close() {
+	s.tpmListener.Close()
+	s.ctrlListener.Close()
+}
+
+func (s *mockSwtpm) serveTPM() {
+	conn, err := s.tpmListener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		var hdr struct {
+			Tag         StructTag
+			CommandSize uint32
+			CommandCode CommandCode
+		}
+		if _, err := mu.UnmarshalFromReader(conn, &hdr); err != nil {
+			return
+		}
+		if _, err := io.CopyN(ioutil.Discard, conn, int64(hdr.CommandSize-10)); err != nil {
+			return
+		}
+		// Respond to every command with a TPM2_GetRandom response.
+		rsp, _ := mu.MarshalToBytes(TagNoSessions, uint32(20), Success, Digest("12345678"))
+		conn.Write(rsp)
+	}
+}
+
+func (s *mockSwtpm) serveCtrl() {
+	conn, err := s.ctrlListener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		req := bytes.NewReader(buf[:n])
+
+		var cmd uint32
+		read := func(data ...interface{}) bool {
+			if _, err := mu.UnmarshalFromReader(req, data...); err != nil {
+				s.t.Errorf("Cannot read control command %d: %v", cmd, err)
+				return false
+			}
+			return true
+		}
+		if !read(&cmd) {
+			return
+		}
+
+		var rsp []interface{}
+		switch cmd {
+		case 2: // CMD_INIT
+			if !read(&s.initFlags) {
+				return
+			}
+			rsp = []interface{}{uint32(0)}
+		case 4: // CMD_GET_TPMESTABLISHED
+			var bit uint8
+			if s.established {
+				bit = 1
+			}
+			rsp = []interface{}{uint32(0), bit, uint8(0), uint8(0), uint8(0)}
+		case 5: // CMD_SET_LOCALITY
+			if !read(&s.locality) {
+				return
+			}
+			rsp = []interface{}{uint32(0)}
+		case 9: // CMD_CANCEL_TPM_CMD
+			rsp = []interface{}{uint32(0x1)}
+		case 11: // CMD_RESET_TPMESTABLISHED
+			var loc uint8
+			if !read(&loc) {
+				return
+			}
+			if loc < 3 {
+				rsp = []interface{}{uint32(0x3d)} // TPM_BAD_LOCALITY
+				break
+			}
+			s.established = false
+			rsp = []interface{}{uint32(0)}
+		case 12: // CMD_GET_STATEBLOB
+			var r struct {
+				Flags, Type, Offset uint32
+			}
+			if !read(&r) {
+				return
+			}
+			blob, ok := s.blobs[r.Type]
+			if !ok {
+				rsp = []interface{}{uint32(0x1), uint32(0), uint32(0), uint32(0)}
+				break
+			}
+			rsp = []interface{}{uint32(0), uint32(0), uint32(len(blob)), uint32(len(blob)), mu.RawBytes(blob)}
+		case 13: // CMD_SET_STATEBLOB
+			var r struct {
+				Flags, Type, Length uint32
+			}
+			if !read(&r) {
+				return
+			}
+			blob := make(mu.RawBytes, r.Length)
+			if !read(&blob) {
+				return
+			}
+			s.blobs[r.Type] = blob
+			rsp = []interface{}{uint32(0)}
+		default:
+			s.t.Errorf("Unexpected control command %d", cmd)
+			return
+		}
+
+		if req.Len() != 0 {
+			s.t.Errorf("Unexpected trailing bytes for control command %d", cmd)
+			return
+		}
+
+		b, _ := mu.MarshalToBytes(rsp...)
+		conn.Write(b)
+	}
+}
+
+func TestSwtpm(t *testing.T) {
+	tpmListener := newPipeListener()
+	ctrlListener := newPipeListener()
+	s := newMockSwtpm(t, tpmListener, ctrlListener)
+	defer s.close()
+
+	tcti := NewSwtpmForTesting(tpmListener.dial(), ctrlListener.dial())
+	tpm, _ := NewTPMContext(tcti)
+	defer closeTPM(t, tpm)
+
+	if err := tcti.Init(true); err != nil {
+		t.Errorf("Init failed: %v", err)
+	}
+	if err := tcti.SetLocality(2); err != nil {
+		t.Errorf("SetLocality failed: %v", err)
+	}
+	if err := tcti.SetLocality(5); err == nil {
+		t.Errorf("SetLocality should fail for an invalid locality")
+	}
+	if tcti.GetLocality() != 2 {
+		t.Errorf("Unexpected locality %d", tcti.GetLocality())
+	}
+
+	digest, err := tpm.GetRandom(8)
+	if err != nil {
+		t.Fatalf("GetRandom failed: %v", err)
+	}
+	if !bytes.Equal(digest, []byte("12345678")) {
+		t.Errorf("Unexpected response (%x)", digest)
+	}
+
+	established, err := tcti.GetTPMEstablished()
+	if err != nil {
+		t.Fatalf("GetTPMEstablished failed: %v", err)
+	}
+	if !established {
+		t.Errorf("Unexpected tpmEstablished value")
+	}
+	if err := tcti.ResetTPMEstablished(0); err == nil {
+		t.Errorf("ResetTPMEstablished should fail from locality 0")
+	}
+	if err := tcti.ResetTPMEstablished(3); err != nil {
+		t.Errorf("ResetTPMEstablished failed: %v", err)
+	}
+	established, err = tcti.GetTPMEstablished()
+	if err != nil {
+		t.Fatalf("GetTPMEstablished failed: %v", err)
+	}
+	if established {
+		t.Errorf("Unexpected tpmEstablished value")
+	}
+
+	var e *SwtpmControlError
+	if err := tcti.Cancel(); !xerrors.As(err, &e) || e.Code != 1 {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	blob, err := tcti.GetStateBlob(SwtpmStateBlobPermanent)
+	if err != nil {
+		t.Fatalf("GetStateBlob failed: %v", err)
+	}
+	if !bytes.Equal(blob.Data, []byte("permanent state")) || blob.Encrypted {
+		t.Errorf("Unexpected state blob")
+	}
+	if _, err := tcti.GetStateBlob(SwtpmStateBlobVolatile); !xerrors.As(err, &e) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := tcti.SetStateBlob(&SwtpmStateBlob{Type: SwtpmStateBlobVolatile, Data: []byte("volatile state")}); err != nil {
+		t.Errorf("SetStateBlob failed: %v", err)
+	}
+	blob, err = tcti.GetStateBlob(SwtpmStateBlobVolatile)
+	if err != nil {
+		t.Fatalf("GetStateBlob failed: %v", err)
+	}
+	if !bytes.Equal(blob.Data, []byte("volatile state")) {
+		t.Errorf("Unexpected state blob")
+	}
+
+	if s.initFlags != 1 {
+		t.Errorf("Unexpected init flags")
+	}
+	if s.locality != 2 {
+		t.Errorf("Unexpected locality")
+	}
+}
+
+func TestOpenSwtpm(t *testing.T) {
+	tpmListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctrlListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	s := newMockSwtpm(t, tpmListener, ctrlListener)
+	defer s.close()
+
+	tcti, err := OpenSwtpm("tcp", tpmListener.Addr().String(), ctrlListener.Addr().String())
+	if err != nil {
+		t.Fatalf("OpenSwtpm failed: %v", err)
+	}
+	tpm, _ := NewTPMContext(tcti)
+	defer closeTPM(t, tpm)
+
+	if err := tcti.Init(false); err != nil {
+		t.Errorf("Init failed: %v", err)
+	}
+	if _, err := tpm.GetRandom(8); err != nil {
+		t.Errorf("GetRandom failed: %v", err)
+	}
+}
@@ -6,11 +6,13 @@ package tpm2
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"reflect"
+	"time"
 
 	"github.com/canonical/go-tpm2/mu"
 
@@ -71,6 +73,7 @@ type cmdContext struct {
 	responseCode  ResponseCode
 	responseTag   StructTag
 	responseBytes []byte
+	trace         *CommandTrace
 }
 
 type delimiterSentinel struct{}
@@ -87,15 +90,10 @@ type ResourceContextWithSession struct {
 }
 
 // TODO: Implement commands from the following sections of part 3 of the TPM library spec:
-// Section 14 - Asymmetric Primitives
-// Section 15 - Symmetric Primitives
 // Section 17 - Hash/HMAC/Event Sequences
-// Section 19 - Ephemeral EC Keys
-// Section 26 - Miscellaneous Management Functions
-// Section 27 - Field Upgrade
 
 // TPMContext is the main entry point by which commands are executed on a TPM device using this package. It communicates with the
-// underlying device via a transmission interface, which is an implementation of io.ReadWriteCloser provided to NewTPMContext.
+// underlying device via a transmission interface, which is an implementation of Transport provided to NewTPMContext.
 //
 // Methods that execute commands on the TPM will return errors where the TPM responds with them. These are in the form of *TPMError,
 // *TPMWarning, *TPMHandleError, *TPMSessionError, *TPMParameterError and *TPMVendorError types.
@@ -104,13 +102,40 @@ type ResourceContextWithSession struct {
 // authorization for a corresponding TPM resource. These sessions may be used for the purposes of session based parameter encryption
 // or command auditing.
 type TPMContext struct {
-	tcti                  io.ReadWriteCloser
+	tcti                  Transport
+	commandCtx            context.Context
+	pendingResponse       <-chan *commandResponse
 	permanentResources    map[Handle]*permanentContext
 	maxSubmissions        uint
 	propertiesInitialized bool
 	maxNVBufferSize       int
 	maxBufferSize         int
+	maxCommandSize        int
 	exclusiveSession      *sessionContext
+	observer              CommandObserver
+}
+
+// Transport returns the transmission interface that was provided to NewTPMContext. This can be used to access optional
+// functionality provided by the transmission interface, by testing whether it implements interfaces such as LocalityTransport,
+// CancellableTransport, PowerControlTransport or PhysicalPresenceTransport.
+func (t *TPMContext) Transport() Transport {
+	return t.tcti
+}
+
+// MaxCommandSize returns the maximum size in bytes of a command that can be executed via this TPMContext. This is the smaller of the
+// value of the TPM_PT_MAX_COMMAND_SIZE property reported by the TPM and the limit imposed by the transmission interface, if it
+// implements MaxCommandSizeTransport. The TPM properties are obtained by calling InitProperties if they haven't already been
+// obtained.
+func (t *TPMContext) MaxCommandSize() (int, error) {
+	if err := t.initPropertiesIfNeeded(); err != nil {
+		return 0, err
+	}
+
+	size := t.maxCommandSize
+	if m, ok := t.tcti.(MaxCommandSizeTransport); ok && m.MaxCommandSize() < size {
+		size = m.MaxCommandSize()
+	}
+	return size, nil
 }
 
 // Close calls Close on the transmission interface.
@@ -122,6 +147,64 @@ func (t *TPMContext) Close() error {
 	return nil
 }
 
+type commandResponse struct {
+	code  ResponseCode
+	tag   StructTag
+	bytes []byte
+	err   error
+}
+
+func (t *TPMContext) readResponse(commandCode CommandCode) *commandResponse {
+	var rHeader responseHeader
+	rHeaderSize := uint32(binary.Size(rHeader))
+	rHeaderBytes := make([]byte, rHeaderSize)
+	if n, err := io.ReadFull(t.tcti, rHeaderBytes); err != nil {
+		if xerrors.Is(err, io.ErrUnexpectedEOF) {
+			return &commandResponse{err: &InvalidResponseError{commandCode, fmt.Sprintf("insufficient bytes for response header "+
+				"(got %d, expected %d)", n, rHeaderSize)}}
+		}
+		return &commandResponse{err: &TctiError{"read", err}}
+	}
+
+	if _, err := mu.UnmarshalFromBytes(rHeaderBytes, &rHeader); err != nil {
+		panic(fmt.Sprintf("cannot unmarshal response header: %v", err))
+	}
+
+	if rHeader.ResponseSize < rHeaderSize {
+		return &commandResponse{err: &InvalidResponseError{commandCode, fmt.Sprintf("invalid responseSize value (%d)", rHeader.ResponseSize)}}
+	}
+
+	responseBytes := make([]byte, rHeader.ResponseSize-rHeaderSize)
+	if n, err := io.ReadFull(t.tcti, responseBytes); err != nil {
+		if xerrors.Is(err, io.ErrUnexpectedEOF) {
+			return &commandResponse{err: &InvalidResponseError{commandCode, fmt.Sprintf("insufficient bytes for response payload "+
+				"(got %d, expected %d)", n, len(responseBytes))}}
+		}
+		return &commandResponse{err: &TctiError{"read", err}}
+	}
+
+	return &commandResponse{code: rHeader.ResponseCode, tag: rHeader.Tag, bytes: responseBytes}
+}
+
+// cancelGracePeriod is how long to wait for the TPM to respond after asking it to cancel a command, before abandoning the command.
+var cancelGracePeriod = time.Second
+
+// waitForPendingResponse waits for and discards the response to a previous command that was abandoned because its context was
+// canceled, so that it isn't mistaken for the response to the next command.
+func (t *TPMContext) waitForPendingResponse(ctx context.Context, commandCode CommandCode) error {
+	if t.pendingResponse == nil {
+		return nil
+	}
+
+	select {
+	case <-t.pendingResponse:
+		t.pendingResponse = nil
+		return nil
+	case <-ctx.Done():
+		return &CommandCanceledError{commandCode, ctx.Err()}
+	}
+}
+
 // RunCommandBytes is a low-level interface for executing the command defined by the specified commandCode. It will construct an
 // appropriate header, but the caller is responsible for providing the rest of the serialized command structure in commandBytes.
 // Valid values for tag are TagNoSessions if the authorization area is empty, else it must be TagSessions.
@@ -130,7 +213,43 @@ func (t *TPMContext) Close() error {
 // response structure (everything except for the header). It will not return an error if the TPM responds with an error as long as
 // the returned response structure is correctly formed, but will return an error if marshalling of the command header or
 // unmarshalling of the response header fails, or the transmission interface returns an error.
+//
+// If the context.Context set with SetCommandContext is canceled or its deadline expires before the response is received, a
+// *CommandCanceledError error will be returned. If the transmission interface implements CancellableTransport, the TPM is asked to
+// cancel the command and this function waits for a short period for the TPM to respond. If the TPM completes the command anyway,
+// its response is returned as normal. If the transmission interface does not implement CancellableTransport or the TPM doesn't
+// respond in time, the command is abandoned and its response is discarded before the next command is sent.
+//
+// If a CommandObserver has been set with SetCommandObserver, it is notified when the command completes.
 func (t *TPMContext) RunCommandBytes(tag StructTag, commandCode CommandCode, commandBytes []byte) (ResponseCode, StructTag, []byte, error) {
+	if t.observer == nil {
+		return t.runCommandBytes(tag, commandCode, commandBytes)
+	}
+
+	trace := &CommandTrace{CommandCode: commandCode, start: time.Now()}
+	rc, rTag, rBytes, err := t.runCommandBytes(tag, commandCode, commandBytes)
+	trace.ResponseCode = rc
+	if err == nil {
+		t.completeCommandTrace(trace, DecodeResponseCode(commandCode, rc))
+	} else {
+		t.completeCommandTrace(trace, err)
+	}
+	return rc, rTag, rBytes, err
+}
+
+func (t *TPMContext) runCommandBytes(tag StructTag, commandCode CommandCode, commandBytes []byte) (ResponseCode, StructTag, []byte, error) {
+	ctx := t.commandCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if err := t.waitForPendingResponse(ctx, commandCode); err != nil {
+		return 0, 0, nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, 0, nil, &CommandCanceledError{commandCode, err}
+	}
+
 	cHeader := commandHeader{tag, 0, commandCode}
 	cHeader.CommandSize = uint32(binary.Size(cHeader) + len(commandBytes))
 
@@ -143,35 +262,40 @@ func (t *TPMContext) RunCommandBytes(tag StructTag, commandCode CommandCode, com
 		return 0, 0, nil, &TctiError{"write", err}
 	}
 
-	var rHeader responseHeader
-	rHeaderSize := uint32(binary.Size(rHeader))
-	rHeaderBytes := make([]byte, rHeaderSize)
-	if n, err := io.ReadFull(t.tcti, rHeaderBytes); err != nil {
-		if xerrors.Is(err, io.ErrUnexpectedEOF) {
-			return 0, 0, nil, &InvalidResponseError{commandCode, fmt.Sprintf("insufficient bytes for response header (got %d, "+
-				"expected %d)", n, rHeaderSize)}
-		}
-		return 0, 0, nil, &TctiError{"read", err}
+	if ctx.Done() == nil {
+		// The context can never be canceled, so there's no need to read the response in another goroutine.
+		r := t.readResponse(commandCode)
+		return r.code, r.tag, r.bytes, r.err
 	}
 
-	if _, err := mu.UnmarshalFromBytes(rHeaderBytes, &rHeader); err != nil {
-		panic(fmt.Sprintf("cannot unmarshal response header: %v", err))
-	}
+	responseCh := make(chan *commandResponse, 1)
+	go func() {
+		responseCh <- t.readResponse(commandCode)
+	}()
 
-	if rHeader.ResponseSize < rHeaderSize {
-		return 0, 0, nil, &InvalidResponseError{commandCode, fmt.Sprintf("invalid responseSize value (%d)", rHeader.ResponseSize)}
+	select {
+	case r := <-responseCh:
+		return r.code, r.tag, r.bytes, r.err
+	case <-ctx.Done():
 	}
 
-	responseBytes := make([]byte, rHeader.ResponseSize-rHeaderSize)
-	if n, err := io.ReadFull(t.tcti, responseBytes); err != nil {
-		if xerrors.Is(err, io.ErrUnexpectedEOF) {
-			return 0, 0, nil, &InvalidResponseError{commandCode, fmt.Sprintf("insufficient bytes for response payload (got %d, "+
-				"expected %d)", n, len(responseBytes))}
+	if c, ok := t.tcti.(CancellableTransport); ok && c.Cancel() == nil {
+		select {
+		case r := <-responseCh:
+			if r.err != nil {
+				return 0, 0, nil, r.err
+			}
+			if e, ok := DecodeResponseCode(commandCode, r.code).(*TPMWarning); ok && e.Code == WarningCanceled {
+				return 0, 0, nil, &CommandCanceledError{commandCode, ctx.Err()}
+			}
+			return r.code, r.tag, r.bytes, nil
+		case <-time.After(cancelGracePeriod):
+			// The TPM didn't respond to the cancellation request in time, so abandon the command.
 		}
-		return 0, 0, nil, &TctiError{"read", err}
 	}
 
-	return rHeader.ResponseCode, rHeader.Tag, responseBytes, nil
+	t.pendingResponse = responseCh
+	return 0, 0, nil, &CommandCanceledError{commandCode, ctx.Err()}
 }
 
 func (t *TPMContext) runCommandWithoutProcessingResponse(commandCode CommandCode, sessionParams []*sessionParam, resources, params []interface{}) (*cmdContext, error) {
@@ -195,9 +319,12 @@ func (t *TPMContext) runCommandWithoutProcessingResponse(commandCode CommandCode
 		}
 	}
 
-	if hasDecryptSession(sessionParams) && (len(params) == 0 || !isParamEncryptable(params[0])) {
+	if hasDecryptSession(sessionParams) && !isCommandParamEncryptable(commandCode, params) {
 		return nil, fmt.Errorf("command %s does not support command parameter encryption", commandCode)
 	}
+	if hasEncryptSession(sessionParams) && !isResponseParamEncryptable(commandCode) {
+		return nil, fmt.Errorf("command %s does not support response parameter encryption", commandCode)
+	}
 
 	cBytes := new(bytes.Buffer)
 
@@ -230,12 +357,18 @@ func (t *TPMContext) runCommandWithoutProcessingResponse(commandCode CommandCode
 	var responseTag StructTag
 	var responseBytes []byte
 
+	trace := t.newCommandTrace(commandCode, sessionParams, handles, params)
+
 	for tries := uint(1); ; tries++ {
 		var err error
-		responseCode, responseTag, responseBytes, err = t.RunCommandBytes(tag, commandCode, cBytes.Bytes())
+		responseCode, responseTag, responseBytes, err = t.runCommandBytes(tag, commandCode, cBytes.Bytes())
 		if err != nil {
+			t.completeCommandTrace(trace, err)
 			return nil, err
 		}
+		if trace != nil {
+			trace.ResponseCode = responseCode
+		}
 
 		err = DecodeResponseCode(commandCode, responseCode)
 		if err == nil {
@@ -243,9 +376,11 @@ func (t *TPMContext) runCommandWithoutProcessingResponse(commandCode CommandCode
 		}
 
 		if tries >= t.maxSubmissions {
+			t.completeCommandTrace(trace, err)
 			return nil, err
 		}
 		if e, ok := err.(*TPMWarning); !ok || !(e.Code == WarningYielded || e.Code == WarningTesting || e.Code == WarningRetry) {
+			t.completeCommandTrace(trace, err)
 			return nil, err
 		}
 	}
@@ -255,10 +390,21 @@ func (t *TPMContext) runCommandWithoutProcessingResponse(commandCode CommandCode
 		sessionParams: sessionParams,
 		responseCode:  responseCode,
 		responseTag:   responseTag,
-		responseBytes: responseBytes}, nil
+		responseBytes: responseBytes,
+		trace:         trace}, nil
 }
 
-func (t *TPMContext) processResponse(context *cmdContext, handles, params []interface{}) error {
+func (t *TPMContext) processResponse(context *cmdContext, handles, params []interface{}) (err error) {
+	defer func() {
+		if context.trace != nil && err == nil {
+			for _, h := range handles {
+				context.trace.ResponseHandles = append(context.trace.ResponseHandles, *h.(*Handle))
+			}
+			context.trace.ResponseParameters = traceResponseParams(context.commandCode, params, hasEncryptSession(context.sessionParams))
+		}
+		t.completeCommandTrace(context.trace, err)
+	}()
+
 	for i, handle := range handles {
 		_, isHandle := handle.(*Handle)
 		if !isHandle {
@@ -367,6 +513,9 @@ func (t *TPMContext) processResponse(context *cmdContext, handles, params []inte
 // The caller can provide additional sessions that aren't associated with a TPM entity (and therefore not used for authorization) via
 // the sessions parameter, for the purposes of command auditing or session based parameter encryption.
 //
+// Vendor specific commands can be executed with this function. If the command has been registered with RegisterVendorCommand, the
+// supplied handles and sessions will be checked against the registered description of the command.
+//
 // In addition to returning an error if any marshalling or unmarshalling fails, or if the transmission backend returns an error,
 // this function will also return an error if the TPM responds with any ResponseCode other than Success.
 func (t *TPMContext) RunCommand(commandCode CommandCode, sessions []SessionContext, params ...interface{}) error {
@@ -405,6 +554,17 @@ func (t *TPMContext) RunCommand(commandCode CommandCode, sessions []SessionConte
 		}
 	}
 
+	if cmd, isVendor := lookupVendorCommand(commandCode); isVendor {
+		if len(commandHandles) != cmd.NumCommandHandles {
+			return fmt.Errorf("invalid number of command handles for command %s (got %d, expected %d)", commandCode, len(commandHandles),
+				cmd.NumCommandHandles)
+		}
+		if len(responseHandles) != cmd.NumResponseHandles {
+			return fmt.Errorf("invalid number of response handles for command %s (got %d, expected %d)", commandCode,
+				len(responseHandles), cmd.NumResponseHandles)
+		}
+	}
+
 	sessionParams, err := t.validateAndAppendExtraSessionParams(sessionParams, sessions)
 	if err != nil {
 		return fmt.Errorf("cannot process non-auth SessionContext parameters for command %s: %v", commandCode, err)
@@ -418,6 +578,32 @@ func (t *TPMContext) RunCommand(commandCode CommandCode, sessions []SessionConte
 	return t.processResponse(ctx, responseHandles, responseParams)
 }
 
+// SetCommandContext sets the context.Context used for subsequent commands executed by this TPMContext. If the context is canceled
+// or its deadline expires whilst a command is executing, the command is canceled and a *CommandCanceledError error is returned. If
+// the context is canceled before a command is sent, the command is not sent to the TPM. Setting ctx to nil restores the default
+// behaviour, where commands cannot be canceled.
+//
+// The TPM is asked to cancel a command in progress if the transmission interface implements CancellableTransport. Otherwise, or if
+// the TPM doesn't respond to the cancellation request within a short period, the command is abandoned and may still complete on the
+// TPM. The response to an abandoned command is read and discarded before the next command is sent, so subsequent commands will block
+// until the TPM responds, until the transmission interface gives up waiting for the response (TctiDeviceLinux does this after its
+// ResponseTimeout), or until the context for the subsequent command is canceled.
+//
+// If a command that is canceled or abandoned was executed with HMAC or policy sessions, the TPM may have processed it and generated
+// new nonces for those sessions, but the response containing them is discarded. The nonces recorded in the associated
+// SessionContexts will then be out of sync with the TPM, and subsequent commands that use these sessions will fail authorization.
+// These sessions should be flushed and replaced with new ones.
+//
+// Example usage:
+//  ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//  defer cancel()
+//  tpm.SetCommandContext(ctx)
+//  defer tpm.SetCommandContext(nil)
+//  digest, err := tpm.GetRandom(32)
+func (t *TPMContext) SetCommandContext(ctx context.Context) {
+	t.commandCtx = ctx
+}
+
 // SetMaxSubmissions sets the maximum number of times that RunCommand will attempt to submit a command before failing with an error.
 // The default value is 5.
 func (t *TPMContext) SetMaxSubmissions(max uint) {
@@ -439,6 +625,8 @@ func (t *TPMContext) InitProperties(sessions ...SessionContext) error {
 			t.maxNVBufferSize = int(prop.Value)
 		case PropertyInputBuffer:
 			t.maxBufferSize = int(prop.Value)
+		case PropertyMaxCommandSize:
+			t.maxCommandSize = int(prop.Value)
 		}
 	}
 
@@ -448,6 +636,9 @@ func (t *TPMContext) InitProperties(sessions ...SessionContext) error {
 	if t.maxBufferSize == 0 {
 		t.maxBufferSize = 1024
 	}
+	if t.maxCommandSize == 0 {
+		t.maxCommandSize = 4096
+	}
 	t.propertiesInitialized = true
 	return nil
 }
@@ -459,7 +650,7 @@ func (t *TPMContext) initPropertiesIfNeeded() error {
 	return t.InitProperties()
 }
 
-func newTpmContext(tcti io.ReadWriteCloser) *TPMContext {
+func newTpmContext(tcti Transport) *TPMContext {
 	r := new(TPMContext)
 	r.tcti = tcti
 	r.permanentResources = make(map[Handle]*permanentContext)
@@ -478,18 +669,19 @@ func newTpmContext(tcti io.ReadWriteCloser) *TPMContext {
 // It will return an error if a TPM interface cannot be detected.
 //
 // If the tcti parameter is not nil, this function never returns an error.
-func NewTPMContext(tcti io.ReadWriteCloser) (*TPMContext, error) {
+func NewTPMContext(tcti Transport) (*TPMContext, error) {
 	if tcti == nil {
 		for _, path := range []string{"/dev/tpmrm0", "/dev/tpm0"} {
-			var err error
-			tcti, err = OpenTPMDevice(path)
-			if err == nil {
+			if device, err := OpenTPMDevice(path); err == nil {
+				tcti = device
 				break
 			}
 		}
 	}
 	if tcti == nil {
-		tcti, _ = OpenMssim("localhost", 2321, 2322)
+		if mssim, err := OpenMssim("localhost", 2321, 2322); err == nil {
+			tcti = mssim
+		}
 	}
 
 	if tcti == nil {
@@ -9,16 +9,20 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"encoding/binary"
 	"flag"
 	"fmt"
+	"io"
 	"math/big"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
 	"github.com/canonical/go-tpm2/mu"
+	"github.com/canonical/go-tpm2/simulator"
 )
 
 type testCapabilityFlags uint32
@@ -90,6 +94,14 @@ var (
 	mssimHost         string
 	mssimTpmPort      uint
 	mssimPlatformPort uint
+
+	useSimulator  bool
+	testSimulator *simulator.Simulator
+
+	recordDir        string
+	replayDir        string
+	recordedTests    = make(map[string]bool)
+	replayTransports = make(map[string]*ReplayTransport)
 )
 
 func init() {
@@ -104,6 +116,14 @@ func init() {
 	flag.StringVar(&mssimHost, "mssim-host", "localhost", "The hostname of the TPM simulator (default: localhost)")
 	flag.UintVar(&mssimTpmPort, "mssim-tpm-port", 2321, "The port number of the TPM simulator command channel (default: 2321)")
 	flag.UintVar(&mssimPlatformPort, "mssim-platform-port", 2322, "The port number of the TPM simulator platform channel (default: 2322)")
+
+	flag.BoolVar(&useSimulator, "use-simulator", false, "Whether to use the in-process simulator from the simulator package for testing")
+
+	flag.StringVar(&recordDir, "record-dir", "", "Directory in which to record the TPM commands and responses of each test, for replaying "+
+		"with -replay-dir")
+	flag.StringVar(&replayDir, "replay-dir", "", "Directory containing TPM commands and responses recorded with -record-dir, to replay "+
+		"instead of using a TPM. Tests without a recording and tests that require the TPM simulator platform interface are skipped. "+
+		"Tests that send randomly generated data to the TPM, such as session nonces, cannot be replayed")
 }
 
 var (
@@ -186,6 +206,9 @@ func verifyCreationData(t *testing.T, tpm *TPMContext, creationData *CreationDat
 	if !bytes.Equal(creationData.OutsideInfo, outsideInfo) {
 		t.Errorf("creation data has the wrong outsideInfo (got %x)", creationData.OutsideInfo)
 	}
+	if l, ok := tpm.Transport().(LocalityTransport); ok && l.GetLocality() <= 4 && creationData.Locality != LocalityZero<<l.GetLocality() {
+		t.Errorf("creation data has the wrong locality (got %v)", creationData.Locality)
+	}
 
 	hasher := template.NameAlg.NewHash()
 	if _, err := mu.MarshalToWriter(hasher, creationData); err != nil {
@@ -451,25 +474,112 @@ func verifyContextFlushed(t *testing.T, tpm *TPMContext, context HandleContext)
 	flushContext(t, tpm, context)
 }
 
-func openTPMSimulatorForTesting(t *testing.T) (*TPMContext, *TctiMssim) {
-	if !useMssim {
+// transportRecordPath returns the path of the file in the specified directory that is used to record and replay the TPM commands
+// and responses of the current test.
+func transportRecordPath(dir string, t *testing.T) string {
+	return filepath.Join(dir, strings.Replace(t.Name(), "/", "_", -1)+".rec")
+}
+
+// recordFileTransport is a RecordingTransport that closes the file that it writes to when it is closed.
+type recordFileTransport struct {
+	*RecordingTransport
+	f *os.File
+}
+
+func (t *recordFileTransport) Close() error {
+	err := t.RecordingTransport.Close()
+	if err2 := t.f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// newTPMContextForTesting returns a new TPMContext that uses the supplied transmission interface. If -record-dir is specified, the
+// commands and responses of the current test are recorded. The first TPMContext opened by a test replaces any existing recording.
+func newTPMContextForTesting(t *testing.T, tcti Transport) *TPMContext {
+	if recordDir != "" {
+		path := transportRecordPath(recordDir, t)
+		flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		if !recordedTests[path] {
+			flags |= os.O_TRUNC
+			recordedTests[path] = true
+		}
+		f, err := os.OpenFile(path, flags, 0644)
+		if err != nil {
+			tcti.Close()
+			t.Fatalf("Cannot open record file: %v", err)
+		}
+		tcti = &recordFileTransport{RecordingTransport: NewRecordingTransport(tcti, f), f: f}
+	}
+
+	tpm, _ := NewTPMContext(tcti)
+	return tpm
+}
+
+// openReplayTPMForTesting returns a TPMContext that replays the TPM commands and responses recorded for the current test with
+// -record-dir, skipping the test if there isn't a recording. All of the TPMContexts opened by a test share the same sequence of
+// records.
+func openReplayTPMForTesting(t *testing.T) *TPMContext {
+	path := transportRecordPath(replayDir, t)
+	replay, ok := replayTransports[path]
+	if !ok {
+		f, err := os.Open(path)
+		switch {
+		case os.IsNotExist(err):
+			t.SkipNow()
+		case err != nil:
+			t.Fatalf("Cannot open record file: %v", err)
+		}
+		defer f.Close()
+
+		records, err := ReadTransportRecords(f)
+		if err != nil {
+			t.Fatalf("Cannot read records: %v", err)
+		}
+		replay = NewReplayTransport(records)
+		replayTransports[path] = replay
+	}
+
+	tpm, _ := NewTPMContext(replay)
+	return tpm
+}
+
+// openSimulatorTransport returns a new connection to the TPM simulator selected with -use-mssim or -use-simulator.
+func openSimulatorTransport() (PowerControlTransport, error) {
+	if useSimulator {
+		return testSimulator.NewTransport(), nil
+	}
+	return OpenMssim(mssimHost, mssimTpmPort, mssimPlatformPort)
+}
+
+func openTPMSimulatorForTesting(t *testing.T) (*TPMContext, PowerControlTransport) {
+	if replayDir != "" || (!useMssim && !useSimulator) {
 		t.SkipNow()
 	}
 
-	if useTpm && useMssim {
-		t.Fatalf("Cannot specify both -use-tpm and -use-mssim")
+	if useTpm && (useMssim || useSimulator) {
+		t.Fatalf("Cannot specify -use-tpm with -use-mssim or -use-simulator")
 	}
 
-	tcti, err := OpenMssim(mssimHost, mssimTpmPort, mssimPlatformPort)
+	tcti, err := openSimulatorTransport()
 	if err != nil {
-		t.Fatalf("Failed to open mssim connection: %v", err)
+		t.Fatalf("Failed to open simulator connection: %v", err)
 	}
 
-	tpm, _ := NewTPMContext(tcti)
-	return tpm, tcti
+	return newTPMContextForTesting(t, tcti), tcti
 }
 
-func resetTPMSimulator(t *testing.T, tpm *TPMContext, tcti *TctiMssim) {
+// openMssimForTesting is like openTPMSimulatorForTesting, but is for tests that require features that are specific to TctiMssim.
+// These tests are skipped unless -use-mssim is specified.
+func openMssimForTesting(t *testing.T) (*TPMContext, *TctiMssim) {
+	if !useMssim {
+		t.SkipNow()
+	}
+	tpm, tcti := openTPMSimulatorForTesting(t)
+	return tpm, tcti.(*TctiMssim)
+}
+
+func resetTPMSimulator(t *testing.T, tpm *TPMContext, tcti PowerControlTransport) {
 	if err := tpm.Shutdown(StartupClear); err != nil {
 		t.Fatalf("Shutdown failed: %v", err)
 	}
@@ -481,14 +591,34 @@ func resetTPMSimulator(t *testing.T, tpm *TPMContext, tcti *TctiMssim) {
 	}
 }
 
+// skipIfCommandNotSupported skips the current test if the TPM doesn't implement all of the specified commands.
+func skipIfCommandNotSupported(t *testing.T, tpm *TPMContext, commands ...CommandCode) {
+	for _, command := range commands {
+		attrs, err := tpm.GetCapabilityCommands(command, 1)
+		if err != nil {
+			t.Fatalf("GetCapabilityCommands failed: %v", err)
+		}
+		if len(attrs) == 0 || attrs[0].CommandCode() != command {
+			t.Skipf("%v is not supported by the TPM", command)
+		}
+	}
+}
+
 func openTPMForTesting(t *testing.T, caps testCapabilityFlags) *TPMContext {
+	if replayDir != "" {
+		if useTpm || useMssim || useSimulator || recordDir != "" {
+			t.Fatalf("Cannot specify -replay-dir with -use-tpm, -use-mssim, -use-simulator or -record-dir")
+		}
+		return openReplayTPMForTesting(t)
+	}
+
 	if !useTpm {
 		tpm, _ := openTPMSimulatorForTesting(t)
 		return tpm
 	}
 
-	if useTpm && useMssim {
-		t.Fatalf("Cannot specify both -use-tpm and -use-mssim")
+	if useTpm && (useMssim || useSimulator) {
+		t.Fatalf("Cannot specify -use-tpm with -use-mssim or -use-simulator")
 	}
 
 	if caps&permittedCaps != caps {
@@ -500,8 +630,7 @@ func openTPMForTesting(t *testing.T, caps testCapabilityFlags) *TPMContext {
 		t.Fatalf("Failed to open the TPM device: %v", err)
 	}
 
-	tpm, _ := NewTPMContext(tcti)
-	return tpm
+	return newTPMContextForTesting(t, tcti)
 }
 
 func closeTPM(t *testing.T, tpm *TPMContext) {
@@ -510,13 +639,62 @@ func closeTPM(t *testing.T, tpm *TPMContext) {
 	}
 }
 
+// scriptedTransport is a transmission interface that returns a canned response for each command, and records the commands that
+// were sent. It is used to test commands that can't be executed on the simulator or a real TPM.
+type scriptedTransport struct {
+	commands  [][]byte
+	responses [][]byte
+	rsp       *bytes.Reader
+}
+
+func (s *scriptedTransport) Read(data []byte) (int, error) {
+	if s.rsp == nil {
+		return 0, io.EOF
+	}
+	return s.rsp.Read(data)
+}
+
+func (s *scriptedTransport) Write(data []byte) (int, error) {
+	if len(s.responses) == 0 {
+		return 0, io.ErrClosedPipe
+	}
+	s.commands = append(s.commands, append([]byte(nil), data...))
+	s.rsp = bytes.NewReader(s.responses[0])
+	s.responses = s.responses[1:]
+	return len(data), nil
+}
+
+func (s *scriptedTransport) Close() error {
+	return nil
+}
+
+func (s *scriptedTransport) addResponse(t *testing.T, rc ResponseCode, params ...interface{}) {
+	b, err := mu.MarshalToBytes(params...)
+	if err != nil {
+		t.Fatalf("MarshalToBytes failed: %v", err)
+	}
+	hdr := make([]byte, 10)
+	binary.BigEndian.PutUint16(hdr, uint16(TagNoSessions))
+	binary.BigEndian.PutUint32(hdr[2:], uint32(len(hdr)+len(b)))
+	binary.BigEndian.PutUint32(hdr[6:], uint32(rc))
+	s.responses = append(s.responses, append(hdr, b...))
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	os.Exit(func() int {
-		if useMssim {
-			tcti, err := OpenMssim(mssimHost, mssimTpmPort, mssimPlatformPort)
+		if useMssim && useSimulator {
+			fmt.Fprintf(os.Stderr, "Cannot specify both -use-mssim and -use-simulator\n")
+			return 1
+		}
+		if useSimulator {
+			testSimulator = simulator.New()
+		}
+
+		if useMssim || useSimulator {
+			tcti, err := openSimulatorTransport()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to open mssim connection: %v", err)
+				fmt.Fprintf(os.Stderr, "Failed to open simulator connection: %v", err)
 				return 1
 			}
 
@@ -531,13 +709,13 @@ func TestMain(m *testing.M) {
 		}
 
 		defer func() {
-			if !useMssim {
+			if !useMssim && !useSimulator {
 				return
 			}
 
-			tcti, err := OpenMssim(mssimHost, mssimTpmPort, mssimPlatformPort)
+			tcti, err := openSimulatorTransport()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to open mssim connection: %v\n", err)
+				fmt.Fprintf(os.Stderr, "Failed to open simulator connection: %v\n", err)
 				return
 			}
 
@@ -545,8 +723,10 @@ func TestMain(m *testing.M) {
 			if err := tpm.Shutdown(StartupClear); err != nil {
 				fmt.Fprintf(os.Stderr, "TPM simulator shutdown failed: %v\n", err)
 			}
-			if err := tcti.Stop(); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to stop TPM simulator: %v\n", err)
+			if mssim, ok := tcti.(*TctiMssim); ok {
+				if err := mssim.Stop(); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to stop TPM simulator: %v\n", err)
+				}
 			}
 			tpm.Close()
 		}()
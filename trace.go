@@ -0,0 +1,214 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// RedactedParameter is used in place of a command or response parameter in a CommandTrace when the parameter contains sensitive
+// data.
+type RedactedParameter struct{}
+
+// SessionTrace describes a session that was used for a command, and is part of a CommandTrace.
+type SessionTrace struct {
+	Handle          Handle            // The handle of the session. This is HandlePW for a password authorization
+	Type            SessionType       // The type of the session. This is not meaningful for a password authorization
+	Attrs           SessionAttributes // The attributes of the session that were used for the command
+	IsAuth          bool              // Whether the session was used for authorization
+	AuthHandle      Handle            // The handle of the resource that the session authorized, if IsAuth is true
+	PolicyAuthValue bool              // Whether a policy session included a HMAC, as a result of TPM2_PolicyAuthValue
+	PolicyPassword  bool              // Whether a policy session included a cleartext password, as a result of TPM2_PolicyPassword
+}
+
+// CommandTrace contains information about a command that was submitted to the TPM by TPMContext, and is supplied to a
+// CommandObserver once the command has completed. Commands that fail before being submitted, eg, because of an invalid argument,
+// are not traced.
+//
+// Command and response parameters are supplied as the go types passed to or returned from TPMContext.RunCommand, with response
+// parameters dereferenced and any sized structures unwrapped to a pointer to the underlying structure. The values are shared with
+// the caller and must not be modified. Parameters of the Auth, SensitiveData, *SensitiveCreate and *Sensitive types are replaced
+// with RedactedParameter, as are other parameters that contain secret values, such as the message returned from TPM2_RSA_Decrypt,
+// the certInfo returned from TPM2_ActivateCredential, the shared points returned from TPM2_ECDH_KeyGen, TPM2_ECDH_ZGen and
+// TPM2_ZGen_2Phase, the input and output data of TPM2_EncryptDecrypt and TPM2_EncryptDecrypt2, and the symmetric keys supplied to
+// TPM2_Duplicate and TPM2_Import or returned from TPM2_Duplicate. If the command was executed with a session that has the AttrCommandEncrypt attribute, the first command
+// parameter is replaced with RedactedParameter. If the command was executed with a session that has the AttrResponseEncrypt
+// attribute, the first response parameter is replaced with RedactedParameter.
+//
+// Commands executed directly with TPMContext.RunCommandBytes are traced with only the CommandCode, ResponseCode, Err and Duration
+// fields populated.
+type CommandTrace struct {
+	CommandCode        CommandCode
+	Handles            []Handle       // The command handles
+	Sessions           []SessionTrace // The sessions used for the command
+	Parameters         []interface{}  // The command parameters
+	ResponseCode       ResponseCode   // The response code from the final submission of the command
+	ResponseHandles    []Handle       // The response handles, if the command succeeded
+	ResponseParameters []interface{}  // The response parameters, if the command succeeded
+	Err                error          // The error returned to the caller, such as a *TPMError, if the command failed
+	Duration           time.Duration  // The time taken to execute the command, including any resubmissions
+
+	start time.Time
+}
+
+// CommandObserver is implemented by types that are notified of every command executed by a TPMContext, and can be set with
+// TPMContext.SetCommandObserver. It can be used for logging, collecting metrics or debugging authorization failures.
+type CommandObserver interface {
+	// CommandCompleted is called on the goroutine that executed the command, once the command has completed.
+	CommandCompleted(trace *CommandTrace)
+}
+
+// CommandObserverFunc is an adapter that allows the use of an ordinary function as a CommandObserver.
+type CommandObserverFunc func(trace *CommandTrace)
+
+// CommandCompleted calls f(trace).
+func (f CommandObserverFunc) CommandCompleted(trace *CommandTrace) {
+	f(trace)
+}
+
+// redactedCommandParams contains the indices of the command parameters of specific commands that are replaced with
+// RedactedParameter in a CommandTrace, because they contain secret values that don't have one of the sensitive types.
+var redactedCommandParams = map[CommandCode][]int{
+	CommandMakeCredential:  {0}, // credential
+	CommandDuplicate:       {0}, // encryptionKeyIn
+	CommandImport:          {0}, // encryptionKey
+	CommandRSAEncrypt:      {0}, // message
+	CommandEncryptDecrypt:  {3}, // inData
+	CommandEncryptDecrypt2: {0}, // inData
+}
+
+// redactedResponseParams contains the indices of the response parameters of specific commands that are replaced with
+// RedactedParameter in a CommandTrace, because they contain secret values that don't have one of the sensitive types.
+var redactedResponseParams = map[CommandCode][]int{
+	CommandActivateCredential: {0},    // certInfo
+	CommandDuplicate:          {0},    // encryptionKeyOut
+	CommandRSADecrypt:         {0},    // message
+	CommandECDHKeyGen:         {0},    // zPoint
+	CommandECDHZGen:           {0},    // outPoint
+	CommandZGen2Phase:         {0, 1}, // outZ1, outZ2
+	CommandEncryptDecrypt:     {0},    // outData
+	CommandEncryptDecrypt2:    {0},    // outData
+}
+
+// traceParam returns the representation of param in a CommandTrace.
+func traceParam(param interface{}) interface{} {
+	v := reflect.ValueOf(param)
+	if v.Kind() == reflect.Struct && isSizedWrapper(v.Type()) {
+		param = v.Field(0).Interface()
+	}
+
+	switch param.(type) {
+	case Auth, SensitiveData, SensitiveCreate, *SensitiveCreate, Sensitive, *Sensitive:
+		return RedactedParameter{}
+	}
+	return param
+}
+
+// isSizedWrapper indicates whether t is one of the internal types used to marshal a pointer to a structure as a sized structure.
+func isSizedWrapper(t reflect.Type) bool {
+	if t.NumField() != 1 {
+		return false
+	}
+	for _, opt := range strings.Split(t.Field(0).Tag.Get("tpm2"), ",") {
+		if opt == "sized" {
+			return true
+		}
+	}
+	return false
+}
+
+// traceParams returns the representation of the supplied parameters in a CommandTrace. The first parameter is redacted if
+// redactFirst is true, and the parameters with the indices in redacted are also redacted.
+func traceParams(params []interface{}, redactFirst bool, redacted []int) (out []interface{}) {
+	isRedacted := func(i int) bool {
+		if i == 0 && redactFirst {
+			return true
+		}
+		for _, r := range redacted {
+			if r == i {
+				return true
+			}
+		}
+		return false
+	}
+
+	for i, p := range params {
+		if isRedacted(i) {
+			out = append(out, RedactedParameter{})
+			continue
+		}
+		out = append(out, traceParam(p))
+	}
+	return
+}
+
+// traceResponseParams returns the representation of the supplied response parameter pointers for the specified command in a
+// CommandTrace.
+func traceResponseParams(commandCode CommandCode, params []interface{}, redactFirst bool) []interface{} {
+	var values []interface{}
+	for _, p := range params {
+		values = append(values, reflect.ValueOf(p).Elem().Interface())
+	}
+	return traceParams(values, redactFirst, redactedResponseParams[commandCode])
+}
+
+func makeSessionTraces(sessionParams []*sessionParam) (out []SessionTrace) {
+	for _, s := range sessionParams {
+		var st SessionTrace
+		if s.session == nil {
+			st.Handle = HandlePW
+		} else {
+			st.Handle = s.session.Handle()
+			st.Attrs = s.session.attrs
+			if scData := s.session.scData(); scData != nil {
+				st.Type = scData.SessionType
+				st.PolicyAuthValue = scData.PolicyHMACType == policyHMACTypeAuth
+				st.PolicyPassword = scData.PolicyHMACType == policyHMACTypePassword
+			}
+		}
+		st.IsAuth = s.isAuth
+		if s.isAuth && s.associatedContext != nil {
+			st.AuthHandle = s.associatedContext.Handle()
+		}
+		out = append(out, st)
+	}
+	return
+}
+
+// SetCommandObserver sets an observer which is notified of every subsequent command submitted to the TPM by this TPMContext. Setting
+// observer to nil disables tracing.
+func (t *TPMContext) SetCommandObserver(observer CommandObserver) {
+	t.observer = observer
+}
+
+// newCommandTrace begins a trace for a command executed via TPMContext.RunCommand, where handles contains the marshalled command
+// handles. It returns nil if there is no observer.
+func (t *TPMContext) newCommandTrace(commandCode CommandCode, sessionParams []*sessionParam, handles, params []interface{}) *CommandTrace {
+	if t.observer == nil {
+		return nil
+	}
+
+	trace := &CommandTrace{
+		CommandCode: commandCode,
+		Sessions:    makeSessionTraces(sessionParams),
+		Parameters:  traceParams(params, hasDecryptSession(sessionParams), redactedCommandParams[commandCode]),
+		start:       time.Now()}
+	for _, h := range handles {
+		trace.Handles = append(trace.Handles, h.(Handle))
+	}
+	return trace
+}
+
+// completeCommandTrace completes the supplied trace and notifies the observer. It does nothing if trace is nil.
+func (t *TPMContext) completeCommandTrace(trace *CommandTrace, err error) {
+	if trace == nil || t.observer == nil {
+		return
+	}
+	trace.Err = err
+	trace.Duration = time.Since(trace.start)
+	t.observer.CommandCompleted(trace)
+}
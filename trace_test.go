@@ -0,0 +1,255 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/simulator"
+)
+
+func TestCommandObserver(t *testing.T) {
+	transport := &scriptedTransport{}
+	tpm, _ := NewTPMContext(transport)
+
+	var traces []*CommandTrace
+	tpm.SetCommandObserver(CommandObserverFunc(func(trace *CommandTrace) {
+		traces = append(traces, trace)
+	}))
+
+	t.Run("GetRandom", func(t *testing.T) {
+		traces = nil
+		transport.addResponse(t, 0x922) // TPM_RC_RETRY
+		transport.addResponse(t, Success, Digest("12345678"))
+		if _, err := tpm.GetRandom(8); err != nil {
+			t.Fatalf("GetRandom failed: %v", err)
+		}
+
+		if len(traces) != 1 {
+			t.Fatalf("Unexpected number of traces (%d)", len(traces))
+		}
+		trace := traces[0]
+		if trace.CommandCode != CommandGetRandom || trace.ResponseCode != Success || trace.Err != nil {
+			t.Errorf("Unexpected trace: %+v", trace)
+		}
+		if !reflect.DeepEqual(trace.Parameters, []interface{}{uint16(8)}) {
+			t.Errorf("Unexpected command parameters: %v", trace.Parameters)
+		}
+		if len(trace.ResponseParameters) != 1 || !reflect.DeepEqual(trace.ResponseParameters[0], Digest("12345678")) {
+			t.Errorf("Unexpected response parameters: %v", trace.ResponseParameters)
+		}
+		if trace.Duration <= 0 {
+			t.Errorf("Unexpected duration")
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		traces = nil
+		transport.addResponse(t, 0x101) // TPM_RC_FAILURE
+		if _, err := tpm.GetRandom(8); err == nil {
+			t.Fatalf("GetRandom should have failed")
+		}
+
+		if len(traces) != 1 {
+			t.Fatalf("Unexpected number of traces (%d)", len(traces))
+		}
+		if e, ok := traces[0].Err.(*TPMError); !ok || e.Code != ErrorFailure {
+			t.Errorf("Unexpected error: %v", traces[0].Err)
+		}
+		if traces[0].ResponseCode != 0x101 || traces[0].ResponseParameters != nil {
+			t.Errorf("Unexpected trace: %+v", traces[0])
+		}
+	})
+
+	t.Run("Redaction", func(t *testing.T) {
+		traces = nil
+		transport.addResponse(t, Success)
+		owner := tpm.OwnerHandleContext()
+		if err := tpm.HierarchyChangeAuth(owner, Auth("foo"), nil); err != nil {
+			t.Fatalf("HierarchyChangeAuth failed: %v", err)
+		}
+		defer owner.SetAuthValue(nil)
+
+		if len(traces) != 1 {
+			t.Fatalf("Unexpected number of traces (%d)", len(traces))
+		}
+		trace := traces[0]
+		if !reflect.DeepEqual(trace.Handles, []Handle{HandleOwner}) {
+			t.Errorf("Unexpected command handles: %v", trace.Handles)
+		}
+		if !reflect.DeepEqual(trace.Sessions, []SessionTrace{{Handle: HandlePW, IsAuth: true, AuthHandle: HandleOwner}}) {
+			t.Errorf("Unexpected sessions: %+v", trace.Sessions)
+		}
+		if !reflect.DeepEqual(trace.Parameters, []interface{}{RedactedParameter{}}) {
+			t.Errorf("Unexpected command parameters: %v", trace.Parameters)
+		}
+	})
+
+	t.Run("CommandSpecificRedaction", func(t *testing.T) {
+		traces = nil
+		transport.addResponse(t, Success, PublicKeyRSA("secret"))
+		var message PublicKeyRSA
+		if err := tpm.RunCommand(CommandRSADecrypt, nil,
+			ResourceContextWithSession{Context: tpm.OwnerHandleContext()}, Delimiter,
+			PublicKeyRSA("ciphertext"), &RSAScheme{Scheme: RSASchemeNull}, Data(nil), Delimiter,
+			Delimiter,
+			&message); err != nil {
+			t.Fatalf("RunCommand failed: %v", err)
+		}
+		if string(message) != "secret" {
+			t.Errorf("Unexpected message (%x)", message)
+		}
+
+		transport.addResponse(t, Success, MaxBuffer("ciphertext"), IV("iv"))
+		var outData MaxBuffer
+		var ivOut IV
+		if err := tpm.RunCommand(CommandEncryptDecrypt, nil,
+			ResourceContextWithSession{Context: tpm.OwnerHandleContext()}, Delimiter,
+			false, SymModeCFB, IV(nil), MaxBuffer("plaintext"), Delimiter,
+			Delimiter,
+			&outData, &ivOut); err != nil {
+			t.Fatalf("RunCommand failed: %v", err)
+		}
+
+		if len(traces) != 2 {
+			t.Fatalf("Unexpected number of traces (%d)", len(traces))
+		}
+		if len(traces[0].Parameters) != 3 || !reflect.DeepEqual(traces[0].Parameters[0], PublicKeyRSA("ciphertext")) {
+			t.Errorf("Unexpected command parameters: %v", traces[0].Parameters)
+		}
+		if !reflect.DeepEqual(traces[0].ResponseParameters, []interface{}{RedactedParameter{}}) {
+			t.Errorf("Unexpected response parameters: %v", traces[0].ResponseParameters)
+		}
+		if !reflect.DeepEqual(traces[1].Parameters, []interface{}{false, SymModeCFB, IV(nil), RedactedParameter{}}) {
+			t.Errorf("Unexpected command parameters: %v", traces[1].Parameters)
+		}
+		if !reflect.DeepEqual(traces[1].ResponseParameters, []interface{}{RedactedParameter{}, IV("iv")}) {
+			t.Errorf("Unexpected response parameters: %v", traces[1].ResponseParameters)
+		}
+	})
+
+	t.Run("IsTPM2", func(t *testing.T) {
+		traces = nil
+		transport.addResponse(t, Success, false, CapabilityData{Capability: CapabilityTPMProperties,
+			Data: CapabilitiesU{Data: TaggedTPMPropertyList{{Property: PropertyTotalCommands, Value: 100}}}})
+		if isTpm2, err := tpm.IsTPM2(); err != nil || !isTpm2 {
+			t.Fatalf("IsTPM2 failed (%v, %v)", isTpm2, err)
+		}
+
+		if len(traces) != 1 {
+			t.Fatalf("Unexpected number of traces (%d)", len(traces))
+		}
+		if traces[0].CommandCode != CommandGetCapability || traces[0].ResponseCode != Success || traces[0].Err != nil ||
+			traces[0].Duration <= 0 {
+			t.Errorf("Unexpected trace: %+v", traces[0])
+		}
+	})
+
+	t.Run("RunCommandBytes", func(t *testing.T) {
+		traces = nil
+		transport.addResponse(t, Success, Digest("12345678"))
+		if _, _, _, err := tpm.RunCommandBytes(TagNoSessions, CommandGetRandom, []byte{0x00, 0x08}); err != nil {
+			t.Fatalf("RunCommandBytes failed: %v", err)
+		}
+
+		if len(traces) != 1 {
+			t.Fatalf("Unexpected number of traces (%d)", len(traces))
+		}
+		if traces[0].CommandCode != CommandGetRandom || traces[0].ResponseCode != Success || traces[0].Err != nil ||
+			traces[0].Parameters != nil {
+			t.Errorf("Unexpected trace: %+v", traces[0])
+		}
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		traces = nil
+		tpm.SetCommandObserver(nil)
+		transport.addResponse(t, Success, Digest("12345678"))
+		if _, err := tpm.GetRandom(8); err != nil {
+			t.Fatalf("GetRandom failed: %v", err)
+		}
+		if len(traces) != 0 {
+			t.Errorf("Unexpected number of traces (%d)", len(traces))
+		}
+	})
+}
+
+func TestCommandObserverParameterEncryption(t *testing.T) {
+	sim := simulator.New()
+	tpm, _ := NewTPMContext(sim.NewTransport())
+	defer tpm.Close()
+	if err := tpm.Startup(StartupClear); err != nil {
+		t.Fatalf("Startup failed: %v", err)
+	}
+
+	owner := tpm.OwnerHandleContext()
+	symmetric := SymDef{
+		Algorithm: SymAlgorithmAES,
+		KeyBits:   SymKeyBitsU{Data: uint16(128)},
+		Mode:      SymModeU{Data: SymModeCFB}}
+	session, err := tpm.StartAuthSession(nil, owner, SessionTypeHMAC, &symmetric, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	defer tpm.FlushContext(session)
+	session.SetAttrs(AttrContinueSession)
+
+	pub := NVPublic{
+		Index:   0x01800000,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   NVTypeOrdinary.WithAttrs(AttrNVOwnerWrite | AttrNVOwnerRead),
+		Size:    8}
+	index, err := tpm.NVDefineSpace(owner, nil, &pub, nil)
+	if err != nil {
+		t.Fatalf("NVDefineSpace failed: %v", err)
+	}
+
+	var traces []*CommandTrace
+	tpm.SetCommandObserver(CommandObserverFunc(func(trace *CommandTrace) {
+		traces = append(traces, trace)
+	}))
+
+	data := []byte("12345678")
+	if err := tpm.NVWrite(owner, index, data, 0, session.IncludeAttrs(AttrCommandEncrypt)); err != nil {
+		t.Fatalf("NVWrite failed: %v", err)
+	}
+	read, err := tpm.NVRead(owner, index, uint16(len(data)), 0, session.IncludeAttrs(AttrResponseEncrypt))
+	if err != nil {
+		t.Fatalf("NVRead failed: %v", err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Errorf("Unexpected data read back: %x", read)
+	}
+
+	// TPMContext may execute other commands, such as TPM2_GetCapability.
+	var nvWrite, nvRead *CommandTrace
+	for _, trace := range traces {
+		switch trace.CommandCode {
+		case CommandNVWrite:
+			nvWrite = trace
+		case CommandNVRead:
+			nvRead = trace
+		}
+	}
+	if nvWrite == nil || nvRead == nil {
+		t.Fatalf("Missing traces")
+	}
+	if !reflect.DeepEqual(nvWrite.Parameters, []interface{}{RedactedParameter{}, uint16(0)}) {
+		t.Errorf("Unexpected command parameters for NVWrite: %v", nvWrite.Parameters)
+	}
+	if !reflect.DeepEqual(nvWrite.Sessions[0], SessionTrace{Handle: session.Handle(), Type: SessionTypeHMAC,
+		Attrs: AttrContinueSession | AttrCommandEncrypt, IsAuth: true, AuthHandle: HandleOwner}) {
+		t.Errorf("Unexpected session trace for NVWrite: %+v", nvWrite.Sessions[0])
+	}
+	if !reflect.DeepEqual(nvRead.Parameters, []interface{}{uint16(len(data)), uint16(0)}) {
+		t.Errorf("Unexpected command parameters for NVRead: %v", nvRead.Parameters)
+	}
+	if !reflect.DeepEqual(nvRead.ResponseParameters, []interface{}{RedactedParameter{}}) {
+		t.Errorf("Unexpected response parameters for NVRead: %v", nvRead.ResponseParameters)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"io"
+)
+
+// Transport is the interface implemented by the transmission interfaces (TCTIs) that are used by TPMContext to communicate with a
+// TPM. Commands are serialized and sent to the TPM with a single call to Write, and the response is obtained by calling Read until
+// a complete response has been received. Close is called when the TPMContext is closed.
+//
+// A Transport may implement any of the optional LocalityTransport, CancellableTransport, MaxCommandSizeTransport,
+// PowerControlTransport and PhysicalPresenceTransport interfaces in order to expose additional functionality. The Transport
+// associated with a TPMContext can be obtained with TPMContext.Transport, and these interfaces can be tested for with a type
+// assertion.
+type Transport interface {
+	io.ReadWriteCloser
+}
+
+// LocalityTransport is implemented by transmission interfaces that permit the caller to select the locality from which commands
+// are sent to the TPM.
+type LocalityTransport interface {
+	Transport
+
+	// GetLocality returns the locality from which commands are currently sent to the TPM.
+	GetLocality() uint8
+
+	// SetLocality sets the locality from which subsequent commands are sent to the TPM. An error will be returned if the locality
+	// is not supported by the transmission interface.
+	SetLocality(locality uint8) error
+}
+
+// CancellableTransport is implemented by transmission interfaces that are able to request the cancellation of a command that is
+// currently executing on the TPM.
+type CancellableTransport interface {
+	Transport
+
+	// Cancel requests that the TPM cancels the command that it is currently executing. Cancellation is a request to the TPM which
+	// may be ignored, and the TPM will return a response in either case. A TPM that honours the request will respond with a
+	// *TPMWarning error with a warning code of WarningCanceled. Cancel may be called from a different goroutine to the one that
+	// is executing the command, and it may be called after the TPM has already responded. Implementations must ensure that a
+	// cancel request doesn't affect subsequent commands.
+	Cancel() error
+}
+
+// MaxCommandSizeTransport is implemented by transmission interfaces that limit the size of commands and responses that they can
+// transmit, such as those that communicate with a kernel driver with a fixed size buffer. TPMContext.MaxCommandSize should be used
+// to determine the maximum size of a command that can be executed, as this also takes in to account the limit imposed by the TPM.
+type MaxCommandSizeTransport interface {
+	Transport
+
+	// MaxCommandSize returns the maximum size in bytes of a command or response that can be transmitted.
+	MaxCommandSize() int
+}
+
+// PowerControlTransport is implemented by transmission interfaces that are able to control the power supply of the TPM, such as
+// those that communicate with a TPM simulator. After power is applied to the TPM, TPMContext.Startup must be called before any
+// other commands can be executed.
+type PowerControlTransport interface {
+	Transport
+
+	// PowerOn applies power to the TPM.
+	PowerOn() error
+
+	// PowerOff removes power from the TPM.
+	PowerOff() error
+
+	// Reset initiates a reset of the TPM, which results in the execution of _TPM_Init().
+	Reset() error
+}
+
+// PhysicalPresenceTransport is implemented by transmission interfaces that are able to assert physical presence to the TPM, which
+// is required by commands such as TPMContext.PPCommands.
+type PhysicalPresenceTransport interface {
+	Transport
+
+	// AssertPhysicalPresence asserts physical presence to the TPM.
+	AssertPhysicalPresence() error
+
+	// DeassertPhysicalPresence deasserts physical presence to the TPM.
+	DeassertPhysicalPresence() error
+}
@@ -15,6 +15,7 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"math/big"
 	"reflect"
 	"sort"
 	"unsafe"
@@ -59,6 +60,9 @@ type CommandCode uint32
 // ResponseCode corresponds to the TPM_RC type.
 type ResponseCode uint32
 
+// ClockAdjust corresponds to the TPM_CLOCK_ADJUST type.
+type ClockAdjust int8
+
 // ArithmeticOp corresponds to the TPM_EO type.
 type ArithmeticOp uint16
 
@@ -106,7 +110,8 @@ type AlgorithmAttributes uint32
 // ObjectAttributes corresponds to the TPMA_OBJECT type, and represents the attributes for an object.
 type ObjectAttributes uint32
 
-// Locality corresponds to the TPMA_LOCALITY type.
+// Locality corresponds to the TPMA_LOCALITY type. Values below 32 are a bitmask of the localities 0 to 4, represented by the
+// LocalityZero to LocalityFour constants. Values of 32 and above represent a single extended locality.
 type Locality uint8
 
 // PermanentAttributes corresponds to the TPMA_PERMANENT type and is returned when querying the value of PropertyPermanent
@@ -131,6 +136,9 @@ func (a CommandAttributes) NumberOfCommandHandles() int {
 	return int((a & 0x0e000000) >> 25)
 }
 
+// ACTAttributes corresponds to the TPMA_ACT type and represents the attributes of an authenticated countdown timer (ACT).
+type ACTAttributes uint32
+
 // 9) Interface types
 
 // HashAlgorithmId corresponds to the TPMI_ALG_HASH type
@@ -237,6 +245,45 @@ func (p *TaggedHash) Unmarshal(buf io.Reader) (nbytes int, err error) {
 	return
 }
 
+// taggedHashOrNull corresponds to the TPMT_HA+ type, which is the same as TPMT_HA except that TPM_ALG_NULL is permitted, in which
+// case there is no digest.
+type taggedHashOrNull TaggedHash
+
+func (p *taggedHashOrNull) Marshal(buf io.Writer) (nbytes int, err error) {
+	if p.HashAlg != HashAlgorithmNull {
+		return (*TaggedHash)(p).Marshal(buf)
+	}
+	if len(p.Digest) > 0 {
+		return nbytes, fmt.Errorf("invalid digest size %d", len(p.Digest))
+	}
+	if err := binary.Write(buf, binary.BigEndian, p.HashAlg); err != nil {
+		return nbytes, xerrors.Errorf("cannot marshal digest algorithm: %w", err)
+	}
+	return binary.Size(p.HashAlg), nil
+}
+
+func (p *taggedHashOrNull) Unmarshal(buf io.Reader) (nbytes int, err error) {
+	if err := binary.Read(buf, binary.BigEndian, &p.HashAlg); err != nil {
+		return nbytes, xerrors.Errorf("cannot unmarshal digest algorithm: %w", err)
+	}
+	nbytes += binary.Size(p.HashAlg)
+	if p.HashAlg == HashAlgorithmNull {
+		p.Digest = nil
+		return
+	}
+	if !p.HashAlg.Supported() {
+		return nbytes, fmt.Errorf("cannot determine digest size for unknown algorithm %v", p.HashAlg)
+	}
+
+	p.Digest = make(Digest, p.HashAlg.Size())
+	n, err := io.ReadFull(buf, p.Digest)
+	nbytes += n
+	if err != nil {
+		return nbytes, xerrors.Errorf("cannot read digest: %w", err)
+	}
+	return
+}
+
 // 10.4 Sized Buffers
 
 // Digest corresponds to the TPM2B_DIGEST type. The largest size of this supported by the TPM can be determined by calling
@@ -270,6 +317,9 @@ type MaxNVBuffer []byte
 // Timeout corresponds to the TPM2B_TIMEOUT type.
 type Timeout []byte
 
+// IV corresponds to the TPM2B_IV type.
+type IV []byte
+
 // 10.5) Names
 
 // Name corresponds to the TPM2B_NAME type.
@@ -437,6 +487,14 @@ type TaggedPolicy struct {
 	PolicyHash TaggedHash // Policy algorithm and hash
 }
 
+// ACTData corresponds to the TPMS_ACT_DATA type. It is used to report the timeout and state of an authenticated countdown timer
+// (ACT).
+type ACTData struct {
+	Handle  Handle        // Handle of the ACT
+	Timeout uint32        // Number of seconds remaining before the ACT expires
+	Attrs   ACTAttributes // State of the ACT
+}
+
 // 10.9) Lists
 
 // CommandCodeList is a slice of CommandCode values, and corresponds to the TPML_CC type.
@@ -642,6 +700,9 @@ type ECCCurveList []ECCCurve
 // TaggedPolicyList is a slice of TaggedPolicy values, and corresponds to the TPML_TAGGED_POLICY type.
 type TaggedPolicyList []TaggedPolicy
 
+// ACTDataList is a slice of ACTData values, and corresponds to the TPML_ACT_DATA type.
+type ACTDataList []ACTData
+
 // 10.10) Capabilities Structures
 
 // Capabilities is a fake union type that corresponds to the TPMU_CAPABILITIES type. The selector type is Capability. Valid types
@@ -656,6 +717,7 @@ type TaggedPolicyList []TaggedPolicy
 //  - CapabilityPCRProperties: TaggedPCRPropertyList
 //  - CapabilityECCCurves: ECCCurveList
 //  - CapabilityAuthPolicies: TaggedPolicyList
+//  - CapabilityACT: ACTDataList
 type CapabilitiesU struct {
 	Data interface{}
 }
@@ -710,6 +772,11 @@ func (c CapabilitiesU) AuthPolicies() TaggedPolicyList {
 	return c.Data.(TaggedPolicyList)
 }
 
+// ACTData returns the underlying value as ACTDataList. It panics if the underlying type is not ACTDataList.
+func (c CapabilitiesU) ACTData() ACTDataList {
+	return c.Data.(ACTDataList)
+}
+
 func (c CapabilitiesU) Select(selector reflect.Value) reflect.Type {
 	switch selector.Interface().(Capability) {
 	case CapabilityAlgs:
@@ -732,6 +799,8 @@ func (c CapabilitiesU) Select(selector reflect.Value) reflect.Type {
 		return reflect.TypeOf(ECCCurveList(nil))
 	case CapabilityAuthPolicies:
 		return reflect.TypeOf(TaggedPolicyList(nil))
+	case CapabilityACT:
+		return reflect.TypeOf(ACTDataList(nil))
 	default:
 		return nil
 	}
@@ -905,6 +974,34 @@ func (a AttestRaw) Decode() (*Attest, error) {
 	return &out, nil
 }
 
+// CheckNVContents checks that this attestation structure was generated by the TPM with TPMContext.NVCertify for the NV index with
+// the name nvIndexName, and that the certified contents of the index starting at offset are equal to expected. It does not verify
+// the signature of the attestation - this should be done separately before trusting the result of this check.
+//
+// An error is returned if any of these checks fail.
+func (a *Attest) CheckNVContents(nvIndexName Name, offset uint16, expected []byte) error {
+	if a.Magic != TPMGeneratedValue {
+		return fmt.Errorf("invalid magic value %#08x", a.Magic)
+	}
+	if a.Type != TagAttestNV {
+		return fmt.Errorf("unexpected attestation type %#04x", a.Type)
+	}
+	info, ok := a.Attested.Data.(*NVCertifyInfo)
+	if !ok {
+		return fmt.Errorf("attestation does not contain NV certification information")
+	}
+	if !bytes.Equal(info.IndexName, nvIndexName) {
+		return fmt.Errorf("unexpected NV index name (got %x, expected %x)", info.IndexName, nvIndexName)
+	}
+	if info.Offset != offset {
+		return fmt.Errorf("unexpected offset (got %d, expected %d)", info.Offset, offset)
+	}
+	if !bytes.Equal(info.NVContents, expected) {
+		return fmt.Errorf("certified contents don't match the expected contents")
+	}
+	return nil
+}
+
 // 11) Algorithm Parameters and Structures
 
 // 11.1) Symmetric
@@ -1387,6 +1484,10 @@ type ECCPoint struct {
 	Y ECCParameter // Y coordinate
 }
 
+type eccPointSized struct {
+	Ptr *ECCPoint `tpm2:"sized"`
+}
+
 // ECCSchemeId corresponds to the TPMI_ALG_ECC_SCHEME type.
 type ECCSchemeId AsymSchemeId
 
@@ -1396,6 +1497,45 @@ type ECCScheme struct {
 	Details AsymSchemeU `tpm2:"selector:Scheme"` // Scheme specific parameters.
 }
 
+// AlgorithmDetailECC corresponds to the TPMS_ALGORITHM_DETAIL_ECC type, and describes the parameters of an ECC curve.
+type AlgorithmDetailECC struct {
+	CurveID ECCCurve     // Identifier for the curve
+	KeySize uint16       // Size in bits of the key
+	KDF     KDFScheme    // The default KDF and hash algorithm for the curve
+	Sign    ECCScheme    // The default signing scheme for the curve
+	P       ECCParameter // Fp (the modulus)
+	A       ECCParameter // Coefficient of the linear term in the curve equation
+	B       ECCParameter // Constant term in the curve equation
+	GX      ECCParameter // X coordinate of the base point
+	GY      ECCParameter // Y coordinate of the base point
+	N       ECCParameter // Order of the base point
+	H       ECCParameter // Cofactor
+}
+
+// GoCurve returns the equivalent elliptic.Curve for this set of curve parameters. If the curve is one that ECCCurve.GoCurve knows
+// about, the implementation returned from that is used. Otherwise, a generic implementation is constructed from the parameters. The
+// generic implementation only supports curves of the form y² = x³ - 3x + b, so this returns nil for curves with any other value of
+// a.
+func (d *AlgorithmDetailECC) GoCurve() elliptic.Curve {
+	if curve := d.CurveID.GoCurve(); curve != nil {
+		return curve
+	}
+
+	p := new(big.Int).SetBytes(d.P)
+	a := new(big.Int).SetBytes(d.A)
+	if p.Sign() == 0 || new(big.Int).Add(a, big.NewInt(3)).Cmp(p) != 0 {
+		return nil
+	}
+
+	return &elliptic.CurveParams{
+		P:       p,
+		N:       new(big.Int).SetBytes(d.N),
+		B:       new(big.Int).SetBytes(d.B),
+		Gx:      new(big.Int).SetBytes(d.GX),
+		Gy:      new(big.Int).SetBytes(d.GY),
+		BitSize: int(d.KeySize)}
+}
+
 // 11.3 Signatures
 
 // SignatureRSA corresponds to the TPMS_SIGNATURE_RSA type.
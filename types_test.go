@@ -6,8 +6,10 @@ package tpm2_test
 
 import (
 	"bytes"
+	"crypto/elliptic"
 	"crypto/sha1"
 	"crypto/sha256"
+	"math/big"
 	"reflect"
 	"testing"
 
@@ -46,6 +48,11 @@ func TestHandle(t *testing.T) {
 			handle:     HandleOwner,
 			handleType: HandleTypePermanent,
 		},
+		{
+			desc:       "ACT",
+			handle:     HandleACT0,
+			handleType: HandleTypePermanent,
+		},
 		{
 			desc:       "Transient",
 			handle:     0x80000003,
@@ -364,6 +371,17 @@ func TestTaggedHash(t *testing.T) {
 		}
 	})
 
+	t.Run("UnmarshalNull", func(t *testing.T) {
+		var a TaggedHash
+		_, err := mu.UnmarshalFromBytes([]byte{0x00, 0x10}, &a)
+		if err == nil {
+			t.Fatalf("UnmarshalFromBytes should fail to unmarshal a TaggedHash with TPM_ALG_NULL")
+		}
+		if err.Error() != "cannot unmarshal argument at index 0: cannot process custom type tpm2.TaggedHash: cannot determine digest size for unknown algorithm TPM_ALG_NULL" {
+			t.Errorf("UnmarshalFromBytes returned an unexpected error: %v", err)
+		}
+	})
+
 	t.Run("UnmarshalFromLongerBuffer", func(t *testing.T) {
 		in := TaggedHash{HashAlg: HashAlgorithmSHA256, Digest: sha256Hash[:]}
 		out, err := mu.MarshalToBytes(&in)
@@ -767,3 +785,203 @@ func TestPCRSelectionListSubtract(t *testing.T) {
 		})
 	}
 }
+
+func TestAlgorithmDetailECCGoCurve(t *testing.T) {
+	p256 := elliptic.P256().Params()
+	p := p256.P.Bytes()
+	a := new(big.Int).Sub(p256.P, big.NewInt(3)).Bytes()
+
+	for _, data := range []struct {
+		desc     string
+		detail   AlgorithmDetailECC
+		expected *elliptic.CurveParams
+	}{
+		{
+			desc:     "Known",
+			detail:   AlgorithmDetailECC{CurveID: ECCCurveNIST_P256},
+			expected: p256,
+		},
+		{
+			desc: "Generic",
+			detail: AlgorithmDetailECC{
+				CurveID: 0x7fff,
+				KeySize: 256,
+				P:       p,
+				A:       a,
+				B:       p256.B.Bytes(),
+				GX:      p256.Gx.Bytes(),
+				GY:      p256.Gy.Bytes(),
+				N:       p256.N.Bytes(),
+				H:       []byte{0x01}},
+			expected: &elliptic.CurveParams{P: p256.P, N: p256.N, B: p256.B, Gx: p256.Gx, Gy: p256.Gy, BitSize: 256},
+		},
+		{
+			desc: "Unsupported",
+			detail: AlgorithmDetailECC{
+				CurveID: 0x7fff,
+				KeySize: 256,
+				P:       p,
+				A:       []byte{0x00},
+				B:       []byte{0x03}},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			curve := data.detail.GoCurve()
+			if data.expected == nil {
+				if curve != nil {
+					t.Errorf("Expected a nil curve")
+				}
+				return
+			}
+			if curve == nil {
+				t.Fatalf("GoCurve returned nil")
+			}
+			if !reflect.DeepEqual(curve.Params(), data.expected) {
+				t.Errorf("Unexpected curve parameters")
+			}
+			if !curve.IsOnCurve(p256.Gx, p256.Gy) {
+				t.Errorf("Base point is not on curve")
+			}
+		})
+	}
+}
+
+func TestAttestCheckNVContents(t *testing.T) {
+	name := Name(append([]byte{0x00, 0x0b}, make([]byte, 32)...))
+	attest := Attest{
+		Magic: TPMGeneratedValue,
+		Type:  TagAttestNV,
+		Attested: AttestU{
+			Data: &NVCertifyInfo{
+				IndexName:  name,
+				Offset:     4,
+				NVContents: MaxNVBuffer("foo")}}}
+
+	b, err := mu.MarshalToBytes(&attest)
+	if err != nil {
+		t.Fatalf("MarshalToBytes failed: %v", err)
+	}
+	a, err := AttestRaw(b).Decode()
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	for _, data := range []struct {
+		desc     string
+		name     Name
+		offset   uint16
+		expected []byte
+		valid    bool
+	}{
+		{
+			desc:     "Good",
+			name:     name,
+			offset:   4,
+			expected: []byte("foo"),
+			valid:    true,
+		},
+		{
+			desc:     "WrongName",
+			name:     Name(append([]byte{0x00, 0x04}, make([]byte, 20)...)),
+			offset:   4,
+			expected: []byte("foo"),
+		},
+		{
+			desc:     "WrongOffset",
+			name:     name,
+			offset:   0,
+			expected: []byte("foo"),
+		},
+		{
+			desc:     "WrongContents",
+			name:     name,
+			offset:   4,
+			expected: []byte("bar"),
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			err := a.CheckNVContents(data.name, data.offset, data.expected)
+			if data.valid && err != nil {
+				t.Errorf("CheckNVContents failed: %v", err)
+			}
+			if !data.valid && err == nil {
+				t.Errorf("CheckNVContents should have failed")
+			}
+		})
+	}
+
+	t.Run("WrongType", func(t *testing.T) {
+		a := Attest{
+			Magic:    TPMGeneratedValue,
+			Type:     TagAttestCertify,
+			Attested: AttestU{Data: &CertifyInfo{}}}
+		if err := a.CheckNVContents(name, 4, []byte("foo")); err == nil {
+			t.Errorf("CheckNVContents should have failed")
+		}
+	})
+}
+
+func TestCapabilityDataACT(t *testing.T) {
+	data := CapabilityData{
+		Capability: CapabilityACT,
+		Data: CapabilitiesU{
+			Data: ACTDataList{
+				{Handle: HandleACT0, Timeout: 60, Attrs: 0},
+				{Handle: HandleACT1, Timeout: 0, Attrs: AttrACTSignaled | AttrACTPreserveSignaled}}}}
+
+	b, err := mu.MarshalToBytes(&data)
+	if err != nil {
+		t.Fatalf("MarshalToBytes failed: %v", err)
+	}
+
+	expected := []byte{0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x02, 0x40, 0x00, 0x01, 0x10, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x00, 0x00,
+		0x00, 0x40, 0x00, 0x01, 0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("Unexpected encoding (got %x)", b)
+	}
+
+	var data2 CapabilityData
+	if _, err := mu.UnmarshalFromBytes(b, &data2); err != nil {
+		t.Fatalf("UnmarshalFromBytes failed: %v", err)
+	}
+	if !reflect.DeepEqual(data, data2) {
+		t.Errorf("Unexpected unmarshalled data")
+	}
+
+	if data2.Data.ACTData()[1].Handle.String() != "TPM_RH_ACT_1" {
+		t.Errorf("Unexpected handle string (%s)", data2.Data.ACTData()[1].Handle)
+	}
+	if data2.Capability.String() != "TPM_CAP_ACT" {
+		t.Errorf("Unexpected capability string (%s)", data2.Capability)
+	}
+}
+
+func TestLocality(t *testing.T) {
+	// TPMA_LOCALITY is a bitmask of localities 0 to 4. Check that the constants have the correct values and that the TPM encoded
+	// form of a bitmask with only TPM_LOC_THREE set (which is what the TPM returns in TPMS_CREATION_DATA for an object created at
+	// locality 3) decodes to LocalityThree.
+	for i, l := range []Locality{LocalityZero, LocalityOne, LocalityTwo, LocalityThree, LocalityFour} {
+		if l != Locality(1<<uint(i)) {
+			t.Errorf("Unexpected value for locality %d: %#02x", i, l)
+		}
+	}
+
+	var creationData CreationData
+	b := []byte{
+		0x00, 0x00, 0x00, 0x00, // pcrSelect
+		0x00, 0x00, // pcrDigest
+		0x08,       // locality
+		0x00, 0x0b, // parentNameAlg
+		0x00, 0x00, // parentName
+		0x00, 0x00, // parentQualifiedName
+		0x00, 0x00} // outsideInfo
+	if _, err := mu.UnmarshalFromBytes(b, &creationData); err != nil {
+		t.Fatalf("UnmarshalFromBytes failed: %v", err)
+	}
+	if creationData.Locality != LocalityThree {
+		t.Errorf("Unexpected locality: %#02x", creationData.Locality)
+	}
+	if creationData.Locality&(LocalityZero|LocalityOne|LocalityTwo|LocalityFour) != 0 {
+		t.Errorf("Unexpected localities in bitmask: %#02x", creationData.Locality)
+	}
+}
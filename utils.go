@@ -98,6 +98,47 @@ func ComputePCRDigestSimple(alg HashAlgorithmId, values PCRValues) (PCRSelection
 	return pcrs, digest, nil
 }
 
+// NewDerivationParentTemplate returns a template that can be used with TPMContext.CreatePrimary, TPMContext.Create or
+// TPMContext.CreateLoaded to create a derivation parent. A derivation parent is a restricted decrypt keyed hash object that can be
+// supplied as the parent to TPMContext.CreateLoaded in order to create derived objects, which are deterministically generated from
+// the secret value of the derivation parent and the label and context values supplied in the template (see
+// NewDerivedObjectTemplate). The nameAlg argument specifies the name algorithm of the derivation parent, and kdfHashAlg specifies
+// the digest algorithm used by the TPM to derive objects from it.
+//
+// The returned template has the AttrFixedTPM, AttrFixedParent, AttrSensitiveDataOrigin, AttrUserWithAuth, AttrRestricted and
+// AttrDecrypt attributes set. These can be modified before the template is used.
+func NewDerivationParentTemplate(nameAlg, kdfHashAlg HashAlgorithmId) *Public {
+	return &Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: nameAlg,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrRestricted | AttrDecrypt,
+		Params: PublicParamsU{
+			Data: &KeyedHashParams{
+				Scheme: KeyedHashScheme{
+					Scheme: KeyedHashSchemeXOR,
+					Details: SchemeKeyedHashU{
+						Data: &SchemeXOR{HashAlg: kdfHashAlg, KDF: KDFAlgorithmKDF1_SP800_108}}}}}}
+}
+
+// NewDerivedObjectTemplate returns a template that can be used with TPMContext.CreateLoaded to create an object derived from a
+// derivation parent (see NewDerivationParentTemplate). The type, name algorithm, attributes, authorization policy and parameters of
+// the derived object are copied from the template argument, and the label and context arguments are used as the derivation values.
+// Creating an object with the same template, label and context from the same derivation parent will always produce the same
+// object, which makes it possible to use a single derivation parent to generate keys for multiple purposes or tenants on demand
+// without having to store them.
+//
+// The TPM requires that the AttrSensitiveDataOrigin attribute is clear for derived objects, so this is cleared in the returned
+// template.
+func NewDerivedObjectTemplate(template *Public, label, context []byte) *PublicDerived {
+	return &PublicDerived{
+		Type:       template.Type,
+		NameAlg:    template.NameAlg,
+		Attrs:      template.Attrs &^ AttrSensitiveDataOrigin,
+		AuthPolicy: template.AuthPolicy,
+		Params:     template.Params,
+		Unique:     &Derive{Label: label, Context: context}}
+}
+
 // TrialAuthPolicy provides a mechanism for computing authorization policy digests without having to execute a trial authorization
 // policy session on the TPM. An advantage of this is that it is possible to compute digests for PolicySecret and PolicyNV assertions
 // without knowledge of the authorization value of the authorizing entities used for those commands.
@@ -192,6 +233,12 @@ func (p *TrialAuthPolicy) PolicyPCR(pcrDigest Digest, pcrs PCRSelectionList) {
 	end()
 }
 
+func (p *TrialAuthPolicy) PolicyLocality(locality Locality) {
+	h, end := p.beginUpdateForCommand(CommandPolicyLocality)
+	binary.Write(h, binary.BigEndian, locality)
+	end()
+}
+
 func (p *TrialAuthPolicy) PolicyNV(nvIndexName Name, operandB Operand, offset uint16, operation ArithmeticOp) {
 	h := p.alg.NewHash()
 	h.Write(operandB)
@@ -225,6 +272,11 @@ func (p *TrialAuthPolicy) PolicyCommandCode(code CommandCode) {
 	end()
 }
 
+func (p *TrialAuthPolicy) PolicyPhysicalPresence() {
+	_, end := p.beginUpdateForCommand(CommandPolicyPhysicalPresence)
+	end()
+}
+
 func (p *TrialAuthPolicy) PolicyCpHash(cpHashA Digest) {
 	h, end := p.beginUpdateForCommand(CommandPolicyCpHash)
 	h.Write(cpHashA)
@@ -268,3 +320,17 @@ func (p *TrialAuthPolicy) PolicyNvWritten(writtenSet bool) {
 	binary.Write(h, binary.BigEndian, writtenSet)
 	end()
 }
+
+func (p *TrialAuthPolicy) PolicyTemplate(templateHash Digest) {
+	h, end := p.beginUpdateForCommand(CommandPolicyTemplate)
+	h.Write(templateHash)
+	end()
+}
+
+func (p *TrialAuthPolicy) PolicyAuthorizeNV(nvIndexName Name) {
+	p.reset()
+
+	h, end := p.beginUpdateForCommand(CommandPolicyAuthorizeNV)
+	h.Write(nvIndexName)
+	end()
+}
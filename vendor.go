@@ -0,0 +1,82 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// VendorCommand describes a vendor specific command, and is used to register it with RegisterVendorCommand.
+type VendorCommand struct {
+	CommandCode CommandCode // Command code, which must have the vendor bit (AttrV) set
+	Name        string      // Name of the command, returned from CommandCode.String
+
+	NumCommandHandles  int // Number of handles in the command handle area
+	NumResponseHandles int // Number of handles in the response handle area (0 or 1)
+
+	// CommandParamEncryption indicates that the first command parameter is a sized buffer that can be encrypted with a session that
+	// has the AttrCommandEncrypt attribute set.
+	CommandParamEncryption bool
+
+	// ResponseParamEncryption indicates that the first response parameter is a sized buffer that can be encrypted with a session
+	// that has the AttrResponseEncrypt attribute set.
+	ResponseParamEncryption bool
+}
+
+var (
+	vendorCommandsMu sync.RWMutex
+	vendorCommands   = make(map[CommandCode]VendorCommand)
+)
+
+// RegisterVendorCommand registers the vendor specific command described by cmd, so that it can be executed with
+// TPMContext.RunCommand in the same way as a command defined by the TPM Library Specification. Once a command is registered, its
+// name is returned from CommandCode.String and is used when formatting errors associated with it.
+//
+// TPMContext.RunCommand will check that the number of command and response handles supplied for a registered command matches the
+// values provided during registration. It will also only permit sessions with the AttrCommandEncrypt and AttrResponseEncrypt
+// attributes to be used with a registered command if it was registered with CommandParamEncryption and ResponseParamEncryption
+// respectively. As the TPM doesn't provide a way to determine which parameters of a vendor specific command can be encrypted,
+// CommandParamEncryption and ResponseParamEncryption must only be set if the first parameter is a sized buffer. A session with the
+// AttrCommandEncrypt attribute will still be rejected if the first command parameter supplied to TPMContext.RunCommand is not a sized
+// buffer.
+//
+// An error will be returned if the command code does not have the vendor bit set, if the number of handles is not valid, or if a
+// command has already been registered with the same command code.
+func RegisterVendorCommand(cmd VendorCommand) error {
+	if CommandAttributes(cmd.CommandCode)&AttrV == 0 {
+		return makeInvalidArgError("cmd", fmt.Sprintf("command code %s is not a vendor command", cmd.CommandCode))
+	}
+	if cmd.Name == "" {
+		return makeInvalidArgError("cmd", "no name")
+	}
+	if cmd.NumCommandHandles < 0 || cmd.NumCommandHandles > 7 {
+		return makeInvalidArgError("cmd", "invalid number of command handles")
+	}
+	if cmd.NumResponseHandles < 0 || cmd.NumResponseHandles > 1 {
+		return makeInvalidArgError("cmd", "invalid number of response handles")
+	}
+
+	vendorCommandsMu.Lock()
+	defer vendorCommandsMu.Unlock()
+
+	if _, exists := vendorCommands[cmd.CommandCode]; exists {
+		return errors.New("a command with the same command code has already been registered")
+	}
+	vendorCommands[cmd.CommandCode] = cmd
+	return nil
+}
+
+func lookupVendorCommand(commandCode CommandCode) (VendorCommand, bool) {
+	if CommandAttributes(commandCode)&AttrV == 0 {
+		return VendorCommand{}, false
+	}
+
+	vendorCommandsMu.RLock()
+	defer vendorCommandsMu.RUnlock()
+	cmd, exists := vendorCommands[commandCode]
+	return cmd, exists
+}
@@ -0,0 +1,215 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+const (
+	testVendorCommandEcho CommandCode = 0x20000101
+	testVendorTCGTest     CommandCode = 0x20000000 // TPM_CC_Vendor_TCG_Test
+)
+
+func init() {
+	for _, cmd := range []VendorCommand{
+		{
+			CommandCode:            testVendorCommandEcho,
+			Name:                   "TPM_CC_Test_Echo",
+			NumCommandHandles:      1,
+			CommandParamEncryption: true},
+		{
+			CommandCode:             testVendorTCGTest,
+			Name:                    "TPM_CC_Vendor_TCG_Test",
+			CommandParamEncryption:  true,
+			ResponseParamEncryption: true},
+	} {
+		if err := RegisterVendorCommand(cmd); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestRegisterVendorCommand(t *testing.T) {
+	if testVendorCommandEcho.String() != "TPM_CC_Test_Echo" {
+		t.Errorf("Unexpected command name (%s)", testVendorCommandEcho)
+	}
+	if CommandCode(0x20000102).String() != "0x20000102" {
+		t.Errorf("Unexpected name for unregistered command")
+	}
+
+	err := DecodeResponseCode(testVendorCommandEcho, 0x1c4) // TPM_RC_VALUE + TPM_RC_P + TPM_RC_1
+	if !strings.Contains(err.Error(), "TPM_CC_Test_Echo") {
+		t.Errorf("Unexpected error string: %v", err)
+	}
+
+	for _, data := range []struct {
+		desc string
+		cmd  VendorCommand
+	}{
+		{
+			desc: "NotVendor",
+			cmd:  VendorCommand{CommandCode: CommandStartup, Name: "TPM_CC_Foo"},
+		},
+		{
+			desc: "NoName",
+			cmd:  VendorCommand{CommandCode: 0x20000103},
+		},
+		{
+			desc: "TooManyCommandHandles",
+			cmd:  VendorCommand{CommandCode: 0x20000103, Name: "TPM_CC_Foo", NumCommandHandles: 8},
+		},
+		{
+			desc: "TooManyResponseHandles",
+			cmd:  VendorCommand{CommandCode: 0x20000103, Name: "TPM_CC_Foo", NumResponseHandles: 2},
+		},
+		{
+			desc: "AlreadyRegistered",
+			cmd:  VendorCommand{CommandCode: testVendorCommandEcho, Name: "TPM_CC_Foo"},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			if err := RegisterVendorCommand(data.cmd); err == nil {
+				t.Errorf("RegisterVendorCommand should have failed")
+			}
+		})
+	}
+}
+
+func TestRunVendorCommand(t *testing.T) {
+	transport := &scriptedTransport{}
+	transport.addResponse(t, Success, MaxBuffer("bar"))
+
+	tpm, _ := NewTPMContext(transport)
+	defer closeTPM(t, tpm)
+
+	var out MaxBuffer
+	if err := tpm.RunCommand(testVendorCommandEcho, nil,
+		tpm.OwnerHandleContext(), Delimiter,
+		MaxBuffer("foo"), Delimiter,
+		Delimiter,
+		&out); err != nil {
+		t.Fatalf("RunCommand failed: %v", err)
+	}
+	if !bytes.Equal(out, []byte("bar")) {
+		t.Errorf("Unexpected response (%x)", out)
+	}
+
+	if len(transport.commands) != 1 {
+		t.Fatalf("Unexpected number of commands (%d)", len(transport.commands))
+	}
+	cmd := transport.commands[0]
+	if CommandCode(binary.BigEndian.Uint32(cmd[6:])) != testVendorCommandEcho {
+		t.Errorf("Unexpected command code")
+	}
+	if Handle(binary.BigEndian.Uint32(cmd[10:])) != HandleOwner {
+		t.Errorf("Unexpected command handle")
+	}
+	var in MaxBuffer
+	if _, err := mu.UnmarshalFromBytes(cmd[14:], &in); err != nil {
+		t.Fatalf("UnmarshalFromBytes failed: %v", err)
+	}
+	if !bytes.Equal(in, []byte("foo")) {
+		t.Errorf("Unexpected command parameter (%x)", in)
+	}
+
+	t.Run("WrongCommandHandles", func(t *testing.T) {
+		err := tpm.RunCommand(testVendorCommandEcho, nil,
+			Delimiter,
+			MaxBuffer("foo"), Delimiter,
+			Delimiter,
+			&out)
+		if err == nil {
+			t.Fatalf("RunCommand should have failed")
+		}
+		if err.Error() != "invalid number of command handles for command TPM_CC_Test_Echo (got 0, expected 1)" {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if len(transport.commands) != 1 {
+			t.Errorf("RunCommand should not have sent the command")
+		}
+	})
+
+	t.Run("WrongResponseHandles", func(t *testing.T) {
+		var handle Handle
+		err := tpm.RunCommand(testVendorCommandEcho, nil,
+			tpm.OwnerHandleContext(), Delimiter,
+			MaxBuffer("foo"), Delimiter,
+			&handle, Delimiter,
+			&out)
+		if err == nil {
+			t.Fatalf("RunCommand should have failed")
+		}
+		if err.Error() != "invalid number of response handles for command TPM_CC_Test_Echo (got 1, expected 0)" {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
+
+func TestRunVendorCommandWithParamEncryption(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	primary := createRSASrkForTesting(t, tpm, nil)
+	defer flushContext(t, tpm, primary)
+
+	symmetric := SymDef{
+		Algorithm: SymAlgorithmAES,
+		KeyBits:   SymKeyBitsU{Data: uint16(128)},
+		Mode:      SymModeU{Data: SymModeCFB}}
+	sessionContext, err := tpm.StartAuthSession(primary, nil, SessionTypeHMAC, &symmetric, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	defer flushContext(t, tpm, sessionContext)
+
+	t.Run("Supported", func(t *testing.T) {
+		var out Data
+		if err := tpm.RunCommand(testVendorTCGTest, []SessionContext{sessionContext.WithAttrs(AttrContinueSession | AttrCommandEncrypt | AttrResponseEncrypt)},
+			Delimiter,
+			Data("foo"), Delimiter,
+			Delimiter,
+			&out); err != nil {
+			if IsTPMError(err, ErrorCommandCode, testVendorTCGTest) {
+				t.Skip("TPM does not implement TPM2_Vendor_TCG_Test")
+			}
+			t.Fatalf("RunCommand failed: %v", err)
+		}
+		if !bytes.Equal(out, []byte("foo")) {
+			t.Errorf("Unexpected response (%x)", out)
+		}
+	})
+
+	t.Run("NotSizedBuffer", func(t *testing.T) {
+		// The command was registered with CommandParamEncryption, but the first parameter supplied here isn't a sized buffer.
+		var out Data
+		err := tpm.RunCommand(testVendorTCGTest, []SessionContext{sessionContext.WithAttrs(AttrContinueSession | AttrCommandEncrypt)},
+			Delimiter,
+			uint32(10), Data("foo"), Delimiter,
+			Delimiter,
+			&out)
+		if err == nil || err.Error() != "command TPM_CC_Vendor_TCG_Test does not support command parameter encryption" {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		var out MaxBuffer
+		err := tpm.RunCommand(testVendorCommandEcho, []SessionContext{sessionContext.WithAttrs(AttrContinueSession | AttrResponseEncrypt)},
+			tpm.OwnerHandleContext(), Delimiter,
+			MaxBuffer("foo"), Delimiter,
+			Delimiter,
+			&out)
+		if err == nil || err.Error() != "command TPM_CC_Test_Echo does not support response parameter encryption" {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,251 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+var oidExtensionKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 15}
+
+// PartialCertificate contains the fields of a X.509 certificate that are supplied by the caller to TPMContext.CertifyX509. The TPM
+// completes the certificate by adding the version, serial number, signature algorithm and subject public key info fields.
+type PartialCertificate struct {
+	Issuer    pkix.Name
+	RawIssuer []byte // If not empty, this DER encoded issuer name is used instead of Issuer
+
+	NotBefore, NotAfter time.Time
+
+	Subject    pkix.Name
+	RawSubject []byte // If not empty, this DER encoded subject name is used instead of Subject
+
+	// KeyUsage is added to the certificate as a critical key usage extension if it is not zero. The TPM checks that the key usage is
+	// consistent with the attributes of the object being certified.
+	KeyUsage x509.KeyUsage
+
+	// ExtraExtensions are added to the certificate as they are supplied.
+	ExtraExtensions []pkix.Extension
+}
+
+type partialCertificateValidity struct {
+	NotBefore, NotAfter time.Time
+}
+
+type partialCertificateASN1 struct {
+	Issuer     asn1.RawValue
+	Validity   partialCertificateValidity
+	Subject    asn1.RawValue
+	Extensions []pkix.Extension `asn1:"optional,explicit,tag:3"`
+}
+
+func reverseBitsInAByte(in byte) byte {
+	b1 := in>>4 | in<<4
+	b2 := b1>>2&0x33 | b1<<2&0xcc
+	return b2>>1&0x55 | b2<<1&0xaa
+}
+
+func marshalKeyUsage(ku x509.KeyUsage) (pkix.Extension, error) {
+	var a [2]byte
+	a[0] = reverseBitsInAByte(byte(ku))
+	a[1] = reverseBitsInAByte(byte(ku >> 8))
+
+	l := 1
+	if a[1] != 0 {
+		l = 2
+	}
+	b := a[:l]
+
+	bitLength := len(b) * 8
+	for i := len(b) - 1; i >= 0 && bitLength > 0; i-- {
+		if b[i] == 0 {
+			bitLength -= 8
+			continue
+		}
+		for j := uint(0); j < 8 && b[i]&(1<<j) == 0; j++ {
+			bitLength--
+		}
+		break
+	}
+
+	value, err := asn1.Marshal(asn1.BitString{Bytes: b, BitLength: bitLength})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtensionKeyUsage, Critical: true, Value: value}, nil
+}
+
+func marshalName(name pkix.Name, raw []byte) (asn1.RawValue, error) {
+	if len(raw) == 0 {
+		var err error
+		raw, err = asn1.Marshal(name.ToRDNSequence())
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+	}
+	return asn1.RawValue{FullBytes: raw}, nil
+}
+
+// Marshal returns the DER encoding of this partial certificate in the form expected by the TPM, which is a SEQUENCE containing the
+// issuer, validity, subject and extensions fields of a TBSCertificate.
+func (c *PartialCertificate) Marshal() ([]byte, error) {
+	issuer, err := marshalName(c.Issuer, c.RawIssuer)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot marshal issuer: %w", err)
+	}
+	subject, err := marshalName(c.Subject, c.RawSubject)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot marshal subject: %w", err)
+	}
+
+	var extensions []pkix.Extension
+	if c.KeyUsage != 0 {
+		ext, err := marshalKeyUsage(c.KeyUsage)
+		if err != nil {
+			return nil, xerrors.Errorf("cannot marshal key usage: %w", err)
+		}
+		extensions = append(extensions, ext)
+	}
+	extensions = append(extensions, c.ExtraExtensions...)
+
+	return asn1.Marshal(partialCertificateASN1{
+		Issuer:     issuer,
+		Validity:   partialCertificateValidity{c.NotBefore.UTC(), c.NotAfter.UTC()},
+		Subject:    subject,
+		Extensions: extensions})
+}
+
+func unmarshalSequenceElements(data []byte) ([]asn1.RawValue, error) {
+	var seq asn1.RawValue
+	if rest, err := asn1.Unmarshal(data, &seq); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing bytes")
+	}
+	if seq.Class != asn1.ClassUniversal || seq.Tag != asn1.TagSequence || !seq.IsCompound {
+		return nil, errors.New("not a SEQUENCE")
+	}
+
+	var elements []asn1.RawValue
+	for data := seq.Bytes; len(data) > 0; {
+		var e asn1.RawValue
+		var err error
+		data, err = asn1.Unmarshal(data, &e)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, e)
+	}
+	return elements, nil
+}
+
+func marshalCertificateSignatureValue(signature *Signature) ([]byte, error) {
+	switch signature.SigAlg {
+	case SigSchemeAlgRSASSA:
+		return signature.Signature.RSASSA().Sig, nil
+	case SigSchemeAlgRSAPSS:
+		return signature.Signature.RSAPSS().Sig, nil
+	case SigSchemeAlgECDSA:
+		sig := signature.Signature.ECDSA()
+		return asn1.Marshal(struct {
+			R, S *big.Int
+		}{new(big.Int).SetBytes(sig.SignatureR), new(big.Int).SetBytes(sig.SignatureS)})
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %v", signature.SigAlg)
+	}
+}
+
+func signatureHashAlg(signature *Signature) HashAlgorithmId {
+	switch signature.SigAlg {
+	case SigSchemeAlgRSASSA:
+		return signature.Signature.RSASSA().Hash
+	case SigSchemeAlgRSAPSS:
+		return signature.Signature.RSAPSS().Hash
+	case SigSchemeAlgECDSA:
+		return signature.Signature.ECDSA().Hash
+	default:
+		return HashAlgorithmNull
+	}
+}
+
+// AssembleCertifyX509Certificate reassembles a complete X.509 certificate from the partialCertificate argument supplied to
+// TPMContext.CertifyX509 and the addedToCertificate, tbsDigest and signature values that it returned. The partialCertificate
+// argument must be identical to the one supplied to the TPM.
+//
+// The digest of the reassembled TBSCertificate is checked against tbsDigest, and an error is returned if they don't match. Only
+// RSASSA, RSAPSS and ECDSA signatures are supported. The signature itself is not verified by this function - this can be done by
+// calling CheckSignatureFrom on the returned certificate with the certificate of the signing key.
+func AssembleCertifyX509Certificate(partialCertificate *PartialCertificate, addedToCertificate MaxBuffer, tbsDigest Digest, signature *Signature) (*x509.Certificate, error) {
+	partial, err := partialCertificate.Marshal()
+	if err != nil {
+		return nil, xerrors.Errorf("cannot marshal partial certificate: %w", err)
+	}
+	partialElements, err := unmarshalSequenceElements(partial)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot unmarshal partial certificate: %w", err)
+	}
+
+	added, err := unmarshalSequenceElements(addedToCertificate)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot unmarshal addedToCertificate: %w", err)
+	}
+	// addedToCertificate contains the version, serialNumber, signature and subjectPublicKeyInfo fields of the TBSCertificate.
+	if len(added) != 4 {
+		return nil, fmt.Errorf("unexpected number of fields in addedToCertificate (%d)", len(added))
+	}
+	if added[0].Class != asn1.ClassContextSpecific || added[0].Tag != 0 {
+		return nil, errors.New("addedToCertificate does not start with a version field")
+	}
+
+	// The TBSCertificate fields are version, serialNumber, signature, issuer, validity, subject, subjectPublicKeyInfo and extensions,
+	// in that order.
+	tbsBuf := new(bytes.Buffer)
+	for _, e := range [][]asn1.RawValue{added[0:3], partialElements[0:3], added[3:4], partialElements[3:]} {
+		for _, f := range e {
+			tbsBuf.Write(f.FullBytes)
+		}
+	}
+	tbs, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: tbsBuf.Bytes()})
+	if err != nil {
+		return nil, xerrors.Errorf("cannot marshal TBSCertificate: %w", err)
+	}
+
+	hashAlg := signatureHashAlg(signature)
+	if !hashAlg.Supported() {
+		return nil, fmt.Errorf("unsupported signature digest algorithm %v", hashAlg)
+	}
+	h := hashAlg.NewHash()
+	h.Write(tbs)
+	if !bytes.Equal(h.Sum(nil), tbsDigest) {
+		return nil, errors.New("digest of reassembled TBSCertificate does not match tbsDigest")
+	}
+
+	sig, err := marshalCertificateSignatureValue(signature)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := asn1.Marshal(struct {
+		TBSCertificate     asn1.RawValue
+		SignatureAlgorithm asn1.RawValue
+		SignatureValue     asn1.BitString
+	}{
+		TBSCertificate:     asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: added[2],
+		SignatureValue:     asn1.BitString{Bytes: sig, BitLength: len(sig) * 8}})
+	if err != nil {
+		return nil, xerrors.Errorf("cannot marshal certificate: %w", err)
+	}
+
+	return x509.ParseCertificate(cert)
+}
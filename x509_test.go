@@ -0,0 +1,192 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	. "github.com/canonical/go-tpm2"
+)
+
+// mockCertifyX509 performs the TPM side of TPM2_CertifyX509 for the supplied partial certificate, using the specified key for both
+// the object being certified and the signing key.
+func mockCertifyX509(t *testing.T, key *ecdsa.PrivateKey, partial *PartialCertificate, serial int64) (MaxBuffer, Digest, *Signature) {
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+	}
+	sigAlg := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}}
+
+	added, err := asn1.Marshal(struct {
+		Version            int `asn1:"explicit,tag:0"`
+		SerialNumber       *big.Int
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		PublicKey          asn1.RawValue
+	}{2, big.NewInt(serial), sigAlg, asn1.RawValue{FullBytes: spki}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	p, err := partial.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var partialFields struct {
+		Issuer     asn1.RawValue
+		Validity   asn1.RawValue
+		Subject    asn1.RawValue
+		Extensions asn1.RawValue `asn1:"optional"`
+	}
+	if _, err := asn1.Unmarshal(p, &partialFields); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	tbs, err := asn1.Marshal(struct {
+		Version            int `asn1:"explicit,tag:0"`
+		SerialNumber       *big.Int
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		Issuer             asn1.RawValue
+		Validity           asn1.RawValue
+		Subject            asn1.RawValue
+		PublicKey          asn1.RawValue
+		Extensions         asn1.RawValue `asn1:"optional"`
+	}{2, big.NewInt(serial), sigAlg, partialFields.Issuer, partialFields.Validity, partialFields.Subject,
+		asn1.RawValue{FullBytes: spki}, partialFields.Extensions})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	digest := sha256.Sum256(tbs)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	signature := Signature{
+		SigAlg: SigSchemeAlgECDSA,
+		Signature: SignatureU{
+			Data: &SignatureECDSA{
+				Hash:       HashAlgorithmSHA256,
+				SignatureR: r.Bytes(),
+				SignatureS: s.Bytes()}}}
+	return added, digest[:], &signature
+}
+
+func TestAssembleCertifyX509Certificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	notBefore := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, data := range []struct {
+		desc    string
+		partial PartialCertificate
+	}{
+		{
+			desc: "Basic",
+			partial: PartialCertificate{
+				Issuer:    pkix.Name{CommonName: "Issuer"},
+				NotBefore: notBefore,
+				NotAfter:  notBefore.AddDate(1, 0, 0),
+				Subject:   pkix.Name{CommonName: "Subject", Organization: []string{"Canonical"}},
+				KeyUsage:  x509.KeyUsageDigitalSignature}},
+		{
+			desc: "MultipleKeyUsage",
+			partial: PartialCertificate{
+				Issuer:    pkix.Name{CommonName: "Issuer"},
+				NotBefore: notBefore,
+				NotAfter:  notBefore.AddDate(1, 0, 0),
+				Subject:   pkix.Name{CommonName: "Subject"},
+				KeyUsage:  x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageDecipherOnly}},
+		{
+			desc: "GeneralizedTime",
+			partial: PartialCertificate{
+				Issuer:    pkix.Name{CommonName: "Issuer"},
+				NotBefore: notBefore,
+				NotAfter:  time.Date(2060, time.January, 1, 0, 0, 0, 0, time.UTC),
+				Subject:   pkix.Name{CommonName: "Subject"},
+				KeyUsage:  x509.KeyUsageDigitalSignature}},
+		{
+			desc: "ExtraExtensions",
+			partial: PartialCertificate{
+				Issuer:    pkix.Name{CommonName: "Issuer"},
+				NotBefore: notBefore,
+				NotAfter:  notBefore.AddDate(1, 0, 0),
+				Subject:   pkix.Name{CommonName: "Subject"},
+				KeyUsage:  x509.KeyUsageDigitalSignature,
+				ExtraExtensions: []pkix.Extension{
+					{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Value: []byte{0x04, 0x02, 0xaa, 0x55}}}}},
+		{
+			desc: "NoExtensions",
+			partial: PartialCertificate{
+				Issuer:    pkix.Name{CommonName: "Issuer"},
+				NotBefore: notBefore,
+				NotAfter:  notBefore.AddDate(1, 0, 0),
+				Subject:   pkix.Name{CommonName: "Subject"}}},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			added, tbsDigest, signature := mockCertifyX509(t, key, &data.partial, 1234)
+
+			cert, err := AssembleCertifyX509Certificate(&data.partial, added, tbsDigest, signature)
+			if err != nil {
+				t.Fatalf("AssembleCertifyX509Certificate failed: %v", err)
+			}
+
+			if err := cert.CheckSignature(x509.ECDSAWithSHA256, cert.RawTBSCertificate, cert.Signature); err != nil {
+				t.Errorf("Invalid signature: %v", err)
+			}
+			if cert.SerialNumber.Int64() != 1234 {
+				t.Errorf("Unexpected serial number")
+			}
+			if cert.Issuer.CommonName != data.partial.Issuer.CommonName || cert.Subject.CommonName != data.partial.Subject.CommonName {
+				t.Errorf("Unexpected issuer or subject")
+			}
+			if !cert.NotBefore.Equal(data.partial.NotBefore) || !cert.NotAfter.Equal(data.partial.NotAfter) {
+				t.Errorf("Unexpected validity")
+			}
+			if cert.KeyUsage != data.partial.KeyUsage {
+				t.Errorf("Unexpected key usage (got %v, expected %v)", cert.KeyUsage, data.partial.KeyUsage)
+			}
+			expectedExtensions := len(data.partial.ExtraExtensions)
+			if data.partial.KeyUsage != 0 {
+				expectedExtensions++
+			}
+			if len(cert.Extensions) != expectedExtensions {
+				t.Errorf("Unexpected number of extensions")
+			}
+			if pubKey, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok || pubKey.X.Cmp(key.X) != 0 || pubKey.Y.Cmp(key.Y) != 0 {
+				t.Errorf("Unexpected public key")
+			}
+		})
+	}
+
+	t.Run("WrongDigest", func(t *testing.T) {
+		partial := PartialCertificate{
+			Issuer:    pkix.Name{CommonName: "Issuer"},
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			Subject:   pkix.Name{CommonName: "Subject"}}
+		added, _, signature := mockCertifyX509(t, key, &partial, 1)
+
+		partial.Subject.CommonName = "Other"
+		_, tbsDigest, _ := mockCertifyX509(t, key, &partial, 1)
+		partial.Subject.CommonName = "Subject"
+
+		if _, err := AssembleCertifyX509Certificate(&partial, added, tbsDigest, signature); err == nil {
+			t.Errorf("AssembleCertifyX509Certificate should fail with the wrong digest")
+		}
+	})
+}